@@ -1,3 +1,4 @@
+use ccms::query::fast_lowercase::{FastLowercase, contains_ignore_ascii_case};
 use ccms::{SearchEngineTrait, SearchOptions, SessionMessage, SmolEngine, parse_query};
 use codspeed_criterion_compat::{
     BenchmarkId, Criterion, black_box, criterion_group, criterion_main,
@@ -119,6 +120,91 @@ fn benchmark_search_matching(c: &mut Criterion) {
     group.finish();
 }
 
+// Case-insensitive matching of a 10+ character query over message-sized texts:
+// lowercasing each text first (one allocation per text), the byte-by-byte scan
+// previously used for ASCII, and the in-place Boyer-Moore-Horspool search. The
+// unicode_text cases show that non-ASCII text no longer falls back to lowercasing.
+fn benchmark_case_insensitive_matching(c: &mut Criterion) {
+    let mut group = c.benchmark_group("case_insensitive_matching");
+
+    let texts: Vec<String> = (0..2000)
+        .map(|i| {
+            let detail = if i % 50 == 0 {
+                "Connection Refused by upstream"
+            } else {
+                "request completed successfully"
+            };
+            format!(
+                "Running cargo test for module {i}: compiling dependencies, checking \
+                 the build cache and collecting output. The {detail} after {i}ms. \
+                 Next, let me look at the configuration and the retry policy."
+            )
+        })
+        .collect();
+    let query = "connection refused";
+
+    fn naive_scan(haystack: &[u8], needle: &[u8]) -> bool {
+        haystack.len() >= needle.len()
+            && (0..=haystack.len() - needle.len())
+                .any(|i| haystack[i..i + needle.len()].eq_ignore_ascii_case(needle))
+    }
+
+    group.bench_function("lowercase_then_contains", |b| {
+        b.iter(|| {
+            texts
+                .iter()
+                .filter(|text| text.to_lowercase().contains(black_box(query)))
+                .count()
+        });
+    });
+
+    group.bench_function("naive_scan", |b| {
+        b.iter(|| {
+            texts
+                .iter()
+                .filter(|text| naive_scan(text.as_bytes(), black_box(query).as_bytes()))
+                .count()
+        });
+    });
+
+    group.bench_function("boyer_moore_horspool", |b| {
+        b.iter(|| {
+            texts
+                .iter()
+                .filter(|text| {
+                    contains_ignore_ascii_case(text.as_bytes(), black_box(query).as_bytes())
+                })
+                .count()
+        });
+    });
+
+    // Text with a few non-ASCII characters used to force the lowercasing fallback
+    let unicode_texts: Vec<String> = texts
+        .iter()
+        .map(|text| format!("{text} — ✓ done"))
+        .collect();
+
+    group.bench_function("unicode_text/lowercase_then_contains", |b| {
+        b.iter(|| {
+            unicode_texts
+                .iter()
+                .filter(|text| text.to_lowercase().contains(black_box(query)))
+                .count()
+        });
+    });
+
+    group.bench_function("unicode_text/fast_contains_ignore_case", |b| {
+        b.iter(|| {
+            unicode_texts
+                .iter()
+                .filter(|text| text.fast_contains_ignore_case(black_box(query)))
+                .count()
+        });
+    });
+
+    group.finish();
+}
+
 fn benchmark_content_extraction(c: &mut Criterion) {
     let mut group = c.benchmark_group("content_extraction");
 
@@ -262,6 +348,7 @@ criterion_group!(
     benchmark_file_reading,
     benchmark_json_parsing,
     benchmark_search_matching,
+    benchmark_case_insensitive_matching,
     benchmark_content_extraction,
     benchmark_parallel_processing,
     benchmark_end_to_end
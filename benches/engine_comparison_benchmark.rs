@@ -2,10 +2,57 @@ use ccms::{RayonEngine, SearchEngineTrait, SearchOptions, SmolEngine, parse_quer
 use codspeed_criterion_compat::{
     BenchmarkId, Criterion, black_box, criterion_group, criterion_main,
 };
+use std::alloc::{GlobalAlloc, Layout, System};
 use std::fs::File;
 use std::io::Write;
+use std::sync::atomic::{AtomicUsize, Ordering};
 use tempfile::TempDir;
 
+// Tracks live heap bytes and their high-water mark across every thread, so the
+// capped and unlimited runs of `benchmark_capped_collection` can be compared by
+// peak memory as well as time
+struct PeakAllocator;
+
+static LIVE_BYTES: AtomicUsize = AtomicUsize::new(0);
+static PEAK_BYTES: AtomicUsize = AtomicUsize::new(0);
+
+fn grow(bytes: usize) {
+    let live = LIVE_BYTES.fetch_add(bytes, Ordering::Relaxed) + bytes;
+    PEAK_BYTES.fetch_max(live, Ordering::Relaxed);
+}
+
+unsafe impl GlobalAlloc for PeakAllocator {
+    unsafe fn alloc(&self, layout: Layout) -> *mut u8 {
+        grow(layout.size());
+        unsafe { System.alloc(layout) }
+    }
+
+    unsafe fn dealloc(&self, ptr: *mut u8, layout: Layout) {
+        LIVE_BYTES.fetch_sub(layout.size(), Ordering::Relaxed);
+        unsafe { System.dealloc(ptr, layout) }
+    }
+
+    unsafe fn realloc(&self, ptr: *mut u8, layout: Layout, new_size: usize) -> *mut u8 {
+        if new_size > layout.size() {
+            grow(new_size - layout.size());
+        } else {
+            LIVE_BYTES.fetch_sub(layout.size() - new_size, Ordering::Relaxed);
+        }
+        unsafe { System.realloc(ptr, layout, new_size) }
+    }
+}
+
+#[global_allocator]
+static GLOBAL: PeakAllocator = PeakAllocator;
+
+// Run `f` and return how far the heap grew above where it started at its peak
+fn peak_heap_growth(f: impl FnOnce()) -> usize {
+    let base = LIVE_BYTES.load(Ordering::Relaxed);
+    PEAK_BYTES.store(base, Ordering::Relaxed);
+    f();
+    PEAK_BYTES.load(Ordering::Relaxed).saturating_sub(base)
+}
+
 struct TestEnvironment {
     _temp_dir: TempDir,
 }
@@ -164,5 +211,76 @@ fn benchmark_engine_comparison(c: &mut Criterion) {
     group.finish();
 }
 
-criterion_group!(benches, benchmark_engine_comparison);
+// A query matching every message, with and without a result cap. With a cap the
+// engines only ever hold about twice the cap in memory while collecting; the
+// peak heap growth of one search of each kind is printed before the timings.
+fn benchmark_capped_collection(c: &mut Criterion) {
+    let env = TestEnvironment::new(50, 1000);
+    let pattern = format!("{}/*.jsonl", env._temp_dir.path().display());
+    let query = parse_query("NOT zzzzzz").unwrap();
+
+    for (name, max_results) in [("capped_50", Some(50)), ("unlimited", None)] {
+        let options = SearchOptions {
+            max_results,
+            ..Default::default()
+        };
+        let smol = peak_heap_growth(|| {
+            let engine = SmolEngine::new(options.clone());
+            black_box(engine.search(&pattern, query.clone()).unwrap());
+        });
+        let rayon = peak_heap_growth(|| {
+            let engine = RayonEngine::new(options.clone());
+            black_box(engine.search(&pattern, query.clone()).unwrap());
+        });
+        let mib = |bytes: usize| bytes as f64 / (1024.0 * 1024.0);
+        println!(
+            "capped_collection/{name}: peak heap growth smol {:.1} MiB, rayon {:.1} MiB",
+            mib(smol),
+            mib(rayon)
+        );
+    }
+
+    let mut group = c.benchmark_group("capped_collection");
+
+    for (name, max_results) in [("capped_50", Some(50)), ("unlimited", None)] {
+        let options = SearchOptions {
+            max_results,
+            ..Default::default()
+        };
+
+        group.bench_with_input(
+            BenchmarkId::new("smol", name),
+            &(&pattern, &query, &options),
+            |b, (pattern, query, options)| {
+                b.iter(|| {
+                    let engine = SmolEngine::new((*options).clone());
+                    let (results, _, total) =
+                        engine.search(pattern, black_box((*query).clone())).unwrap();
+                    black_box((results.len(), total))
+                });
+            },
+        );
+
+        group.bench_with_input(
+            BenchmarkId::new("rayon", name),
+            &(&pattern, &query, &options),
+            |b, (pattern, query, options)| {
+                b.iter(|| {
+                    let engine = RayonEngine::new((*options).clone());
+                    let (results, _, total) =
+                        engine.search(pattern, black_box((*query).clone())).unwrap();
+                    black_box((results.len(), total))
+                });
+            },
+        );
+    }
+
+    group.finish();
+}
+
+criterion_group!(
+    benches,
+    benchmark_engine_comparison,
+    benchmark_capped_collection
+);
 criterion_main!(benches);
@@ -36,6 +36,10 @@ fn create_test_search_results(count: usize) -> Vec<SearchResult> {
                 },
                 cwd: "/test".to_string(),
                 raw_json: None,
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             }
         })
         .collect()
@@ -472,6 +476,7 @@ fn create_test_session_results(count: usize) -> Vec<SearchResult> {
                     let content = match &message.content {
                         UserContent::String(s) => s.clone(),
                         UserContent::Array(_) => "Array content".to_string(),
+                        UserContent::Other(_) => "Other content".to_string(),
                     };
                     ("user", content)
                 }
@@ -499,6 +504,10 @@ fn create_test_session_results(count: usize) -> Vec<SearchResult> {
                 },
                 cwd: "/test".to_string(),
                 raw_json: Some(raw_json),
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             }
         })
         .collect()
@@ -1,7 +1,11 @@
-use ccms::{SearchEngineTrait, SearchOptions, SmolEngine, parse_query};
-use codspeed_criterion_compat::{Criterion, black_box, criterion_group, criterion_main};
+use ccms::utils::line_reader::{BoundedLine, MAX_LINE_BYTES, read_bounded_line};
+use ccms::utils::mapped_file::MappedFile;
+use ccms::{RayonEngine, SearchEngineTrait, SearchOptions, SmolEngine, parse_query};
+use codspeed_criterion_compat::{
+    Criterion, Throughput, black_box, criterion_group, criterion_main,
+};
 use std::fs::File;
-use std::io::Write;
+use std::io::{BufRead, BufReader, BufWriter, Write};
 use tempfile::tempdir;
 
 fn create_large_test_data(num_lines: usize) -> String {
@@ -48,9 +52,104 @@ fn benchmark_very_large_file_search(c: &mut Criterion) {
     });
 }
 
+// Count non-empty lines the way the engines read them
+fn count_lines(mut reader: impl BufRead) -> usize {
+    let mut line_buffer = Vec::with_capacity(16 * 1024);
+    let mut lines = 0;
+    while read_bounded_line(&mut reader, &mut line_buffer, MAX_LINE_BYTES).unwrap()
+        != BoundedLine::Eof
+    {
+        lines += usize::from(!line_buffer.trim_ascii().is_empty());
+    }
+    lines
+}
+
+// Reading a ~60 MB file through a memory map versus a 64 KiB read buffer
+fn benchmark_mapped_vs_buffered_read(c: &mut Criterion) {
+    let temp_dir = tempdir().unwrap();
+    let test_file = temp_dir.path().join("large_test.jsonl");
+    let mut file = File::create(&test_file).unwrap();
+    for i in 0..250_000 {
+        writeln!(
+            file,
+            r#"{{"type":"user","message":{{"role":"user","content":"Message {i} with some test content that is longer to simulate real messages"}},"uuid":"{i}","timestamp":"2024-01-01T00:00:{:02}Z","sessionId":"session1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/test","version":"1.0"}}"#,
+            i % 60
+        )
+        .unwrap();
+    }
+    drop(file);
+
+    let mut group = c.benchmark_group("read_large_file");
+    group.throughput(Throughput::Bytes(
+        std::fs::metadata(&test_file).unwrap().len(),
+    ));
+
+    group.bench_function("mmap", |b| {
+        b.iter(|| black_box(count_lines(MappedFile::open(&test_file).unwrap())));
+    });
+
+    group.bench_function("buffered", |b| {
+        b.iter(|| {
+            let file = File::open(&test_file).unwrap();
+            black_box(count_lines(BufReader::with_capacity(64 * 1024, file)))
+        });
+    });
+
+    group.finish();
+}
+
+// One ~500 MB session, the shape of a long-running always-on conversation. The
+// Rayon engine splits it into runs of lines searched side by side; with a single
+// worker, and in the Smol engine, one thread reads it start to end.
+fn benchmark_single_huge_file(c: &mut Criterion) {
+    let temp_dir = tempdir().unwrap();
+    let test_file = temp_dir.path().join("huge_session.jsonl");
+    let mut file = BufWriter::new(File::create(&test_file).unwrap());
+    for i in 0..2_000_000 {
+        writeln!(
+            file,
+            r#"{{"type":"user","message":{{"role":"user","content":"Message {i} with some test content that is longer to simulate real messages, error code {}"}},"uuid":"{i}","timestamp":"2024-01-01T00:00:{:02}Z","sessionId":"session1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/test","version":"1.0"}}"#,
+            i % 1000,
+            i % 60
+        )
+        .unwrap();
+    }
+    drop(file);
+    let test_file = test_file.to_string_lossy().to_string();
+    let query = parse_query("\"error code 999\"").unwrap();
+
+    let mut group = c.benchmark_group("single_huge_file");
+    group.sample_size(10);
+    group.throughput(Throughput::Bytes(
+        std::fs::metadata(&test_file).unwrap().len(),
+    ));
+
+    group.bench_function("rayon_split", |b| {
+        let engine = RayonEngine::new(SearchOptions::default());
+        b.iter(|| engine.search(&test_file, black_box(query.clone())).unwrap());
+    });
+
+    group.bench_function("rayon_one_worker", |b| {
+        let engine = RayonEngine::new(SearchOptions {
+            workers: Some(1),
+            ..Default::default()
+        });
+        b.iter(|| engine.search(&test_file, black_box(query.clone())).unwrap());
+    });
+
+    group.bench_function("smol", |b| {
+        let engine = SmolEngine::new(SearchOptions::default());
+        b.iter(|| engine.search(&test_file, black_box(query.clone())).unwrap());
+    });
+
+    group.finish();
+}
+
 criterion_group!(
     benches,
     benchmark_large_file_search,
-    benchmark_very_large_file_search
+    benchmark_very_large_file_search,
+    benchmark_mapped_vs_buffered_read,
+    benchmark_single_huge_file
 );
 criterion_main!(benches);
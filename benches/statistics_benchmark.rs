@@ -43,6 +43,10 @@ fn generate_search_results(
             },
             cwd: format!("/project{}", i % 5),
             raw_json: None,
+            line_number: None,
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
         });
     }
 
@@ -0,0 +1,304 @@
+//! Runs every search strategy over the same corpus and prints time, allocations
+//! and match counts side by side. Exits with an error if the strategies disagree
+//! on the number of matches, so a divergence between implementations is caught
+//! together with the performance numbers.
+//!
+//! ```bash
+//! cargo bench --bench strategy_comparison
+//! # Use a real corpus instead of generated data
+//! CCMS_BENCH_PATTERN="$HOME/.claude/projects/**/*.jsonl" cargo bench --bench strategy_comparison
+//! ```
+//!
+//! `CCMS_BENCH_ITERATIONS` sets how many runs each measurement takes the best of (default 5).
+
+use ccms::utils::compression;
+use ccms::{
+    QueryCondition, RayonEngine, SearchEngineTrait, SearchOptions, SessionMessage, SmolEngine,
+    discover_claude_files, parse_query,
+};
+use std::alloc::{GlobalAlloc, Layout, System};
+use std::fs::File;
+use std::io::{BufRead, BufReader, Write};
+use std::path::PathBuf;
+use std::process::ExitCode;
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::time::{Duration, Instant};
+use tempfile::TempDir;
+
+// Counts allocations made by every thread, so parallel strategies are measured too
+struct CountingAllocator;
+
+static ALLOCATIONS: AtomicUsize = AtomicUsize::new(0);
+static ALLOCATED_BYTES: AtomicUsize = AtomicUsize::new(0);
+
+unsafe impl GlobalAlloc for CountingAllocator {
+    unsafe fn alloc(&self, layout: Layout) -> *mut u8 {
+        ALLOCATIONS.fetch_add(1, Ordering::Relaxed);
+        ALLOCATED_BYTES.fetch_add(layout.size(), Ordering::Relaxed);
+        unsafe { System.alloc(layout) }
+    }
+
+    unsafe fn dealloc(&self, ptr: *mut u8, layout: Layout) {
+        unsafe { System.dealloc(ptr, layout) }
+    }
+
+    unsafe fn realloc(&self, ptr: *mut u8, layout: Layout, new_size: usize) -> *mut u8 {
+        ALLOCATIONS.fetch_add(1, Ordering::Relaxed);
+        ALLOCATED_BYTES.fetch_add(new_size, Ordering::Relaxed);
+        unsafe { System.realloc(ptr, layout, new_size) }
+    }
+}
+
+#[global_allocator]
+static GLOBAL: CountingAllocator = CountingAllocator;
+
+const QUERIES: &[(&str, &str)] = &[
+    ("simple", "error"),
+    ("complex", "error AND (code OR debug)"),
+    ("not", "NOT failed"),
+    ("regex", r"/process \d+/"),
+];
+
+#[derive(Clone, Copy)]
+enum Strategy {
+    Smol,
+    Rayon,
+    SerdeJson,
+    SonicRs,
+}
+
+impl Strategy {
+    const ALL: [Strategy; 4] = [
+        Strategy::Smol,
+        Strategy::Rayon,
+        Strategy::SerdeJson,
+        Strategy::SonicRs,
+    ];
+
+    fn name(self) -> &'static str {
+        match self {
+            Strategy::Smol => "smol engine",
+            Strategy::Rayon => "rayon engine",
+            Strategy::SerdeJson => "serde_json (sequential)",
+            Strategy::SonicRs => "sonic-rs (sequential)",
+        }
+    }
+
+    // Number of messages matching `query` across the corpus
+    fn count_matches(self, corpus: &Corpus, query: &QueryCondition) -> usize {
+        let options = SearchOptions {
+            max_results: None,
+            ..Default::default()
+        };
+        match self {
+            Strategy::Smol => {
+                let engine = SmolEngine::new(options);
+                engine.search(&corpus.pattern, query.clone()).unwrap().2
+            }
+            Strategy::Rayon => {
+                let engine = RayonEngine::new(options);
+                engine.search(&corpus.pattern, query.clone()).unwrap().2
+            }
+            Strategy::SerdeJson => count_sequential(corpus, query, |line| {
+                serde_json::from_slice::<SessionMessage>(line).ok()
+            }),
+            Strategy::SonicRs => count_sequential(corpus, query, |line| {
+                sonic_rs::from_slice::<SessionMessage>(line).ok()
+            }),
+        }
+    }
+}
+
+struct Corpus {
+    pattern: String,
+    files: Vec<PathBuf>,
+    _temp_dir: Option<TempDir>,
+}
+
+impl Corpus {
+    fn load() -> Corpus {
+        let (pattern, temp_dir) = match std::env::var("CCMS_BENCH_PATTERN") {
+            Ok(pattern) => (pattern, None),
+            Err(_) => {
+                let temp_dir = generate_corpus(20, 1000);
+                (
+                    format!("{}/*.jsonl", temp_dir.path().display()),
+                    Some(temp_dir),
+                )
+            }
+        };
+        let files = discover_claude_files(Some(&pattern)).unwrap();
+        Corpus {
+            pattern,
+            files,
+            _temp_dir: temp_dir,
+        }
+    }
+}
+
+fn count_sequential(
+    corpus: &Corpus,
+    query: &QueryCondition,
+    parse: impl Fn(&[u8]) -> Option<SessionMessage>,
+) -> usize {
+    let mut matches = 0;
+    let mut line_buffer = Vec::with_capacity(16 * 1024);
+
+    for path in &corpus.files {
+        let file = compression::open_session_file(path).unwrap();
+        let mut reader = BufReader::with_capacity(64 * 1024, file);
+        loop {
+            line_buffer.clear();
+            if reader.read_until(b'\n', &mut line_buffer).unwrap() == 0 {
+                break;
+            }
+            let line = line_buffer.trim_ascii();
+            if line.is_empty() {
+                continue;
+            }
+            if let Some(message) = parse(line)
+                && query
+                    .evaluate(&message.get_searchable_text())
+                    .unwrap_or(false)
+            {
+                matches += 1;
+            }
+        }
+    }
+
+    matches
+}
+
+struct Measurement {
+    best: Duration,
+    allocations: usize,
+    allocated_bytes: usize,
+    matches: usize,
+}
+
+fn measure(iterations: usize, mut run: impl FnMut() -> usize) -> Measurement {
+    let mut best = Duration::MAX;
+    let mut allocations = usize::MAX;
+    let mut allocated_bytes = usize::MAX;
+    let mut matches = 0;
+
+    for _ in 0..iterations {
+        let allocations_before = ALLOCATIONS.load(Ordering::Relaxed);
+        let bytes_before = ALLOCATED_BYTES.load(Ordering::Relaxed);
+        let start = Instant::now();
+        matches = run();
+        best = best.min(start.elapsed());
+        allocations = allocations.min(ALLOCATIONS.load(Ordering::Relaxed) - allocations_before);
+        allocated_bytes =
+            allocated_bytes.min(ALLOCATED_BYTES.load(Ordering::Relaxed) - bytes_before);
+    }
+
+    Measurement {
+        best,
+        allocations,
+        allocated_bytes,
+        matches,
+    }
+}
+
+// `"type"` value and message body of a generated line
+fn user_message(content: &str) -> String {
+    format!(r#""user","message":{{"role":"user","content":"{content}"}}"#)
+}
+
+fn assistant_message(text: &str) -> String {
+    format!(
+        r#""assistant","message":{{"id":"msg","type":"message","role":"assistant","model":"claude","content":[{{"type":"text","text":"{text}"}}],"stop_reason":"end_turn","stop_sequence":null,"usage":{{"input_tokens":10,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":20}}}}"#
+    )
+}
+
+fn generate_corpus(num_files: usize, lines_per_file: usize) -> TempDir {
+    let temp_dir = TempDir::new().unwrap();
+
+    for file_idx in 0..num_files {
+        let file_path = temp_dir.path().join(format!("session_{file_idx}.jsonl"));
+        let mut file = File::create(&file_path).unwrap();
+
+        for line_idx in 0..lines_per_file {
+            let message = match line_idx % 4 {
+                0 => user_message(&format!(
+                    "Can you show me how to handle error code {line_idx}?"
+                )),
+                1 => assistant_message(&format!(
+                    "Here’s a solution for error code {line_idx} — first, check the input ✓"
+                )),
+                2 => user_message(&format!(
+                    "Debug log shows: process {line_idx} failed with status {}",
+                    line_idx % 10
+                )),
+                _ => assistant_message(&format!(
+                    "The debug log for process {line_idx} points at memory allocation"
+                )),
+            };
+
+            writeln!(
+                file,
+                r#"{{"type":{message},"uuid":"{file_idx}-{line_idx}","timestamp":"2024-01-{:02}T{:02}:00:{:02}Z","sessionId":"session{file_idx}","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/Users/dev/project{file_idx}","version":"1.0"}}"#,
+                (line_idx % 28) + 1,
+                line_idx % 24,
+                line_idx % 60
+            )
+            .unwrap();
+        }
+    }
+
+    temp_dir
+}
+
+fn main() -> ExitCode {
+    let iterations = std::env::var("CCMS_BENCH_ITERATIONS")
+        .ok()
+        .and_then(|n| n.parse().ok())
+        .unwrap_or(5)
+        .max(1);
+    let corpus = Corpus::load();
+    println!(
+        "Corpus: {} ({} files), best of {iterations} runs\n",
+        corpus.pattern,
+        corpus.files.len()
+    );
+    println!(
+        "{:<10} {:<24} {:>10} {:>12} {:>14} {:>10}",
+        "query", "strategy", "ms", "allocs", "bytes", "matches"
+    );
+
+    let mut mismatches = Vec::new();
+    for (query_name, query_str) in QUERIES {
+        let query = parse_query(query_str).unwrap();
+        let mut counts = Vec::new();
+
+        for strategy in Strategy::ALL {
+            let m = measure(iterations, || strategy.count_matches(&corpus, &query));
+            println!(
+                "{:<10} {:<24} {:>10.2} {:>12} {:>14} {:>10}",
+                query_name,
+                strategy.name(),
+                m.best.as_secs_f64() * 1000.0,
+                m.allocations,
+                m.allocated_bytes,
+                m.matches
+            );
+            counts.push((strategy.name(), m.matches));
+        }
+
+        if counts.iter().any(|&(_, count)| count != counts[0].1) {
+            mismatches.push(format!("{query_name} ({query_str}): {counts:?}"));
+        }
+    }
+
+    if mismatches.is_empty() {
+        println!("\nAll strategies agree on every match count.");
+        ExitCode::SUCCESS
+    } else {
+        eprintln!("\nMATCH COUNT MISMATCH between strategies:");
+        for mismatch in &mismatches {
+            eprintln!("  {mismatch}");
+        }
+        ExitCode::FAILURE
+    }
+}
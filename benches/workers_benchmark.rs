@@ -0,0 +1,92 @@
+use ccms::{RayonEngine, SearchEngineTrait, SearchOptions, auto_workers, parse_query, plan_search};
+use codspeed_criterion_compat::{
+    BenchmarkId, Criterion, black_box, criterion_group, criterion_main,
+};
+use std::fs::File;
+use std::io::{BufWriter, Write};
+use std::path::PathBuf;
+use tempfile::TempDir;
+
+struct Corpus {
+    temp_dir: TempDir,
+    files: Vec<PathBuf>,
+}
+
+impl Corpus {
+    fn new(num_files: usize, lines_per_file: usize) -> Self {
+        let temp_dir = TempDir::new().unwrap();
+        let mut files = Vec::with_capacity(num_files);
+
+        for file_idx in 0..num_files {
+            let file_path = temp_dir.path().join(format!("session_{file_idx}.jsonl"));
+            let mut file = BufWriter::new(File::create(&file_path).unwrap());
+
+            for line_idx in 0..lines_per_file {
+                writeln!(
+                    file,
+                    r#"{{"type":"user","message":{{"role":"user","content":"Message {} with test content and error code {}"}},"uuid":"{}-{}","timestamp":"2024-01-01T00:00:{:02}Z","sessionId":"session{}","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/test","version":"1.0"}}"#,
+                    line_idx, line_idx, file_idx, line_idx, line_idx % 60, file_idx
+                ).unwrap();
+            }
+            files.push(file_path);
+        }
+
+        Corpus { temp_dir, files }
+    }
+
+    fn pattern(&self) -> String {
+        format!("{}/*.jsonl", self.temp_dir.path().display())
+    }
+}
+
+// Fixed (one worker per CPU, the default) against --workers auto. Only the
+// Rayon engine is measured: it builds a pool of the requested size for every
+// search, while smol's blocking pool is sized once per process.
+fn benchmark_worker_counts(c: &mut Criterion) {
+    let mut group = c.benchmark_group("workers");
+    group.sample_size(20);
+
+    // Representative shapes: lots of short sessions, typical sessions, and a
+    // handful of very long ones
+    let corpora = vec![
+        ("many_small", 2000, 10),
+        ("typical", 100, 2000),
+        ("few_large", 4, 100_000),
+    ];
+    let cpus = num_cpus::get();
+
+    for (corpus_name, num_files, lines_per_file) in corpora {
+        let corpus = Corpus::new(num_files, lines_per_file);
+        let pattern = corpus.pattern();
+        let query = parse_query("error").unwrap();
+
+        let plan = plan_search(&corpus.files, &SearchOptions::default());
+        let auto = auto_workers(plan.files.len(), plan.total_bytes, cpus);
+
+        for (label, workers) in [("fixed", cpus), ("auto", auto)] {
+            let options = SearchOptions {
+                max_results: Some(50),
+                workers: Some(workers),
+                ..Default::default()
+            };
+
+            group.bench_with_input(
+                BenchmarkId::new(label, corpus_name),
+                &(&pattern, &query, &options),
+                |b, (pattern, query, options)| {
+                    b.iter(|| {
+                        let engine = RayonEngine::new((*options).clone());
+                        let (results, _, _) =
+                            engine.search(pattern, black_box((*query).clone())).unwrap();
+                        black_box(results.len())
+                    });
+                },
+            );
+        }
+    }
+
+    group.finish();
+}
+
+criterion_group!(benches, benchmark_worker_counts);
+criterion_main!(benches);
@@ -0,0 +1,248 @@
+//! Default flags from a TOML config file
+//!
+//! Keys are long flag names, with `_` or `-` between words, and values are
+//! what the flag would take on the command line:
+//!
+//! ```toml
+//! project = "/home/me/src/my-app"
+//! context = 3
+//! no_color = true
+//! term = ["panic", "backtrace"]
+//! ```
+//!
+//! Config values are turned back into arguments and parsed together with the
+//! real command line, so they go through the same validation. A flag given on
+//! the command line or through its environment variable wins over the config,
+//! as does a flag that conflicts with one given on the command line.
+
+use anyhow::{Context, Result, bail};
+use clap::parser::ValueSource;
+use clap::{Arg, ArgAction, ArgMatches, Command};
+use std::ffi::OsString;
+use std::fs;
+use std::io::ErrorKind;
+use std::path::{Path, PathBuf};
+use toml::{Table, Value};
+
+/// Environment variable naming the config file; set it empty to ignore the config
+pub const CONFIG_ENV: &str = "CCMS_CONFIG";
+
+/// `$CCMS_CONFIG`, else `$XDG_CONFIG_HOME/ccms/config.toml`, else
+/// `~/.config/ccms/config.toml`. `None` when the config is disabled.
+pub fn config_path() -> Option<PathBuf> {
+    if let Some(path) = std::env::var_os(CONFIG_ENV) {
+        return (!path.is_empty()).then(|| PathBuf::from(path));
+    }
+    let config_home = std::env::var_os("XDG_CONFIG_HOME")
+        .filter(|dir| !dir.is_empty())
+        .map(PathBuf::from)
+        .or_else(|| dirs::home_dir().map(|home| home.join(".config")))?;
+    Some(config_home.join("ccms").join("config.toml"))
+}
+
+/// Parse the config at `path`; a missing file is not an error
+pub fn load_config(path: &Path) -> Result<Option<Table>> {
+    let text = match fs::read_to_string(path) {
+        Ok(text) => text,
+        Err(e) if e.kind() == ErrorKind::NotFound => return Ok(None),
+        Err(e) => {
+            return Err(e).with_context(|| format!("failed to read config {}", path.display()));
+        }
+    };
+    let table = text
+        .parse::<Table>()
+        .with_context(|| format!("failed to parse config {}", path.display()))?;
+    Ok(Some(table))
+}
+
+/// Arguments for the config entries that `matches` did not already set
+pub fn config_args(
+    config: &Table,
+    command: &Command,
+    matches: &ArgMatches,
+) -> Result<Vec<OsString>> {
+    let set_explicitly = |arg: &Arg| {
+        matches!(
+            matches.value_source(arg.get_id().as_str()),
+            Some(ValueSource::CommandLine | ValueSource::EnvVariable)
+        )
+    };
+
+    let mut args = Vec::new();
+    for (key, value) in config {
+        let long = key.replace('_', "-");
+        let Some(arg) = command
+            .get_arguments()
+            .find(|arg| arg.get_long() == Some(long.as_str()))
+        else {
+            bail!("unknown config key '{key}'");
+        };
+        // Conflicts are declared on one side only, so check both directions
+        let conflicts_with_command_line = command.get_arguments().any(|other| {
+            set_explicitly(other)
+                && (command.get_arg_conflicts_with(arg).contains(&other)
+                    || command.get_arg_conflicts_with(other).contains(&arg))
+        });
+        if set_explicitly(arg) || conflicts_with_command_line {
+            continue;
+        }
+        push_flag(&mut args, &long, arg.get_action(), value)
+            .with_context(|| format!("invalid value for config key '{key}'"))?;
+    }
+    Ok(args)
+}
+
+fn push_flag(
+    args: &mut Vec<OsString>,
+    long: &str,
+    action: &ArgAction,
+    value: &Value,
+) -> Result<()> {
+    let flag = format!("--{long}");
+    match (action, value) {
+        (ArgAction::SetTrue, Value::Boolean(enabled)) => {
+            if *enabled {
+                args.push(flag.into());
+            }
+        }
+        (ArgAction::SetTrue, _) => bail!("expected true or false"),
+        (ArgAction::Count, Value::Integer(count)) => {
+            let count = usize::try_from(*count).context("expected a non-negative count")?;
+            args.extend(std::iter::repeat_n(OsString::from(&flag), count));
+        }
+        (ArgAction::Count, _) => bail!("expected a count"),
+        (ArgAction::Append, Value::Array(items)) => {
+            for item in items {
+                args.push(format!("{flag}={}", scalar(item)?).into());
+            }
+        }
+        (_, Value::Array(_)) => bail!("expected a single value"),
+        _ => args.push(format!("{flag}={}", scalar(value)?).into()),
+    }
+    Ok(())
+}
+
+fn scalar(value: &Value) -> Result<String> {
+    Ok(match value {
+        Value::String(s) => s.clone(),
+        Value::Integer(i) => i.to_string(),
+        Value::Float(f) => f.to_string(),
+        Value::Boolean(b) => b.to_string(),
+        Value::Datetime(d) => d.to_string(),
+        Value::Array(_) | Value::Table(_) => bail!("expected a string or number"),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::io::Write;
+    use tempfile::tempdir;
+
+    fn command() -> Command {
+        Command::new("ccms")
+            .arg(Arg::new("query"))
+            .arg(Arg::new("context").short('C').long("context"))
+            .arg(Arg::new("color").long("color").env("CCMS_TEST_COLOR"))
+            .arg(
+                Arg::new("no_meta")
+                    .long("no-meta")
+                    .action(ArgAction::SetTrue),
+            )
+            .arg(
+                Arg::new("verbose")
+                    .short('v')
+                    .long("verbose")
+                    .action(ArgAction::Count),
+            )
+            .arg(
+                Arg::new("quiet")
+                    .short('q')
+                    .long("quiet")
+                    .action(ArgAction::SetTrue)
+                    .conflicts_with("verbose"),
+            )
+            .arg(Arg::new("term").long("term").action(ArgAction::Append))
+    }
+
+    fn args_for(config: &str, command_line: &[&str]) -> Result<Vec<String>> {
+        let command = command();
+        let matches = command
+            .clone()
+            .try_get_matches_from(std::iter::once("ccms").chain(command_line.iter().copied()))?;
+        let config: Table = config.parse()?;
+        Ok(config_args(&config, &command, &matches)?
+            .into_iter()
+            .map(|arg| arg.into_string().unwrap())
+            .collect())
+    }
+
+    #[test]
+    fn test_values_become_flags() -> Result<()> {
+        let args = args_for(
+            r#"
+            context = 3
+            no_meta = true
+            verbose = 2
+            term = ["a", "b"]
+            "#,
+            &[],
+        )?;
+        assert_eq!(
+            args,
+            vec![
+                "--context=3",
+                "--no-meta",
+                "--term=a",
+                "--term=b",
+                "--verbose",
+                "--verbose"
+            ]
+        );
+        assert!(args_for("no-meta = false", &[])?.is_empty());
+        Ok(())
+    }
+
+    #[test]
+    fn test_command_line_wins() -> Result<()> {
+        assert!(args_for("context = 3", &["-C", "5"])?.is_empty());
+        assert!(args_for("verbose = 1", &["--quiet"])?.is_empty());
+        assert_eq!(args_for("context = 3", &["query"])?, vec!["--context=3"]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_environment_wins() -> Result<()> {
+        // SAFETY: no other test reads this variable
+        unsafe { std::env::set_var("CCMS_TEST_COLOR", "never") };
+        let args = args_for(r#"color = "always""#, &[]);
+        unsafe { std::env::remove_var("CCMS_TEST_COLOR") };
+        assert!(args?.is_empty());
+        Ok(())
+    }
+
+    #[test]
+    fn test_invalid_entries_are_rejected() {
+        let error = args_for("colour = \"always\"", &[]).unwrap_err();
+        assert!(error.to_string().contains("unknown config key 'colour'"));
+        let error = args_for("no_meta = \"yes\"", &[]).unwrap_err();
+        assert!(format!("{error:#}").contains("expected true or false"));
+        let error = args_for("context = [1, 2]", &[]).unwrap_err();
+        assert!(format!("{error:#}").contains("expected a single value"));
+    }
+
+    #[test]
+    fn test_load_config() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let path = temp_dir.path().join("config.toml");
+        assert_eq!(load_config(&path)?, None);
+
+        writeln!(fs::File::create(&path)?, "context = 2")?;
+        let config = load_config(&path)?.unwrap();
+        assert_eq!(config["context"].as_integer(), Some(2));
+
+        writeln!(fs::File::create(&path)?, "context = ")?;
+        assert!(load_config(&path).is_err());
+        Ok(())
+    }
+}
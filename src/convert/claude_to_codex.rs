@@ -1,5 +1,6 @@
 use crate::schemas::SessionMessage;
 use crate::search::discover_claude_files;
+use crate::utils::compression;
 use anyhow::{Context, Result, bail};
 use chrono::{DateTime, Datelike, Utc};
 use serde_json::{Value, json};
@@ -154,7 +155,7 @@ fn resolve_source_file(session_id: &str, hint: Option<&Path>) -> Result<PathBuf>
 }
 
 fn file_contains_session_id(path: &Path, session_id: &str) -> Result<bool> {
-    let file = fs::File::open(path).with_context(|| {
+    let file = compression::open_session_file(path).with_context(|| {
         format!(
             "failed to open file while resolving session_id: {}",
             path.display()
@@ -197,7 +198,7 @@ fn build_rollout_jsonl(
     session_id: &str,
     codex_session_id: &str,
 ) -> Result<RolloutBuild> {
-    let file = fs::File::open(source_file)
+    let file = compression::open_session_file(source_file)
         .with_context(|| format!("failed to open source file: {}", source_file.display()))?;
     let reader = BufReader::new(file);
 
@@ -0,0 +1,481 @@
+//! Environment self-check for `ccms doctor`
+//!
+//! Answers "why are no files found?" without searching: whether the directory
+//! the pattern starts from exists and can be read, how many session files the
+//! pattern picks up, whether its `**` reaches into subdirectories, and which
+//! files or directories can't be opened. The walk that checks recursion lists
+//! every session file under the directory by name, independently of the glob,
+//! so a pattern that silently matches nothing shows up as a difference.
+
+use crate::search::dry_run::format_bytes;
+use crate::search::file_discovery::{DiscoveryPlan, FileDiscovery, plan_discovery};
+use crate::utils::compression;
+use std::collections::HashSet;
+use std::path::{Path, PathBuf};
+
+/// How a check turned out
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Status {
+    Ok,
+    Warning,
+    Error,
+}
+
+/// One line of the report, with a hint on what to do when it isn't ok
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Check {
+    pub status: Status,
+    pub message: String,
+    pub hint: Option<String>,
+    /// Paths the check is about, such as the files that can't be read
+    pub details: Vec<String>,
+}
+
+impl Check {
+    fn new(status: Status, message: impl Into<String>) -> Self {
+        Self {
+            status,
+            message: message.into(),
+            hint: None,
+            details: Vec::new(),
+        }
+    }
+
+    fn hint(mut self, hint: impl Into<String>) -> Self {
+        self.hint = Some(hint.into());
+        self
+    }
+}
+
+/// Outcome of checking a pattern
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct DoctorReport {
+    pub pattern: String,
+    pub checks: Vec<Check>,
+}
+
+impl DoctorReport {
+    /// Number of checks that failed outright
+    pub fn errors(&self) -> usize {
+        self.count(Status::Error)
+    }
+
+    pub fn warnings(&self) -> usize {
+        self.count(Status::Warning)
+    }
+
+    fn count(&self, status: Status) -> usize {
+        self.checks
+            .iter()
+            .filter(|check| check.status == status)
+            .count()
+    }
+}
+
+// Details listed per check before the rest are summarized
+const MAX_DETAILS: usize = 10;
+
+/// Check the files `pattern` (or the default `~/.claude/projects` pattern)
+/// would search. Nothing is searched; files are only opened to see that they
+/// can be read.
+pub fn diagnose(pattern: Option<&str>) -> DoctorReport {
+    let (pattern, checks) = match plan_discovery(pattern) {
+        DiscoveryPlan::SingleFile(path) => {
+            (path.display().to_string(), vec![check_single_file(&path)])
+        }
+        DiscoveryPlan::Walk { base, glob } => {
+            let checks = check_walk(&base, &glob);
+            (glob, checks)
+        }
+    };
+    DoctorReport { pattern, checks }
+}
+
+fn check_single_file(path: &Path) -> Check {
+    match std::fs::metadata(path) {
+        Err(e) => Check::new(
+            Status::Error,
+            format!("{} can't be read: {e}", path.display()),
+        )
+        .hint("Check the path, or pass a directory or a glob such as 'dir/**/*.jsonl'"),
+        Ok(metadata) if !metadata.is_file() => {
+            Check::new(Status::Error, format!("{} is not a file", path.display()))
+        }
+        Ok(metadata) => match compression::open_session_file(path) {
+            Err(e) => Check::new(
+                Status::Error,
+                format!("{} can't be read: {e}", path.display()),
+            ),
+            Ok(_) => Check::new(
+                Status::Ok,
+                format!(
+                    "{} is readable ({})",
+                    path.display(),
+                    format_bytes(metadata.len())
+                ),
+            ),
+        },
+    }
+}
+
+fn check_walk(base: &Path, glob: &str) -> Vec<Check> {
+    let mut checks = vec![check_directory(base)];
+    if checks[0].status == Status::Error {
+        return checks;
+    }
+
+    let discovery = match FileDiscovery::from_pattern(glob) {
+        Ok(discovery) => discovery,
+        Err(e) => {
+            checks.push(Check::new(Status::Error, format!("{e:#}")));
+            return checks;
+        }
+    };
+    let files = match discovery.discover_files(base) {
+        Ok(files) => files,
+        Err(e) => {
+            checks.push(Check::new(
+                Status::Error,
+                format!("Can't list session files under {}: {e:#}", base.display()),
+            ));
+            return checks;
+        }
+    };
+    let walk = walk_sessions(base);
+
+    checks.push(check_file_count(&files, &walk));
+    if let Some(check) = check_largest(&files) {
+        checks.push(check);
+    }
+    // With nothing matched at all the count already says so
+    if !files.is_empty() {
+        checks.push(check_recursion(base, &files, &walk));
+    }
+    checks.push(check_readable(&files));
+    if !walk.unreadable_dirs.is_empty() {
+        let mut check = Check::new(
+            Status::Warning,
+            format!(
+                "{} {} under {} can't be listed",
+                walk.unreadable_dirs.len(),
+                plural(walk.unreadable_dirs.len(), "directory", "directories"),
+                base.display()
+            ),
+        )
+        .hint("Session files in these directories are not searched; check their permissions");
+        check.details = walk.unreadable_dirs;
+        checks.push(check);
+    }
+    checks
+}
+
+fn check_directory(base: &Path) -> Check {
+    let display = base.display();
+    match std::fs::metadata(base) {
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => {
+            Check::new(Status::Error, format!("{display} does not exist")).hint(
+                "Claude Code writes sessions under ~/.claude/projects; \
+                 use -p to search another directory",
+            )
+        }
+        Err(e) => Check::new(Status::Error, format!("{display} can't be read: {e}")),
+        Ok(metadata) if !metadata.is_dir() => {
+            Check::new(Status::Error, format!("{display} is not a directory"))
+        }
+        Ok(_) => match std::fs::read_dir(base) {
+            Err(e) => Check::new(Status::Error, format!("{display} can't be listed: {e}"))
+                .hint("Check the directory's permissions"),
+            Ok(_) => Check::new(Status::Ok, format!("{display} exists and is readable")),
+        },
+    }
+}
+
+// Session files under a directory, found by name rather than by glob
+#[derive(Default)]
+struct Walk {
+    /// Session files directly in the directory
+    top_level: usize,
+    /// Session files in its subdirectories
+    nested: Vec<PathBuf>,
+    unreadable_dirs: Vec<String>,
+}
+
+fn walk_sessions(base: &Path) -> Walk {
+    let mut walk = Walk::default();
+    for entry in jwalk::WalkDir::new(base).follow_links(true) {
+        match entry {
+            Err(e) => walk.unreadable_dirs.push(e.to_string()),
+            Ok(entry) => {
+                let path = entry.path();
+                if !entry.file_type().is_file() || !is_session_name(&path) {
+                    continue;
+                }
+                if entry.depth() > 1 {
+                    walk.nested.push(path);
+                } else {
+                    walk.top_level += 1;
+                }
+            }
+        }
+    }
+    walk
+}
+
+fn is_session_name(path: &Path) -> bool {
+    let name = path.file_name().unwrap_or_default().to_string_lossy();
+    [".jsonl", ".jsonl.gz", ".jsonl.zst"]
+        .iter()
+        .any(|extension| name.ends_with(extension))
+}
+
+fn check_file_count(files: &[PathBuf], walk: &Walk) -> Check {
+    let total_bytes: u64 = files
+        .iter()
+        .filter_map(|path| std::fs::metadata(path).ok())
+        .map(|metadata| metadata.len())
+        .sum();
+    let on_disk = walk.top_level + walk.nested.len();
+    match files.len() {
+        0 if on_disk > 0 => Check::new(
+            Status::Error,
+            format!(
+                "The pattern matches no files, but {on_disk} session {} under the directory",
+                plural(on_disk, "file exists", "files exist")
+            ),
+        )
+        .hint("Check the pattern's file extension and '**'"),
+        0 => Check::new(Status::Warning, "No session files found")
+            .hint("Sessions appear here after running Claude Code in a project"),
+        count => Check::new(
+            Status::Ok,
+            format!(
+                "{count} session {} ({})",
+                plural(count, "file", "files"),
+                format_bytes(total_bytes)
+            ),
+        ),
+    }
+}
+
+fn check_largest(files: &[PathBuf]) -> Option<Check> {
+    let (path, len) = files
+        .iter()
+        .filter_map(|path| Some((path, std::fs::metadata(path).ok()?.len())))
+        .max_by_key(|&(_, len)| len)?;
+    Some(Check::new(
+        Status::Ok,
+        format!("Largest file: {} ({})", path.display(), format_bytes(len)),
+    ))
+}
+
+fn check_recursion(base: &Path, files: &[PathBuf], walk: &Walk) -> Check {
+    if walk.nested.is_empty() {
+        return Check::new(
+            Status::Ok,
+            format!(
+                "'**' recursion not checked: no session files in subdirectories of {}",
+                base.display()
+            ),
+        );
+    }
+    let files: HashSet<&PathBuf> = files.iter().collect();
+    let matched = walk
+        .nested
+        .iter()
+        .filter(|path| files.contains(path))
+        .count();
+    let nested = walk.nested.len();
+    if matched == 0 {
+        Check::new(
+            Status::Error,
+            format!(
+                "'**' recursion is not working: none of the {nested} session {} in subdirectories matched",
+                plural(nested, "file", "files")
+            ),
+        )
+        .hint("Check that the pattern is quoted so the shell doesn't expand it")
+    } else {
+        Check::new(
+            Status::Ok,
+            format!(
+                "'**' recursion works: {matched} of {nested} session {} in subdirectories matched",
+                plural(nested, "file", "files")
+            ),
+        )
+    }
+}
+
+fn check_readable(files: &[PathBuf]) -> Check {
+    let unreadable: Vec<String> = files
+        .iter()
+        .filter_map(|path| {
+            let error = compression::open_session_file(path).err()?;
+            Some(format!("{}: {error}", path.display()))
+        })
+        .collect();
+    if unreadable.is_empty() {
+        return Check::new(Status::Ok, "All session files can be opened");
+    }
+    let mut check = Check::new(
+        Status::Warning,
+        format!(
+            "{} session {} can't be opened",
+            unreadable.len(),
+            plural(unreadable.len(), "file", "files")
+        ),
+    )
+    .hint("These files are skipped by searches; check their permissions");
+    check.details = unreadable;
+    check
+}
+
+fn plural<'a>(count: usize, one: &'a str, many: &'a str) -> &'a str {
+    if count == 1 { one } else { many }
+}
+
+/// One line per check, with its hint and details indented below it
+pub fn format_report(report: &DoctorReport, use_color: bool) -> String {
+    use colored::Colorize;
+
+    let mut output = format!("Pattern: {}\n", report.pattern);
+    for check in &report.checks {
+        let label = match check.status {
+            Status::Ok => "ok",
+            Status::Warning => "warning",
+            Status::Error => "error",
+        };
+        let label = format!("[{label}]");
+        let label = if !use_color {
+            label
+        } else {
+            match check.status {
+                Status::Ok => label.green().to_string(),
+                Status::Warning => label.yellow().to_string(),
+                Status::Error => label.red().bold().to_string(),
+            }
+        };
+        output.push_str(&format!("{label} {}\n", check.message));
+        for detail in check.details.iter().take(MAX_DETAILS) {
+            output.push_str(&format!("    {detail}\n"));
+        }
+        if check.details.len() > MAX_DETAILS {
+            output.push_str(&format!(
+                "    ... and {} more\n",
+                check.details.len() - MAX_DETAILS
+            ));
+        }
+        if let Some(hint) = &check.hint {
+            output.push_str(&format!("    hint: {hint}\n"));
+        }
+    }
+    output
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+    use tempfile::tempdir;
+
+    fn pattern(dir: &Path) -> String {
+        format!("{}/**/*.{{jsonl,jsonl.gz,jsonl.zst}}", dir.display())
+    }
+
+    fn statuses(report: &DoctorReport) -> Vec<Status> {
+        report.checks.iter().map(|check| check.status).collect()
+    }
+
+    #[test]
+    fn test_healthy_projects_directory() {
+        let temp = tempdir().unwrap();
+        let project = temp.path().join("-home-me-app");
+        fs::create_dir(&project).unwrap();
+        fs::write(project.join("a.jsonl"), "{}\n").unwrap();
+        fs::write(project.join("b.jsonl"), "{}\n{}\n{}\n").unwrap();
+        fs::write(project.join("notes.txt"), "not a session").unwrap();
+
+        let report = diagnose(Some(&pattern(temp.path())));
+        assert_eq!(report.errors(), 0);
+        assert_eq!(report.warnings(), 0);
+        let output = format_report(&report, false);
+        assert!(output.contains("[ok] 2 session files (12 B)"), "{output}");
+        assert!(output.contains("b.jsonl (9 B)"), "{output}");
+        assert!(
+            output.contains("'**' recursion works: 2 of 2 session files"),
+            "{output}"
+        );
+        assert!(output.contains("[ok] All session files can be opened"));
+    }
+
+    #[test]
+    fn test_missing_directory() {
+        let temp = tempdir().unwrap();
+        let report = diagnose(Some(&pattern(&temp.path().join("missing"))));
+        assert_eq!(statuses(&report), [Status::Error]);
+        let output = format_report(&report, false);
+        assert!(output.contains("does not exist"), "{output}");
+        assert!(output.contains("hint: "), "{output}");
+    }
+
+    #[test]
+    fn test_pattern_that_matches_no_session_file() {
+        let temp = tempdir().unwrap();
+        let project = temp.path().join("project");
+        fs::create_dir(&project).unwrap();
+        fs::write(project.join("a.jsonl"), "{}\n").unwrap();
+
+        let glob = format!("{}/**/*.json", temp.path().display());
+        let report = diagnose(Some(&glob));
+        assert_eq!(report.errors(), 1);
+        let output = format_report(&report, false);
+        assert!(
+            output.contains("[error] The pattern matches no files, but 1 session file exists"),
+            "{output}"
+        );
+    }
+
+    #[test]
+    fn test_empty_directory_warns() {
+        let temp = tempdir().unwrap();
+        let report = diagnose(Some(&pattern(temp.path())));
+        assert_eq!(report.errors(), 0);
+        assert!(format_report(&report, false).contains("[warning] No session files found"));
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_unreadable_files_are_reported() {
+        use std::os::unix::fs::PermissionsExt;
+
+        let temp = tempdir().unwrap();
+        let project = temp.path().join("project");
+        fs::create_dir(&project).unwrap();
+        let locked = project.join("locked.jsonl");
+        fs::write(&locked, "{}\n").unwrap();
+        fs::set_permissions(&locked, fs::Permissions::from_mode(0o000)).unwrap();
+        if fs::File::open(&locked).is_ok() {
+            // Running as root, where permissions don't apply
+            return;
+        }
+
+        let report = diagnose(Some(&pattern(temp.path())));
+        let output = format_report(&report, false);
+        assert!(
+            output.contains("[warning] 1 session file can't be opened"),
+            "{output}"
+        );
+        assert!(output.contains("locked.jsonl: "), "{output}");
+    }
+
+    #[test]
+    fn test_single_file() {
+        let temp = tempdir().unwrap();
+        let file = temp.path().join("session.jsonl");
+        fs::write(&file, "{}\n").unwrap();
+        let report = diagnose(Some(&file.to_string_lossy()));
+        assert_eq!(statuses(&report), [Status::Ok]);
+
+        let report = diagnose(Some(&temp.path().join("gone.jsonl").to_string_lossy()));
+        assert_eq!(statuses(&report), [Status::Error]);
+    }
+}
@@ -0,0 +1,466 @@
+//! Flat JSON-lines export of parsed messages for `ccms export`
+//!
+//! Every message becomes one object with the same set of keys, so the output
+//! loads directly into a dataframe (pandas, DuckDB, ...). Fields a message type
+//! does not have are written as `null` rather than left out.
+
+use crate::schemas::SessionMessage;
+use crate::schemas::session_message::Content;
+use crate::search::{SessionIndex, discover_claude_files};
+use crate::session::{group_turns, load_session, order_conversation, same_turn};
+use crate::utils::{compression, line_reader};
+use anyhow::{Context, Result};
+use serde::Serialize;
+use std::collections::HashSet;
+use std::io::Write;
+use std::path::Path;
+
+/// Session ID argument that exports every session
+pub const ALL_SESSIONS: &str = "all";
+
+/// One exported message
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct ExportRecord {
+    #[serde(rename = "type")]
+    pub message_type: String,
+    pub role: String,
+    pub model: Option<String>,
+    pub timestamp: Option<String>,
+    pub session_id: Option<String>,
+    pub uuid: Option<String>,
+    pub parent_uuid: Option<String>,
+    pub cwd: Option<String>,
+    pub is_sidechain: bool,
+    pub content_text: String,
+    pub input_tokens: Option<u32>,
+    pub output_tokens: Option<u32>,
+    pub cache_creation_input_tokens: Option<u32>,
+    pub cache_read_input_tokens: Option<u32>,
+    pub tool_names: Vec<String>,
+}
+
+impl ExportRecord {
+    pub fn from_message(message: &SessionMessage) -> Self {
+        let (role, model, tool_names) = match message {
+            SessionMessage::Assistant { message: inner, .. } => (
+                inner.role.clone(),
+                Some(inner.model.clone()),
+                inner
+                    .content
+                    .iter()
+                    .filter_map(|content| match content {
+                        Content::ToolUse { name, .. } => Some(name.clone()),
+                        _ => None,
+                    })
+                    .collect(),
+            ),
+            SessionMessage::User { message: inner, .. } => (inner.role.clone(), None, Vec::new()),
+            other => (other.get_type().to_string(), None, Vec::new()),
+        };
+        let usage = message.get_usage();
+
+        Self {
+            message_type: message.get_type().to_string(),
+            role,
+            model,
+            timestamp: message.get_timestamp().map(str::to_string),
+            session_id: message.get_session_id().map(str::to_string),
+            uuid: message.get_uuid().map(str::to_string),
+            parent_uuid: message.get_parent_uuid().map(str::to_string),
+            cwd: message.get_cwd().map(str::to_string),
+            is_sidechain: message.is_sidechain(),
+            content_text: message.get_content_text(),
+            input_tokens: usage.map(|u| u.input_tokens),
+            output_tokens: usage.map(|u| u.output_tokens),
+            cache_creation_input_tokens: usage.map(|u| u.cache_creation_input_tokens),
+            cache_read_input_tokens: usage.map(|u| u.cache_read_input_tokens),
+            tool_names,
+        }
+    }
+
+    /// One record for a turn of consecutive messages (see
+    /// [`group_turns`](crate::session::group_turns)). Identity fields come from
+    /// the first message, text and tool names are concatenated and token counts
+    /// summed; lines split from one API response repeat its usage, so each
+    /// `requestId` is counted once.
+    pub fn from_turn(turn: &[SessionMessage]) -> Self {
+        let (first, rest) = turn.split_first().expect("a turn has at least one message");
+        let mut record = Self::from_message(first);
+        let mut counted: HashSet<&str> = first.get_request_id().into_iter().collect();
+        for message in rest {
+            let next = Self::from_message(message);
+            if !next.content_text.is_empty() {
+                if !record.content_text.is_empty() {
+                    record.content_text.push('\n');
+                }
+                record.content_text.push_str(&next.content_text);
+            }
+            record.tool_names.extend(next.tool_names);
+            record.model = record.model.or(next.model);
+
+            let repeated = message
+                .get_request_id()
+                .is_some_and(|request_id| !counted.insert(request_id));
+            if !repeated {
+                add_tokens(&mut record.input_tokens, next.input_tokens);
+                add_tokens(&mut record.output_tokens, next.output_tokens);
+                add_tokens(
+                    &mut record.cache_creation_input_tokens,
+                    next.cache_creation_input_tokens,
+                );
+                add_tokens(
+                    &mut record.cache_read_input_tokens,
+                    next.cache_read_input_tokens,
+                );
+            }
+        }
+        record
+    }
+}
+
+fn add_tokens(total: &mut Option<u32>, more: Option<u32>) {
+    if let Some(more) = more {
+        *total = Some(total.unwrap_or(0) + more);
+    }
+}
+
+/// Write `session_id` (in conversation order), or every session for
+/// [`ALL_SESSIONS`], as JSON lines. With `merge_adjacent`, consecutive
+/// messages of the same role become one record. Lines longer than
+/// `max_line_bytes` are skipped. Returns the number of records written.
+pub fn export_session<W: Write>(
+    session_id: &str,
+    pattern: Option<&str>,
+    merge_adjacent: bool,
+    max_line_bytes: usize,
+    writer: &mut W,
+) -> Result<usize> {
+    if session_id == ALL_SESSIONS {
+        return export_all(pattern, merge_adjacent, max_line_bytes, writer);
+    }
+
+    let messages = order_conversation(load_session(session_id, pattern, max_line_bytes)?);
+    let turns = group_turns(&messages, merge_adjacent);
+    for turn in &turns {
+        write_record(writer, turn)?;
+    }
+    Ok(turns.len())
+}
+
+/// Like [`export_session`], but each session is first stitched together from
+/// every file holding its messages (see [`SessionIndex`]): messages repeated in
+/// resumed files are written once and a session's records are in time order.
+/// For [`ALL_SESSIONS`], sessions follow each other in order of first appearance
+/// and summaries that belong to no session are left out.
+pub fn export_resolved_sessions<W: Write>(
+    session_id: &str,
+    pattern: Option<&str>,
+    merge_adjacent: bool,
+    max_line_bytes: usize,
+    writer: &mut W,
+) -> Result<usize> {
+    let files =
+        discover_claude_files(pattern).context("failed to discover Claude session files")?;
+    let index = SessionIndex::build(&files, max_line_bytes)?;
+    let session_ids = if session_id == ALL_SESSIONS {
+        index.session_ids().to_vec()
+    } else {
+        vec![session_id.to_string()]
+    };
+
+    let mut written = 0;
+    for session_id in &session_ids {
+        let messages = index.load(session_id)?;
+        let turns = group_turns(&messages, merge_adjacent);
+        for turn in &turns {
+            write_record(writer, turn)?;
+        }
+        written += turns.len();
+    }
+    Ok(written)
+}
+
+// Stream every parseable message of every file, in file and line order
+fn export_all<W: Write>(
+    pattern: Option<&str>,
+    merge_adjacent: bool,
+    max_line_bytes: usize,
+    writer: &mut W,
+) -> Result<usize> {
+    let files =
+        discover_claude_files(pattern).context("failed to discover Claude session files")?;
+
+    let mut written = 0;
+    for file in files {
+        written += export_file(&file, merge_adjacent, max_line_bytes, writer)?;
+    }
+    Ok(written)
+}
+
+fn export_file<W: Write>(
+    path: &Path,
+    merge_adjacent: bool,
+    max_line_bytes: usize,
+    writer: &mut W,
+) -> Result<usize> {
+    let mut reader = compression::open_session_reader(path)
+        .with_context(|| format!("failed to open file: {}", path.display()))?;
+    let mut line_buffer = Vec::with_capacity(16 * 1024);
+    let mut line_number = 0;
+
+    // Messages of the turn being built; only ever one without merging
+    let mut turn: Vec<SessionMessage> = Vec::new();
+    let mut written = 0;
+    while line_reader::read_line_within(
+        &mut reader,
+        &mut line_buffer,
+        max_line_bytes,
+        path,
+        &mut line_number,
+    )
+    .with_context(|| format!("failed to read line from {}", path.display()))?
+    {
+        let line = line_buffer.trim_ascii();
+        if line.is_empty() {
+            continue;
+        }
+        let Ok(message) = sonic_rs::from_slice::<SessionMessage>(line) else {
+            continue;
+        };
+        if turn
+            .last()
+            .is_some_and(|last| !merge_adjacent || !same_turn(last, &message))
+        {
+            write_record(writer, &turn)?;
+            written += 1;
+            turn.clear();
+        }
+        turn.push(message);
+    }
+    if !turn.is_empty() {
+        write_record(writer, &turn)?;
+        written += 1;
+    }
+    Ok(written)
+}
+
+fn write_record<W: Write>(writer: &mut W, turn: &[SessionMessage]) -> Result<()> {
+    serde_json::to_writer(&mut *writer, &ExportRecord::from_turn(turn))?;
+    writer.write_all(b"\n")?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::utils::line_reader::MAX_LINE_BYTES;
+    use std::fs::File;
+    use tempfile::tempdir;
+
+    const ASSISTANT: &str = r#"{"type":"assistant","message":{"id":"m","type":"message","role":"assistant","model":"claude-sonnet","content":[{"type":"text","text":"Listing files"},{"type":"tool_use","id":"toolu_1","name":"Bash","input":{"command":"ls"}}],"stop_reason":"tool_use","stop_sequence":null,"usage":{"input_tokens":12,"cache_creation_input_tokens":3,"cache_read_input_tokens":4,"output_tokens":5}},"uuid":"a1","timestamp":"2024-01-01T00:00:01Z","sessionId":"s1","parentUuid":"u1","isSidechain":false,"userType":"external","cwd":"/work","version":"1"}"#;
+    const USER: &str = r#"{"type":"user","message":{"role":"user","content":"show files"},"uuid":"u1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/work","version":"1"}"#;
+    const OTHER: &str = r#"{"type":"user","message":{"role":"user","content":"other session"},"uuid":"u2","timestamp":"2024-01-02T00:00:00Z","sessionId":"s2","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/work","version":"1"}"#;
+
+    #[test]
+    fn test_record_flattens_assistant_fields() {
+        let message: SessionMessage = serde_json::from_str(ASSISTANT).unwrap();
+        let record = ExportRecord::from_message(&message);
+
+        assert_eq!(record.message_type, "assistant");
+        assert_eq!(record.role, "assistant");
+        assert_eq!(record.model.as_deref(), Some("claude-sonnet"));
+        assert_eq!(record.parent_uuid.as_deref(), Some("u1"));
+        assert_eq!(record.input_tokens, Some(12));
+        assert_eq!(record.cache_read_input_tokens, Some(4));
+        assert_eq!(record.tool_names, vec!["Bash"]);
+        assert!(record.content_text.contains("Listing files"));
+    }
+
+    #[test]
+    fn test_records_share_one_schema() {
+        let user: SessionMessage = serde_json::from_str(USER).unwrap();
+        let summary: SessionMessage =
+            serde_json::from_str(r#"{"type":"summary","summary":"Files","leafUuid":"a1"}"#)
+                .unwrap();
+        let assistant: SessionMessage = serde_json::from_str(ASSISTANT).unwrap();
+
+        let keys = |message: &SessionMessage| -> Vec<String> {
+            let value = serde_json::to_value(ExportRecord::from_message(message)).unwrap();
+            value.as_object().unwrap().keys().cloned().collect()
+        };
+        assert_eq!(keys(&user), keys(&assistant));
+        assert_eq!(keys(&summary), keys(&assistant));
+
+        let record = ExportRecord::from_message(&user);
+        assert_eq!(record.model, None);
+        assert_eq!(record.output_tokens, None);
+    }
+
+    #[test]
+    fn test_export_session_and_all() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let mut file = File::create(temp_dir.path().join("session.jsonl"))?;
+        writeln!(file, "{ASSISTANT}\n{USER}\nnot json\n{OTHER}")?;
+        let pattern = format!("{}/*.jsonl", temp_dir.path().display());
+
+        let mut output = Vec::new();
+        assert_eq!(
+            export_session("s1", Some(&pattern), false, MAX_LINE_BYTES, &mut output)?,
+            2
+        );
+        let uuids: Vec<String> = String::from_utf8(output)?
+            .lines()
+            .map(|line| {
+                let value: serde_json::Value = serde_json::from_str(line).unwrap();
+                value["uuid"].as_str().unwrap().to_string()
+            })
+            .collect();
+        // Conversation order, not file order
+        assert_eq!(uuids, vec!["u1", "a1"]);
+
+        let mut output = Vec::new();
+        assert_eq!(
+            export_session(
+                ALL_SESSIONS,
+                Some(&pattern),
+                false,
+                MAX_LINE_BYTES,
+                &mut output
+            )?,
+            3
+        );
+        assert_eq!(String::from_utf8(output)?.lines().count(), 3);
+        Ok(())
+    }
+
+    #[test]
+    fn test_export_resolved_sessions() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let mut first = File::create(temp_dir.path().join("first.jsonl"))?;
+        writeln!(first, "{USER}\n{OTHER}")?;
+        // Resumed: the conversation so far is copied in before the reply
+        let mut resumed = File::create(temp_dir.path().join("resumed.jsonl"))?;
+        writeln!(resumed, "{USER}\n{ASSISTANT}")?;
+        let pattern = format!("{}/*.jsonl", temp_dir.path().display());
+
+        let mut output = Vec::new();
+        assert_eq!(
+            export_session(
+                ALL_SESSIONS,
+                Some(&pattern),
+                false,
+                MAX_LINE_BYTES,
+                &mut output
+            )?,
+            4
+        );
+        let mut output = Vec::new();
+        assert_eq!(
+            export_resolved_sessions(
+                ALL_SESSIONS,
+                Some(&pattern),
+                false,
+                MAX_LINE_BYTES,
+                &mut output
+            )?,
+            3
+        );
+        let mut output = Vec::new();
+        assert_eq!(
+            export_resolved_sessions("s1", Some(&pattern), false, MAX_LINE_BYTES, &mut output)?,
+            2
+        );
+        let uuids: Vec<String> = String::from_utf8(output)?
+            .lines()
+            .map(|line| {
+                let value: serde_json::Value = serde_json::from_str(line).unwrap();
+                value["uuid"].as_str().unwrap().to_string()
+            })
+            .collect();
+        assert_eq!(uuids, vec!["u1", "a1"]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_merge_adjacent_records() -> Result<()> {
+        // One response logged as two lines of the same request, then a second request
+        let chunk = |uuid: &str, request: &str, text: &str| {
+            ASSISTANT
+                .replace(r#""uuid":"a1""#, &format!(r#""uuid":"{uuid}""#))
+                .replace("Listing files", text)
+                .replace(
+                    r#""userType""#,
+                    &format!(r#""requestId":"{request}","userType""#),
+                )
+        };
+        let temp_dir = tempdir()?;
+        let mut file = File::create(temp_dir.path().join("session.jsonl"))?;
+        writeln!(
+            file,
+            "{USER}\n{}\n{}\n{}\n{OTHER}",
+            chunk("a1", "req_1", "first"),
+            chunk("a2", "req_1", "second"),
+            chunk("a3", "req_2", "third")
+        )?;
+        let pattern = format!("{}/*.jsonl", temp_dir.path().display());
+
+        let mut output = Vec::new();
+        assert_eq!(
+            export_session(
+                ALL_SESSIONS,
+                Some(&pattern),
+                true,
+                MAX_LINE_BYTES,
+                &mut output
+            )?,
+            3
+        );
+        let records: Vec<serde_json::Value> = String::from_utf8(output)?
+            .lines()
+            .map(|line| serde_json::from_str(line).unwrap())
+            .collect();
+        let merged = &records[1];
+        assert_eq!(merged["uuid"], "a1");
+        assert_eq!(
+            merged["content_text"],
+            "first\nBash: ls\nsecond\nBash: ls\nthird\nBash: ls"
+        );
+        assert_eq!(
+            merged["tool_names"],
+            serde_json::json!(["Bash", "Bash", "Bash"])
+        );
+        // req_1 is counted once
+        assert_eq!(merged["input_tokens"], 24);
+        assert_eq!(records[2]["uuid"], "u2");
+
+        let mut output = Vec::new();
+        assert_eq!(
+            export_session("s1", Some(&pattern), true, MAX_LINE_BYTES, &mut output)?,
+            2
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_export_skips_overlong_lines() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let mut file = File::create(temp_dir.path().join("session.jsonl"))?;
+        writeln!(file, "{USER}\n{ASSISTANT}\n{OTHER}")?;
+        let pattern = format!("{}/*.jsonl", temp_dir.path().display());
+
+        // ASSISTANT is the only line over the limit
+        let limit = USER.len().max(OTHER.len()) + 1;
+        assert!(ASSISTANT.len() >= limit);
+        let mut output = Vec::new();
+        assert_eq!(
+            export_session(ALL_SESSIONS, Some(&pattern), false, limit, &mut output)?,
+            2
+        );
+        let mut output = Vec::new();
+        assert_eq!(
+            export_resolved_sessions("s1", Some(&pattern), false, limit, &mut output)?,
+            1
+        );
+        Ok(())
+    }
+}
@@ -26,7 +26,7 @@ impl CacheService {
         };
 
         if needs_reload {
-            let file = std::fs::File::open(path)?;
+            let file = crate::utils::compression::open_session_file(path)?;
             let reader = std::io::BufReader::with_capacity(FILE_READ_BUFFER_SIZE, file);
             use std::io::BufRead;
 
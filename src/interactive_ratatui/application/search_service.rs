@@ -2,9 +2,12 @@ use crate::interactive_ratatui::domain::models::{SearchRequest, SearchResponse};
 use crate::query::condition::{QueryCondition, SearchResult};
 use crate::search::SmolEngine;
 use crate::search::engine::SearchEngineTrait;
-use crate::search::file_discovery::discover_claude_files;
+use crate::search::file_discovery::{discover_claude_files, exclude_files};
+use crate::utils::compression::open_session_file;
+use crate::utils::line_reader::lines;
 use crate::{SearchOptions, parse_query};
 use anyhow::Result;
+use std::io::Read;
 
 // Type alias for session data: (file_path, session_id, timestamp, message_count, first_message, preview_messages, summary)
 pub type SessionData = (
@@ -147,18 +150,25 @@ impl SearchService {
 
             let encoded_path = encode_project_path(&absolute_path);
             // Use wildcard to include related projects
-            let claude_project_dir = format!("~/.claude/projects/{encoded_path}*/*.jsonl");
+            let claude_project_dir =
+                format!("~/.claude/projects/{encoded_path}*/*.{{jsonl,jsonl.gz,jsonl.zst}}");
 
             discover_claude_files(Some(&claude_project_dir))?
         } else {
             // No filter, use all files
             discover_claude_files(None)?
         };
+        let files = exclude_files(files, &self.base_options.exclude)?;
 
-        // Find all session files
+        // Find all session files; one buffer is reused for every file and its
+        // lines are walked in place rather than split up front
+        let mut content = Vec::new();
         for path in files {
-            // Read first line to get session info
-            if let Ok(content) = std::fs::read_to_string(&path) {
+            content.clear();
+            if open_session_file(&path)
+                .and_then(|mut file| file.read_to_end(&mut content))
+                .is_ok()
+            {
                 let mut session_id = String::new();
                 let mut timestamp = String::new();
                 let mut message_count = 0;
@@ -167,8 +177,8 @@ impl SearchService {
                 let mut summary_message: Option<String> = None;
                 const MAX_PREVIEW_MESSAGES: usize = 5;
 
-                for line in content.lines() {
-                    if let Ok(json) = serde_json::from_str::<serde_json::Value>(line) {
+                for line in lines(&content) {
+                    if let Ok(json) = serde_json::from_slice::<serde_json::Value>(line) {
                         message_count += 1;
 
                         // First message - get session info
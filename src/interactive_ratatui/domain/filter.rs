@@ -32,15 +32,13 @@ impl SessionFilter {
         query: &str,
         role_filter: &Option<String>,
     ) -> Vec<usize> {
-        let query_lower = query.fast_to_lowercase();
-
         items
             .iter()
             .enumerate()
             .filter(|(_, item)| {
                 // Apply role filter first
                 if let Some(role) = role_filter
-                    && item.role.fast_to_lowercase() != role.fast_to_lowercase()
+                    && !item.role.eq_ignore_ascii_case(role)
                 {
                     return false;
                 }
@@ -49,8 +47,7 @@ impl SessionFilter {
                 if query.is_empty() {
                     true
                 } else {
-                    let search_text = item.to_search_text();
-                    search_text.fast_to_lowercase().contains(&query_lower)
+                    item.to_search_text().fast_contains_ignore_case(query)
                 }
             })
             .map(|(idx, _)| idx)
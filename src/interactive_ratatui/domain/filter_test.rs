@@ -18,6 +18,10 @@ mod tests {
             },
             cwd: "/test".to_string(),
             raw_json: None,
+            line_number: None,
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
         }
     }
 
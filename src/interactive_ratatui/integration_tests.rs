@@ -144,6 +144,10 @@ mod tests {
             },
             cwd: "/test".to_string(),
             raw_json: None,
+            line_number: None,
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
         });
 
         // Test session loading failure handling
@@ -242,6 +246,10 @@ mod tests {
             },
             cwd: "/test/project".to_string(),
             raw_json: None,
+            line_number: None,
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
         }
     }
 
@@ -910,6 +918,10 @@ mod tests {
                 },
                 cwd: "/test".to_string(),
                 raw_json: Some(r#"{"type":"user","message":{"content":"Hello"},"timestamp":"2024-01-01T00:00:00Z"}"#.to_string()),
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             },
             SearchResult {
                 file: "test.jsonl".to_string(),
@@ -925,6 +937,10 @@ mod tests {
                 },
                 cwd: "/test".to_string(),
                 raw_json: Some(r#"{"type":"assistant","message":{"content":"Hi"},"timestamp":"2024-01-01T00:01:00Z"}"#.to_string()),
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             },
         ];
         app.state.session.file_path = Some("test.jsonl".to_string());
@@ -1123,6 +1139,10 @@ mod tests {
             },
             cwd: "/test".to_string(),
             raw_json: None,
+            line_number: None,
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
         }];
 
         // Initially preview should be disabled
@@ -1,4 +1,4 @@
-use anyhow::{Context, Result};
+use anyhow::Result;
 use crossterm::{
     event::{self, KeyCode, KeyEvent, KeyEventKind, poll},
     execute,
@@ -881,83 +881,7 @@ impl InteractiveSearch {
     }
 
     fn copy_to_clipboard(&self, text: &str) -> Result<()> {
-        #[cfg(target_os = "macos")]
-        {
-            use std::process::Command;
-            let mut child = Command::new("pbcopy")
-                .stdin(std::process::Stdio::piped())
-                .spawn()
-                .context("Failed to spawn pbcopy")?;
-
-            if let Some(mut stdin) = child.stdin.take() {
-                use std::io::Write;
-                stdin
-                    .write_all(text.as_bytes())
-                    .context("Failed to write to pbcopy")?;
-            }
-
-            child.wait().context("Failed to wait for pbcopy")?;
-            Ok(())
-        }
-
-        #[cfg(target_os = "linux")]
-        {
-            use std::process::Command;
-            let mut child = Command::new("xclip")
-                .arg("-selection")
-                .arg("clipboard")
-                .stdin(std::process::Stdio::piped())
-                .spawn()
-                .context("Failed to spawn xclip")?;
-
-            if let Some(mut stdin) = child.stdin.take() {
-                use std::io::Write;
-                stdin
-                    .write_all(text.as_bytes())
-                    .context("Failed to write to xclip")?;
-            }
-
-            child.wait().context("Failed to wait for xclip")?;
-            Ok(())
-        }
-
-        #[cfg(target_os = "windows")]
-        {
-            use std::process::Command;
-            // Use PowerShell's Set-Clipboard with an explicit UTF-8 input
-            // encoding so non-ASCII text round-trips correctly. clip.exe
-            // would otherwise interpret stdin in the active OEM codepage and
-            // mangle multibyte characters.
-            let mut child = Command::new("powershell")
-                .args([
-                    "-NoProfile",
-                    "-NonInteractive",
-                    "-Command",
-                    "[Console]::InputEncoding = [System.Text.Encoding]::UTF8; \
-                     [Console]::In.ReadToEnd() | Set-Clipboard",
-                ])
-                .stdin(std::process::Stdio::piped())
-                .spawn()
-                .context("Failed to spawn powershell Set-Clipboard")?;
-
-            if let Some(mut stdin) = child.stdin.take() {
-                use std::io::Write;
-                stdin
-                    .write_all(text.as_bytes())
-                    .context("Failed to write to powershell Set-Clipboard")?;
-            }
-
-            child
-                .wait()
-                .context("Failed to wait for powershell Set-Clipboard")?;
-            Ok(())
-        }
-
-        #[cfg(not(any(target_os = "macos", target_os = "linux", target_os = "windows")))]
-        {
-            let _ = text;
-            Err(anyhow::anyhow!("Clipboard not supported on this platform"))
-        }
+        crate::utils::clipboard::copy_to_clipboard(text)
     }
 
     #[cfg(test)]
@@ -53,6 +53,10 @@ mod tests {
                 raw_json: Some(
                     r#"{"type":"user","message":{"content":"Test message 1"}}"#.to_string(),
                 ),
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             },
             SearchResult {
                 file: "test.jsonl".to_string(),
@@ -70,6 +74,10 @@ mod tests {
                 raw_json: Some(
                     r#"{"type":"assistant","message":{"content":"Test response 1"}}"#.to_string(),
                 ),
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             },
         ];
 
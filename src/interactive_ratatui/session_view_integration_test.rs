@@ -22,6 +22,10 @@ mod tests {
                 },
                 cwd: "/test".to_string(),
                 raw_json: Some(r#"{"type":"user","message":{"role":"user","content":"Hello Claude"}}"#.to_string()),
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             },
             SearchResult {
                 file: "/path/to/session.jsonl".to_string(),
@@ -37,6 +41,10 @@ mod tests {
                 },
                 cwd: "/test".to_string(),
                 raw_json: Some(r#"{"type":"assistant","message":{"role":"assistant","content":"Hello! How can I help you today?"}}"#.to_string()),
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             },
         ]
     }
@@ -57,6 +57,10 @@ fn test_message_handling() {
         },
         cwd: "/test".to_string(),
         raw_json: None,
+        line_number: None,
+        request_id: None,
+        parent_uuid: None,
+        is_sidechain: false,
     }];
 
     let command = state.update(Message::EnterMessageDetail);
@@ -84,6 +88,10 @@ fn test_search_filter() {
             },
             cwd: "/test".to_string(),
             raw_json: None,
+            line_number: None,
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
         },
         SearchResult {
             file: "test2.jsonl".to_string(),
@@ -99,6 +107,10 @@ fn test_search_filter() {
             },
             cwd: "/test".to_string(),
             raw_json: None,
+            line_number: None,
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
         },
     ];
 
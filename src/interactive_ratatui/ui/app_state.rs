@@ -704,6 +704,10 @@ impl AppState {
                         },
                         cwd: String::new(), // Not available from session viewer
                         raw_json: Some(raw_json), // Store full JSON
+                        line_number: None,
+                        request_id: None,
+                        parent_uuid: None,
+                        is_sidechain: false,
                     };
 
                     // If this is our first navigation, save the initial state
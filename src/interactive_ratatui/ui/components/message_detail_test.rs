@@ -24,6 +24,10 @@ mod tests {
             raw_json: Some(
                 r#"{"type":"user","message":{"content":"This is a test message"}}"#.to_string(),
             ),
+            line_number: None,
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
         }
     }
 
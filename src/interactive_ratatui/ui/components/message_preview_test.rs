@@ -21,6 +21,10 @@ mod tests {
             },
             cwd: "/test/path".to_string(),
             raw_json: None,
+            line_number: None,
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
         }
     }
 
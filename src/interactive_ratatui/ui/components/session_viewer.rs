@@ -702,6 +702,10 @@ mod tests {
                 },
                 cwd: "/path".to_string(),
                 raw_json: Some("{}".to_string()),
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             },
             SearchResult {
                 file: "/file.jsonl".to_string(),
@@ -717,6 +721,10 @@ mod tests {
                 },
                 cwd: "/path".to_string(),
                 raw_json: Some("{}".to_string()),
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             },
         ];
         viewer.set_results(results);
@@ -814,6 +822,10 @@ mod tests {
                 },
                 cwd: "/path".to_string(),
                 raw_json: Some("{}".to_string()),
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             },
             SearchResult {
                 file: "/file.jsonl".to_string(),
@@ -829,6 +841,10 @@ mod tests {
                 },
                 cwd: "/path".to_string(),
                 raw_json: Some("{}".to_string()),
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             },
         ];
         viewer.set_results(results);
@@ -864,6 +880,10 @@ mod tests {
             },
             cwd: "/path".to_string(),
             raw_json: Some("{}".to_string()),
+            line_number: None,
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
         }];
         viewer.set_results(results);
 
@@ -903,6 +923,10 @@ mod tests {
             },
             cwd: "/path".to_string(),
             raw_json: None,
+            line_number: None,
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
         }];
         viewer.set_results(results);
 
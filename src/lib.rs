@@ -1,18 +1,33 @@
+pub mod config;
 pub mod convert;
+pub mod doctor;
+pub mod export;
 pub mod interactive_ratatui;
 pub mod profiling;
 #[cfg(all(feature = "profiling", unix))]
 pub mod profiling_enhanced;
+pub mod projects;
 pub mod query;
+pub mod recent;
 pub mod schemas;
 pub mod search;
+pub mod server;
+pub mod session;
 pub mod stats;
+#[cfg(test)]
+mod test_fixtures;
+pub mod usage;
 pub mod utils;
+pub mod validate;
 
 pub use query::{QueryCondition, SearchOptions, SearchResult, parse_query};
 pub use schemas::{SessionMessage, ToolResult};
 pub use search::{
-    RayonEngine, SearchEngineTrait, SmolEngine, default_claude_pattern, discover_claude_files,
-    expand_tilde, format_search_result,
+    ContextWindow, CsvFormat, OutputTemplate, RayonEngine, SearchEngineTrait, SessionIndex,
+    SkipCounter, SmolEngine, ThreadIndex, ThreadNode, WorkerCount, auto_workers, collect_context,
+    collect_raw_context, collect_threads, default_claude_pattern, discover_claude_files,
+    discover_claude_files_cached, exclude_files, expand_tilde, format_compact_result,
+    format_context_result, format_search_plan, format_search_result, format_thread_node,
+    plan_search, read_file_list,
 };
-pub use stats::{Statistics, format_statistics};
+pub use stats::{Statistics, format_statistics, format_type_breakdown};
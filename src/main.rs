@@ -2,24 +2,45 @@
 #[global_allocator]
 static GLOBAL: mimalloc::MiMalloc = mimalloc::MiMalloc;
 
-use anyhow::Result;
+use anyhow::{Context, Result};
 #[cfg(all(feature = "profiling", unix))]
 use ccms::profiling_enhanced;
 use ccms::{
-    QueryCondition, RayonEngine, SearchEngineTrait, SearchOptions, SearchResult, SmolEngine,
-    Statistics,
+    CsvFormat, OutputTemplate, QueryCondition, RayonEngine, SearchEngineTrait, SearchOptions,
+    SearchResult, SkipCounter, SmolEngine, Statistics, WorkerCount, auto_workers, collect_context,
+    collect_raw_context, collect_threads, config,
     convert::{ConvertMode, ConvertRequest, convert_session_to_codex},
-    default_claude_pattern, format_search_result,
+    default_claude_pattern, discover_claude_files, discover_claude_files_cached, exclude_files,
+    format_compact_result, format_context_result, format_search_plan, format_search_result,
+    format_thread_node,
     interactive_ratatui::InteractiveSearch,
-    parse_query, profiling,
+    parse_query, plan_search, profiling,
+    query::MatchTarget,
+    read_file_list,
+    search::{
+        CachedSearch, CancelToken, CorpusStamp, FieldPath, LastRunStore, ResultCache,
+        format_file_header, format_grouped_result, format_window_match, group_by_file,
+        search_windows,
+    },
+    session::{MessageOrder, format_turns, group_turns, load_session, order_messages},
 };
 use chrono::{DateTime, Utc};
-use clap::{Args, Command, CommandFactory, Parser, Subcommand, ValueEnum};
+use clap::{Args, Command, CommandFactory, FromArgMatches, Parser, Subcommand, ValueEnum};
 use clap_complete::{Generator, Shell, generate};
 use parse_datetime::parse_datetime;
 use std::collections::HashMap;
-use std::io::{self, Write};
+use std::ffi::OsString;
+use std::fmt::Write as _;
+use std::io::{self, IsTerminal, Write};
 use std::path::PathBuf;
+use std::process::ExitCode;
+
+// Exit statuses follow grep: matches found, nothing matched, usage or I/O error
+const EXIT_MATCH: u8 = 0;
+const EXIT_NO_MATCH: u8 = 1;
+const EXIT_ERROR: u8 = 2;
+// As a shell reports a command ended by SIGINT
+const EXIT_INTERRUPTED: u8 = 130;
 
 #[derive(Parser)]
 #[command(
@@ -28,7 +49,8 @@ use std::path::PathBuf;
     about = "High-performance CLI for searching Claude session JSONL files",
     args_conflicts_with_subcommands = true,
     subcommand_precedence_over_arg = true,
-    long_about = None
+    long_about = None,
+    after_help = "Exit status is 0 if any message matched, 1 if none matched, and 2 if an error occurred."
 )]
 struct Cli {
     #[command(subcommand)]
@@ -37,18 +59,99 @@ struct Cli {
     /// Search query (supports literal, regex, AND/OR/NOT operators). If not provided, enters interactive mode.
     query: Option<String>,
 
-    /// File pattern to search (default: ~/.claude/projects/**/*.jsonl)
+    /// Literal term to search for; repeat to match messages containing any of the terms
+    #[arg(short = 'e', long = "term", value_name = "TERM")]
+    terms: Vec<String>,
+
+    /// File pattern to search (default: ~/.claude/projects/**/*.{jsonl,jsonl.gz,jsonl.zst})
     #[arg(short, long)]
     pattern: Option<String>,
 
-    /// Filter by message role (user, assistant, system, summary)
-    #[arg(short, long)]
+    /// Search the files listed in FILE, one path per line ("-" for stdin), instead of
+    /// discovering them; --project then only applies when given explicitly
+    #[arg(long, value_name = "FILE", conflicts_with = "pattern")]
+    files_from: Option<String>,
+
+    /// Filter by message role (user, assistant, system, summary; human and ai/claude are accepted too)
+    #[arg(short, long, value_parser = parse_role)]
     role: Option<String>,
 
+    /// Only search session summaries (same as --role summary)
+    #[arg(long, conflicts_with = "role")]
+    summaries_only: bool,
+
     /// Filter by session ID
     #[arg(short, long)]
     session_id: Option<String>,
 
+    /// Filter by message origin (`userType`, e.g. external); messages without one are excluded
+    #[arg(long, value_name = "TYPE")]
+    user_type: Option<String>,
+
+    /// Only messages with the tool call of this id or the tool result answering it
+    #[arg(long, value_name = "ID")]
+    tool_use_id: Option<String>,
+
+    /// Only messages with a failed tool call (a `tool_result` marked `is_error`)
+    #[arg(long)]
+    tool_errors: bool,
+
+    /// Only messages from this API request (`requestId`), e.g. to match server-side logs
+    #[arg(long, value_name = "ID")]
+    request_id: Option<String>,
+
+    /// Only messages whose text is at least this many characters long
+    #[arg(long, value_name = "CHARS")]
+    min_length: Option<usize>,
+
+    /// Only messages whose text is at most this many characters long
+    #[arg(long, value_name = "CHARS")]
+    max_length: Option<usize>,
+
+    /// Exclude meta messages and compaction summaries (isMeta / isCompactSummary)
+    #[arg(long)]
+    no_meta: bool,
+
+    /// Ignore assistant thinking blocks when matching and displaying messages
+    #[arg(long)]
+    no_thinking: bool,
+
+    /// Also match message content of an unexpected shape (e.g. an object instead of
+    /// text or blocks), as compact JSON
+    #[arg(long)]
+    include_unknown: bool,
+
+    /// Also match the text of toolUseResult payloads (command stdout/stderr, file
+    /// contents, diffs), which some tools record outside the message content
+    #[arg(long)]
+    include_tool_use_result: bool,
+
+    /// List results in on-disk order, by file and then by line, instead of newest first.
+    /// Files keep the order they are searched in (most recently modified first, or as
+    /// listed with --files-from), so output is the same run to run without sorting on
+    /// timestamps, e.g. for golden tests
+    #[arg(long)]
+    order_stable: bool,
+
+    /// Parse and match every line of every file instead of first skipping files whose
+    /// raw bytes can't hold a match. Slower, but exact; for checking result counts
+    #[arg(long)]
+    no_pre_filter: bool,
+
+    /// Report each content block (text, thinking, tool call, tool output) that matches
+    /// by itself as a separate result labeled with its kind
+    #[arg(long, conflicts_with_all = ["window", "agg"])]
+    per_segment: bool,
+
+    /// Match the query against this part of each message instead of its text: the
+    /// assistant's model, the session ID, the git branch, or all of them together
+    #[arg(long, value_enum, default_value = "content")]
+    match_field: MatchField,
+
+    /// Prefix lines that come from assistant thinking blocks with [thinking] in results
+    #[arg(long, conflicts_with = "no_thinking")]
+    highlight_thinking: bool,
+
     /// Jump directly to the latest message detail in the most recent session
     #[arg(long, conflicts_with_all = ["session_id", "latest_session"])]
     latest: bool,
@@ -61,10 +164,18 @@ struct Cli {
     #[arg(long)]
     message_id: Option<String>,
 
-    /// Maximum number of results to return
+    /// Maximum number of results to return, keeping the newest (0 for unlimited)
     #[arg(short = 'n', long, default_value = "200")]
     max_results: usize,
 
+    /// Stop once --max-results results are found instead of searching every file for the newest
+    #[arg(long, conflicts_with = "stats")]
+    first: bool,
+
+    /// Maximum number of results from any single session file (0 for unlimited)
+    #[arg(long, value_name = "N")]
+    max_per_file: Option<usize>,
+
     /// Filter messages before this timestamp (RFC3339 format)
     #[arg(long)]
     before: Option<String>,
@@ -73,10 +184,18 @@ struct Cli {
     #[arg(long)]
     after: Option<String>,
 
-    /// Filter messages since this time (Unix timestamp or relative time like "1 day ago")
+    /// Filter messages since this time (Unix timestamp, duration like "48h" or "7d", or relative time like "1 day ago")
     #[arg(long)]
     since: Option<String>,
 
+    /// Only messages newer than the message with this UUID (for paging through results)
+    #[arg(long, value_name = "UUID")]
+    after_uuid: Option<String>,
+
+    /// Only messages older than the message with this UUID (for paging through results)
+    #[arg(long, value_name = "UUID")]
+    before_uuid: Option<String>,
+
     /// Output format
     #[arg(short = 'f', long, value_enum, default_value = "text")]
     format: OutputFormat,
@@ -85,9 +204,13 @@ struct Cli {
     #[arg(long)]
     no_color: bool,
 
-    /// Enable verbose output
-    #[arg(short, long)]
-    verbose: bool,
+    /// Log diagnostics to stderr; repeat for more detail (-v info, -vv debug, -vvv trace)
+    #[arg(short, long, action = clap::ArgAction::Count)]
+    verbose: u8,
+
+    /// Suppress status banners and the timing footer, printing only results
+    #[arg(short, long, conflicts_with = "verbose")]
+    quiet: bool,
 
     /// Show query syntax help
     #[arg(long)]
@@ -97,14 +220,141 @@ struct Cli {
     #[arg(long)]
     full_text: bool,
 
+    /// Print each result on one line, `timestamp role file:line  snippet`, like grep
+    #[arg(
+        long,
+        conflicts_with_all = ["format", "raw", "template", "only_matching", "only_matching_group", "extract", "stats"]
+    )]
+    compact: bool,
+
+    /// Print each file's path once, with its results indented beneath it, like grep --group
+    #[arg(
+        long,
+        conflicts_with_all = ["format", "raw", "template", "only_matching", "only_matching_group", "extract", "stats", "compact"]
+    )]
+    group_by_file: bool,
+
+    /// Columns to fit --compact lines in (default: the terminal width, or no limit when not a terminal)
+    #[arg(long, value_name = "COLUMNS", requires = "compact")]
+    width: Option<usize>,
+
+    /// Match query terms approximately (tolerates typos)
+    #[arg(long)]
+    fuzzy: bool,
+
+    /// Maximum edit distance per term for --fuzzy (default: 0-2 depending on term length)
+    #[arg(long, requires = "fuzzy")]
+    fuzzy_max_edits: Option<usize>,
+
+    /// Match multi-word terms regardless of how the words are separated by whitespace
+    #[arg(long, conflicts_with = "fuzzy")]
+    phrase: bool,
+
+    /// Ignore how quotes, dashes, ellipses and spaces were typed (curly vs straight,
+    /// em dash vs hyphen) and compare text in Unicode NFC
+    #[arg(long, conflicts_with_all = ["fuzzy", "phrase"])]
+    normalize: bool,
+
+    /// Stop reading a file after this long (e.g. 30s), keeping the matches found in it
+    /// so far, so one huge or stalled file can't hold up a search. The deadline is
+    /// checked between lines: a single huge line or a read that blocks is not interrupted
+    #[arg(long, value_name = "DURATION", value_parser = parse_cache_ttl)]
+    file_timeout: Option<std::time::Duration>,
+
+    /// Cache the discovered file list on disk and reuse it for this long (e.g. 60s, 10m);
+    /// adding a project invalidates it early
+    #[arg(long, value_name = "DURATION", value_parser = parse_cache_ttl)]
+    file_cache_ttl: Option<std::time::Duration>,
+
+    /// Stop before searching when discovery finds more than N files (asks first on a
+    /// terminal), to catch patterns that match far more than intended
+    #[arg(long, value_name = "N")]
+    max_files: Option<usize>,
+
+    /// Reuse the results of an identical earlier search while none of the files it covered
+    /// changed (kept under ~/.cache/ccms/results)
+    #[arg(long)]
+    cache_results: bool,
+
+    /// Show messages that do NOT match the query (filters still apply)
+    #[arg(long)]
+    invert_match: bool,
+
+    /// Print NUM messages of trailing context after each match
+    #[arg(short = 'A', long)]
+    after_context: Option<usize>,
+
+    /// Print NUM messages of leading context before each match
+    #[arg(short = 'B', long)]
+    before_context: Option<usize>,
+
+    /// Print NUM messages of context around each match (same as -A NUM -B NUM)
+    #[arg(short = 'C', long)]
+    context: Option<usize>,
+
+    /// Show the chain of parent messages (up to the root) for each match
+    #[arg(long)]
+    thread: bool,
+
+    /// Suppress repeated copies of a message (e.g. from resumed sessions), keeping the earliest
+    #[arg(long)]
+    dedupe: bool,
+
     /// Show raw JSON of matched messages
     #[arg(long)]
     raw: bool,
 
+    /// Also copy the top result's full text (raw JSON with --raw) to the clipboard
+    #[arg(long)]
+    copy: bool,
+
+    /// Print each result with a template, e.g. "{timestamp}\t{file}:{line}\t{snippet}".
+    /// Fields: timestamp, type, role, uuid, session_id, file, line, cwd, content, snippet
+    #[arg(
+        long,
+        conflicts_with_all = ["format", "raw", "thread", "context", "after_context", "before_context"]
+    )]
+    template: Option<String>,
+
+    /// Print only the matched text, one match per line (like grep -o)
+    #[arg(
+        short = 'o',
+        long,
+        conflicts_with_all = ["format", "raw", "template", "thread", "context", "after_context", "before_context", "stats"]
+    )]
+    only_matching: bool,
+
+    /// Print only this capture group of each regex match (implies --only-matching)
+    #[arg(
+        long,
+        value_name = "N",
+        conflicts_with_all = ["format", "raw", "template", "thread", "context", "after_context", "before_context", "stats"]
+    )]
+    only_matching_group: Option<usize>,
+
+    /// Print one field of each matched message instead of its text, by a path into its JSON
+    /// such as ".message.usage.output_tokens" or ".message.content[0].text"
+    #[arg(
+        long,
+        value_name = "PATH",
+        conflicts_with_all = ["format", "raw", "template", "only_matching", "only_matching_group", "thread", "context", "after_context", "before_context", "stats"]
+    )]
+    extract: Option<String>,
+
+    /// Columns for -f csv, comma-separated (any template field;
+    /// default: timestamp,type,session_id,file,line,uuid,content)
+    #[arg(long, value_delimiter = ',', value_name = "FIELDS")]
+    csv_fields: Vec<String>,
+
     /// Filter by working directory (cwd) path
     #[arg(long = "project")]
     project_path: Option<String>,
 
+    /// Leave out files whose path, or a parent directory, matches this glob
+    /// (e.g. '*/archive/*'; repeatable)
+    #[arg(long, value_name = "GLOB", value_parser = parse_exclude_glob)]
+    exclude: Vec<String>,
+
     /// Generate profiling report (requires --features profiling)
     #[cfg(all(feature = "profiling", unix))]
     #[arg(long)]
@@ -118,19 +368,206 @@ struct Cli {
     #[arg(long = "completion", value_enum)]
     generator: Option<Shell>,
 
+    /// Files searched at once: a number, or "auto" to pick one from the number and size of files (default: one per CPU)
+    #[arg(long, value_name = "N|auto")]
+    workers: Option<WorkerCount>,
+
     /// Search engine to use
     #[arg(long, value_enum, default_value = "smol")]
     engine: EngineType,
 
+    /// Skip session files larger than this size (bytes, or with a K/M/G suffix like 100M)
+    #[arg(long, value_parser = parse_file_size)]
+    max_filesize: Option<u64>,
+
+    /// Skip (and count) lines longer than this (bytes, or with a K/M/G suffix), such as
+    /// a message holding a huge tool output
+    #[arg(
+        long,
+        global = true,
+        value_name = "SIZE",
+        default_value = "32M",
+        value_parser = parse_line_size
+    )]
+    max_line_bytes: usize,
+
+    /// Stop printing results once this much output has been written (bytes, or with a
+    /// K/M/G suffix like 10M); results are never cut in half
+    #[arg(long, value_name = "SIZE", value_parser = parse_file_size)]
+    limit_bytes: Option<u64>,
+
+    /// Show a "processed N/M files" progress line on stderr while searching
+    #[arg(long)]
+    progress: bool,
+
     /// Show only statistics
     #[arg(long)]
     stats: bool,
+
+    /// Match the query against the combined text of up to N consecutive messages of a
+    /// session, to find phrases split across turns (e.g. a question and its answer)
+    #[arg(
+        long,
+        value_name = "N",
+        value_parser = clap::value_parser!(u16).range(1..),
+        conflicts_with_all = ["stats", "agg", "raw", "template", "extract", "only_matching", "only_matching_group", "compact", "group_by_file", "thread", "context", "after_context", "before_context", "invert_match"]
+    )]
+    window: Option<u16>,
+
+    /// Instead of listing matches, sum a field over them: `tokens` totals input, output
+    /// and cache tokens per day
+    #[arg(
+        long,
+        value_enum,
+        value_name = "FIELD",
+        conflicts_with_all = ["stats", "raw", "template", "extract", "only_matching", "only_matching_group", "compact", "group_by_file", "thread", "context", "after_context", "before_context"]
+    )]
+    agg: Option<Aggregate>,
+
+    /// After the results, show how many matches each message type had
+    #[arg(long, conflicts_with = "stats")]
+    type_breakdown: bool,
+
+    /// List projects with their session and message counts, most recently active first
+    #[arg(long, conflicts_with = "stats")]
+    project_summary: bool,
+
+    /// List the N most recently modified sessions with their project, last activity and
+    /// what they were last about; no query needed
+    #[arg(long, value_name = "N", conflicts_with_all = ["stats", "project_summary"])]
+    recent: Option<usize>,
+
+    /// Check every line of the session files against the message schema and report
+    /// each line that doesn't fit as file:line and the problem; no query needed
+    #[arg(long, conflicts_with_all = ["stats", "project_summary", "recent"])]
+    validate: bool,
+
+    /// Only show matches newer than the last run of the same query over the same files
+    /// (or --after/--since, whichever is later); run times are kept under ~/.local/state/ccms
+    #[arg(long, conflicts_with_all = ["message_id", "latest", "latest_session", "project_summary", "recent", "validate"])]
+    since_last_run: bool,
+
+    /// Print the resolved pattern, the files that would be read and the options in effect, then exit without searching
+    #[arg(long, conflicts_with_all = ["message_id", "latest", "latest_session", "project_summary", "recent", "validate"])]
+    dry_run: bool,
 }
 
 #[derive(Debug, Subcommand)]
 enum CliCommand {
     /// Convert Claude session messages into Codex rollout format
     Convert(ConvertCommand),
+    /// Print a whole session as a readable conversation
+    Session(SessionCommand),
+    /// Write a session's messages as flat JSON lines for dataframe tools
+    Export(ExportCommand),
+    /// Print a content hash per session to spot conversations replayed elsewhere
+    Fingerprint(FingerprintCommand),
+    /// Serve a local HTTP search API (GET /search, /stats, /healthz)
+    Serve(ServeCommand),
+    /// Load every session file the way `serve` does and report how long it took
+    Warm(WarmCommand),
+    /// Check that session files can be found and read, without searching
+    Doctor(DoctorCommand),
+    /// Print the project path a ~/.claude/projects directory name stands for
+    DecodeDir(DecodeDirCommand),
+}
+
+#[derive(Debug, Args)]
+struct ServeCommand {
+    /// Address to listen on; ":PORT" listens on localhost only
+    #[arg(default_value = "127.0.0.1:8080")]
+    addr: String,
+
+    /// File pattern to search (default: ~/.claude/projects/**/*.{jsonl,jsonl.gz,jsonl.zst})
+    #[arg(short, long)]
+    pattern: Option<String>,
+
+    /// Start accepting requests right away and load files on the first one
+    #[arg(long)]
+    no_warm: bool,
+
+    /// Hold at most N parsed messages in memory, dropping the files searched
+    /// least recently first (default: keep every file)
+    #[arg(long, value_name = "N", value_parser = clap::value_parser!(u64).range(1..))]
+    cache_messages: Option<u64>,
+
+    /// Let pages from this origin (e.g. chrome-extension://ID) call the API and read
+    /// its responses; requests from any other page are refused
+    #[arg(long, value_name = "ORIGIN")]
+    allow_origin: Option<String>,
+}
+
+#[derive(Debug, Args)]
+struct WarmCommand {
+    /// File pattern to search (default: ~/.claude/projects/**/*.{jsonl,jsonl.gz,jsonl.zst})
+    #[arg(short, long)]
+    pattern: Option<String>,
+}
+
+#[derive(Debug, Args)]
+struct DecodeDirCommand {
+    /// Encoded directory names (e.g. -Users-me-src-app), or paths ending in one
+    #[arg(required = true, allow_hyphen_values = true)]
+    names: Vec<String>,
+}
+
+#[derive(Debug, Args)]
+struct DoctorCommand {
+    /// File pattern to check (default: ~/.claude/projects/**/*.{jsonl,jsonl.gz,jsonl.zst})
+    #[arg(short, long)]
+    pattern: Option<String>,
+
+    /// Disable colored output
+    #[arg(long)]
+    no_color: bool,
+}
+
+#[derive(Debug, Args)]
+struct FingerprintCommand {
+    /// File pattern to search (default: ~/.claude/projects/**/*.{jsonl,jsonl.gz,jsonl.zst})
+    #[arg(short, long)]
+    pattern: Option<String>,
+}
+
+#[derive(Debug, Args)]
+struct ExportCommand {
+    /// Session ID to export, or "all" for every session
+    session_id: String,
+
+    /// File pattern to search (default: ~/.claude/projects/**/*.{jsonl,jsonl.gz,jsonl.zst})
+    #[arg(short, long)]
+    pattern: Option<String>,
+
+    /// Combine consecutive messages of the same role into one record
+    #[arg(long)]
+    merge_adjacent: bool,
+
+    /// Stitch each session together from every file holding its messages,
+    /// dropping messages repeated by resumed sessions and ordering by time
+    #[arg(long)]
+    resolve_sessions: bool,
+}
+
+#[derive(Debug, Args)]
+struct SessionCommand {
+    /// Session ID to print
+    session_id: String,
+
+    /// File pattern to search (default: ~/.claude/projects/**/*.{jsonl,jsonl.gz,jsonl.zst})
+    #[arg(short, long)]
+    pattern: Option<String>,
+
+    /// Disable colored output
+    #[arg(long)]
+    no_color: bool,
+
+    /// Message order: as stored on disk, by timestamp, or replies after their parent
+    #[arg(long, value_enum, default_value = "thread")]
+    order: SessionOrder,
+
+    /// Show consecutive messages of the same role as one turn
+    #[arg(long)]
+    merge_adjacent: bool,
 }
 
 #[derive(Debug, Args)]
@@ -169,6 +606,50 @@ enum OutputFormat {
     Text,
     Json,
     JsonL,
+    Csv,
+}
+
+#[derive(Clone, Copy, Debug, PartialEq, ValueEnum)]
+enum SessionOrder {
+    File,
+    Time,
+    Thread,
+}
+
+impl From<SessionOrder> for MessageOrder {
+    fn from(order: SessionOrder) -> Self {
+        match order {
+            SessionOrder::File => MessageOrder::File,
+            SessionOrder::Time => MessageOrder::Time,
+            SessionOrder::Thread => MessageOrder::Thread,
+        }
+    }
+}
+
+#[derive(Clone, Copy, Debug, PartialEq, ValueEnum)]
+enum MatchField {
+    Content,
+    Model,
+    Session,
+    Branch,
+    All,
+}
+
+impl From<MatchField> for MatchTarget {
+    fn from(field: MatchField) -> Self {
+        match field {
+            MatchField::Content => MatchTarget::Content,
+            MatchField::Model => MatchTarget::Model,
+            MatchField::Session => MatchTarget::Session,
+            MatchField::Branch => MatchTarget::Branch,
+            MatchField::All => MatchTarget::All,
+        }
+    }
+}
+
+#[derive(Clone, Copy, Debug, PartialEq, ValueEnum)]
+enum Aggregate {
+    Tokens,
 }
 
 #[derive(Clone, Copy, Debug, ValueEnum)]
@@ -186,28 +667,93 @@ fn print_completions<G: Generator>(generator: G, cmd: &mut Command) {
     );
 }
 
-fn main() -> Result<()> {
-    let cli = Cli::parse();
+fn main() -> ExitCode {
+    match run() {
+        Ok(code) => code,
+        Err(e) => {
+            eprintln!("Error: {e:?}");
+            ExitCode::from(EXIT_ERROR)
+        }
+    }
+}
+
+// Save when a --since-last-run search started, so the next one starts there.
+// Not when `complete` is false: results are newest first, so the matches left
+// out by -n or --limit-bytes are older than the time that would be saved and no
+// later run would show them.
+fn remember_run(last_run: Option<&(LastRunStore, String, DateTime<Utc>)>, complete: bool) {
+    let Some((store, key, started)) = last_run else {
+        return;
+    };
+    if !complete {
+        eprintln!(
+            "⚠️  Not saving the time of this run for --since-last-run: not every new result was shown (see -n and --limit-bytes), so the next run shows them again"
+        );
+        return;
+    }
+    if let Err(e) = store.record(key, *started) {
+        eprintln!("⚠️  Could not save the time of this run for --since-last-run: {e:#}");
+    }
+}
+
+// Exit status for a finished search
+fn search_exit_code(found: bool) -> ExitCode {
+    ExitCode::from(if found { EXIT_MATCH } else { EXIT_NO_MATCH })
+}
+
+// Parse the command line, filling in flags it leaves unset from the config file
+fn parse_cli() -> Result<Cli> {
+    let args: Vec<OsString> = std::env::args_os().collect();
+    let command = Cli::command();
+    let matches = command.clone().get_matches_from(&args);
+
+    // Subcommands take no top-level flags, so the config does not apply
+    let config = match config::config_path() {
+        Some(path) if matches.subcommand().is_none() => config::load_config(&path)?
+            .map(|table| {
+                config::config_args(&table, &command, &matches)
+                    .with_context(|| format!("in config {}", path.display()))
+            })
+            .transpose()?,
+        _ => None,
+    };
+    let matches = match config {
+        Some(config_args) if !config_args.is_empty() => {
+            let mut full_args = args;
+            full_args.splice(1..1, config_args);
+            command.get_matches_from(full_args)
+        }
+        _ => matches,
+    };
+    Cli::from_arg_matches(&matches).map_err(|e| e.exit())
+}
+
+fn run() -> Result<ExitCode> {
+    let mut cli = parse_cli()?;
+    if cli.summaries_only {
+        cli.role = Some("summary".to_string());
+    }
 
     // Handle completion generation
     if let Some(generator) = cli.generator {
         let mut cmd = Cli::command();
         eprintln!("Generating completion file for {generator:?}...");
         print_completions(generator, &mut cmd);
-        return Ok(());
+        return Ok(ExitCode::SUCCESS);
     }
 
+    // Initialize tracing
+    profiling::init_tracing(cli.verbose);
+
     // Handle subcommands
     if let Some(command) = &cli.command {
-        return handle_cli_command(command, cli.verbose);
+        return handle_cli_command(command, cli.verbose > 0, cli.max_line_bytes)
+            .map(|()| ExitCode::SUCCESS);
     }
 
-    // Initialize tracing
-    profiling::init_tracing();
-
     if cli.help_query {
         print_query_help();
-        return Ok(());
+        return Ok(ExitCode::SUCCESS);
     }
 
     // Initialize profiler if requested
@@ -231,15 +777,19 @@ fn main() -> Result<()> {
             Ok(dt) => Some(dt),
             Err(e) => {
                 eprintln!("Error parsing --since: {e}");
-                std::process::exit(1);
+                return Ok(ExitCode::from(EXIT_ERROR));
             }
         }
     } else {
         cli.after.clone()
     };
 
-    // Set default project_path to current directory if not specified
+    // Set default project_path to current directory if not specified; an explicit
+    // file list is searched as given
     let project_path = cli.project_path.clone().or_else(|| {
+        if cli.files_from.is_some() {
+            return None;
+        }
         std::env::current_dir()
             .ok()
             .and_then(|path| path.to_str().map(|s| s.to_string()))
@@ -248,6 +798,165 @@ fn main() -> Result<()> {
     // Get pattern
     let default_pattern = default_claude_pattern();
     let pattern = cli.pattern.as_deref().unwrap_or(&default_pattern);
+    let listed_files = match &cli.files_from {
+        Some(list) => match read_listed_files(list, cli.quiet) {
+            Ok(files) => Some(files),
+            Err(e) => {
+                eprintln!("Error: {e:#}");
+                return Ok(ExitCode::from(EXIT_ERROR));
+            }
+        },
+        None => None,
+    };
+    // Files the search pattern finds (or --files-from lists), less any --exclude matches
+    let discover_files = || {
+        match &listed_files {
+            Some(files) => Ok(files.clone()),
+            None => discover_claude_files_cached(Some(pattern), cli.file_cache_ttl),
+        }
+        .and_then(|files| exclude_files(files, &cli.exclude))
+    };
+    let no_files_found = || match &cli.files_from {
+        Some(list) => eprintln!("No files to search in {list}"),
+        None => eprintln!("No files found matching pattern: {pattern}"),
+    };
+
+    // Project overview: every project unless --project narrows it down
+    if cli.project_summary {
+        let mut files = discover_files()?;
+        if let Some(project) = &cli.project_path {
+            files.retain(|path| {
+                ccms::utils::path_encoding::file_belongs_to_project(
+                    &path.to_string_lossy(),
+                    project,
+                )
+            });
+        }
+        let projects = ccms::projects::summarize_projects(&files, cli.max_line_bytes)?;
+        print!(
+            "{}",
+            ccms::projects::format_project_summaries(&projects, !cli.no_color)
+        );
+        return Ok(search_exit_code(!projects.is_empty()));
+    }
+
+    // Recent sessions: across every project unless --project narrows it down
+    if let Some(count) = cli.recent {
+        let mut files = discover_files()?;
+        if let Some(project) = &cli.project_path {
+            files.retain(|path| {
+                ccms::utils::path_encoding::file_belongs_to_project(
+                    &path.to_string_lossy(),
+                    project,
+                )
+            });
+        }
+        let sessions = ccms::recent::recent_sessions(&files, count)?;
+        print!(
+            "{}",
+            ccms::recent::format_recent_sessions(&sessions, !cli.no_color)
+        );
+        return Ok(search_exit_code(!sessions.is_empty()));
+    }
+
+    // Schema check: exits 1 when any line doesn't fit, like a linter
+    if cli.validate {
+        let mut files = discover_files()?;
+        if let Some(project) = &cli.project_path {
+            files.retain(|path| {
+                ccms::utils::path_encoding::file_belongs_to_project(
+                    &path.to_string_lossy(),
+                    project,
+                )
+            });
+        }
+        if files.is_empty() {
+            no_files_found();
+            return Ok(ExitCode::from(EXIT_ERROR));
+        }
+        let report = ccms::validate::validate_files(&files, cli.max_line_bytes);
+        print!("{}", ccms::validate::format_problems(&report.problems));
+        if report.problems.is_empty() {
+            eprintln!(
+                "All {} line(s) in {} file(s) match the schema",
+                report.lines, report.files
+            );
+            return Ok(ExitCode::from(EXIT_MATCH));
+        }
+        eprintln!(
+            "{} problem(s) in {} of {} line(s) in {} file(s)",
+            report.problems.len(),
+            report.invalid_lines(),
+            report.lines,
+            report.files
+        );
+        return Ok(ExitCode::from(EXIT_NO_MATCH));
+    }
+
+    // Resolve --after-uuid / --before-uuid to the anchor's timestamp, excluding
+    // the anchor itself, and keep whichever bound is stricter
+    let mut parsed_after = parsed_after;
+    let mut before = cli.before.clone();
+    for (flag, uuid, direction) in [
+        ("--after-uuid", &cli.after_uuid, 1),
+        ("--before-uuid", &cli.before_uuid, -1),
+    ] {
+        let Some(uuid) = uuid else {
+            continue;
+        };
+        let Some(timestamp) =
+            find_message_timestamp(pattern, discover_files()?, project_path.clone(), uuid)?
+        else {
+            eprintln!("Error: message '{uuid}' given to {flag} not found");
+            return Ok(ExitCode::from(EXIT_ERROR));
+        };
+        let Some(bound) = shift_timestamp(&timestamp, direction) else {
+            eprintln!("Error: message '{uuid}' given to {flag} has no usable timestamp");
+            return Ok(ExitCode::from(EXIT_ERROR));
+        };
+        if direction > 0 {
+            parsed_after = Some(stricter_bound(
+                parsed_after,
+                bound,
+                std::cmp::Ordering::Greater,
+            ));
+        } else {
+            before = Some(stricter_bound(before, bound, std::cmp::Ordering::Less));
+        }
+    }
+
+    // --since-last-run: matches after the last run of this query, if it ran before
+    let last_run = if cli.since_last_run {
+        if cli.query.as_deref().unwrap_or("").is_empty() && cli.terms.is_empty() {
+            eprintln!("Error: --since-last-run needs a query to remember");
+            return Ok(ExitCode::from(EXIT_ERROR));
+        }
+        let Some(store) = LastRunStore::in_default_dir() else {
+            eprintln!("Error: --since-last-run found no home directory to keep run times in");
+            return Ok(ExitCode::from(EXIT_ERROR));
+        };
+        // The same query over other files is a different standing query
+        let key = [
+            pattern,
+            project_path.as_deref().unwrap_or_default(),
+            cli.query.as_deref().unwrap_or_default(),
+        ]
+        .into_iter()
+        .chain(cli.terms.iter().map(String::as_str))
+        .collect::<Vec<_>>()
+        .join("\n");
+        if let Some(last) = store.last_run(&key) {
+            tracing::info!("Last run of this query started at {last}");
+            parsed_after = Some(stricter_bound(
+                parsed_after,
+                last,
+                std::cmp::Ordering::Greater,
+            ));
+        }
+        Some((store, key, Utc::now()))
+    } else {
+        None
+    };
 
     // Handle --message-id search
     if let Some(message_id) = &cli.message_id {
@@ -262,13 +971,38 @@ fn main() -> Result<()> {
             message_id: Some(message_id.clone()),
             before: None,
             after: None,
-            verbose: cli.verbose,
+            verbose: cli.verbose > 0,
             project_path: None,
+            progress: false,
+            dedupe: false,
+            max_file_size: None,
+            user_type: None,
+            exclude_meta: false,
+            include_thinking: true,
+            tool_use_id: None,
+            file_cache_ttl: cli.file_cache_ttl,
+            request_id: None,
+            min_length: None,
+            max_length: None,
+            tool_errors: false,
+            max_per_file: None,
+            workers: None,
+            stop_early: false,
+            exclude: Vec::new(),
+            include_unknown: false,
+            files: None,
+            label_thinking: false,
+            match_target: MatchTarget::Content,
+            keep_raw_json: false,
+            include_tool_use_result: false,
+            per_segment: false,
+            pre_filter: !cli.no_pre_filter,
+            max_line_bytes: cli.max_line_bytes,
+            order_stable: cli.order_stable,
+            file_timeout: cli.file_timeout,
         };
 
-        if cli.verbose {
-            eprintln!("Searching for message ID: {message_id}");
-        }
+        tracing::info!("Searching for message ID: {message_id}");
 
         // Execute search
         let engine = SmolEngine::new(options);
@@ -276,25 +1010,23 @@ fn main() -> Result<()> {
 
         if results.is_empty() {
             eprintln!("Message with ID '{message_id}' not found.");
-            std::process::exit(1);
+            return Ok(ExitCode::from(EXIT_NO_MATCH));
         }
 
         // Pretty print the message
         let result = &results[0];
         print_message_details(result, !cli.no_color);
 
-        if cli.verbose {
-            eprintln!("\n⏱️  Search completed in {}ms", duration.as_millis());
-        }
+        tracing::info!("Search completed in {}ms", duration.as_millis());
 
-        return Ok(());
+        return Ok(ExitCode::SUCCESS);
     }
 
     // Handle --latest mode
     if cli.latest {
-        if cli.query.as_ref().map(|q| !q.is_empty()).unwrap_or(false) {
+        if cli.query.as_ref().map(|q| !q.is_empty()).unwrap_or(false) || !cli.terms.is_empty() {
             eprintln!("Error: --latest cannot be used with a search query");
-            std::process::exit(1);
+            return Ok(ExitCode::from(EXIT_ERROR));
         }
 
         let options = SearchOptions {
@@ -302,22 +1034,49 @@ fn main() -> Result<()> {
             role: cli.role,
             session_id: None,
             message_id: None,
-            before: cli.before,
+            before: before.clone(),
             after: parsed_after.clone(),
-            verbose: cli.verbose,
+            verbose: cli.verbose > 0,
             project_path: project_path.clone(),
+            progress: false,
+            dedupe: false,
+            max_file_size: cli.max_filesize,
+            user_type: cli.user_type,
+            exclude_meta: cli.no_meta,
+            include_thinking: !cli.no_thinking,
+            tool_use_id: cli.tool_use_id,
+            file_cache_ttl: cli.file_cache_ttl,
+            request_id: cli.request_id,
+            min_length: cli.min_length,
+            max_length: cli.max_length,
+            tool_errors: cli.tool_errors,
+            max_per_file: cli.max_per_file,
+            workers: cli.workers.and_then(WorkerCount::fixed),
+            stop_early: false,
+            exclude: cli.exclude.clone(),
+            include_unknown: cli.include_unknown,
+            files: listed_files.clone(),
+            label_thinking: cli.highlight_thinking,
+            match_target: cli.match_field.into(),
+            keep_raw_json: false,
+            include_tool_use_result: cli.include_tool_use_result,
+            per_segment: false,
+            pre_filter: !cli.no_pre_filter,
+            max_line_bytes: cli.max_line_bytes,
+            order_stable: cli.order_stable,
+            file_timeout: cli.file_timeout,
         };
 
         let mut interactive = InteractiveSearch::new(options);
         interactive.set_start_latest_message_detail(true);
-        return interactive.run(pattern);
+        return interactive.run(pattern).map(|()| ExitCode::SUCCESS);
     }
 
     // Handle --latest-session mode
     if cli.latest_session {
-        if cli.query.as_ref().map(|q| !q.is_empty()).unwrap_or(false) {
+        if cli.query.as_ref().map(|q| !q.is_empty()).unwrap_or(false) || !cli.terms.is_empty() {
             eprintln!("Error: --latest-session cannot be used with a search query");
-            std::process::exit(1);
+            return Ok(ExitCode::from(EXIT_ERROR));
         }
 
         let options = SearchOptions {
@@ -325,19 +1084,54 @@ fn main() -> Result<()> {
             role: cli.role,
             session_id: None,
             message_id: None,
-            before: cli.before,
+            before: before.clone(),
             after: parsed_after.clone(),
-            verbose: cli.verbose,
+            verbose: cli.verbose > 0,
             project_path: project_path.clone(),
+            progress: false,
+            dedupe: false,
+            max_file_size: cli.max_filesize,
+            user_type: cli.user_type,
+            exclude_meta: cli.no_meta,
+            include_thinking: !cli.no_thinking,
+            tool_use_id: cli.tool_use_id,
+            file_cache_ttl: cli.file_cache_ttl,
+            request_id: cli.request_id,
+            min_length: cli.min_length,
+            max_length: cli.max_length,
+            tool_errors: cli.tool_errors,
+            max_per_file: cli.max_per_file,
+            workers: cli.workers.and_then(WorkerCount::fixed),
+            stop_early: false,
+            exclude: cli.exclude.clone(),
+            include_unknown: cli.include_unknown,
+            files: listed_files.clone(),
+            label_thinking: cli.highlight_thinking,
+            match_target: cli.match_field.into(),
+            keep_raw_json: false,
+            include_tool_use_result: cli.include_tool_use_result,
+            per_segment: false,
+            pre_filter: !cli.no_pre_filter,
+            max_line_bytes: cli.max_line_bytes,
+            order_stable: cli.order_stable,
+            file_timeout: cli.file_timeout,
         };
 
         let mut interactive = InteractiveSearch::new(options);
         interactive.set_start_latest(true);
-        return interactive.run(pattern);
+        return interactive.run(pattern).map(|()| ExitCode::SUCCESS);
     }
 
-    // Interactive mode when no query provided or query is empty (but not when --stats is used)
+    // Interactive mode when no query provided or query is empty (but not when --stats or --agg
+    // is used, or --tool-use-id / --request-id / --tool-errors already say what to look for)
     if !cli.stats
+        && cli.agg.is_none()
+        && !cli.dry_run
+        && cli.terms.is_empty()
+        && cli.tool_use_id.is_none()
+        && !cli.tool_errors
+        && !cli.summaries_only
+        && cli.request_id.is_none()
         && (cli.query.is_none() || cli.query.as_ref().map(|s| s.is_empty()).unwrap_or(false))
     {
         let options = SearchOptions {
@@ -345,40 +1139,158 @@ fn main() -> Result<()> {
             role: cli.role,
             session_id: cli.session_id,
             message_id: None,
-            before: cli.before,
+            before: before.clone(),
             after: parsed_after.clone(),
-            verbose: cli.verbose,
+            verbose: cli.verbose > 0,
             project_path: project_path.clone(),
+            progress: false,
+            dedupe: false,
+            max_file_size: cli.max_filesize,
+            user_type: cli.user_type,
+            exclude_meta: cli.no_meta,
+            include_thinking: !cli.no_thinking,
+            tool_use_id: cli.tool_use_id,
+            file_cache_ttl: cli.file_cache_ttl,
+            request_id: cli.request_id,
+            min_length: cli.min_length,
+            max_length: cli.max_length,
+            tool_errors: cli.tool_errors,
+            max_per_file: cli.max_per_file,
+            workers: cli.workers.and_then(WorkerCount::fixed),
+            stop_early: false,
+            exclude: cli.exclude.clone(),
+            include_unknown: cli.include_unknown,
+            files: listed_files.clone(),
+            label_thinking: cli.highlight_thinking,
+            match_target: cli.match_field.into(),
+            keep_raw_json: false,
+            include_tool_use_result: cli.include_tool_use_result,
+            per_segment: false,
+            pre_filter: !cli.no_pre_filter,
+            max_line_bytes: cli.max_line_bytes,
+            order_stable: cli.order_stable,
+            file_timeout: cli.file_timeout,
         };
 
         let mut interactive = InteractiveSearch::new(options);
-        return interactive.run(pattern);
+        return interactive.run(pattern).map(|()| ExitCode::SUCCESS);
     }
 
     // Regular search mode - query is provided (or empty string for --stats)
     let query_str = cli.query.unwrap_or_else(String::new);
 
     // Parse the query (empty query for --stats means match all)
-    let query = if cli.stats && query_str.is_empty() {
+    let parsed_query = if query_str.is_empty() {
+        None
+    } else {
+        match parse_query(&query_str) {
+            Ok(q) => Some(q),
+            Err(e) => {
+                eprintln!("Error parsing query: {e}");
+                eprintln!("Use --help-query for query syntax help");
+                return Ok(ExitCode::from(EXIT_ERROR));
+            }
+        }
+    };
+
+    // Repeated -e/--term values match if any of them occurs literally
+    let terms_query = (!cli.terms.is_empty()).then(|| QueryCondition::Or {
+        conditions: cli
+            .terms
+            .iter()
+            .map(|term| QueryCondition::Literal {
+                pattern: term.clone(),
+                case_sensitive: false,
+            })
+            .collect(),
+    });
+
+    let query = match (parsed_query, terms_query) {
+        (Some(q), Some(terms)) => QueryCondition::And {
+            conditions: vec![q, terms],
+        },
+        (Some(q), None) | (None, Some(q)) => q,
         // Empty query for stats: match everything
-        QueryCondition::Literal {
+        (None, None) => QueryCondition::Literal {
             pattern: String::new(),
             case_sensitive: false,
+        },
+    };
+    let query = if cli.fuzzy {
+        query.into_fuzzy(cli.fuzzy_max_edits)
+    } else if cli.phrase {
+        query.into_phrase()
+    } else if cli.normalize {
+        query.into_normalized()
+    } else {
+        query
+    };
+    // Inverting the whole query keeps role, session and time filters as they are
+    let query = if cli.invert_match {
+        QueryCondition::Not {
+            condition: Box::new(query),
         }
     } else {
-        match parse_query(&query_str) {
-            Ok(q) => q,
+        query
+    };
+
+    // A capture group needs a regex in the query that has that many groups
+    if let Some(group) = cli.only_matching_group {
+        match query.capture_group_count() {
+            None => {
+                eprintln!("Error: --only-matching-group needs a /regex/ in the query");
+                return Ok(ExitCode::from(EXIT_ERROR));
+            }
+            Some(groups) if group > groups => {
+                eprintln!(
+                    "Error: --only-matching-group {group} is out of range (highest capture group in the query: {groups})"
+                );
+                return Ok(ExitCode::from(EXIT_ERROR));
+            }
+            Some(_) => {}
+        }
+    }
+
+    // Validate the output template before searching
+    let template = match cli
+        .template
+        .as_deref()
+        .map(OutputTemplate::parse)
+        .transpose()
+    {
+        Ok(template) => template,
+        Err(e) => {
+            eprintln!("Error parsing --template: {e}");
+            return Ok(ExitCode::from(EXIT_ERROR));
+        }
+    };
+
+    let extract = match cli.extract.as_deref().map(FieldPath::parse).transpose() {
+        Ok(extract) => extract,
+        Err(e) => {
+            eprintln!("Error parsing --extract: {e}");
+            return Ok(ExitCode::from(EXIT_ERROR));
+        }
+    };
+
+    let csv = match cli.format {
+        OutputFormat::Csv => match CsvFormat::new(&cli.csv_fields) {
+            Ok(csv) => Some(csv),
             Err(e) => {
-                eprintln!("Error parsing query: {e}");
-                eprintln!("Use --help-query for query syntax help");
-                std::process::exit(1);
+                eprintln!("Error parsing --csv-fields: {e}");
+                return Ok(ExitCode::from(EXIT_ERROR));
             }
+        },
+        _ if !cli.csv_fields.is_empty() => {
+            eprintln!("Error: --csv-fields requires --format csv");
+            return Ok(ExitCode::from(EXIT_ERROR));
         }
+        _ => None,
     };
 
     // Create search options
-    let options = SearchOptions {
-        max_results: if cli.stats {
+    let mut options = SearchOptions {
+        max_results: if cli.stats || cli.agg.is_some() {
             None // Don't limit results when calculating statistics
         } else {
             Some(cli.max_results)
@@ -386,108 +1298,615 @@ fn main() -> Result<()> {
         role: cli.role,
         session_id: cli.session_id,
         message_id: None,
-        before: cli.before,
+        before,
         after: parsed_after,
-        verbose: cli.verbose,
+        verbose: cli.verbose > 0,
         project_path,
+        progress: cli.progress && !cli.quiet,
+        dedupe: cli.dedupe,
+        max_file_size: cli.max_filesize,
+        user_type: cli.user_type,
+        exclude_meta: cli.no_meta,
+        include_thinking: !cli.no_thinking,
+        tool_use_id: cli.tool_use_id,
+        file_cache_ttl: cli.file_cache_ttl,
+        request_id: cli.request_id,
+        min_length: cli.min_length,
+        max_length: cli.max_length,
+        tool_errors: cli.tool_errors,
+        max_per_file: cli.max_per_file,
+        workers: cli.workers.and_then(WorkerCount::fixed),
+        stop_early: cli.first,
+        exclude: cli.exclude.clone(),
+        include_unknown: cli.include_unknown,
+        files: listed_files.clone(),
+        label_thinking: cli.highlight_thinking,
+        match_target: cli.match_field.into(),
+        keep_raw_json: cli.raw || cli.extract.is_some() || cli.agg.is_some(),
+        include_tool_use_result: cli.include_tool_use_result,
+        per_segment: cli.per_segment,
+        pre_filter: !cli.no_pre_filter,
+        max_line_bytes: cli.max_line_bytes,
+        order_stable: cli.order_stable,
+        file_timeout: cli.file_timeout,
     };
 
-    if cli.verbose {
-        eprintln!("Searching in: {pattern}");
-        eprintln!("Query: {query:?}");
+    tracing::info!("Searching in: {pattern}");
+    tracing::debug!("Query: {query:?}");
+
+    // Files are discovered once, before any is read: the count is reported and
+    // checked against --max-files, --dry-run and --workers auto plan from the
+    // list, and the engine searches it instead of walking again
+    let files = discover_files()?;
+    if !cli.dry_run {
+        if let Some(max_files) = cli.max_files
+            && files.len() > max_files
+            && !confirm_many_files(files.len(), max_files)?
+        {
+            eprintln!(
+                "Error: {} files found, more than --max-files {max_files}; narrow the pattern or raise the limit",
+                files.len()
+            );
+            return Ok(ExitCode::from(EXIT_ERROR));
+        }
+        if !cli.quiet && !files.is_empty() {
+            eprintln!("Searching {} files", files.len());
+        }
     }
+    options.files = Some(files.clone());
 
-    // Debug: only search specific file
-    let debug_file = "/Users/masatomokusaka/.claude/projects/-Users-masatomokusaka-src-github-com-mkusaka-bookmark-agent/9ca2db47-82d6-4da7-998e-3d7cd28ce5b5.jsonl";
-    let pattern_to_use = if std::env::var("DEBUG_SINGLE_FILE").is_ok() {
-        eprintln!("DEBUG: Searching only {debug_file}");
-        debug_file
+    let auto_workers_requested = cli.workers == Some(WorkerCount::Auto);
+    let plan = if cli.dry_run || auto_workers_requested {
+        Some(plan_search(&files, &options))
     } else {
-        pattern
+        None
     };
+    if auto_workers_requested && let Some(plan) = &plan {
+        options.workers = Some(auto_workers(
+            plan.files.len(),
+            plan.total_bytes,
+            num_cpus::get(),
+        ));
+    }
+    if let Some(workers) = options.workers {
+        tracing::info!(
+            "Using {workers} workers{}",
+            if auto_workers_requested {
+                " (auto)"
+            } else {
+                ""
+            }
+        );
+    }
 
-    // Execute search
-    if cli.verbose {
-        eprintln!(
-            "Using {} engine",
-            match cli.engine {
-                EngineType::Smol => "Smol",
-                EngineType::Rayon => "Rayon",
+    // --dry-run stops after file discovery, before any session file is read
+    if cli.dry_run
+        && let Some(plan) = plan
+    {
+        let (engine, default_workers) = match cli.engine {
+            EngineType::Smol => {
+                // Reads are spread over smol's blocking pool, sized by SmolEngine::new
+                let workers = std::env::var("BLOCKING_MAX_THREADS")
+                    .ok()
+                    .and_then(|threads| threads.parse().ok())
+                    .unwrap_or_else(num_cpus::get);
+                ("smol", workers)
             }
+            EngineType::Rayon => ("rayon", rayon::current_num_threads()),
+        };
+        let workers = options.workers.unwrap_or(default_workers);
+        let source = match &cli.files_from {
+            Some(list) => format!("{list} (--files-from)"),
+            None => pattern.to_string(),
+        };
+        print!(
+            "{}",
+            format_search_plan(&source, &plan, &options, engine, workers)
         );
+        if !query_str.is_empty() || !cli.terms.is_empty() {
+            println!("{:<9} {query:?}", "Query:");
+        }
+        if plan.files.is_empty() {
+            no_files_found();
+            return Ok(ExitCode::from(EXIT_ERROR));
+        }
+        return Ok(ExitCode::SUCCESS);
+    }
+
+    // Corpus statistics (--stats without a query) only count messages, no search needed
+    if cli.stats && query_str.is_empty() && cli.terms.is_empty() {
+        let start = std::time::Instant::now();
+        if !files.iter().any(|path| path.is_file()) {
+            no_files_found();
+            return Ok(ExitCode::from(EXIT_ERROR));
+        }
+
+        let skipped = SkipCounter::new();
+        let stats = ccms::stats::collect_corpus_statistics(&files, &options, &skipped);
+        println!("{}", ccms::stats::format_statistics(&stats, !cli.no_color));
+
+        if !cli.quiet {
+            eprintln!(
+                "\n⏱️  Scanned {} files in {}ms",
+                files.len(),
+                start.elapsed().as_millis()
+            );
+            for warning in skip_warnings(&skipped) {
+                eprintln!("⚠️  {warning}");
+            }
+        }
+        return Ok(search_exit_code(stats.total_messages > 0));
+    }
+
+    // Token totals without a query only need each message's usage, no search
+    if cli.agg == Some(Aggregate::Tokens) && query_str.is_empty() && cli.terms.is_empty() {
+        if !files.iter().any(|path| path.is_file()) {
+            no_files_found();
+            return Ok(ExitCode::from(EXIT_ERROR));
+        }
+        let skipped = SkipCounter::new();
+        let usage = ccms::usage::collect_corpus_token_usage(&files, &options, &skipped);
+        print_token_usage(&usage, cli.format, !cli.no_color)?;
+        if !cli.quiet {
+            for warning in skip_warnings(&skipped) {
+                eprintln!("⚠️  {warning}");
+            }
+        }
+        return Ok(search_exit_code(usage.total.messages > 0));
+    }
+
+    // --window matches across consecutive messages, which the engines never see together
+    if let Some(size) = cli.window {
+        if query_str.is_empty() && cli.terms.is_empty() {
+            eprintln!("Error: --window needs a query");
+            return Ok(ExitCode::from(EXIT_ERROR));
+        }
+        if matches!(cli.format, OutputFormat::Csv) {
+            eprintln!("Error: --window does not support --format csv");
+            return Ok(ExitCode::from(EXIT_ERROR));
+        }
+        if !files.iter().any(|path| path.is_file()) {
+            no_files_found();
+            return Ok(ExitCode::from(EXIT_ERROR));
+        }
+        let start = std::time::Instant::now();
+        let skipped = SkipCounter::new();
+        let windows = search_windows(&files, &query, &options, usize::from(size), &skipped)?;
+        match cli.format {
+            OutputFormat::Json => println!("{}", serde_json::to_string_pretty(&windows)?),
+            OutputFormat::JsonL => {
+                for window in &windows {
+                    println!("{}", serde_json::to_string(window)?);
+                }
+            }
+            _ if windows.is_empty() => {
+                if !cli.quiet {
+                    println!("No results found.");
+                }
+            }
+            _ => {
+                if !cli.quiet {
+                    println!("Found {} windows:\n", windows.len());
+                }
+                for window in &windows {
+                    println!("{}", format_window_match(window, !cli.no_color));
+                }
+            }
+        }
+        if !cli.quiet {
+            eprintln!(
+                "\n⏱️  Search completed in {}ms",
+                start.elapsed().as_millis()
+            );
+            for warning in skip_warnings(&skipped) {
+                eprintln!("⚠️  {warning}");
+            }
+        }
+        // Windows past -n were dropped, and the next run must still show them
+        let capped = options
+            .max_results
+            .is_some_and(|max| max > 0 && windows.len() >= max);
+        remember_run(last_run.as_ref(), !capped);
+        return Ok(search_exit_code(!windows.is_empty()));
     }
 
+    // Execute search
+    tracing::info!(
+        "Using {} engine",
+        match cli.engine {
+            EngineType::Smol => "Smol",
+            EngineType::Rayon => "Rayon",
+        }
+    );
+
+    // Ctrl-C stops the search early; what was found until then is still printed
+    let interrupt = CancelToken::new();
+    if let Err(e) = interrupt.cancel_on_interrupt() {
+        tracing::debug!("Could not handle Ctrl-C: {e}");
+    }
+
+    // --cache-results: the same search over unchanged files is read back from disk.
+    // A search bounded relative to now differs every time, so it is not stored
+    let result_cache = if cli.cache_results && has_relative_time_bound(&cli) {
+        tracing::info!("Not caching results: --since and --since-last-run change with the time");
+        None
+    } else if cli.cache_results {
+        let cache = ResultCache::in_default_dir();
+        if cache.is_none() {
+            tracing::debug!("No cache directory; searching without --cache-results");
+        }
+        cache.map(|cache| {
+            let key = result_cache_key(pattern, &query, &options);
+            (cache, key, CorpusStamp::of(&files))
+        })
+    } else {
+        None
+    };
+    let start = std::time::Instant::now();
+    let cached = result_cache
+        .as_ref()
+        .and_then(|(cache, key, corpus)| cache.load(key, corpus));
+    let from_cache = cached.is_some();
+
     // Create appropriate engine based on CLI flag
-    let (results, duration, total_count) = match cli.engine {
-        EngineType::Smol => {
-            let engine = SmolEngine::new(options);
-            engine.search(pattern_to_use, query)?
+    let (results, duration, total_count, skip_notes, type_counts) = if let Some(cached) = cached {
+        tracing::info!("Using cached results");
+        (
+            cached.results,
+            start.elapsed(),
+            cached.total_count,
+            cached.skip_warnings,
+            cached.type_counts,
+        )
+    } else {
+        match cli.engine {
+            EngineType::Smol => {
+                let engine = SmolEngine::new(options.clone()).with_cancel(interrupt.clone());
+                let (results, duration, total_count) = engine.search(pattern, query)?;
+                (
+                    results,
+                    duration,
+                    total_count,
+                    skip_warnings(engine.skipped()),
+                    engine.type_counts(),
+                )
+            }
+            EngineType::Rayon => {
+                let engine = RayonEngine::new(options.clone()).with_cancel(interrupt.clone());
+                let (results, duration, total_count) = engine.search(pattern, query)?;
+                (
+                    results,
+                    duration,
+                    total_count,
+                    skip_warnings(engine.skipped()),
+                    engine.type_counts(),
+                )
+            }
         }
-        EngineType::Rayon => {
-            let engine = RayonEngine::new(options);
-            engine.search(pattern_to_use, query)?
+    };
+    let warn_skipped = || {
+        if !cli.quiet {
+            for warning in &skip_notes {
+                eprintln!("⚠️  {warning}");
+            }
         }
     };
+    let interrupted = interrupt.is_cancelled();
+    // A search that didn't finish is not worth reusing
+    if let Some((cache, key, corpus)) = result_cache
+        && !from_cache
+        && !interrupted
+    {
+        let search = CachedSearch {
+            results: results.clone(),
+            total_count,
+            type_counts: type_counts.clone(),
+            skip_warnings: skip_notes.clone(),
+        };
+        if let Err(e) = cache.store(&key, corpus, search) {
+            tracing::debug!("Failed to write the result cache: {e}");
+        }
+    }
+
+    // An empty result is an error when there was nothing to search at all
+    if results.is_empty() && !files.iter().any(|path| path.is_file()) {
+        no_files_found();
+        return Ok(ExitCode::from(EXIT_ERROR));
+    }
+
+    if cli.agg == Some(Aggregate::Tokens) {
+        let usage = ccms::usage::TokenUsage::from_results(&results);
+        print_token_usage(&usage, cli.format, !cli.no_color)?;
+        if !cli.quiet {
+            eprintln!(
+                "\n⏱️  Search completed in {}ms ({} results)",
+                duration.as_millis(),
+                results.len()
+            );
+        }
+        warn_skipped();
+        if interrupted {
+            eprintln!("(interrupted, partial results)");
+            return Ok(ExitCode::from(EXIT_INTERRUPTED));
+        }
+        remember_run(last_run.as_ref(), total_count <= results.len());
+        return Ok(search_exit_code(!results.is_empty()));
+    }
 
     // If stats flag is set, collect and display statistics
     if cli.stats {
         let stats = collect_statistics(&results);
         println!("{}", ccms::stats::format_statistics(&stats, !cli.no_color));
 
-        eprintln!("\n⏱️  Search completed in {}ms", duration.as_millis());
-        if total_count > results.len() {
-            eprintln!(
-                "(Showing stats for {} of {} total results)",
-                results.len(),
-                total_count
-            );
+        if !cli.quiet {
+            eprintln!("\n⏱️  Search completed in {}ms", duration.as_millis());
+            if total_count > results.len() {
+                eprintln!(
+                    "(Showing stats for {} of {} total results)",
+                    results.len(),
+                    total_count
+                );
+            }
+        }
+        warn_skipped();
+        if interrupted {
+            eprintln!("(interrupted, partial results)");
+            return Ok(ExitCode::from(EXIT_INTERRUPTED));
+        }
+        remember_run(last_run.as_ref(), total_count <= results.len());
+        return Ok(search_exit_code(!results.is_empty()));
+    }
+
+    // --copy puts the top result on the clipboard as well as printing everything
+    if cli.copy
+        && let Some(top) = results.first()
+    {
+        let text = match (&top.raw_json, cli.raw) {
+            (Some(raw_json), true) => raw_json,
+            _ => &top.text,
+        };
+        if let Err(e) = ccms::utils::clipboard::copy_to_clipboard(text) {
+            eprintln!("Error: failed to copy to the clipboard: {e:#}");
+            return Ok(ExitCode::from(EXIT_ERROR));
+        }
+        if !cli.quiet {
+            eprintln!("📋 Copied {} to the clipboard", top.uuid);
         }
-        return Ok(());
     }
 
+    // --group-by-file lists each file's results together
+    let results = if cli.group_by_file {
+        group_by_file(results)
+    } else {
+        results
+    };
+
     // Output results
     let stdout = io::stdout();
     let mut handle = stdout.lock();
+    let mut budget = OutputBudget::new(cli.limit_bytes);
+    // Surrounding messages for -A/-B/-C (explicit -A/-B take precedence over -C)
+    let before_context = cli.before_context.or(cli.context).unwrap_or(0);
+    let after_context = cli.after_context.or(cli.context).unwrap_or(0);
 
     match cli.format {
         OutputFormat::Text => {
             if results.is_empty() {
-                println!("No results found.");
+                if !cli.quiet {
+                    println!("No results found.");
+                    if cli.max_files.is_none() {
+                        eprintln!(
+                            "(Searched {} files; --max-files N guards against patterns that match far more than intended)",
+                            files.len()
+                        );
+                    }
+                }
+            } else if cli.only_matching || cli.only_matching_group.is_some() {
+                for result in &results {
+                    let mut record = String::new();
+                    for text in result
+                        .query
+                        .matched_texts(&result.text, cli.only_matching_group)
+                    {
+                        record.push_str(text);
+                        record.push('\n');
+                    }
+                    if !budget.take(&record) {
+                        break;
+                    }
+                    handle.write_all(record.as_bytes())?;
+                }
+            } else if let Some(path) = &extract {
+                for result in &results {
+                    let raw_json = result.raw_json.as_deref().unwrap_or_default();
+                    let record = format!("{}\n", path.extract(raw_json));
+                    if !budget.take(&record) {
+                        break;
+                    }
+                    handle.write_all(record.as_bytes())?;
+                }
+            } else if let Some(template) = &template {
+                for result in &results {
+                    let record = format!("{}\n", template.render(result));
+                    if !budget.take(&record) {
+                        break;
+                    }
+                    handle.write_all(record.as_bytes())?;
+                }
+            } else if cli.raw && (before_context > 0 || after_context > 0) {
+                // Raw mode with context: the neighbouring lines of the file as
+                // they are, one contiguous block per hit
+                for block in collect_raw_context(
+                    &results,
+                    before_context,
+                    after_context,
+                    cli.max_line_bytes,
+                )? {
+                    let record: String = block.iter().map(|line| format!("{line}\n")).collect();
+                    if !budget.take(&record) {
+                        break;
+                    }
+                    handle.write_all(record.as_bytes())?;
+                }
             } else if cli.raw {
                 // Raw mode: output raw JSON lines
                 for result in &results {
                     if let Some(raw_json) = &result.raw_json {
-                        println!("{raw_json}");
+                        let record = format!("{raw_json}\n");
+                        if !budget.take(&record) {
+                            break;
+                        }
+                        handle.write_all(record.as_bytes())?;
                     }
                 }
             } else {
-                println!("Found {} results:\n", results.len());
-                for result in &results {
-                    println!(
-                        "{}",
-                        format_search_result(result, !cli.no_color, cli.full_text)
-                    );
+                if !cli.quiet {
+                    writeln!(handle, "Found {} results:\n", results.len())?;
                 }
 
-                // Print search statistics
-                eprintln!("\n⏱️  Search completed in {}ms", duration.as_millis());
-                if total_count > results.len() {
-                    eprintln!(
-                        "(Showing {} of {} total results)",
-                        results.len(),
-                        total_count
-                    );
+                let windows = if before_context > 0 || after_context > 0 {
+                    collect_context(&results, before_context, after_context, &options)?
+                } else {
+                    Vec::new()
+                };
+                let threads = if cli.thread {
+                    collect_threads(&results, &options)?
                 } else {
-                    eprintln!("(Found {total_count} results)");
+                    Vec::new()
+                };
+
+                // --compact fits lines to the terminal unless piped or given --width
+                let width = cli.width.or_else(|| {
+                    if !cli.compact || !io::stdout().is_terminal() {
+                        return None;
+                    }
+                    crossterm::terminal::size()
+                        .ok()
+                        .map(|(columns, _)| columns as usize)
+                });
+
+                for (index, result) in results.iter().enumerate() {
+                    // Everything printed for one result, so it is cut whole by --limit-bytes
+                    let mut record = String::new();
+                    let window = windows.get(index);
+                    let new_file = cli.group_by_file
+                        && index
+                            .checked_sub(1)
+                            .is_none_or(|previous| results[previous].file != result.file);
+                    if index > 0 && !new_file && (window.is_some() || cli.thread) {
+                        record.push_str("--\n");
+                    }
+                    if let Some((hit, ancestors)) =
+                        threads.get(index).and_then(|chain| chain.split_last())
+                    {
+                        for (depth, node) in ancestors.iter().enumerate() {
+                            writeln!(record, "{}", format_thread_node(node, depth, !cli.no_color))?;
+                        }
+                        if hit.is_sidechain {
+                            record.push_str("[sidechain]\n");
+                        }
+                    }
+                    if let Some(window) = window {
+                        for message in &window.before {
+                            writeln!(
+                                record,
+                                "{}",
+                                format_context_result(message, !cli.no_color, cli.full_text)
+                            )?;
+                        }
+                    }
+                    if cli.compact {
+                        writeln!(
+                            record,
+                            "{}",
+                            format_compact_result(result, !cli.no_color, cli.full_text, width)
+                        )?;
+                    } else if cli.group_by_file {
+                        writeln!(
+                            record,
+                            "{}",
+                            format_grouped_result(result, !cli.no_color, cli.full_text)
+                        )?;
+                    } else {
+                        writeln!(
+                            record,
+                            "{}",
+                            format_search_result(result, !cli.no_color, cli.full_text)
+                        )?;
+                    }
+                    if cli.verbose > 0
+                        && let Some(request_id) = &result.request_id
+                    {
+                        writeln!(record, "  request: {request_id}")?;
+                    }
+                    if let Some(window) = window {
+                        for message in &window.after {
+                            writeln!(
+                                record,
+                                "{}",
+                                format_context_result(message, !cli.no_color, cli.full_text)
+                            )?;
+                        }
+                    }
+                    if cli.group_by_file {
+                        // Indented under the file's header, with a blank line between files
+                        let mut grouped = String::new();
+                        if new_file {
+                            if index > 0 {
+                                grouped.push('\n');
+                            }
+                            writeln!(
+                                grouped,
+                                "{}",
+                                format_file_header(&result.file, !cli.no_color)
+                            )?;
+                        }
+                        for line in record.lines() {
+                            writeln!(grouped, "  {line}")?;
+                        }
+                        record = grouped;
+                    }
+                    if !budget.take(&record) {
+                        break;
+                    }
+                    handle.write_all(record.as_bytes())?;
+                }
+
+                // Print search statistics
+                if !cli.quiet {
+                    eprintln!("\n⏱️  Search completed in {}ms", duration.as_millis());
+                    if cli.first && cli.max_results > 0 && results.len() == cli.max_results {
+                        // The total only covers the files searched before stopping
+                        eprintln!("(Stopped after the first {} results)", results.len());
+                    } else if total_count > results.len() {
+                        eprintln!(
+                            "(Showing {} of {} total results)",
+                            results.len(),
+                            total_count
+                        );
+                    } else {
+                        eprintln!("(Found {total_count} results)");
+                    }
                 }
             }
         }
         OutputFormat::Json => {
+            // The document is printed at once, so results are dropped from its end by
+            // their size on their own, which is close to what they take up in it
+            let shown = results
+                .iter()
+                .take_while(|&result| {
+                    serde_json::to_string_pretty(&JsonResult::from(result))
+                        .is_ok_and(|json| budget.take(&json))
+                })
+                .count();
+            let results = &results[..shown];
+
             // Collect statistics
             let mut session_counts: HashMap<String, usize> = HashMap::new();
             let mut file_counts: HashMap<String, usize> = HashMap::new();
 
-            for result in &results {
+            for result in results {
                 *session_counts.entry(result.session_id.clone()).or_insert(0) += 1;
                 *file_counts.entry(result.file.clone()).or_insert(0) += 1;
             }
@@ -518,8 +1937,8 @@ fn main() -> Result<()> {
                 })
                 .collect();
 
-            let output = serde_json::json!({
-                "results": results,
+            let mut output = serde_json::json!({
+                "results": results.iter().map(JsonResult::from).collect::<Vec<_>>(),
                 "summary": {
                     "duration_ms": duration.as_millis(),
                     "total_count": total_count,
@@ -530,25 +1949,74 @@ fn main() -> Result<()> {
                 "files": files_detail,
                 "sessions": sessions_detail
             });
+            if cli.type_breakdown {
+                output["summary"]["type_counts"] = serde_json::json!(type_counts);
+            }
+            if budget.truncated() {
+                output["summary"]["truncated"] = serde_json::json!(true);
+            }
+            if interrupted {
+                output["summary"]["interrupted"] = serde_json::json!(true);
+            }
             serde_json::to_writer_pretty(&mut handle, &output)?;
             writeln!(&mut handle)?;
         }
         OutputFormat::JsonL => {
             for result in &results {
-                serde_json::to_writer(&mut handle, result)?;
-                writeln!(&mut handle)?;
+                let record = format!("{}\n", serde_json::to_string(&JsonResult::from(result))?);
+                if !budget.take(&record) {
+                    break;
+                }
+                handle.write_all(record.as_bytes())?;
             }
             // Write metadata as last line
             let metadata = serde_json::json!({
                 "_metadata": {
                     "duration_ms": duration.as_millis(),
                     "total_count": total_count,
-                    "returned_count": results.len()
+                    "returned_count": budget.records
                 }
             });
             serde_json::to_writer(&mut handle, &metadata)?;
             writeln!(&mut handle)?;
         }
+        OutputFormat::Csv => {
+            if let Some(csv) = &csv {
+                handle.write_all(csv.header().as_bytes())?;
+                for result in &results {
+                    let record = csv.row(result);
+                    if !budget.take(&record) {
+                        break;
+                    }
+                    handle.write_all(record.as_bytes())?;
+                }
+            }
+        }
+    }
+    drop(handle);
+    if budget.truncated() {
+        eprintln!(
+            "⚠️  Output stopped at --limit-bytes {}: {} of {} results printed",
+            budget.limit.unwrap_or_default(),
+            budget.records,
+            results.len()
+        );
+    }
+    // Part of the summary object in JSON output; on stderr otherwise so it
+    // stays out of piped results
+    if cli.type_breakdown && !matches!(cli.format, OutputFormat::Json) {
+        eprintln!(
+            "Types: {}",
+            ccms::stats::format_type_breakdown(&type_counts)
+        );
+    }
+    warn_skipped();
+    // A run that didn't finish may have missed matches the next one should show
+    if !interrupted {
+        remember_run(
+            last_run.as_ref(),
+            total_count <= results.len() && !budget.truncated(),
+        );
     }
 
     // Generate profiling report if requested
@@ -561,18 +2029,303 @@ fn main() -> Result<()> {
         eprintln!("\nDetailed profiling reports saved to {profile_path}_{{comprehensive.txt,svg}}");
     }
 
+    if interrupted {
+        eprintln!("(interrupted, partial results)");
+        return Ok(ExitCode::from(EXIT_INTERRUPTED));
+    }
+    Ok(search_exit_code(!results.is_empty()))
+}
+
+// --agg tokens output: a table, or the same numbers as JSON for --format json/jsonl
+fn print_token_usage(
+    usage: &ccms::usage::TokenUsage,
+    format: OutputFormat,
+    use_color: bool,
+) -> Result<()> {
+    match format {
+        OutputFormat::Json | OutputFormat::JsonL => {
+            let by_day: Vec<_> = usage
+                .by_day
+                .iter()
+                .map(|(day, counts)| {
+                    let mut row = serde_json::to_value(counts)?;
+                    row["day"] = serde_json::json!(day.to_string());
+                    row["total_tokens"] = serde_json::json!(counts.total_tokens());
+                    Ok(row)
+                })
+                .collect::<Result<_>>()?;
+            let mut total = serde_json::to_value(usage.total)?;
+            total["total_tokens"] = serde_json::json!(usage.total.total_tokens());
+            let output = serde_json::json!({ "total": total, "by_day": by_day });
+            if matches!(format, OutputFormat::Json) {
+                println!("{}", serde_json::to_string_pretty(&output)?);
+            } else {
+                println!("{output}");
+            }
+        }
+        OutputFormat::Text | OutputFormat::Csv => {
+            print!("{}", ccms::usage::format_token_usage(usage, use_color));
+        }
+    }
     Ok(())
 }
 
-fn parse_since_time(input: &str) -> Result<String> {
-    use anyhow::Context;
+// Whether a time bound is resolved against the current time (`--since 2h`,
+// `--since-last-run`), making every run a different search
+fn has_relative_time_bound(cli: &Cli) -> bool {
+    cli.since_last_run
+        || cli
+            .since
+            .as_deref()
+            .is_some_and(|since| since.parse::<i64>().is_err())
+}
+
+// What identifies a search for --cache-results: everything that changes which
+// messages it finds, but not settings that only change how it runs
+fn result_cache_key(pattern: &str, query: &QueryCondition, options: &SearchOptions) -> String {
+    let mut options = options.clone();
+    options.verbose = false;
+    options.progress = false;
+    options.workers = None;
+    options.file_cache_ttl = None;
+    // Which files were searched is in the corpus stamp
+    options.files = None;
+    format!("{pattern}\n{query:?}\n{options:?}")
+}
+
+// Whether to search more files than --max-files allows: asked on a terminal,
+// refused otherwise
+fn confirm_many_files(found: usize, max_files: usize) -> io::Result<bool> {
+    if !io::stdin().is_terminal() || !io::stderr().is_terminal() {
+        return Ok(false);
+    }
+    eprint!("{found} files found, more than --max-files {max_files}. Search them anyway? [y/N] ");
+    io::stderr().flush()?;
+    let mut answer = String::new();
+    io::stdin().read_line(&mut answer)?;
+    Ok(matches!(answer.trim(), "y" | "Y" | "yes"))
+}
+
+// Warnings about input a search skipped: each file over --max-filesize, then a summary
+fn skip_warnings(skipped: &SkipCounter) -> Vec<String> {
+    let mut warnings: Vec<String> = skipped
+        .large_files()
+        .into_iter()
+        .map(|file| format!("Skipped {file} (larger than --max-filesize)"))
+        .collect();
+    warnings.extend(skipped.summary());
+    warnings.extend(skipped.parse_error_summary());
+    warnings
+}
+
+// Parse a size like "1048576", "512K", "100M" or "2G" (binary units) into bytes
+fn parse_file_size(input: &str) -> Result<u64, String> {
+    let trimmed = input.trim();
+    let upper = trimmed.to_ascii_uppercase();
+    let number = upper
+        .strip_suffix("IB")
+        .or_else(|| upper.strip_suffix('B'))
+        .unwrap_or(&upper);
+    let (digits, multiplier) = match number.char_indices().last() {
+        Some((index, 'K')) => (&number[..index], 1u64 << 10),
+        Some((index, 'M')) => (&number[..index], 1 << 20),
+        Some((index, 'G')) => (&number[..index], 1 << 30),
+        Some((index, 'T')) => (&number[..index], 1 << 40),
+        _ => (number, 1),
+    };
+    digits
+        .trim()
+        .parse::<u64>()
+        .ok()
+        .and_then(|value| value.checked_mul(multiplier))
+        .ok_or_else(|| format!("invalid size '{trimmed}' (expected e.g. 1048576, 512K, 100M, 2G)"))
+}
+
+// --max-line-bytes: a size as for --max-filesize, of at least one byte
+fn parse_line_size(input: &str) -> Result<usize, String> {
+    match usize::try_from(parse_file_size(input)?) {
+        Ok(0) => Err("the line size limit must be at least 1 byte".to_string()),
+        Ok(bytes) => Ok(bytes),
+        Err(_) => Err(format!("line size '{}' is too large", input.trim())),
+    }
+}
+
+// A result as --format json and jsonl print it, with how often the query
+// occurs in its text
+#[derive(serde::Serialize)]
+struct JsonResult<'a> {
+    #[serde(flatten)]
+    result: &'a SearchResult,
+    matches: usize,
+}
+
+impl<'a> From<&'a SearchResult> for JsonResult<'a> {
+    fn from(result: &'a SearchResult) -> Self {
+        Self {
+            matches: result.query.matched_texts(&result.text, None).len(),
+            result,
+        }
+    }
+}
+
+// Bytes of results printed so far, against --limit-bytes
+struct OutputBudget {
+    limit: Option<u64>,
+    used: u64,
+    // Records printed, and whether one was held back
+    records: usize,
+    stopped: bool,
+}
+
+impl OutputBudget {
+    fn new(limit: Option<u64>) -> Self {
+        Self {
+            limit,
+            used: 0,
+            records: 0,
+            stopped: false,
+        }
+    }
+
+    // Whether `record` still fits; once one does not, nothing more is printed
+    fn take(&mut self, record: &str) -> bool {
+        let used = self.used + record.len() as u64;
+        if self.stopped || self.limit.is_some_and(|limit| used > limit) {
+            self.stopped = true;
+            return false;
+        }
+        self.used = used;
+        self.records += 1;
+        true
+    }
+
+    fn truncated(&self) -> bool {
+        self.stopped
+    }
+}
+
+// Message types --role can filter on, and the names people tend to use instead
+const ROLES: &[&str] = &["user", "assistant", "system", "summary"];
+const ROLE_ALIASES: &[(&str, &str)] = &[
+    ("human", "user"),
+    ("ai", "assistant"),
+    ("claude", "assistant"),
+];
+
+// Resolve a --role value to a message type, case-insensitively
+fn parse_role(input: &str) -> Result<String, String> {
+    let role = input.trim().to_ascii_lowercase();
+    if ROLES.contains(&role.as_str()) {
+        return Ok(role);
+    }
+    if let Some((_, canonical)) = ROLE_ALIASES.iter().find(|(alias, _)| *alias == role) {
+        return Ok(canonical.to_string());
+    }
+    let aliases: Vec<String> = ROLE_ALIASES
+        .iter()
+        .map(|(alias, canonical)| format!("{alias}={canonical}"))
+        .collect();
+    Err(format!(
+        "unknown role '{input}' (expected one of: {}; aliases: {})",
+        ROLES.join(", "),
+        aliases.join(", ")
+    ))
+}
+
+// Parse a duration like "60s", "10m" or "1h30m" (cache lifetimes, --file-timeout)
+fn parse_cache_ttl(input: &str) -> Result<std::time::Duration, String> {
+    parse_short_duration(input)
+        .and_then(|duration| duration.to_std().ok())
+        .ok_or_else(|| format!("invalid duration '{input}' (expected e.g. 60s, 10m, 1h)"))
+}
+
+// Paths listed in `list` ("-" for stdin) that exist, warning about the rest
+fn read_listed_files(list: &str, quiet: bool) -> Result<Vec<PathBuf>> {
+    let files = if list == "-" {
+        read_file_list(io::stdin().lock())?
+    } else {
+        let file = std::fs::File::open(list).with_context(|| format!("failed to open {list}"))?;
+        read_file_list(io::BufReader::new(file))?
+    };
+    let (found, missing): (Vec<_>, Vec<_>) = files.into_iter().partition(|path| path.is_file());
+    if !quiet {
+        for path in &missing {
+            eprintln!("⚠️  Listed file not found: {}", path.display());
+        }
+    }
+    Ok(found)
+}
+
+// Reject a malformed --exclude glob before any file is searched
+fn parse_exclude_glob(input: &str) -> Result<String, String> {
+    globset::Glob::new(input)
+        .map(|_| input.to_string())
+        .map_err(|error| error.to_string())
+}
+
+// Timestamp of the message with `uuid`, searched the same way as --message-id
+// among the files (already less --exclude) and project the search itself reads
+fn find_message_timestamp(
+    pattern: &str,
+    files: Vec<PathBuf>,
+    project_path: Option<String>,
+    uuid: &str,
+) -> Result<Option<String>> {
+    let options = SearchOptions {
+        max_results: Some(1),
+        message_id: Some(uuid.to_string()),
+        project_path,
+        stop_early: true,
+        files: Some(files),
+        ..Default::default()
+    };
+    let (results, _, _) = SmolEngine::new(options).search(pattern, parse_query(uuid)?)?;
+    Ok(results.into_iter().next().map(|result| result.timestamp))
+}
+
+// Move an RFC3339 timestamp by one nanosecond in `direction`, turning the
+// inclusive --after/--before comparison into a strict one
+fn shift_timestamp(timestamp: &str, direction: i64) -> Option<String> {
+    let dt = DateTime::parse_from_rfc3339(timestamp).ok()?;
+    let shifted = dt.checked_add_signed(chrono::Duration::nanoseconds(direction))?;
+    Some(shifted.to_rfc3339_opts(chrono::SecondsFormat::Nanos, true))
+}
+
+// Of an existing bound and a new one, the one further in `direction`
+// (`Greater` for lower bounds, `Less` for upper bounds)
+fn stricter_bound(
+    existing: Option<String>,
+    bound: String,
+    direction: std::cmp::Ordering,
+) -> String {
+    let parse = |ts: &str| DateTime::parse_from_rfc3339(ts).ok();
+    match existing {
+        Some(existing)
+            if parse(&existing)
+                .zip(parse(&bound))
+                .is_some_and(|(old, new)| old.cmp(&new) == direction) =>
+        {
+            existing
+        }
+        _ => bound,
+    }
+}
 
+fn parse_since_time(input: &str) -> Result<String> {
     // First, try to parse as Unix timestamp
     if let Ok(timestamp) = input.parse::<i64>() {
         let dt = DateTime::<Utc>::from_timestamp(timestamp, 0).context("Invalid Unix timestamp")?;
         return Ok(dt.to_rfc3339());
     }
 
+    // Short durations like "48h" or "7d" mean that long before now
+    if let Some(duration) = parse_short_duration(input) {
+        let dt = Utc::now()
+            .checked_sub_signed(duration)
+            .context("Duration is too large")?;
+        return Ok(dt.to_rfc3339());
+    }
+
     // Try to parse as relative/absolute time using parse_datetime
     match parse_datetime(input) {
         Ok(dt) => {
@@ -583,18 +2336,123 @@ fn parse_since_time(input: &str) -> Result<String> {
             Ok(utc_dt.to_rfc3339())
         }
         Err(e) => Err(anyhow::anyhow!(
-            "Failed to parse time '{input}': {e}. Expected Unix timestamp or relative time like '1 day ago'"
+            "Failed to parse time '{input}': {e}. Expected Unix timestamp, duration like '48h' or '7d', or relative time like '1 day ago'"
         )),
     }
 }
 
-fn handle_cli_command(command: &CliCommand, verbose: bool) -> Result<()> {
+/// Parse a compact duration such as "90m", "48h", "7d" or "1h30m".
+/// Units are s, m, h, d and w; returns `None` for anything else.
+fn parse_short_duration(input: &str) -> Option<chrono::Duration> {
+    let mut total = chrono::Duration::zero();
+    let mut rest = input.trim();
+    if rest.is_empty() {
+        return None;
+    }
+
+    while !rest.is_empty() {
+        let digits = rest.find(|c: char| !c.is_ascii_digit())?;
+        if digits == 0 {
+            return None;
+        }
+        let value: i64 = rest[..digits].parse().ok()?;
+        let mut units = rest[digits..].chars();
+        let part = match units.next()? {
+            's' => chrono::Duration::try_seconds(value)?,
+            'm' => chrono::Duration::try_minutes(value)?,
+            'h' => chrono::Duration::try_hours(value)?,
+            'd' => chrono::Duration::try_days(value)?,
+            'w' => chrono::Duration::try_weeks(value)?,
+            _ => return None,
+        };
+        total = total.checked_add(&part)?;
+        rest = units.as_str();
+    }
+
+    Some(total)
+}
+
+fn handle_cli_command(command: &CliCommand, verbose: bool, max_line_bytes: usize) -> Result<()> {
     match command {
         CliCommand::Convert(convert) => match &convert.command {
             ConvertSubcommand::ClaudeToCodex(args) => {
                 handle_convert_claude_to_codex(args, verbose)?;
             }
         },
+        CliCommand::Session(args) => {
+            let messages = load_session(&args.session_id, args.pattern.as_deref(), max_line_bytes)?;
+            let ordered = order_messages(messages, args.order.into());
+            let turns = group_turns(&ordered, args.merge_adjacent);
+            print!("{}", format_turns(&turns, !args.no_color));
+        }
+        CliCommand::Export(args) => {
+            let mut stdout = io::BufWriter::new(io::stdout().lock());
+            let export = if args.resolve_sessions {
+                ccms::export::export_resolved_sessions
+            } else {
+                ccms::export::export_session
+            };
+            let written = export(
+                &args.session_id,
+                args.pattern.as_deref(),
+                args.merge_adjacent,
+                max_line_bytes,
+                &mut stdout,
+            )?;
+            stdout.flush()?;
+            tracing::info!("Exported {written} records");
+        }
+        CliCommand::Fingerprint(args) => {
+            let files = discover_claude_files(args.pattern.as_deref())?;
+            let mut stdout = io::BufWriter::new(io::stdout().lock());
+            for fingerprint in
+                ccms::search::fingerprint::fingerprint_sessions(&files, max_line_bytes)?
+            {
+                writeln!(
+                    stdout,
+                    "{:016x}  {}  {}",
+                    fingerprint.hash, fingerprint.session_id, fingerprint.messages
+                )?;
+            }
+            stdout.flush()?;
+        }
+        CliCommand::Serve(args) => {
+            let cache_limit = args
+                .cache_messages
+                .map(|limit| usize::try_from(limit).unwrap_or(usize::MAX));
+            ccms::server::serve(
+                &args.addr,
+                args.pattern.clone(),
+                !args.no_warm,
+                cache_limit,
+                args.allow_origin.clone(),
+                max_line_bytes,
+            )?;
+        }
+        CliCommand::Warm(args) => {
+            let server =
+                ccms::server::Server::new(args.pattern.clone()).with_max_line_bytes(max_line_bytes);
+            println!("{}", server.warm()?);
+        }
+        CliCommand::DecodeDir(args) => {
+            for name in &args.names {
+                // Accept a whole path to the directory too, as a shell completes it
+                let name = std::path::Path::new(name)
+                    .file_name()
+                    .map_or(name.clone(), |name| name.to_string_lossy().into_owned());
+                println!("{}", ccms::utils::path_encoding::decode_project_dir(&name));
+            }
+        }
+        CliCommand::Doctor(args) => {
+            let report = ccms::doctor::diagnose(args.pattern.as_deref());
+            print!("{}", ccms::doctor::format_report(&report, !args.no_color));
+            let errors = report.errors();
+            anyhow::ensure!(
+                errors == 0,
+                "{errors} {} failed",
+                if errors == 1 { "check" } else { "checks" }
+            );
+        }
     }
 
     Ok(())
@@ -764,6 +2622,20 @@ BASIC QUERIES:
   'hello world'          Single-quoted literal
   /hello.*world/i        Regular expression with flags
 
+FUZZY MATCHING (via --fuzzy):
+  Literal terms match words within a small edit distance, so
+  "conection" also finds "connection". Regular expressions are unchanged.
+  Use --fuzzy-max-edits N to set the distance explicitly.
+
+PHRASE MATCHING (via --phrase):
+  Any run of spaces, tabs or newlines in a term matches any other run, so
+  "connection reset" also finds "connection\nreset". Regular expressions are unchanged.
+
+NORMALIZED MATCHING (via --normalize):
+  Curly quotes, dashes, ellipses and non-breaking spaces match their ASCII
+  forms and accented letters match however they were composed, so "don't"
+  also finds "don’t". Regular expressions are unchanged.
+
 OPERATORS:
   hello AND world        Both terms must be present
   hello OR world         Either term must be present
@@ -814,6 +2686,32 @@ mod tests {
         // Just check it parses correctly - exact time depends on when test runs
     }
 
+    #[test]
+    fn test_parse_short_duration() {
+        assert_eq!(
+            parse_short_duration("48h"),
+            Some(chrono::Duration::hours(48))
+        );
+        assert_eq!(parse_short_duration("7d"), Some(chrono::Duration::days(7)));
+        assert_eq!(
+            parse_short_duration("1h30m"),
+            Some(chrono::Duration::minutes(90))
+        );
+        assert_eq!(parse_short_duration("2w"), Some(chrono::Duration::days(14)));
+        assert_eq!(parse_short_duration("48"), None);
+        assert_eq!(parse_short_duration("h"), None);
+        assert_eq!(parse_short_duration("3y"), None);
+        assert_eq!(parse_short_duration("1 day ago"), None);
+    }
+
+    #[test]
+    fn test_parse_since_duration() {
+        let since = parse_since_time("30d").unwrap();
+        let since = DateTime::parse_from_rfc3339(&since).unwrap();
+        let expected = Utc::now() - chrono::Duration::days(30);
+        assert!((since.with_timezone(&Utc) - expected).num_seconds().abs() < 5);
+    }
+
     #[test]
     fn test_parse_invalid_time() {
         // Test invalid input
@@ -840,6 +2738,10 @@ mod tests {
                 },
                 cwd: "/project1".to_string(),
                 raw_json: None,
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             },
             SearchResult {
                 file: "file1.jsonl".to_string(),
@@ -855,6 +2757,10 @@ mod tests {
                 },
                 cwd: "/project1".to_string(),
                 raw_json: None,
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             },
             SearchResult {
                 file: "file2.jsonl".to_string(),
@@ -870,6 +2776,10 @@ mod tests {
                 },
                 cwd: "/project2".to_string(),
                 raw_json: None,
+                line_number: None,
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
             },
         ];
 
@@ -944,6 +2854,322 @@ mod tests {
         assert!(parsed.is_err());
     }
 
+    #[test]
+    fn test_cli_since_last_run() {
+        let cli = Cli::try_parse_from([
+            "ccms",
+            "--since-last-run",
+            "--after",
+            "2024-01-01T00:00:00Z",
+            "error",
+        ])
+        .unwrap();
+        assert!(cli.since_last_run);
+        assert!(Cli::try_parse_from(["ccms", "--since-last-run", "--latest"]).is_err());
+    }
+
+    #[test]
+    fn test_cli_parse_context_flags() {
+        let cli = Cli::try_parse_from(["ccms", "-C", "2", "-A", "1", "query"]).unwrap();
+        assert_eq!(cli.context, Some(2));
+        assert_eq!(cli.after_context, Some(1));
+        assert_eq!(cli.before_context, None);
+    }
+
+    #[test]
+    fn test_cli_template_conflicts_with_format() {
+        let cli = Cli::try_parse_from(["ccms", "--template", "{uuid}", "query"]).unwrap();
+        assert_eq!(cli.template.as_deref(), Some("{uuid}"));
+        assert!(
+            Cli::try_parse_from(["ccms", "--template", "{uuid}", "-f", "json", "query"]).is_err()
+        );
+    }
+
+    #[test]
+    fn test_parse_file_size() {
+        assert_eq!(parse_file_size("1048576"), Ok(1_048_576));
+        assert_eq!(parse_file_size("512K"), Ok(512 * 1024));
+        assert_eq!(parse_file_size("100M"), Ok(100 * 1024 * 1024));
+        assert_eq!(parse_file_size("2gb"), Ok(2 * 1024 * 1024 * 1024));
+        assert_eq!(parse_file_size("1MiB"), Ok(1024 * 1024));
+        assert!(parse_file_size("").is_err());
+        assert!(parse_file_size("1.5G").is_err());
+        assert!(parse_file_size("lots").is_err());
+    }
+
+    #[test]
+    fn test_parse_line_size() {
+        assert_eq!(parse_line_size("64M"), Ok(64 * 1024 * 1024));
+        assert!(parse_line_size("0").is_err());
+
+        let cli = Cli::try_parse_from(["ccms", "query"]).expect("query should parse");
+        assert_eq!(cli.max_line_bytes, ccms::utils::line_reader::MAX_LINE_BYTES);
+        // Subcommands read session files too
+        let cli = Cli::try_parse_from(["ccms", "session", "s1", "--max-line-bytes", "1M"])
+            .expect("session should parse");
+        assert_eq!(cli.max_line_bytes, 1024 * 1024);
+    }
+
+    #[test]
+    fn test_json_result_counts_matches() {
+        let result = SearchResult {
+            file: "file1.jsonl".to_string(),
+            uuid: "uuid1".to_string(),
+            timestamp: "2024-01-01T00:00:00Z".to_string(),
+            session_id: "session1".to_string(),
+            role: "user".to_string(),
+            text: "Test the tests, then test again".to_string(),
+            message_type: "user".to_string(),
+            query: parse_query("test OR again").unwrap(),
+            cwd: "/project1".to_string(),
+            raw_json: None,
+            line_number: Some(3),
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
+        };
+        let json = serde_json::to_value(JsonResult::from(&result)).unwrap();
+        assert_eq!(json["matches"], 4);
+        assert_eq!(json["line_number"], 3);
+        assert_eq!(json["uuid"], "uuid1");
+    }
+
+    #[test]
+    fn test_output_budget() {
+        let mut budget = OutputBudget::new(Some(10));
+        assert!(budget.take("12345\n"));
+        assert!(budget.take("123\n"));
+        assert!(!budget.take("1\n"));
+        // Stays stopped even when a smaller record would fit
+        assert!(!budget.take(""));
+        assert_eq!(budget.records, 2);
+        assert!(budget.truncated());
+
+        let mut budget = OutputBudget::new(None);
+        assert!(budget.take(&"x".repeat(1 << 20)));
+        assert!(!budget.truncated());
+    }
+
+    #[test]
+    fn test_uuid_anchor_bounds() {
+        assert_eq!(
+            shift_timestamp("2024-01-01T00:00:00.500Z", 1).as_deref(),
+            Some("2024-01-01T00:00:00.500000001Z")
+        );
+        assert_eq!(
+            shift_timestamp("2024-01-01T00:00:00Z", -1).as_deref(),
+            Some("2023-12-31T23:59:59.999999999Z")
+        );
+        assert_eq!(shift_timestamp("yesterday", 1), None);
+
+        let later = "2024-02-01T00:00:00Z".to_string();
+        let earlier = "2024-01-01T00:00:00Z".to_string();
+        let greater = std::cmp::Ordering::Greater;
+        assert_eq!(
+            stricter_bound(Some(later.clone()), earlier.clone(), greater),
+            later
+        );
+        assert_eq!(
+            stricter_bound(Some(earlier.clone()), later.clone(), greater),
+            later
+        );
+        assert_eq!(stricter_bound(None, earlier.clone(), greater), earlier);
+        assert_eq!(
+            stricter_bound(Some(later), earlier.clone(), std::cmp::Ordering::Less),
+            earlier
+        );
+    }
+
+    #[test]
+    fn test_uuid_anchor_found_in_listed_files() -> Result<()> {
+        let dir = tempfile::tempdir()?;
+        let listed = dir.path().join("listed.jsonl");
+        std::fs::write(
+            &listed,
+            r#"{"type":"user","message":{"role":"user","content":"anchor"},"uuid":"anchor-uuid","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}"#,
+        )?;
+        // The pattern matches nothing; the file comes from --files-from
+        let pattern = dir.path().join("elsewhere/*.jsonl");
+        let pattern = pattern.to_string_lossy();
+
+        assert_eq!(
+            find_message_timestamp(&pattern, vec![listed], None, "anchor-uuid")?.as_deref(),
+            Some("2024-01-01T00:00:00Z")
+        );
+        assert_eq!(
+            find_message_timestamp(&pattern, Vec::new(), None, "anchor-uuid")?,
+            None
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_relative_time_bounds() {
+        let relative = |args: &[&str]| {
+            let cli = Cli::try_parse_from(["ccms"].iter().chain(args)).unwrap();
+            has_relative_time_bound(&cli)
+        };
+        assert!(!relative(&["error"]));
+        assert!(!relative(&["--since", "1700000000", "error"]));
+        assert!(!relative(&["--after", "2024-01-01T00:00:00Z", "error"]));
+        assert!(relative(&["--since", "2h", "error"]));
+        assert!(relative(&["--since", "1 day ago", "error"]));
+        assert!(relative(&["--since-last-run", "error"]));
+    }
+
+    #[test]
+    fn test_capped_run_is_not_remembered() -> Result<()> {
+        let dir = tempfile::tempdir()?;
+        let line = |uuid: &str, timestamp: &str| {
+            format!(
+                r#"{{"type":"user","message":{{"role":"user","content":"deploy failed"}},"uuid":"{uuid}","timestamp":"{timestamp}","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            )
+        };
+        std::fs::write(
+            dir.path().join("session.jsonl"),
+            format!(
+                "{}\n{}\n",
+                line("older", "2024-01-01T00:00:00Z"),
+                line("newer", "2024-01-02T00:00:00Z")
+            ),
+        )?;
+        let pattern = format!("{}/*.jsonl", dir.path().display());
+        let search = |after: Option<String>, max_results: Option<usize>| -> Result<_> {
+            let engine = RayonEngine::new(SearchOptions {
+                after,
+                max_results,
+                ..Default::default()
+            });
+            let (results, _, total_count) = engine.search(&pattern, parse_query("deploy")?)?;
+            let uuids: Vec<String> = results.into_iter().map(|result| result.uuid).collect();
+            Ok((uuids, total_count))
+        };
+        let last_run = (
+            LastRunStore::new(dir.path().join("last-run")),
+            "deploy".to_string(),
+            Utc::now(),
+        );
+        let (store, key, _) = &last_run;
+
+        // -n 1 shows only the newest match, so the time is not saved...
+        let (uuids, total_count) = search(store.last_run(key), Some(1))?;
+        assert_eq!(uuids, ["newer"]);
+        assert_eq!(total_count, 2);
+        remember_run(Some(&last_run), total_count <= uuids.len());
+        assert_eq!(store.last_run(key), None);
+
+        // ...and the next run still returns the match that was cut
+        let (uuids, total_count) = search(store.last_run(key), None)?;
+        assert_eq!(uuids, ["newer", "older"]);
+        remember_run(Some(&last_run), total_count <= uuids.len());
+        assert!(store.last_run(key).is_some());
+        assert_eq!(search(store.last_run(key), None)?.1, 0);
+        Ok(())
+    }
+
+    #[test]
+    fn test_cli_summaries_only_conflicts_with_role() {
+        let cli = Cli::try_parse_from(["ccms", "--summaries-only", "build"]).unwrap();
+        assert!(cli.summaries_only);
+        assert!(Cli::try_parse_from(["ccms", "--summaries-only", "-r", "user", "q"]).is_err());
+    }
+
+    #[test]
+    fn test_parse_role_aliases() {
+        assert_eq!(parse_role("assistant").as_deref(), Ok("assistant"));
+        assert_eq!(parse_role("Human").as_deref(), Ok("user"));
+        assert_eq!(parse_role("ai").as_deref(), Ok("assistant"));
+        assert_eq!(parse_role("claude").as_deref(), Ok("assistant"));
+
+        let error = parse_role("bot").unwrap_err();
+        assert!(error.contains("unknown role 'bot'"));
+        assert!(error.contains("user, assistant, system, summary"));
+
+        let cli = Cli::try_parse_from(["ccms", "-r", "human", "q"]).unwrap();
+        assert_eq!(cli.role.as_deref(), Some("user"));
+        assert!(Cli::try_parse_from(["ccms", "-r", "bot", "q"]).is_err());
+    }
+
+    #[test]
+    fn test_parse_cache_ttl() {
+        assert_eq!(
+            parse_cache_ttl("60s"),
+            Ok(std::time::Duration::from_secs(60))
+        );
+        assert_eq!(
+            parse_cache_ttl("1h30m"),
+            Ok(std::time::Duration::from_secs(5400))
+        );
+        assert!(parse_cache_ttl("soon").is_err());
+    }
+
+    #[test]
+    fn test_cli_parse_quiet_flag() {
+        let cli = Cli::try_parse_from(["ccms", "-q", "query"]).unwrap();
+        assert!(cli.quiet);
+        assert!(Cli::try_parse_from(["ccms", "-q", "-v", "query"]).is_err());
+    }
+
+    #[test]
+    fn test_cli_parse_repeated_terms() {
+        let cli = Cli::try_parse_from(["ccms", "-e", "panic", "--term", "unwrap"]).unwrap();
+        assert_eq!(cli.terms, vec!["panic", "unwrap"]);
+        assert!(cli.query.is_none());
+    }
+
+    #[test]
+    fn test_cli_parse_invert_match() {
+        let cli = Cli::try_parse_from(["ccms", "--invert-match", "-e", "flaky_test"]).unwrap();
+        assert!(cli.invert_match);
+        assert_eq!(cli.terms, vec!["flaky_test"]);
+    }
+
+    #[test]
+    fn test_cli_parse_verbosity_count() {
+        assert_eq!(Cli::try_parse_from(["ccms", "q"]).unwrap().verbose, 0);
+        assert_eq!(
+            Cli::try_parse_from(["ccms", "-vv", "q"]).unwrap().verbose,
+            2
+        );
+        assert_eq!(
+            Cli::try_parse_from(["ccms", "-v", "--verbose", "-v", "q"])
+                .unwrap()
+                .verbose,
+            3
+        );
+        assert!(Cli::try_parse_from(["ccms", "-v", "-q", "q"]).is_err());
+    }
+
+    #[test]
+    fn test_config_defaults_every_flag_kind() {
+        let table: toml::Table = r#"
+            project = "/work"
+            context = 2
+            no_color = true
+            term = ["a", "b"]
+            verbose = 1
+        "#
+        .parse()
+        .unwrap();
+        let command = Cli::command();
+        let matches = command
+            .clone()
+            .try_get_matches_from(["ccms", "-C", "5", "q"])
+            .unwrap();
+        let mut args: Vec<OsString> = vec!["ccms".into(), "-C".into(), "5".into(), "q".into()];
+        args.splice(
+            1..1,
+            config::config_args(&table, &command, &matches).unwrap(),
+        );
+
+        let cli = Cli::try_parse_from(args).unwrap();
+        assert_eq!(cli.project_path.as_deref(), Some("/work"));
+        assert_eq!(cli.context, Some(5));
+        assert!(cli.no_color);
+        assert_eq!(cli.terms, vec!["a", "b"]);
+        assert_eq!(cli.verbose, 1);
+    }
+
     #[test]
     fn test_cli_parse_convert_subcommand() {
         let parsed = Cli::try_parse_from([
@@ -963,6 +3189,74 @@ mod tests {
         assert_eq!(args.session_id, "session-123");
     }
 
+    #[test]
+    fn test_cli_parse_session_subcommand() {
+        let parsed = Cli::try_parse_from(["ccms", "session", "session-123", "--no-color"])
+            .expect("session command should parse");
+
+        let Some(CliCommand::Session(args)) = parsed.command else {
+            panic!("expected session subcommand");
+        };
+
+        assert_eq!(args.session_id, "session-123");
+        assert!(args.no_color);
+        assert_eq!(args.order, SessionOrder::Thread);
+
+        let parsed = Cli::try_parse_from(["ccms", "session", "s", "--order", "time"]).unwrap();
+        let Some(CliCommand::Session(args)) = parsed.command else {
+            panic!("expected session subcommand");
+        };
+        assert_eq!(args.order, SessionOrder::Time);
+    }
+
+    #[test]
+    fn test_cli_parse_fingerprint_subcommand() {
+        let parsed = Cli::try_parse_from(["ccms", "fingerprint", "--pattern", "/tmp/*.jsonl"])
+            .expect("fingerprint command should parse");
+
+        let Some(CliCommand::Fingerprint(args)) = parsed.command else {
+            panic!("expected fingerprint subcommand");
+        };
+        assert_eq!(args.pattern.as_deref(), Some("/tmp/*.jsonl"));
+    }
+
+    #[test]
+    fn test_cli_parse_decode_dir_subcommand() {
+        let parsed = Cli::try_parse_from(["ccms", "decode-dir", "-Users-me-app", "C--work"])
+            .expect("decode-dir command should parse");
+
+        let Some(CliCommand::DecodeDir(args)) = parsed.command else {
+            panic!("expected decode-dir subcommand");
+        };
+        assert_eq!(args.names, ["-Users-me-app", "C--work"]);
+        assert!(Cli::try_parse_from(["ccms", "decode-dir"]).is_err());
+    }
+
+    #[test]
+    fn test_cli_parse_doctor_subcommand() {
+        let parsed = Cli::try_parse_from(["ccms", "doctor", "-p", "/tmp/*.jsonl", "--no-color"])
+            .expect("doctor command should parse");
+
+        let Some(CliCommand::Doctor(args)) = parsed.command else {
+            panic!("expected doctor subcommand");
+        };
+        assert_eq!(args.pattern.as_deref(), Some("/tmp/*.jsonl"));
+        assert!(args.no_color);
+    }
+
+    #[test]
+    fn test_cli_parse_export_subcommand() {
+        let parsed = Cli::try_parse_from(["ccms", "export", "all", "-p", "/tmp/*.jsonl"])
+            .expect("export command should parse");
+
+        let Some(CliCommand::Export(args)) = parsed.command else {
+            panic!("expected export subcommand");
+        };
+
+        assert_eq!(args.session_id, "all");
+        assert_eq!(args.pattern.as_deref(), Some("/tmp/*.jsonl"));
+    }
+
     #[test]
     fn test_cli_convert_conflicts_with_query_positional() {
         let parsed = Cli::try_parse_from([
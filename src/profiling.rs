@@ -6,13 +6,26 @@ use pprof::{ProfilerGuard, ProfilerGuardBuilder};
 #[cfg(all(feature = "profiling", unix))]
 use std::fs::File;
 
-pub fn init_tracing() {
+/// Install the stderr logger. `RUST_LOG` takes precedence; otherwise only
+/// warnings are shown, and each `-v` lowers ccms' own level one step.
+pub fn init_tracing(verbosity: u8) {
+    let default_filter = match verbosity {
+        0 => "warn",
+        1 => "warn,ccms=info",
+        2 => "warn,ccms=debug",
+        _ => "warn,ccms=trace",
+    };
     tracing_subscriber::registry()
         .with(
             tracing_subscriber::EnvFilter::try_from_default_env()
-                .unwrap_or_else(|_| "claude_search=info".into()),
+                .unwrap_or_else(|_| default_filter.into()),
+        )
+        .with(
+            tracing_subscriber::fmt::layer()
+                .with_writer(std::io::stderr)
+                .without_time()
+                .with_target(false),
         )
-        .with(tracing_subscriber::fmt::layer())
         .init();
 }
 
@@ -0,0 +1,303 @@
+//! Per-project overview for `--project-summary`
+//!
+//! Session files are grouped by their directory under `~/.claude/projects`.
+//! Lines are counted without being parsed as messages; only the `cwd` and
+//! `timestamp` values are picked out of the raw JSON, so the scan stays cheap
+//! even for large histories.
+
+use crate::utils::{compression, line_reader, path_encoding};
+use anyhow::{Context, Result};
+use chrono::{DateTime, Local};
+use rayon::prelude::*;
+use std::borrow::Cow;
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+
+/// Session counts and latest activity of one project directory
+#[derive(Debug, Clone, PartialEq)]
+pub struct ProjectSummary {
+    /// Encoded directory name, e.g. `-Users-me-app`
+    pub directory: String,
+    /// Working directory recorded in the sessions, when any has one
+    pub cwd: Option<String>,
+    pub sessions: usize,
+    /// Non-empty lines, so summaries count as messages too
+    pub messages: usize,
+    /// Newest message timestamp (RFC3339)
+    pub last_activity: Option<String>,
+}
+
+impl ProjectSummary {
+    /// The project path: the recorded `cwd`, or else the directory name
+    /// decoded as well as it can be. Encoding is lossy (`/`, `.` and `_` all
+    /// become `-`), so the `cwd` is the only reliable way back.
+    pub fn display_path(&self) -> Cow<'_, str> {
+        match &self.cwd {
+            Some(cwd) => Cow::Borrowed(cwd),
+            None => Cow::Owned(path_encoding::decode_project_dir(&self.directory)),
+        }
+    }
+}
+
+// What one session file contributes to its project
+struct FileScan {
+    cwd: Option<String>,
+    messages: usize,
+    last_timestamp: Option<String>,
+}
+
+/// Summarize `files` per project directory, most recently active first.
+/// Lines longer than `max_line_bytes` are not counted.
+pub fn summarize_projects(files: &[PathBuf], max_line_bytes: usize) -> Result<Vec<ProjectSummary>> {
+    let scans: Vec<(String, FileScan)> = files
+        .par_iter()
+        .map(|path| Ok((project_directory(path), scan_file(path, max_line_bytes)?)))
+        .collect::<Result<_>>()?;
+
+    let mut projects: HashMap<String, ProjectSummary> = HashMap::new();
+    for (directory, scan) in scans {
+        let project = projects
+            .entry(directory.clone())
+            .or_insert_with(|| ProjectSummary {
+                directory,
+                cwd: None,
+                sessions: 0,
+                messages: 0,
+                last_activity: None,
+            });
+        project.sessions += 1;
+        project.messages += scan.messages;
+        if project.cwd.is_none() {
+            project.cwd = scan.cwd;
+        }
+        if newer(
+            scan.last_timestamp.as_deref(),
+            project.last_activity.as_deref(),
+        ) {
+            project.last_activity = scan.last_timestamp;
+        }
+    }
+
+    let mut projects: Vec<ProjectSummary> = projects.into_values().collect();
+    projects.sort_by(|a, b| {
+        parse_timestamp(b.last_activity.as_deref())
+            .cmp(&parse_timestamp(a.last_activity.as_deref()))
+            .then_with(|| a.directory.cmp(&b.directory))
+    });
+    Ok(projects)
+}
+
+/// One line per project: last activity, session and message counts, path
+pub fn format_project_summaries(projects: &[ProjectSummary], use_color: bool) -> String {
+    use colored::Colorize;
+
+    let mut output = String::new();
+    for project in projects {
+        let last_activity = project
+            .last_activity
+            .as_deref()
+            .and_then(|t| DateTime::parse_from_rfc3339(t).ok())
+            .map(|t| {
+                t.with_timezone(&Local)
+                    .format("%Y-%m-%d %H:%M:%S")
+                    .to_string()
+            })
+            .unwrap_or_else(|| "-".repeat(19));
+        let counts = format!(
+            "{:>5} sessions {:>8} messages",
+            project.sessions, project.messages
+        );
+        if use_color {
+            output.push_str(&format!(
+                "{}  {}  {}\n",
+                last_activity.bright_blue(),
+                counts,
+                project.display_path().bright_green()
+            ));
+        } else {
+            output.push_str(&format!(
+                "{last_activity}  {counts}  {}\n",
+                project.display_path()
+            ));
+        }
+    }
+    output
+}
+
+// Directory under `.claude/projects`, or the parent directory's name for files elsewhere
+pub(crate) fn project_directory(path: &Path) -> String {
+    path_encoding::extract_project_from_file_path(&path.to_string_lossy())
+        .filter(|directory| {
+            ![".jsonl", ".gz", ".zst"]
+                .iter()
+                .any(|extension| directory.ends_with(extension))
+        })
+        .or_else(|| {
+            path.parent()
+                .and_then(Path::file_name)
+                .map(|name| name.to_string_lossy().into_owned())
+        })
+        .unwrap_or_default()
+}
+
+fn scan_file(path: &Path, max_line_bytes: usize) -> Result<FileScan> {
+    let mut reader = compression::open_session_reader(path)
+        .with_context(|| format!("failed to open file: {}", path.display()))?;
+    let mut line_buffer = Vec::with_capacity(16 * 1024);
+    let mut line_number = 0;
+    let mut scan = FileScan {
+        cwd: None,
+        messages: 0,
+        last_timestamp: None,
+    };
+
+    while line_reader::read_line_within(
+        &mut reader,
+        &mut line_buffer,
+        max_line_bytes,
+        path,
+        &mut line_number,
+    )
+    .with_context(|| format!("failed to read line from {}", path.display()))?
+    {
+        let Ok(line) = std::str::from_utf8(line_buffer.trim_ascii()) else {
+            continue;
+        };
+        if line.is_empty() {
+            continue;
+        }
+
+        scan.messages += 1;
+        // Sessions are appended in order, so the last timestamp is the newest
+        if let Some(timestamp) = json_string_field(line, "timestamp") {
+            scan.last_timestamp = Some(timestamp);
+        }
+        if scan.cwd.is_none() {
+            scan.cwd = json_string_field(line, "cwd");
+        }
+    }
+    Ok(scan)
+}
+
+// Value of the first `"key": "..."` string in a raw JSON line
+pub(crate) fn json_string_field(line: &str, key: &str) -> Option<String> {
+    let quoted_key = format!("\"{key}\"");
+    let bytes = line.as_bytes();
+    let mut search_from = 0;
+    while let Some(found) = line[search_from..].find(&quoted_key) {
+        search_from += found + quoted_key.len();
+        let rest = line[search_from..].trim_start();
+        let Some(value) = rest.strip_prefix(':').map(str::trim_start) else {
+            continue;
+        };
+        if !value.starts_with('"') {
+            continue;
+        }
+
+        let start = line.len() - value.len();
+        let mut end = start + 1;
+        while end < bytes.len() {
+            match bytes[end] {
+                b'\\' => end += 2,
+                b'"' => return serde_json::from_str(&line[start..=end]).ok(),
+                _ => end += 1,
+            }
+        }
+        return None;
+    }
+    None
+}
+
+fn parse_timestamp(timestamp: Option<&str>) -> Option<DateTime<chrono::FixedOffset>> {
+    timestamp.and_then(|t| DateTime::parse_from_rfc3339(t).ok())
+}
+
+fn newer(candidate: Option<&str>, current: Option<&str>) -> bool {
+    parse_timestamp(candidate) > parse_timestamp(current)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::utils::line_reader::MAX_LINE_BYTES;
+    use std::fs::{self, File};
+    use std::io::Write;
+    use tempfile::tempdir;
+
+    fn line(session: &str, timestamp: &str, cwd: &str) -> String {
+        format!(
+            r#"{{"type":"user","message":{{"role":"user","content":"hi"}},"uuid":"u","timestamp":"{timestamp}","sessionId":"{session}","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"{cwd}","version":"1"}}"#
+        )
+    }
+
+    #[test]
+    fn test_json_string_field() {
+        let line = r#"{"type":"user","cwd":"C:\\work\\app","timestamp": "2024-01-01T00:00:00Z"}"#;
+        assert_eq!(
+            json_string_field(line, "cwd").as_deref(),
+            Some(r"C:\work\app")
+        );
+        assert_eq!(
+            json_string_field(line, "timestamp").as_deref(),
+            Some("2024-01-01T00:00:00Z")
+        );
+        assert_eq!(json_string_field(line, "sessionId"), None);
+        // A value that happens to equal the key is skipped
+        assert_eq!(
+            json_string_field(r#"{"note":"cwd","cwd":"/x"}"#, "cwd").as_deref(),
+            Some("/x")
+        );
+    }
+
+    #[test]
+    fn test_projects_sorted_by_recency() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let projects_dir = temp_dir.path().join(".claude/projects");
+        let old = projects_dir.join("-work-old");
+        let new = projects_dir.join("-work-new-app");
+        fs::create_dir_all(&old)?;
+        fs::create_dir_all(&new)?;
+
+        let files = vec![
+            old.join("a.jsonl"),
+            old.join("b.jsonl"),
+            new.join("c.jsonl"),
+        ];
+        writeln!(
+            File::create(&files[0])?,
+            "{}\n{}",
+            line("a", "2024-01-01T00:00:00Z", "/work/old"),
+            line("a", "2024-01-02T00:00:00Z", "/work/old")
+        )?;
+        writeln!(
+            File::create(&files[1])?,
+            "{}\n\n{{\"type\":\"summary\",\"summary\":\"s\",\"leafUuid\":\"u\"}}",
+            line("b", "2024-01-03T00:00:00Z", "/work/old")
+        )?;
+        writeln!(
+            File::create(&files[2])?,
+            "{}",
+            line("c", "2024-02-01T00:00:00Z", "/work/new_app")
+        )?;
+
+        let projects = summarize_projects(&files, MAX_LINE_BYTES)?;
+        assert_eq!(projects.len(), 2);
+
+        assert_eq!(projects[0].directory, "-work-new-app");
+        assert_eq!(projects[0].display_path(), "/work/new_app");
+        assert_eq!(projects[0].sessions, 1);
+
+        assert_eq!(projects[1].directory, "-work-old");
+        assert_eq!(projects[1].sessions, 2);
+        assert_eq!(projects[1].messages, 4);
+        assert_eq!(
+            projects[1].last_activity.as_deref(),
+            Some("2024-01-03T00:00:00Z")
+        );
+
+        let output = format_project_summaries(&projects, false);
+        assert!(output.lines().next().unwrap().ends_with("/work/new_app"));
+        assert!(output.contains("    2 sessions        4 messages  /work/old"));
+        Ok(())
+    }
+}
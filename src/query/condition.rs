@@ -1,4 +1,6 @@
 use super::fast_lowercase::FastLowercase;
+use crate::schemas::{SegmentKind, SessionMessage};
+use crate::utils::line_reader::MAX_LINE_BYTES;
 use serde::{Deserialize, Serialize};
 
 #[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
@@ -13,6 +15,24 @@ pub enum QueryCondition {
         pattern: String,
         flags: String,
     },
+    /// Typo-tolerant literal, matched word by word (see `--fuzzy`)
+    Fuzzy {
+        pattern: String,
+        #[serde(rename = "maxEdits", skip_serializing_if = "Option::is_none")]
+        max_edits: Option<usize>,
+    },
+    /// Literal where any run of whitespace matches any run of whitespace (see `--phrase`)
+    Phrase {
+        pattern: String,
+        #[serde(rename = "caseSensitive")]
+        case_sensitive: bool,
+    },
+    /// Literal compared after Unicode punctuation folding and NFC (see `--normalize`)
+    Normalized {
+        pattern: String,
+        #[serde(rename = "caseSensitive")]
+        case_sensitive: bool,
+    },
     Not {
         condition: Box<QueryCondition>,
     },
@@ -43,6 +63,17 @@ impl QueryCondition {
                 let regex = super::regex_cache::get_or_compile_regex(pattern, flags)?;
                 Ok(regex.is_match(text))
             }
+            QueryCondition::Fuzzy { pattern, max_edits } => {
+                Ok(super::fuzzy::find_fuzzy_match(text, pattern, *max_edits).is_some())
+            }
+            QueryCondition::Phrase {
+                pattern,
+                case_sensitive,
+            } => Ok(super::phrase::find_phrase(text, pattern, *case_sensitive).is_some()),
+            QueryCondition::Normalized {
+                pattern,
+                case_sensitive,
+            } => Ok(super::normalize::find_normalized(text, pattern, *case_sensitive).is_some()),
             QueryCondition::Not { condition } => Ok(!condition.evaluate(text)?),
             QueryCondition::And { conditions } => {
                 for condition in conditions {
@@ -72,11 +103,7 @@ impl QueryCondition {
                 if *case_sensitive {
                     text.find(pattern).map(|pos| (pos, pattern.len()))
                 } else {
-                    let lower_text = text.fast_to_lowercase();
-                    let lower_pattern = pattern.fast_to_lowercase();
-                    lower_text
-                        .find(&lower_pattern)
-                        .map(|pos| (pos, pattern.len()))
+                    text.fast_find_ignore_case_span(pattern)
                 }
             }
             QueryCondition::Regex { pattern, flags } => {
@@ -86,6 +113,17 @@ impl QueryCondition {
                     None
                 }
             }
+            QueryCondition::Fuzzy { pattern, max_edits } => {
+                super::fuzzy::find_fuzzy_match(text, pattern, *max_edits)
+            }
+            QueryCondition::Phrase {
+                pattern,
+                case_sensitive,
+            } => super::phrase::find_phrase(text, pattern, *case_sensitive),
+            QueryCondition::Normalized {
+                pattern,
+                case_sensitive,
+            } => super::normalize::find_normalized(text, pattern, *case_sensitive),
             QueryCondition::Not { .. } => None,
             QueryCondition::And { conditions } => {
                 // Return the first match from any condition
@@ -97,28 +135,303 @@ impl QueryCondition {
                 None
             }
             QueryCondition::Or { conditions } => {
-                // Return the first match from any condition
+                // Return whichever alternative occurs earliest in the text
+                conditions
+                    .iter()
+                    .filter_map(|condition| condition.find_match(text))
+                    .min_by_key(|&(start, _)| start)
+            }
+        }
+    }
+
+    /// Every occurrence of every positive term, as sorted, non-overlapping
+    /// `(start, len)` byte spans. Negated terms never produce spans.
+    pub fn find_all_matches(&self, text: &str) -> Vec<(usize, usize)> {
+        let mut spans = Vec::new();
+        self.collect_matches(text, &mut spans);
+        spans.sort_unstable();
+
+        // Overlapping spans from different terms are merged into one
+        let mut merged: Vec<(usize, usize)> = Vec::with_capacity(spans.len());
+        for (start, len) in spans {
+            match merged.last_mut() {
+                Some((last_start, last_len)) if start <= *last_start + *last_len => {
+                    *last_len = (*last_len).max(start + len - *last_start);
+                }
+                _ => merged.push((start, len)),
+            }
+        }
+        merged
+    }
+
+    /// The matched substrings of `text`, left to right, for `--only-matching`.
+    /// Unlike [`find_all_matches`](Self::find_all_matches), adjacent matches
+    /// stay separate; where matches of different terms overlap, the leftmost
+    /// (and then longest) one wins. With `group`, only regular expressions
+    /// contribute, each with that capture group of every match that sets it.
+    pub fn matched_texts<'a>(&self, text: &'a str, group: Option<usize>) -> Vec<&'a str> {
+        let mut spans = Vec::new();
+        match group {
+            Some(group) => self.collect_group_matches(text, group, &mut spans),
+            None => self.collect_matches(text, &mut spans),
+        }
+        spans.sort_unstable_by_key(|&(start, len)| (start, std::cmp::Reverse(len)));
+
+        let mut end = 0;
+        let mut texts = Vec::with_capacity(spans.len());
+        for (start, len) in spans {
+            if start >= end {
+                texts.push(&text[start..start + len]);
+                end = start + len;
+            }
+        }
+        texts
+    }
+
+    /// The most capture groups of any regular expression that can match
+    /// (outside `NOT`), or `None` when the query has no such expression
+    pub fn capture_group_count(&self) -> Option<usize> {
+        match self {
+            QueryCondition::Not { .. } => None,
+            QueryCondition::And { conditions } | QueryCondition::Or { conditions } => conditions
+                .iter()
+                .filter_map(QueryCondition::capture_group_count)
+                .max(),
+            QueryCondition::Regex { pattern, flags } => {
+                super::regex_cache::get_or_compile_regex(pattern, flags)
+                    .ok()
+                    // captures_len counts the implicit whole-match group 0
+                    .map(|regex| regex.captures_len() - 1)
+            }
+            _ => None,
+        }
+    }
+
+    fn collect_group_matches(&self, text: &str, group: usize, spans: &mut Vec<(usize, usize)>) {
+        match self {
+            QueryCondition::And { conditions } | QueryCondition::Or { conditions } => {
                 for condition in conditions {
-                    if let Some(m) = condition.find_match(text) {
-                        return Some(m);
+                    condition.collect_group_matches(text, group, spans);
+                }
+            }
+            QueryCondition::Regex { pattern, flags } => {
+                if let Ok(regex) = super::regex_cache::get_or_compile_regex(pattern, flags) {
+                    spans.extend(
+                        regex
+                            .captures_iter(text)
+                            .filter_map(|captures| captures.get(group))
+                            .filter(|m| !m.is_empty())
+                            .map(|m| (m.start(), m.len())),
+                    );
+                }
+            }
+            _ => {}
+        }
+    }
+
+    fn collect_matches(&self, text: &str, spans: &mut Vec<(usize, usize)>) {
+        match self {
+            QueryCondition::Not { .. } => {}
+            QueryCondition::And { conditions } | QueryCondition::Or { conditions } => {
+                for condition in conditions {
+                    condition.collect_matches(text, spans);
+                }
+            }
+            QueryCondition::Regex { pattern, flags } => {
+                if let Ok(regex) = super::regex_cache::get_or_compile_regex(pattern, flags) {
+                    spans.extend(
+                        regex
+                            .find_iter(text)
+                            .filter(|m| !m.is_empty())
+                            .map(|m| (m.start(), m.len())),
+                    );
+                }
+            }
+            leaf => {
+                // Search again after each hit; empty hits would never advance
+                let mut offset = 0;
+                while let Some((start, len)) = leaf.find_match(&text[offset..]) {
+                    if len == 0 {
+                        break;
+                    }
+                    spans.push((offset + start, len));
+                    offset += start + len;
+                    if !text.is_char_boundary(offset) {
+                        break;
                     }
                 }
-                None
             }
         }
     }
 }
 
+impl QueryCondition {
+    /// Replace every literal in the condition with a fuzzy match.
+    /// Regular expressions are kept as-is.
+    pub fn into_fuzzy(self, max_edits: Option<usize>) -> QueryCondition {
+        match self {
+            QueryCondition::Literal { pattern, .. } => QueryCondition::Fuzzy { pattern, max_edits },
+            QueryCondition::Not { condition } => QueryCondition::Not {
+                condition: Box::new(condition.into_fuzzy(max_edits)),
+            },
+            QueryCondition::And { conditions } => QueryCondition::And {
+                conditions: conditions
+                    .into_iter()
+                    .map(|c| c.into_fuzzy(max_edits))
+                    .collect(),
+            },
+            QueryCondition::Or { conditions } => QueryCondition::Or {
+                conditions: conditions
+                    .into_iter()
+                    .map(|c| c.into_fuzzy(max_edits))
+                    .collect(),
+            },
+            other => other,
+        }
+    }
+
+    /// Replace every literal in the condition with a whitespace-insensitive phrase.
+    /// Regular expressions are kept as-is.
+    pub fn into_phrase(self) -> QueryCondition {
+        match self {
+            QueryCondition::Literal {
+                pattern,
+                case_sensitive,
+            } => QueryCondition::Phrase {
+                pattern: super::phrase::normalize_whitespace(&pattern),
+                case_sensitive,
+            },
+            QueryCondition::Not { condition } => QueryCondition::Not {
+                condition: Box::new(condition.into_phrase()),
+            },
+            QueryCondition::And { conditions } => QueryCondition::And {
+                conditions: conditions.into_iter().map(|c| c.into_phrase()).collect(),
+            },
+            QueryCondition::Or { conditions } => QueryCondition::Or {
+                conditions: conditions.into_iter().map(|c| c.into_phrase()).collect(),
+            },
+            other => other,
+        }
+    }
+
+    /// Replace every literal in the condition with one that ignores how quotes,
+    /// dashes and spaces were typed. Regular expressions are kept as-is.
+    pub fn into_normalized(self) -> QueryCondition {
+        match self {
+            QueryCondition::Literal {
+                pattern,
+                case_sensitive,
+            } => QueryCondition::Normalized {
+                pattern: super::normalize::normalize_text(&pattern).into_owned(),
+                case_sensitive,
+            },
+            QueryCondition::Not { condition } => QueryCondition::Not {
+                condition: Box::new(condition.into_normalized()),
+            },
+            QueryCondition::And { conditions } => QueryCondition::And {
+                conditions: conditions
+                    .into_iter()
+                    .map(|c| c.into_normalized())
+                    .collect(),
+            },
+            QueryCondition::Or { conditions } => QueryCondition::Or {
+                conditions: conditions
+                    .into_iter()
+                    .map(|c| c.into_normalized())
+                    .collect(),
+            },
+            other => other,
+        }
+    }
+}
+
+/// The part of a message a query is matched against
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum MatchTarget {
+    /// Message text, session ID and uuid
+    #[default]
+    Content,
+    /// Model of an assistant response
+    Model,
+    /// Session ID
+    Session,
+    /// Git branch the message was written on
+    Branch,
+    /// All of the above
+    All,
+}
+
 #[derive(Debug, Clone)]
 pub struct SearchOptions {
+    /// Maximum number of results to return; `None` or `Some(0)` returns every match
     pub max_results: Option<usize>,
     pub role: Option<String>,
     pub session_id: Option<String>,
     pub message_id: Option<String>,
     pub before: Option<String>,
     pub after: Option<String>,
+    /// Set by `-v`; diagnostics themselves go through `tracing`
     pub verbose: bool,
     pub project_path: Option<String>,
+    /// Report per-file progress on stderr (only when it is a terminal)
+    pub progress: bool,
+    /// Drop repeated messages (same uuid, or same text for summaries), keeping the earliest
+    pub dedupe: bool,
+    /// Skip files larger than this many bytes (on disk, so compressed size for `.gz` and `.zst`)
+    pub max_file_size: Option<u64>,
+    /// Only match messages with this `userType` (e.g. `external`); messages without one are excluded
+    pub user_type: Option<String>,
+    /// Drop meta messages and compaction summaries (`isMeta` / `isCompactSummary`)
+    pub exclude_meta: bool,
+    /// Match against assistant `thinking` blocks as well as visible text
+    pub include_thinking: bool,
+    /// Only messages with a `tool_use` of this id or a `tool_result` referencing it
+    pub tool_use_id: Option<String>,
+    /// Reuse the discovered file list from an on-disk cache younger than this
+    pub file_cache_ttl: Option<std::time::Duration>,
+    /// Only messages produced by this API request (`requestId`)
+    pub request_id: Option<String>,
+    /// Only messages whose text has at least this many characters
+    pub min_length: Option<usize>,
+    /// Only messages whose text has at most this many characters
+    pub max_length: Option<usize>,
+    /// Only messages carrying a `tool_result` with `is_error: true`
+    pub tool_errors: bool,
+    /// Keep at most this many results from any one file, before `max_results` applies
+    pub max_per_file: Option<usize>,
+    /// Number of files searched at once; `None` uses one worker per CPU
+    pub workers: Option<usize>,
+    /// Stop searching once `max_results` results are collected, instead of finding the newest
+    pub stop_early: bool,
+    /// Globs for files to leave out after discovery; a file is dropped when its path or a parent directory matches
+    pub exclude: Vec<String>,
+    /// Also search `message.content` of an unexpected shape (an object), as compact JSON
+    pub include_unknown: bool,
+    /// Search exactly these files instead of discovering them from the pattern (`--files-from`)
+    pub files: Option<Vec<std::path::PathBuf>>,
+    /// Prefix lines from thinking blocks with `[thinking]` in result text
+    pub label_thinking: bool,
+    /// Which part of a message the query is matched against (--match-field)
+    pub match_target: MatchTarget,
+    /// Keep each result's raw JSON line (--raw, --extract); it is always kept
+    /// with a session or message ID filter
+    pub keep_raw_json: bool,
+    /// Also search text from `toolUseResult` payloads (command output, diffs, file contents)
+    pub include_tool_use_result: bool,
+    /// Report each content block that matches by itself as its own result
+    /// (`--per-segment`)
+    pub per_segment: bool,
+    /// Skip files whose raw bytes rule out a match before parsing them (see
+    /// [`FilePrescan`](crate::search::FilePrescan)); `--no-pre-filter` turns it off
+    pub pre_filter: bool,
+    /// Longest line parsed; longer lines are skipped and counted (`--max-line-bytes`)
+    pub max_line_bytes: usize,
+    /// Sort results by file, then line, instead of by timestamp (`--order-stable`)
+    pub order_stable: bool,
+    /// Stop reading a file after this long and keep what it matched so far (`--file-timeout`).
+    /// Checked before each line is read, so a line being read (or a read that blocks)
+    /// runs to completion first
+    pub file_timeout: Option<std::time::Duration>,
 }
 
 impl Default for SearchOptions {
@@ -132,10 +445,168 @@ impl Default for SearchOptions {
             after: None,
             verbose: false,
             project_path: None,
+            progress: false,
+            dedupe: false,
+            max_file_size: None,
+            user_type: None,
+            exclude_meta: false,
+            include_thinking: true,
+            tool_use_id: None,
+            file_cache_ttl: None,
+            request_id: None,
+            min_length: None,
+            max_length: None,
+            tool_errors: false,
+            max_per_file: None,
+            workers: None,
+            stop_early: false,
+            exclude: Vec::new(),
+            include_unknown: false,
+            files: None,
+            label_thinking: false,
+            match_target: MatchTarget::Content,
+            keep_raw_json: false,
+            include_tool_use_result: false,
+            per_segment: false,
+            pre_filter: true,
+            max_line_bytes: MAX_LINE_BYTES,
+            order_stable: false,
+            file_timeout: None,
         }
     }
 }
 
+impl SearchOptions {
+    /// Text of `message` as these options see it: without thinking blocks
+    /// unless `include_thinking`, with unknown content if `include_unknown` and
+    /// `toolUseResult` text if `include_tool_use_result`
+    pub fn content_text(&self, message: &SessionMessage) -> String {
+        let text = message.get_content_text_with(self.include_thinking);
+        match self.extra_text(message) {
+            Some(extra) if text.is_empty() => extra,
+            Some(extra) => format!("{text}\n{extra}"),
+            None => text,
+        }
+    }
+
+    // Text beyond the message content that these options add to it
+    fn extra_text(&self, message: &SessionMessage) -> Option<String> {
+        let unknown = message
+            .get_unknown_content()
+            .filter(|_| self.include_unknown);
+        let tool_use_result = message
+            .get_tool_use_result_text()
+            .filter(|_| self.include_tool_use_result);
+        match (unknown, tool_use_result) {
+            (Some(unknown), Some(result)) => Some(format!("{unknown}\n{result}")),
+            (unknown, result) => unknown.or(result),
+        }
+    }
+
+    /// Text of `message` to show in a result: [`content_text`](Self::content_text),
+    /// with every line from a thinking block prefixed by `[thinking] ` if `label_thinking`
+    pub fn display_text(&self, message: &SessionMessage) -> String {
+        if !self.label_thinking {
+            return self.content_text(message);
+        }
+        let mut lines: Vec<String> = Vec::new();
+        for segment in message.get_content_segments() {
+            match segment.kind {
+                SegmentKind::Thinking if !self.include_thinking => {}
+                SegmentKind::Thinking => lines.extend(
+                    segment
+                        .text
+                        .split('\n')
+                        .map(|line| format!("[thinking] {line}")),
+                ),
+                _ => lines.push(segment.text),
+            }
+        }
+        if let Some(extra) = self.extra_text(message) {
+            lines.push(extra);
+        }
+        lines.join("\n")
+    }
+
+    /// Text the query is matched against: for [`MatchTarget::Content`],
+    /// [`content_text`](Self::content_text) plus the session ID and uuid
+    pub fn searchable_text(&self, message: &SessionMessage) -> String {
+        let content = || {
+            let text = message.get_searchable_text_with(self.include_thinking);
+            match self.extra_text(message) {
+                Some(extra) => format!("{extra} {text}"),
+                None => text,
+            }
+        };
+        let model = || message.get_model().unwrap_or_default().to_string();
+        let session = || message.get_session_id().unwrap_or_default().to_string();
+        let branch = || message.get_git_branch().unwrap_or_default().to_string();
+        match self.match_target {
+            MatchTarget::Content => content(),
+            MatchTarget::Model => model(),
+            MatchTarget::Session => session(),
+            MatchTarget::Branch => branch(),
+            // The session ID is part of the content already
+            MatchTarget::All => [content(), model(), branch()]
+                .into_iter()
+                .filter(|text| !text.is_empty())
+                .collect::<Vec<_>>()
+                .join(" "),
+        }
+    }
+
+    /// The results for a `message` that matched `query`: `result` itself or,
+    /// with `per_segment`, a copy of it for every content segment that matches
+    /// `query` on its own, its text the segment's prefixed by its kind
+    /// (`[tool_result] ...`). A message that only matches with several segments
+    /// (or text outside them) together stays one result.
+    pub fn segment_results(
+        &self,
+        message: &SessionMessage,
+        query: &QueryCondition,
+        result: SearchResult,
+    ) -> Vec<SearchResult> {
+        if !self.per_segment {
+            return vec![result];
+        }
+        let segments: Vec<SearchResult> = message
+            .get_content_segments()
+            .into_iter()
+            .filter(|segment| self.include_thinking || segment.kind != SegmentKind::Thinking)
+            .filter(|segment| query.evaluate(&segment.text).unwrap_or(false))
+            .map(|segment| SearchResult {
+                text: format!("[{}] {}", segment.kind.name(), segment.text),
+                ..result.clone()
+            })
+            .collect();
+        if segments.is_empty() {
+            vec![result]
+        } else {
+            segments
+        }
+    }
+
+    /// Whether results carry their raw JSON line
+    pub fn captures_raw_json(&self) -> bool {
+        self.keep_raw_json || self.session_id.is_some() || self.message_id.is_some()
+    }
+
+    /// Whether `min_length` or `max_length` is set
+    pub fn filters_length(&self) -> bool {
+        self.min_length.is_some() || self.max_length.is_some()
+    }
+
+    /// Whether `text` is within `min_length..=max_length`, counted in characters
+    pub fn accepts_length(&self, text: &str) -> bool {
+        if !self.filters_length() {
+            return true;
+        }
+        let length = text.chars().count();
+        self.min_length.is_none_or(|min| length >= min)
+            && self.max_length.is_none_or(|max| length <= max)
+    }
+}
+
 #[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
 pub struct SearchResult {
     pub file: String,
@@ -149,6 +620,19 @@ pub struct SearchResult {
     pub cwd: String,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub raw_json: Option<String>,
+    /// 1-based line number of the message within `file`
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub line_number: Option<usize>,
+    /// API request that produced the message (`requestId`), for correlating with API logs
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub request_id: Option<String>,
+    /// The message this one replies to (`parentUuid`); `None` for the first
+    /// message of a conversation. With `session_id`, enough to rebuild threads
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub parent_uuid: Option<String>,
+    /// Whether the message belongs to a sidechain (`isSidechain`), such as a subagent's run
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub is_sidechain: bool,
 }
 
 use crate::interactive_ratatui::ui::components::list_item::{ListItem, wrap_text};
@@ -438,6 +922,91 @@ mod tests {
         assert_eq!(&text[start..start + len], "404");
     }
 
+    #[test]
+    fn test_find_match_or_returns_earliest_alternative() {
+        let condition = QueryCondition::Or {
+            conditions: vec![
+                QueryCondition::Literal {
+                    pattern: "warning".to_string(),
+                    case_sensitive: false,
+                },
+                QueryCondition::Literal {
+                    pattern: "error".to_string(),
+                    case_sensitive: false,
+                },
+            ],
+        };
+
+        let text = "An error, then a warning";
+        let (start, len) = condition.find_match(text).unwrap();
+        assert_eq!(&text[start..start + len], "error");
+        assert_eq!(condition.find_match("nothing here"), None);
+    }
+
+    #[test]
+    fn test_find_all_matches_merges_terms() {
+        let condition = QueryCondition::Or {
+            conditions: vec![
+                QueryCondition::Literal {
+                    pattern: "error".to_string(),
+                    case_sensitive: false,
+                },
+                QueryCondition::Regex {
+                    pattern: r"\d+".to_string(),
+                    flags: String::new(),
+                },
+                QueryCondition::Not {
+                    condition: Box::new(QueryCondition::Literal {
+                        pattern: "then".to_string(),
+                        case_sensitive: false,
+                    }),
+                },
+            ],
+        };
+
+        let text = "Error 42, then error404 and ERROR";
+        let spans: Vec<&str> = condition
+            .find_all_matches(text)
+            .into_iter()
+            .map(|(start, len)| &text[start..start + len])
+            .collect();
+        // "error" and "404" touch, so they come back as one span
+        assert_eq!(spans, vec!["Error", "42", "error404", "ERROR"]);
+        assert!(condition.find_all_matches("nothing here").is_empty());
+    }
+
+    #[test]
+    fn test_matched_texts_keeps_adjacent_matches_apart() {
+        let condition = QueryCondition::Or {
+            conditions: vec![
+                QueryCondition::Literal {
+                    pattern: "error".to_string(),
+                    case_sensitive: false,
+                },
+                QueryCondition::Regex {
+                    pattern: r"(E|W)(\d+)".to_string(),
+                    flags: String::new(),
+                },
+            ],
+        };
+
+        let text = "error E42 then errorW7, see E1";
+        assert_eq!(
+            condition.matched_texts(text, None),
+            vec!["error", "E42", "error", "W7", "E1"]
+        );
+        assert_eq!(condition.matched_texts(text, Some(2)), vec!["42", "7", "1"]);
+        assert!(condition.matched_texts(text, Some(3)).is_empty());
+        assert_eq!(condition.capture_group_count(), Some(2));
+
+        let literal = QueryCondition::Literal {
+            pattern: "aa".to_string(),
+            case_sensitive: false,
+        };
+        assert_eq!(literal.matched_texts("aaaaa", None), vec!["aa", "aa"]);
+        assert_eq!(literal.capture_group_count(), None);
+    }
+
     #[test]
     fn test_invalid_regex_error() {
         let condition = QueryCondition::Regex {
@@ -463,4 +1032,74 @@ mod tests {
         // Empty OR should return false (no conditions are satisfied)
         assert!(!condition.evaluate("anything").unwrap());
     }
+
+    #[test]
+    fn test_into_fuzzy_converts_literals() {
+        let condition = QueryCondition::And {
+            conditions: vec![
+                QueryCondition::Literal {
+                    pattern: "conection".to_string(),
+                    case_sensitive: false,
+                },
+                QueryCondition::Regex {
+                    pattern: "err.r".to_string(),
+                    flags: "".to_string(),
+                },
+            ],
+        }
+        .into_fuzzy(None);
+
+        assert!(condition.evaluate("connection error").unwrap());
+        assert!(!condition.evaluate("connection ok").unwrap());
+
+        let text = "lost connection";
+        let (start, len) = condition.find_match(text).unwrap();
+        assert_eq!(&text[start..start + len], "connection");
+    }
+
+    #[test]
+    fn test_into_phrase_matches_across_line_breaks() {
+        let condition = QueryCondition::Not {
+            condition: Box::new(QueryCondition::Literal {
+                pattern: "connection  reset".to_string(),
+                case_sensitive: false,
+            }),
+        }
+        .into_phrase();
+        assert!(!condition.evaluate("Connection\nreset by peer").unwrap());
+
+        let QueryCondition::Not { condition } = condition else {
+            panic!("expected NOT to be kept");
+        };
+        let text = "error: connection\n\treset by peer";
+        let (start, len) = condition.find_match(text).unwrap();
+        assert_eq!(&text[start..start + len], "connection\n\treset");
+    }
+
+    #[test]
+    fn test_into_normalized_ignores_typographic_punctuation() {
+        let condition = QueryCondition::And {
+            conditions: vec![
+                QueryCondition::Literal {
+                    pattern: "don't \u{2014} stop".to_string(),
+                    case_sensitive: false,
+                },
+                QueryCondition::Regex {
+                    pattern: "^I".to_string(),
+                    flags: String::new(),
+                },
+            ],
+        }
+        .into_normalized();
+        let text = "I said \u{201C}Don\u{2019}t - stop\u{201D}";
+        assert!(condition.evaluate(text).unwrap());
+        assert!(!condition.evaluate("I said do not stop").unwrap());
+
+        let QueryCondition::And { conditions } = condition else {
+            panic!("expected AND to be kept");
+        };
+        let (start, len) = conditions[0].find_match(text).unwrap();
+        assert_eq!(&text[start..start + len], "Don\u{2019}t - stop");
+        assert!(matches!(conditions[1], QueryCondition::Regex { .. }));
+    }
 }
@@ -4,6 +4,11 @@
 pub trait FastLowercase {
     fn fast_to_lowercase(&self) -> String;
     fn fast_contains_ignore_case(&self, pattern: &str) -> bool;
+    /// Byte offset of the first case-insensitive occurrence of `pattern`
+    fn fast_find_ignore_case(&self, pattern: &str) -> Option<usize>;
+    /// Byte span `(start, len)` of the first case-insensitive occurrence of
+    /// `pattern`, which may differ from the pattern's length in non-ASCII text
+    fn fast_find_ignore_case_span(&self, pattern: &str) -> Option<(usize, usize)>;
 }
 
 impl FastLowercase for str {
@@ -21,38 +26,138 @@ impl FastLowercase for str {
 
     #[inline]
     fn fast_contains_ignore_case(&self, pattern: &str) -> bool {
-        // If both strings are ASCII, use optimized comparison
-        if self.is_ascii() && pattern.is_ascii() {
-            // Convert to bytes for SIMD-friendly operations
-            let self_bytes = self.as_bytes();
-            let pattern_bytes = pattern.as_bytes();
-
-            if pattern_bytes.is_empty() {
-                return true;
-            }
+        if can_match_in_place(self, pattern) {
+            contains_ignore_ascii_case(self.as_bytes(), pattern.as_bytes())
+        } else {
+            find_folded(self, pattern).is_some()
+        }
+    }
+
+    #[inline]
+    fn fast_find_ignore_case(&self, pattern: &str) -> Option<usize> {
+        self.fast_find_ignore_case_span(pattern)
+            .map(|(start, _)| start)
+    }
+
+    #[inline]
+    fn fast_find_ignore_case_span(&self, pattern: &str) -> Option<(usize, usize)> {
+        if can_match_in_place(self, pattern) {
+            find_ignore_ascii_case(self.as_bytes(), pattern.as_bytes())
+                .map(|start| (start, pattern.len()))
+        } else {
+            find_folded(self, pattern)
+        }
+    }
+}
 
-            if self_bytes.len() < pattern_bytes.len() {
-                return false;
+/// The character `c` is compared as in a case-insensitive search: its
+/// lowercase form, with the variant letters Unicode case folding also merges
+/// (final sigma, long s, Greek symbol forms) mapped to their usual letter.
+///
+/// Like Unicode simple case folding, a character is never compared as several:
+/// `ß` does not match `ss`. The exception is `İ`, which lowercases to `i` plus a
+/// combining dot and is compared as `i`.
+#[inline]
+pub fn fold_case(c: char) -> char {
+    match c {
+        'ς' => 'σ',
+        'ſ' => 's',
+        'µ' => 'μ',
+        'ϐ' => 'β',
+        'ϑ' => 'θ',
+        'ϕ' => 'φ',
+        'ϖ' => 'π',
+        'ϰ' => 'κ',
+        'ϱ' => 'ρ',
+        'ϵ' => 'ε',
+        'ẛ' => 'ṡ',
+        '\u{1FBE}' | '\u{0345}' => 'ι',
+        _ => c.to_lowercase().next().unwrap_or(c),
+    }
+}
+
+// Span of the first occurrence of `pattern` in `text`, comparing character by
+// character with `fold_case`, for queries or text the byte search can't handle
+fn find_folded(text: &str, pattern: &str) -> Option<(usize, usize)> {
+    let needle: Vec<char> = pattern.chars().map(fold_case).collect();
+    let Some(&first) = needle.first() else {
+        return Some((0, 0));
+    };
+    text.char_indices()
+        .filter(|&(_, c)| fold_case(c) == first)
+        .find_map(|(start, _)| {
+            let mut chars = text[start..].chars();
+            let mut len = 0;
+            for &wanted in &needle {
+                let c = chars.next().filter(|&c| fold_case(c) == wanted)?;
+                len += c.len_utf8();
             }
+            Some((start, len))
+        })
+}
 
-            // ASCII case-insensitive substring search
-            'outer: for i in 0..=(self_bytes.len() - pattern_bytes.len()) {
-                for j in 0..pattern_bytes.len() {
-                    let a = self_bytes[i + j];
-                    let b = pattern_bytes[j];
-
-                    // Fast ASCII case-insensitive comparison
-                    if a != b && !a.eq_ignore_ascii_case(&b) {
-                        continue 'outer;
-                    }
-                }
-                return true;
+// An ASCII pattern can be matched against the raw bytes of any text: multi-byte
+// UTF-8 sequences never contain ASCII bytes, and no non-ASCII character lowercases
+// to ASCII except KELVIN SIGN ('k') and CAPITAL I WITH DOT ABOVE ("i\u{307}").
+#[inline]
+fn can_match_in_place(text: &str, pattern: &str) -> bool {
+    pattern.is_ascii() && (text.is_ascii() || !text.contains(['\u{212A}', '\u{0130}']))
+}
+
+/// ASCII case-insensitive substring search (Boyer-Moore-Horspool).
+///
+/// Scans `haystack` in place without allocating. Non-ASCII bytes are compared
+/// exactly, so the result equals lowercasing both sides only for ASCII input.
+pub fn contains_ignore_ascii_case(haystack: &[u8], needle: &[u8]) -> bool {
+    find_ignore_ascii_case(haystack, needle).is_some()
+}
+
+/// Like [`contains_ignore_ascii_case`], returning the offset of the first match
+pub fn find_ignore_ascii_case(haystack: &[u8], needle: &[u8]) -> Option<usize> {
+    let n = needle.len();
+    if n == 0 {
+        return Some(0);
+    }
+    if haystack.len() < n {
+        return None;
+    }
+    // Building the skip table doesn't pay off for very short needles
+    if n < 4 {
+        return haystack
+            .windows(n)
+            .position(|window| window.eq_ignore_ascii_case(needle));
+    }
+
+    // How far the window may move when its last byte is `b`, for both cases of
+    // each needle byte. Shifts are capped at u8::MAX, which is always safe.
+    let mut skip = [n.min(u8::MAX as usize) as u8; 256];
+    for (i, &b) in needle[..n - 1].iter().enumerate() {
+        let shift = (n - 1 - i).min(u8::MAX as usize) as u8;
+        skip[b.to_ascii_lowercase() as usize] = shift;
+        skip[b.to_ascii_uppercase() as usize] = shift;
+    }
+    // A zero shift marks a window ending in the needle's last byte, so the inner
+    // loop only has one branch per step; such windows then move by `last_shift`
+    let last = needle[n - 1];
+    let last_shift = skip[last as usize] as usize;
+    skip[last.to_ascii_lowercase() as usize] = 0;
+    skip[last.to_ascii_uppercase() as usize] = 0;
+
+    let mut pos = 0;
+    loop {
+        loop {
+            if pos + n > haystack.len() {
+                return None;
+            }
+            match skip[haystack[pos + n - 1] as usize] {
+                0 => break,
+                shift => pos += shift as usize,
             }
-            false
-        } else {
-            // Unicode fallback
-            self.to_lowercase().contains(&pattern.to_lowercase())
         }
+        if haystack[pos..pos + n - 1].eq_ignore_ascii_case(&needle[..n - 1]) {
+            return Some(pos);
+        }
+        pos += last_shift;
     }
 }
 
@@ -89,6 +194,101 @@ mod tests {
         assert!(!"Hello".fast_contains_ignore_case("привет"));
     }
 
+    #[test]
+    fn test_contains_ignore_ascii_case_matches_naive_search() {
+        let haystacks = [
+            "The quick brown fox jumps over the lazy dog",
+            "error: Connection REFUSED while connecting to localhost:5432",
+            "aaaaaaaaaaaaaaaaaaaaaaaaaaaaab",
+            "abcabcabdabcabcabcabd",
+            "",
+        ];
+        let needles = [
+            "LAZY DOG",
+            "connection refused",
+            "aaab",
+            "abcabd",
+            "abcabcabcabd",
+            "fox jumps over the lazy cat",
+            "x",
+            "dog",
+        ];
+
+        for haystack in haystacks {
+            for needle in needles {
+                let expected = haystack.to_lowercase().contains(&needle.to_lowercase());
+                assert_eq!(
+                    contains_ignore_ascii_case(haystack.as_bytes(), needle.as_bytes()),
+                    expected,
+                    "{needle:?} in {haystack:?}"
+                );
+            }
+        }
+    }
+
+    #[test]
+    fn test_contains_ignore_ascii_case_long_needle() {
+        let needle = "ab".repeat(200);
+        let haystack = format!("{}{}", "x".repeat(1000), needle.to_uppercase());
+        assert!(contains_ignore_ascii_case(
+            haystack.as_bytes(),
+            needle.as_bytes()
+        ));
+        assert!(!contains_ignore_ascii_case(
+            &haystack.as_bytes()[..haystack.len() - 1],
+            needle.as_bytes()
+        ));
+    }
+
+    #[test]
+    fn test_ascii_pattern_in_unicode_text() {
+        let text = "Ünïcödé text with an ERROR — and ✓ more";
+        assert!(text.fast_contains_ignore_case("error"));
+        assert!(!text.fast_contains_ignore_case("errors"));
+        assert_eq!(text.fast_find_ignore_case("error"), text.find("ERROR"));
+        assert_eq!(
+            &text[text.fast_find_ignore_case("an e").unwrap()..][..4],
+            "an E"
+        );
+
+        // Non-ASCII characters that lowercase to ASCII still match
+        assert!("\u{212A}ELVIN".fast_contains_ignore_case("kelvin"));
+        assert!("\u{0130}stanbul".fast_contains_ignore_case("i"));
+    }
+
+    #[test]
+    fn test_unicode_case_folding() {
+        // Accented Latin
+        assert!("Das ist über alles".fast_contains_ignore_case("ÜBER"));
+        assert!("ÉCOLE NORMALE".fast_contains_ignore_case("école"));
+        assert!("Ångström".fast_contains_ignore_case("ÅNGSTRÖM"));
+        assert!(!"uber".fast_contains_ignore_case("ÜBER"));
+        // Cyrillic
+        assert!("Ошибка компиляции".fast_contains_ignore_case("ОШИБКА"));
+        assert!("ПРИВЕТ, мир".fast_contains_ignore_case("привет, МИР"));
+        assert!(!"Привет".fast_contains_ignore_case("пока"));
+        // Greek final sigma folds like the medial one
+        assert!("ΟΔΟΣ".fast_contains_ignore_case("οδος"));
+        assert!("οδος".fast_contains_ignore_case("ΟΔΟΣ"));
+        // One character never matches two
+        assert!(!"Straße".fast_contains_ignore_case("STRASSE"));
+        assert!("Straße".fast_contains_ignore_case("STRAßE"));
+    }
+
+    #[test]
+    fn test_unicode_match_spans_are_in_the_original_text() {
+        let text = "Temperature 5 \u{212A}, then ÜBER";
+        let (start, len) = text.fast_find_ignore_case_span("k, then über").unwrap();
+        assert_eq!(&text[start..start + len], "\u{212A}, then ÜBER");
+
+        // Lowercasing İ adds a byte, which must not shift later offsets
+        let text = "İİ Ошибка";
+        let (start, len) = text.fast_find_ignore_case_span("ошибка").unwrap();
+        assert_eq!(&text[start..start + len], "Ошибка");
+        assert_eq!(text.fast_find_ignore_case("ОШИБКА"), Some(start));
+        assert_eq!("abc".fast_find_ignore_case_span("ü"), None);
+    }
+
     #[test]
     fn test_edge_cases() {
         assert!("".fast_contains_ignore_case(""));
@@ -0,0 +1,149 @@
+//! Typo-tolerant word matching for `--fuzzy` searches
+//!
+//! Query terms are compared against the words of the message text using a
+//! bounded Levenshtein distance. Because approximate matches cannot be found by
+//! substring search, fuzzy conditions must never be used with a raw-line
+//! substring pre-filter.
+
+/// Default edit budget for a term: exact for very short terms, then 1, then 2
+pub fn default_max_edits(term_len: usize) -> usize {
+    match term_len {
+        0..=2 => 0,
+        3..=5 => 1,
+        _ => 2,
+    }
+}
+
+/// Levenshtein distance between `a` and `b`, or `None` if it exceeds `max`
+pub fn bounded_levenshtein(a: &[char], b: &[char], max: usize) -> Option<usize> {
+    if a.len().abs_diff(b.len()) > max {
+        return None;
+    }
+
+    let mut prev: Vec<usize> = (0..=b.len()).collect();
+    let mut curr = vec![0; b.len() + 1];
+
+    for (i, ca) in a.iter().enumerate() {
+        curr[0] = i + 1;
+        let mut row_min = curr[0];
+        for (j, cb) in b.iter().enumerate() {
+            let cost = usize::from(ca != cb);
+            curr[j + 1] = (prev[j] + cost).min(prev[j + 1] + 1).min(curr[j] + 1);
+            row_min = row_min.min(curr[j + 1]);
+        }
+        // Every later row is at least the current minimum, so stop early
+        if row_min > max {
+            return None;
+        }
+        std::mem::swap(&mut prev, &mut curr);
+    }
+
+    let distance = prev[b.len()];
+    (distance <= max).then_some(distance)
+}
+
+/// Find the first word in `text` matching the first term of `pattern`, requiring
+/// every term to fuzzily match some word. Returns the byte span of that word.
+pub fn find_fuzzy_match(
+    text: &str,
+    pattern: &str,
+    max_edits: Option<usize>,
+) -> Option<(usize, usize)> {
+    let terms: Vec<Vec<char>> = tokenize(pattern)
+        .map(|(_, word)| lowercase_chars(word))
+        .collect();
+    if terms.is_empty() {
+        return Some((0, 0));
+    }
+
+    let words: Vec<(usize, &str, Vec<char>)> = tokenize(text)
+        .map(|(start, word)| (start, word, lowercase_chars(word)))
+        .collect();
+
+    let mut first_match = None;
+    for term in &terms {
+        let max = max_edits.unwrap_or_else(|| default_max_edits(term.len()));
+        let (start, word, _) = words
+            .iter()
+            .find(|(_, _, chars)| bounded_levenshtein(term, chars, max).is_some())?;
+        first_match.get_or_insert((*start, word.len()));
+    }
+    first_match
+}
+
+// Split text into alphanumeric words along with their byte offsets
+fn tokenize(text: &str) -> impl Iterator<Item = (usize, &str)> {
+    text.split(|c: char| !(c.is_alphanumeric() || c == '_'))
+        .filter(|word| !word.is_empty())
+        .map(move |word| (word.as_ptr() as usize - text.as_ptr() as usize, word))
+}
+
+fn lowercase_chars(word: &str) -> Vec<char> {
+    word.chars().flat_map(char::to_lowercase).collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn chars(s: &str) -> Vec<char> {
+        s.chars().collect()
+    }
+
+    #[test]
+    fn test_bounded_levenshtein() {
+        assert_eq!(
+            bounded_levenshtein(&chars("kitten"), &chars("kitten"), 0),
+            Some(0)
+        );
+        assert_eq!(
+            bounded_levenshtein(&chars("kitten"), &chars("sitting"), 3),
+            Some(3)
+        );
+        assert_eq!(
+            bounded_levenshtein(&chars("kitten"), &chars("sitting"), 2),
+            None
+        );
+        assert_eq!(bounded_levenshtein(&chars("a"), &chars("abcd"), 1), None);
+    }
+
+    #[test]
+    fn test_default_max_edits() {
+        assert_eq!(default_max_edits(2), 0);
+        assert_eq!(default_max_edits(4), 1);
+        assert_eq!(default_max_edits(9), 2);
+    }
+
+    #[test]
+    fn test_find_fuzzy_match_typo() {
+        let text = "The configuration file was missing";
+        assert_eq!(find_fuzzy_match(text, "configuraton", None), Some((4, 13)));
+        assert_eq!(find_fuzzy_match(text, "MISSNG", None), Some((27, 7)));
+        assert_eq!(find_fuzzy_match(text, "database", None), None);
+    }
+
+    #[test]
+    fn test_find_fuzzy_match_requires_all_terms() {
+        let text = "connection refused by server";
+        assert_eq!(
+            find_fuzzy_match(text, "conection refusd", None),
+            Some((0, 10))
+        );
+        assert_eq!(find_fuzzy_match(text, "conection timeout", None), None);
+    }
+
+    #[test]
+    fn test_find_fuzzy_match_explicit_max_edits() {
+        assert!(find_fuzzy_match("hello world", "helo", Some(0)).is_none());
+        assert!(find_fuzzy_match("hello world", "hxllx", Some(2)).is_some());
+    }
+
+    #[test]
+    fn test_find_fuzzy_match_unicode() {
+        let text = "日本語 テキスト café";
+        assert_eq!(
+            find_fuzzy_match(text, "cafe", None),
+            Some((text.find("café").unwrap(), 5))
+        );
+    }
+}
@@ -1,6 +1,9 @@
 pub mod condition;
 pub mod fast_lowercase;
+pub mod fuzzy;
+pub mod normalize;
 pub mod parser;
+pub mod phrase;
 mod regex_cache;
 
 pub use condition::*;
@@ -0,0 +1,160 @@
+//! Unicode-insensitive literal matching for `--normalize` searches
+//!
+//! Text pasted from documents and chat tools is full of curly quotes, en and em
+//! dashes and non-breaking spaces that a query typed in ASCII never matches.
+//! Normalized matching compares both sides after NFC composition and after
+//! folding that punctuation to its ASCII equivalent. Matches are reported as
+//! spans of the original text, so previews and `-o` show what was written.
+
+use super::fast_lowercase::FastLowercase;
+use std::borrow::Cow;
+use unicode_normalization::UnicodeNormalization;
+use unicode_normalization::char::canonical_combining_class;
+
+/// `text` in NFC with common Unicode punctuation and spaces folded to ASCII;
+/// ASCII text is returned unchanged
+pub fn normalize_text(text: &str) -> Cow<'_, str> {
+    if text.is_ascii() {
+        Cow::Borrowed(text)
+    } else {
+        Cow::Owned(Normalized::new(text).text)
+    }
+}
+
+/// Byte span of the first occurrence of `pattern` (already normalized) in
+/// `text`, comparing `text` in its normalized form
+pub fn find_normalized(text: &str, pattern: &str, case_sensitive: bool) -> Option<(usize, usize)> {
+    let find = |haystack: &str| {
+        if case_sensitive {
+            haystack.find(pattern)
+        } else {
+            haystack.fast_find_ignore_case(pattern)
+        }
+    };
+    if text.is_ascii() {
+        return find(text).map(|start| (start, pattern.len()));
+    }
+
+    let normalized = Normalized::new(text);
+    let start = find(&normalized.text)?;
+    let (original_start, _) = normalized.segment_at(start);
+    if pattern.is_empty() {
+        return Some((original_start, 0));
+    }
+    let (_, original_end) = normalized.segment_at(start + pattern.len() - 1);
+    Some((original_start, original_end - original_start))
+}
+
+// ASCII replacement for a quote, dash, ellipsis or space character
+fn fold_char(c: char) -> Option<&'static str> {
+    Some(match c {
+        '\u{2018}' | '\u{2019}' | '\u{201A}' | '\u{201B}' | '\u{2032}' | '\u{FF07}' => "'",
+        '\u{201C}' | '\u{201D}' | '\u{201E}' | '\u{201F}' | '\u{2033}' | '\u{00AB}'
+        | '\u{00BB}' | '\u{FF02}' => "\"",
+        '\u{2010}'..='\u{2015}' | '\u{2212}' | '\u{FE58}' | '\u{FE63}' | '\u{FF0D}' => "-",
+        '\u{2026}' => "...",
+        '\u{00A0}' | '\u{2000}'..='\u{200A}' | '\u{202F}' | '\u{205F}' | '\u{3000}' => " ",
+        // Zero-width spaces and joiners, and a stray byte order mark
+        '\u{200B}' | '\u{200C}' | '\u{200D}' | '\u{2060}' | '\u{FEFF}' => "",
+        _ => return None,
+    })
+}
+
+// Normalized text, built one segment at a time: a character together with the
+// combining marks that follow it, the unit NFC composes
+struct Normalized<'a> {
+    original: &'a str,
+    text: String,
+    // (start in `text`, start in `original`) of every segment, ascending
+    segments: Vec<(usize, usize)>,
+}
+
+impl<'a> Normalized<'a> {
+    fn new(original: &'a str) -> Self {
+        let mut normalized = Normalized {
+            original,
+            text: String::with_capacity(original.len()),
+            segments: Vec::new(),
+        };
+        let mut segment_start = 0;
+        for (index, c) in original.char_indices().skip(1) {
+            if canonical_combining_class(c) == 0 {
+                normalized.push_segment(segment_start, index);
+                segment_start = index;
+            }
+        }
+        if !original.is_empty() {
+            normalized.push_segment(segment_start, original.len());
+        }
+        normalized
+    }
+
+    fn push_segment(&mut self, start: usize, end: usize) {
+        self.segments.push((self.text.len(), start));
+        for c in self.original[start..end].nfc() {
+            match fold_char(c) {
+                Some(folded) => self.text.push_str(folded),
+                None => self.text.push(c),
+            }
+        }
+    }
+
+    // Span in `original` of the segment that produced byte `offset` of `text`
+    fn segment_at(&self, offset: usize) -> (usize, usize) {
+        let index = self
+            .segments
+            .partition_point(|&(start, _)| start <= offset)
+            .saturating_sub(1);
+        let start = self.segments.get(index).map_or(0, |&(_, start)| start);
+        let end = self
+            .segments
+            .get(index + 1)
+            .map_or(self.original.len(), |&(_, start)| start);
+        (start, end)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_normalize_text() {
+        assert_eq!(normalize_text("plain ascii"), "plain ascii");
+        assert!(matches!(normalize_text("plain ascii"), Cow::Borrowed(_)));
+        assert_eq!(
+            normalize_text("\u{201C}don\u{2019}t\u{201D} \u{2014} wait\u{2026}"),
+            "\"don't\" - wait..."
+        );
+        assert_eq!(normalize_text("a\u{00A0}b\u{200B}c"), "a bc");
+        // e + combining acute composes to a single é
+        assert_eq!(normalize_text("caf\u{0065}\u{0301}"), "caf\u{00E9}");
+    }
+
+    #[test]
+    fn test_find_maps_back_to_original_text() {
+        let text = "She said \u{201C}don\u{2019}t panic\u{201D}";
+        let (start, len) = find_normalized(text, "\"don't panic\"", false).unwrap();
+        assert_eq!(
+            &text[start..start + len],
+            "\u{201C}don\u{2019}t panic\u{201D}"
+        );
+
+        let text = "a\u{200B}b \u{2013} caf\u{0065}\u{0301}!";
+        let (start, len) = find_normalized(text, "ab - caf\u{00E9}", true).unwrap();
+        assert_eq!(
+            &text[start..start + len],
+            "a\u{200B}b \u{2013} caf\u{0065}\u{0301}"
+        );
+
+        assert_eq!(
+            find_normalized("Non\u{00A0}breaking", "non breaking", false),
+            Some((0, 13))
+        );
+        assert_eq!(
+            find_normalized("Non\u{00A0}breaking", "non breaking", true),
+            None
+        );
+        assert_eq!(find_normalized("no match here \u{2014}", "--", false), None);
+    }
+}
@@ -0,0 +1,115 @@
+//! Whitespace-insensitive phrase matching for `--phrase` searches
+//!
+//! A phrase matches like a literal, except that any run of whitespace in the
+//! pattern matches any run of whitespace in the text. Message text joins content
+//! items with `\n`, so "connection reset" still finds "connection\nreset".
+//! Matches are reported as spans of the original text so previews highlight
+//! what was actually found.
+
+use super::fast_lowercase::FastLowercase;
+
+/// Byte span of the first occurrence of `pattern` in `text`, treating every run
+/// of whitespace in either as a single space
+pub fn find_phrase(text: &str, pattern: &str, case_sensitive: bool) -> Option<(usize, usize)> {
+    let words: Vec<&str> = pattern.split_whitespace().collect();
+    let Some(first) = words.first() else {
+        return Some((0, 0));
+    };
+
+    // Cheap rejection before trying every start position
+    let first_present = if case_sensitive {
+        text.contains(first)
+    } else {
+        text.fast_contains_ignore_case(first)
+    };
+    if !first_present {
+        return None;
+    }
+
+    text.char_indices().find_map(|(start, _)| {
+        match_words_at(text, start, &words, case_sensitive).map(|end| (start, end - start))
+    })
+}
+
+/// Collapse every run of whitespace to a single space and trim the ends
+pub fn normalize_whitespace(text: &str) -> String {
+    text.split_whitespace().collect::<Vec<_>>().join(" ")
+}
+
+// End of the phrase if `words`, separated by whitespace, start at `start`
+fn match_words_at(text: &str, start: usize, words: &[&str], case_sensitive: bool) -> Option<usize> {
+    let mut pos = start;
+    for (i, word) in words.iter().enumerate() {
+        if i > 0 {
+            let rest = &text[pos..];
+            let gap = rest.len() - rest.trim_start().len();
+            if gap == 0 {
+                return None;
+            }
+            pos += gap;
+        }
+        pos = match_word_at(text, pos, word, case_sensitive)?;
+    }
+    Some(pos)
+}
+
+fn match_word_at(text: &str, pos: usize, word: &str, case_sensitive: bool) -> Option<usize> {
+    let mut text_chars = text[pos..].char_indices();
+    let mut end = pos;
+    for expected in word.chars() {
+        let (offset, actual) = text_chars.next()?;
+        let equal = actual == expected
+            || (!case_sensitive && actual.to_lowercase().eq(expected.to_lowercase()));
+        if !equal {
+            return None;
+        }
+        end = pos + offset + actual.len_utf8();
+    }
+    Some(end)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_matches_across_whitespace_runs() {
+        let text = "got a Connection\n  reset by peer";
+        assert_eq!(find_phrase(text, "connection reset", false), Some((6, 18)));
+        assert_eq!(&text[6..24], "Connection\n  reset");
+        assert_eq!(
+            find_phrase(text, "connection   reset", false),
+            Some((6, 18))
+        );
+        assert_eq!(find_phrase(text, "connection reset", true), None);
+    }
+
+    #[test]
+    fn test_requires_whitespace_between_words() {
+        assert_eq!(
+            find_phrase("connectionreset", "connection reset", false),
+            None
+        );
+        assert_eq!(
+            find_phrase("connection, reset", "connection reset", false),
+            None
+        );
+    }
+
+    #[test]
+    fn test_single_word_and_empty_pattern() {
+        assert_eq!(find_phrase("an Error here", "error", false), Some((3, 5)));
+        assert_eq!(find_phrase("anything", "  ", false), Some((0, 0)));
+    }
+
+    #[test]
+    fn test_non_ascii_text() {
+        let text = "日本語\tのテキスト";
+        assert_eq!(find_phrase(text, "日本語 の", false), Some((0, 13)));
+    }
+
+    #[test]
+    fn test_normalize_whitespace() {
+        assert_eq!(normalize_whitespace("  a\n\tb  c "), "a b c");
+    }
+}
@@ -0,0 +1,292 @@
+//! Most recently modified sessions for `--recent`
+//!
+//! Files are ordered by modification time, then only the end of each is read:
+//! the newest timestamp and the headline (the last summary or message typed by
+//! the user) are near the end of a session, so the scan stays cheap however
+//! long the sessions are. Compressed files cannot be read from the end and are
+//! read whole.
+
+use crate::projects::{json_string_field, project_directory};
+use crate::schemas::{SegmentKind, SessionMessage};
+use crate::search::engine::preview_end;
+use crate::utils::compression;
+use anyhow::{Context, Result};
+use chrono::{DateTime, Local};
+use rayon::prelude::*;
+use std::fs::File;
+use std::io::{Read, Seek, SeekFrom};
+use std::path::{Path, PathBuf};
+use std::time::SystemTime;
+
+// Bytes read from the end of a file at first; doubled until a headline turns up
+const TAIL_BYTES: u64 = 64 * 1024;
+
+// Longest headline shown, in characters
+const HEADLINE_CHARS: usize = 100;
+
+/// A session file and what it was last about
+#[derive(Debug, Clone, PartialEq)]
+pub struct RecentSession {
+    pub path: PathBuf,
+    /// Working directory recorded in the session, or the project directory name
+    pub project: String,
+    pub session_id: String,
+    /// Newest message timestamp (RFC3339)
+    pub last_timestamp: Option<String>,
+    /// Text of the last summary or user message, on one line
+    pub headline: Option<String>,
+}
+
+/// The `count` most recently modified of `files`, newest first
+pub fn recent_sessions(files: &[PathBuf], count: usize) -> Result<Vec<RecentSession>> {
+    let mut modified: Vec<(SystemTime, &PathBuf)> = files
+        .iter()
+        .filter_map(|path| {
+            let modified = std::fs::metadata(path).and_then(|m| m.modified()).ok()?;
+            Some((modified, path))
+        })
+        .collect();
+    modified.sort_by(|a, b| b.0.cmp(&a.0).then_with(|| a.1.cmp(b.1)));
+    modified.truncate(count);
+
+    modified
+        .par_iter()
+        .map(|(_, path)| read_recent(path))
+        .collect()
+}
+
+/// Two lines per session: last activity, project and session ID, then the headline
+pub fn format_recent_sessions(sessions: &[RecentSession], use_color: bool) -> String {
+    use colored::Colorize;
+
+    let mut output = String::new();
+    for session in sessions {
+        let last_activity = session
+            .last_timestamp
+            .as_deref()
+            .and_then(|t| DateTime::parse_from_rfc3339(t).ok())
+            .map(|t| {
+                t.with_timezone(&Local)
+                    .format("%Y-%m-%d %H:%M:%S")
+                    .to_string()
+            })
+            .unwrap_or_else(|| "-".repeat(19));
+        let headline = session.headline.as_deref().unwrap_or("(no messages)");
+        if use_color {
+            output.push_str(&format!(
+                "{}  {}  {}\n  {headline}\n",
+                last_activity.bright_blue(),
+                session.project.bright_green(),
+                session.session_id.dimmed()
+            ));
+        } else {
+            output.push_str(&format!(
+                "{last_activity}  {}  {}\n  {headline}\n",
+                session.project, session.session_id
+            ));
+        }
+    }
+    output
+}
+
+fn read_recent(path: &Path) -> Result<RecentSession> {
+    let mut session = RecentSession {
+        path: path.to_path_buf(),
+        project: String::new(),
+        session_id: String::new(),
+        last_timestamp: None,
+        headline: None,
+    };
+    let mut cwd = None;
+    let mut tail = TAIL_BYTES;
+    loop {
+        let (lines, whole) = read_tail(path, tail)?;
+        for line in lines.iter().rev() {
+            let Ok(line) = std::str::from_utf8(line.trim_ascii()) else {
+                continue;
+            };
+            if session.last_timestamp.is_none() {
+                session.last_timestamp = json_string_field(line, "timestamp");
+            }
+            if cwd.is_none() {
+                cwd = json_string_field(line, "cwd");
+            }
+            if session.session_id.is_empty() {
+                session.session_id = json_string_field(line, "sessionId").unwrap_or_default();
+            }
+            if session.headline.is_none() {
+                session.headline = sonic_rs::from_str::<SessionMessage>(line)
+                    .ok()
+                    .and_then(|message| headline(&message));
+            }
+        }
+        if session.headline.is_some() || whole {
+            break;
+        }
+        tail *= 2;
+    }
+
+    session.project = cwd.unwrap_or_else(|| project_directory(path));
+    if session.session_id.is_empty() {
+        session.session_id = path
+            .file_name()
+            .map(|name| {
+                name.to_string_lossy()
+                    .trim_end_matches(".gz")
+                    .trim_end_matches(".zst")
+                    .trim_end_matches(".jsonl")
+                    .to_string()
+            })
+            .unwrap_or_default();
+    }
+    Ok(session)
+}
+
+// The last `bytes` of the file as lines, less a first line that may be cut,
+// and whether that covers the whole file
+fn read_tail(path: &Path, bytes: u64) -> Result<(Vec<Vec<u8>>, bool)> {
+    let open = || format!("failed to open file: {}", path.display());
+    let mut content = Vec::new();
+    let whole = if compression::is_compressed_path(path) {
+        compression::open_session_file(path)
+            .with_context(open)?
+            .read_to_end(&mut content)?;
+        true
+    } else {
+        let mut file = File::open(path).with_context(open)?;
+        let len = file.metadata()?.len();
+        let start = len.saturating_sub(bytes);
+        file.seek(SeekFrom::Start(start))?;
+        file.read_to_end(&mut content)?;
+        if start > 0 {
+            let first_newline = content.iter().position(|&b| b == b'\n');
+            content.drain(..first_newline.map_or(content.len(), |i| i + 1));
+        } else if content.starts_with(compression::UTF8_BOM) {
+            content.drain(..compression::UTF8_BOM.len());
+        }
+        start == 0
+    };
+    let lines = content.split(|&b| b == b'\n').map(<[u8]>::to_vec).collect();
+    Ok((lines, whole))
+}
+
+// A summary's text, or the text the user typed (not tool results or meta messages)
+fn headline(message: &SessionMessage) -> Option<String> {
+    let text = match message {
+        SessionMessage::Summary { summary, .. } => summary.clone(),
+        SessionMessage::User { .. } if !message.is_meta() => message
+            .get_content_segments()
+            .into_iter()
+            .filter(|segment| segment.kind == SegmentKind::Text)
+            .map(|segment| segment.text)
+            .collect::<Vec<_>>()
+            .join(" "),
+        _ => return None,
+    };
+    let text = text.split_whitespace().collect::<Vec<_>>().join(" ");
+    if text.is_empty() {
+        return None;
+    }
+    Some(match text.char_indices().nth(HEADLINE_CHARS) {
+        Some((end, _)) => format!("{}…", &text[..preview_end(&text, 0, end)]),
+        None => text,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_fixtures;
+    use serde_json::{Value, json};
+    use std::fs;
+    use tempfile::tempdir;
+
+    fn user(content: Value, timestamp: &str) -> String {
+        test_fixtures::user("u")
+            .timestamp(timestamp)
+            .cwd("/work/app")
+            .content(content)
+            .to_string()
+    }
+
+    #[test]
+    fn test_recent_sessions() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let old = temp_dir.path().join("old.jsonl");
+        let new = temp_dir.path().join("new.jsonl");
+        let empty = temp_dir.path().join("empty.jsonl");
+        fs::write(&old, user(json!("first question"), "2024-01-01T00:00:00Z"))?;
+        // The last thing typed comes before a tool result, and has to be found
+        // further back than the first tail read
+        let padding = format!(
+            r#"{{"type":"system","content":"{}","isMeta":false,"uuid":"x","timestamp":"2024-01-02T00:00:01Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/work/app","version":"1"}}"#,
+            "x".repeat(TAIL_BYTES as usize)
+        );
+        fs::write(
+            &new,
+            [
+                user(json!("older question"), "2024-01-02T00:00:00Z"),
+                user(
+                    json!([{"type": "text", "text": "Why does\n  the build fail?"}]),
+                    "2024-01-02T00:00:00Z",
+                ),
+                padding,
+                user(
+                    json!([{"type": "tool_result", "tool_use_id": "t", "content": "ok"}]),
+                    "2024-01-02T00:00:02Z",
+                ),
+                String::new(),
+            ]
+            .join("\n"),
+        )?;
+        fs::write(&empty, "")?;
+        let now = SystemTime::now();
+        File::options()
+            .write(true)
+            .open(&old)?
+            .set_modified(now - std::time::Duration::from_secs(60))?;
+        File::options()
+            .write(true)
+            .open(&empty)?
+            .set_modified(now - std::time::Duration::from_secs(120))?;
+        File::options().write(true).open(&new)?.set_modified(now)?;
+
+        let files = vec![old.clone(), empty.clone(), new.clone()];
+        let sessions = recent_sessions(&files, 2)?;
+        assert_eq!(sessions.len(), 2);
+        assert_eq!(sessions[0].path, new);
+        assert_eq!(sessions[0].project, "/work/app");
+        assert_eq!(sessions[0].session_id, "s1");
+        assert_eq!(
+            sessions[0].last_timestamp.as_deref(),
+            Some("2024-01-02T00:00:02Z")
+        );
+        assert_eq!(
+            sessions[0].headline.as_deref(),
+            Some("Why does the build fail?")
+        );
+        assert_eq!(sessions[1].headline.as_deref(), Some("first question"));
+
+        let sessions = recent_sessions(&files, 10)?;
+        assert_eq!(sessions[2].session_id, "empty");
+        assert_eq!(sessions[2].headline, None);
+        let output = format_recent_sessions(&sessions, false);
+        assert!(output.contains("/work/app  s1\n  Why does the build fail?\n"));
+        assert!(output.ends_with("  (no messages)\n"));
+        Ok(())
+    }
+
+    #[test]
+    fn test_summary_headline() {
+        let summary: SessionMessage =
+            serde_json::from_str(r#"{"type":"summary","summary":"Fix login","leafUuid":"u"}"#)
+                .unwrap();
+        assert_eq!(headline(&summary).as_deref(), Some("Fix login"));
+
+        let long: SessionMessage =
+            serde_json::from_str(&user(json!("word ".repeat(40)), "2024-01-01T00:00:00Z")).unwrap();
+        let text = headline(&long).unwrap();
+        assert!(text.ends_with("word…"));
+        assert!(text.chars().count() <= HEADLINE_CHARS + 1);
+    }
+}
@@ -6,9 +6,11 @@ pub use session_message::{
     AssistantMessageContent,
     BaseMessage,
     Content,
+    ContentSegment,
     ImageContent,
     // Helper functions are not exported from session_message module
     // They are implemented as methods on SessionMessage
+    SegmentKind,
     SessionMessage,
     ToolResultContent,
     Usage,
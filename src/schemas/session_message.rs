@@ -43,6 +43,128 @@ pub enum Content {
     },
 }
 
+/// What kind of content block a piece of message text came from
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum SegmentKind {
+    Text,
+    Thinking,
+    ToolUse,
+    ToolResult,
+    Image,
+}
+
+impl SegmentKind {
+    /// The content block `type` the kind comes from, e.g. `tool_use`
+    pub fn name(self) -> &'static str {
+        match self {
+            SegmentKind::Text => "text",
+            SegmentKind::Thinking => "thinking",
+            SegmentKind::ToolUse => "tool_use",
+            SegmentKind::ToolResult => "tool_result",
+            SegmentKind::Image => "image",
+        }
+    }
+}
+
+/// One content block's contribution to the message text
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ContentSegment {
+    pub kind: SegmentKind,
+    pub text: String,
+}
+
+impl Content {
+    /// The text this block adds to its message: tool calls are reduced to the
+    /// tool name and its most telling argument, and empty tool results and
+    /// images to a placeholder
+    pub fn segment(&self) -> ContentSegment {
+        let (kind, text) = match self {
+            Content::Text { text } => (SegmentKind::Text, text.clone()),
+            Content::Thinking { thinking, .. } => (SegmentKind::Thinking, thinking.clone()),
+            Content::ToolUse { name, input, .. } => {
+                (SegmentKind::ToolUse, tool_use_text(name, input))
+            }
+            Content::ToolResult {
+                tool_use_id,
+                content,
+                is_error,
+            } => (
+                SegmentKind::ToolResult,
+                tool_result_text(tool_use_id, content.as_ref(), is_error.unwrap_or(false)),
+            ),
+            // Add placeholder for image entries
+            Content::Image { .. } => (SegmentKind::Image, "[Image]".to_string()),
+        };
+        ContentSegment { kind, text }
+    }
+}
+
+// Tool name plus the input field that best describes the call, e.g. "Bash: ls -la"
+fn tool_use_text(name: &str, input: &Value) -> String {
+    let mut tool_text = name.to_string();
+    let Some(obj) = input.as_object() else {
+        return tool_text;
+    };
+    // Field to show and how many characters of it; paths are shortened to the file name
+    let (field, limit) = match name {
+        "Bash" => ("command", Some(50)),
+        "Read" | "Write" | "Edit" => ("file_path", None),
+        "Grep" => ("pattern", Some(30)),
+        // For other tools, try to find a descriptive field
+        _ => ("description", Some(40)),
+    };
+    if let Some(value) = obj.get(field).and_then(|v| v.as_str()) {
+        tool_text.push_str(": ");
+        match limit {
+            Some(limit) => {
+                tool_text.extend(value.chars().take(limit));
+                if value.len() > limit {
+                    tool_text.push_str("...");
+                }
+            }
+            None => tool_text.push_str(value.split('/').next_back().unwrap_or(value)),
+        }
+    }
+    tool_text
+}
+
+// Every non-empty string in `value`, objects in key order. Left out are
+// `type` tags and `originalFile` (an edited file's whole previous contents,
+// which repeats what the patch shows and would make every edit match)
+fn collect_strings<'a>(value: &'a Value, strings: &mut Vec<&'a str>) {
+    match value {
+        Value::String(text) if !text.trim().is_empty() => strings.push(text),
+        Value::Array(items) => items.iter().for_each(|item| collect_strings(item, strings)),
+        Value::Object(fields) => fields
+            .iter()
+            .filter(|(key, _)| !matches!(key.as_str(), "type" | "originalFile"))
+            .for_each(|(_, field)| collect_strings(field, strings)),
+        _ => {}
+    }
+}
+
+// Tool output as text, or a placeholder naming the call when there is none
+fn tool_result_text(
+    tool_use_id: &str,
+    content: Option<&ToolResultContent>,
+    is_error: bool,
+) -> String {
+    let error = if is_error { " (error)" } else { "" };
+    match content {
+        Some(ToolResultContent::String(s)) if !s.is_empty() => s.clone(),
+        Some(ToolResultContent::TextArray(items)) if !items.is_empty() => items
+            .iter()
+            .map(|item| item.text.as_str())
+            .collect::<Vec<_>>()
+            .join("\n"),
+        Some(ToolResultContent::Value(value)) => match value.as_str() {
+            Some(s) => s.to_string(),
+            None => format!("[Tool Result: {tool_use_id} - JSON value{error}]"),
+        },
+        _ => format!("[Tool Result: {tool_use_id}{error}]"),
+    }
+}
+
 #[derive(Debug, Clone, Serialize, Deserialize)]
 #[serde(untagged)]
 pub enum ToolResultContent {
@@ -107,6 +229,8 @@ pub struct UserMessageContent {
 pub enum UserContent {
     String(String),
     Array(Vec<Content>),
+    // Any other shape (an object, say) is kept rather than failing the whole line
+    Other(Value),
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
@@ -174,6 +298,23 @@ pub enum SessionMessage {
 
 // Helper methods
 impl SessionMessage {
+    /// Whether `line` is valid JSON for an entry that is not a message, such as a
+    /// `file-history-snapshot`. These are skipped quietly rather than counted
+    /// as parse errors.
+    pub fn is_other_entry(line: &[u8]) -> bool {
+        #[derive(Deserialize)]
+        struct Entry {
+            #[serde(rename = "type")]
+            kind: Option<String>,
+        }
+        match sonic_rs::from_slice::<Entry>(line) {
+            Ok(Entry { kind: Some(kind) }) => {
+                !matches!(kind.as_str(), "summary" | "system" | "user" | "assistant")
+            }
+            _ => false,
+        }
+    }
+
     pub fn get_type(&self) -> &'static str {
         match self {
             SessionMessage::Summary { .. } => "summary",
@@ -184,337 +325,39 @@ impl SessionMessage {
     }
 
     pub fn get_content_text(&self) -> String {
-        match self {
-            SessionMessage::Summary { summary, .. } => summary.clone(),
-            SessionMessage::System { content, .. } => content.clone(),
-            SessionMessage::User { message, .. } => {
-                let mut texts = Vec::new();
-
-                // Extract content text
-                match &message.content {
-                    UserContent::String(s) => texts.push(s.clone()),
-                    UserContent::Array(contents) => {
-                        for content in contents {
-                            match content {
-                                Content::Text { text } => texts.push(text.clone()),
-                                Content::ToolResult {
-                                    tool_use_id,
-                                    content: Some(tool_content),
-                                    is_error,
-                                } => {
-                                    let result_text = match tool_content {
-                                        ToolResultContent::String(s) => {
-                                            if !s.is_empty() {
-                                                s.clone()
-                                            } else {
-                                                format!(
-                                                    "[Tool Result: {}{}]",
-                                                    tool_use_id,
-                                                    if is_error.unwrap_or(false) {
-                                                        " (error)"
-                                                    } else {
-                                                        ""
-                                                    }
-                                                )
-                                            }
-                                        }
-                                        ToolResultContent::TextArray(arr) => {
-                                            if !arr.is_empty() {
-                                                arr.iter()
-                                                    .map(|item| &item.text)
-                                                    .cloned()
-                                                    .collect::<Vec<_>>()
-                                                    .join("\n")
-                                            } else {
-                                                format!(
-                                                    "[Tool Result: {}{}]",
-                                                    tool_use_id,
-                                                    if is_error.unwrap_or(false) {
-                                                        " (error)"
-                                                    } else {
-                                                        ""
-                                                    }
-                                                )
-                                            }
-                                        }
-                                        ToolResultContent::Value(val) => {
-                                            if let Some(s) = val.as_str() {
-                                                s.to_string()
-                                            } else {
-                                                format!(
-                                                    "[Tool Result: {} - JSON value{}]",
-                                                    tool_use_id,
-                                                    if is_error.unwrap_or(false) {
-                                                        " (error)"
-                                                    } else {
-                                                        ""
-                                                    }
-                                                )
-                                            }
-                                        }
-                                        _ => format!(
-                                            "[Tool Result: {}{}]",
-                                            tool_use_id,
-                                            if is_error.unwrap_or(false) {
-                                                " (error)"
-                                            } else {
-                                                ""
-                                            }
-                                        ),
-                                    };
-                                    texts.push(result_text);
-                                }
-                                Content::ToolResult {
-                                    tool_use_id,
-                                    content: None,
-                                    is_error,
-                                } => {
-                                    // Show placeholder for tool results with no content
-                                    texts.push(format!(
-                                        "[Tool Result: {}{}]",
-                                        tool_use_id,
-                                        if is_error.unwrap_or(false) {
-                                            " (error)"
-                                        } else {
-                                            ""
-                                        }
-                                    ));
-                                }
-                                Content::ToolUse { name, input, .. } => {
-                                    let mut tool_text = name.clone();
-
-                                    // Extract key information from input based on tool type
-                                    if let Some(obj) = input.as_object() {
-                                        match name.as_str() {
-                                            "Bash" => {
-                                                if let Some(cmd) =
-                                                    obj.get("command").and_then(|v| v.as_str())
-                                                {
-                                                    tool_text.push_str(": ");
-                                                    tool_text.push_str(
-                                                        &cmd.chars().take(50).collect::<String>(),
-                                                    );
-                                                    if cmd.len() > 50 {
-                                                        tool_text.push_str("...");
-                                                    }
-                                                }
-                                            }
-                                            "Read" | "Write" | "Edit" => {
-                                                if let Some(path) =
-                                                    obj.get("file_path").and_then(|v| v.as_str())
-                                                {
-                                                    tool_text.push_str(": ");
-                                                    tool_text.push_str(
-                                                        path.split('/').next_back().unwrap_or(path),
-                                                    );
-                                                }
-                                            }
-                                            "Grep" => {
-                                                if let Some(pattern) =
-                                                    obj.get("pattern").and_then(|v| v.as_str())
-                                                {
-                                                    tool_text.push_str(": ");
-                                                    tool_text.push_str(
-                                                        &pattern
-                                                            .chars()
-                                                            .take(30)
-                                                            .collect::<String>(),
-                                                    );
-                                                    if pattern.len() > 30 {
-                                                        tool_text.push_str("...");
-                                                    }
-                                                }
-                                            }
-                                            _ => {
-                                                // For other tools, try to find a descriptive field
-                                                if let Some(desc) =
-                                                    obj.get("description").and_then(|v| v.as_str())
-                                                {
-                                                    tool_text.push_str(": ");
-                                                    tool_text.push_str(
-                                                        &desc.chars().take(40).collect::<String>(),
-                                                    );
-                                                    if desc.len() > 40 {
-                                                        tool_text.push_str("...");
-                                                    }
-                                                }
-                                            }
-                                        }
-                                    }
-
-                                    texts.push(tool_text);
-                                }
-                                Content::Image { .. } => {
-                                    // Add placeholder for image entries
-                                    texts.push("[Image]".to_string());
-                                }
-                                Content::Thinking { thinking, .. } => texts.push(thinking.clone()),
-                            }
-                        }
-                    }
-                }
+        self.get_content_text_with(true)
+    }
 
-                texts.join("\n")
-            }
-            SessionMessage::Assistant { message, .. } => {
-                let mut texts = Vec::new();
-
-                for content in &message.content {
-                    match content {
-                        Content::Text { text } => texts.push(text.clone()),
-                        Content::Thinking { thinking, .. } => texts.push(thinking.clone()),
-                        Content::ToolUse { name, input, .. } => {
-                            let mut tool_text = name.clone();
-
-                            // Extract key information from input based on tool type
-                            if let Some(obj) = input.as_object() {
-                                match name.as_str() {
-                                    "Bash" => {
-                                        if let Some(cmd) =
-                                            obj.get("command").and_then(|v| v.as_str())
-                                        {
-                                            tool_text.push_str(": ");
-                                            tool_text.push_str(
-                                                &cmd.chars().take(50).collect::<String>(),
-                                            );
-                                            if cmd.len() > 50 {
-                                                tool_text.push_str("...");
-                                            }
-                                        }
-                                    }
-                                    "Read" | "Write" | "Edit" => {
-                                        if let Some(path) =
-                                            obj.get("file_path").and_then(|v| v.as_str())
-                                        {
-                                            tool_text.push_str(": ");
-                                            tool_text.push_str(
-                                                path.split('/').next_back().unwrap_or(path),
-                                            );
-                                        }
-                                    }
-                                    "Grep" => {
-                                        if let Some(pattern) =
-                                            obj.get("pattern").and_then(|v| v.as_str())
-                                        {
-                                            tool_text.push_str(": ");
-                                            tool_text.push_str(
-                                                &pattern.chars().take(30).collect::<String>(),
-                                            );
-                                            if pattern.len() > 30 {
-                                                tool_text.push_str("...");
-                                            }
-                                        }
-                                    }
-                                    _ => {
-                                        // For other tools, try to find a descriptive field
-                                        if let Some(desc) =
-                                            obj.get("description").and_then(|v| v.as_str())
-                                        {
-                                            tool_text.push_str(": ");
-                                            tool_text.push_str(
-                                                &desc.chars().take(40).collect::<String>(),
-                                            );
-                                            if desc.len() > 40 {
-                                                tool_text.push_str("...");
-                                            }
-                                        }
-                                    }
-                                }
-                            }
-
-                            texts.push(tool_text);
-                        }
-                        Content::ToolResult {
-                            tool_use_id,
-                            content: Some(tool_content),
-                            is_error,
-                        } => {
-                            let result_text = match tool_content {
-                                ToolResultContent::String(s) => {
-                                    if !s.is_empty() {
-                                        s.clone()
-                                    } else {
-                                        format!(
-                                            "[Tool Result: {}{}]",
-                                            tool_use_id,
-                                            if is_error.unwrap_or(false) {
-                                                " (error)"
-                                            } else {
-                                                ""
-                                            }
-                                        )
-                                    }
-                                }
-                                ToolResultContent::TextArray(arr) => {
-                                    if !arr.is_empty() {
-                                        arr.iter()
-                                            .map(|item| &item.text)
-                                            .cloned()
-                                            .collect::<Vec<_>>()
-                                            .join("\n")
-                                    } else {
-                                        format!(
-                                            "[Tool Result: {}{}]",
-                                            tool_use_id,
-                                            if is_error.unwrap_or(false) {
-                                                " (error)"
-                                            } else {
-                                                ""
-                                            }
-                                        )
-                                    }
-                                }
-                                ToolResultContent::Value(val) => {
-                                    if let Some(s) = val.as_str() {
-                                        s.to_string()
-                                    } else {
-                                        format!(
-                                            "[Tool Result: {} - JSON value{}]",
-                                            tool_use_id,
-                                            if is_error.unwrap_or(false) {
-                                                " (error)"
-                                            } else {
-                                                ""
-                                            }
-                                        )
-                                    }
-                                }
-                                _ => format!(
-                                    "[Tool Result: {}{}]",
-                                    tool_use_id,
-                                    if is_error.unwrap_or(false) {
-                                        " (error)"
-                                    } else {
-                                        ""
-                                    }
-                                ),
-                            };
-                            texts.push(result_text);
-                        }
-                        Content::ToolResult {
-                            tool_use_id,
-                            content: None,
-                            is_error,
-                        } => {
-                            // Show placeholder for tool results with no content
-                            texts.push(format!(
-                                "[Tool Result: {}{}]",
-                                tool_use_id,
-                                if is_error.unwrap_or(false) {
-                                    " (error)"
-                                } else {
-                                    ""
-                                }
-                            ));
-                        }
-                        Content::Image { .. } => {
-                            // Add placeholder for image entries
-                            texts.push("[Image]".to_string());
-                        }
-                    }
-                }
+    /// Message text, optionally leaving out assistant `thinking` blocks
+    pub fn get_content_text_with(&self, include_thinking: bool) -> String {
+        self.get_content_segments()
+            .into_iter()
+            .filter(|segment| include_thinking || segment.kind != SegmentKind::Thinking)
+            .map(|segment| segment.text)
+            .collect::<Vec<_>>()
+            .join("\n")
+    }
 
-                texts.join("\n")
+    /// Message text split by the kind of content it came from, in order.
+    /// Joined with newlines, the segments are [`get_content_text`](Self::get_content_text)
+    pub fn get_content_segments(&self) -> Vec<ContentSegment> {
+        let text = |text: &str| {
+            vec![ContentSegment {
+                kind: SegmentKind::Text,
+                text: text.to_string(),
+            }]
+        };
+        match self {
+            SessionMessage::Summary { summary, .. } => text(summary),
+            SessionMessage::System { content, .. } => text(content),
+            SessionMessage::User { message, .. } => match &message.content {
+                UserContent::String(s) => text(s),
+                // Only searched with --include-unknown (see `get_unknown_content`)
+                UserContent::Other(_) => Vec::new(),
+                UserContent::Array(contents) => contents.iter().map(Content::segment).collect(),
+            },
+            SessionMessage::Assistant { message, .. } => {
+                message.content.iter().map(Content::segment).collect()
             }
         }
     }
@@ -555,8 +398,178 @@ impl SessionMessage {
         }
     }
 
+    /// Origin of the message (`external` for the person at the keyboard);
+    /// `None` for summaries and messages that don't record one
+    pub fn get_user_type(&self) -> Option<&str> {
+        match self {
+            SessionMessage::Summary { .. } => None,
+            SessionMessage::System { base, .. }
+            | SessionMessage::User { base, .. }
+            | SessionMessage::Assistant { base, .. } => {
+                Some(base.user_type.as_str()).filter(|user_type| !user_type.is_empty())
+            }
+        }
+    }
+
+    /// Id of the API response an assistant message belongs to (`message.id`),
+    /// shared by every line Claude Code writes for that response
+    pub fn get_response_id(&self) -> Option<&str> {
+        match self {
+            SessionMessage::Assistant { message, .. } => Some(message.id.as_str()),
+            _ => None,
+        }
+    }
+
+    /// API request id (`requestId`) recorded on assistant and system messages
+    pub fn get_request_id(&self) -> Option<&str> {
+        match self {
+            SessionMessage::System { request_id, .. }
+            | SessionMessage::Assistant { request_id, .. } => request_id.as_deref(),
+            SessionMessage::Summary { .. } | SessionMessage::User { .. } => None,
+        }
+    }
+
+    /// Model that wrote an assistant response
+    pub fn get_model(&self) -> Option<&str> {
+        match self {
+            SessionMessage::Assistant { message, .. } => Some(message.model.as_str()),
+            _ => None,
+        }
+    }
+
+    /// Git branch checked out when the message was written (`gitBranch`)
+    pub fn get_git_branch(&self) -> Option<&str> {
+        match self {
+            SessionMessage::System { git_branch, .. }
+            | SessionMessage::User { git_branch, .. }
+            | SessionMessage::Assistant { git_branch, .. } => git_branch.as_deref(),
+            SessionMessage::Summary { .. } => None,
+        }
+    }
+
+    pub fn get_parent_uuid(&self) -> Option<&str> {
+        match self {
+            SessionMessage::Summary { .. } => None,
+            SessionMessage::System { base, .. } => base.parent_uuid.as_deref(),
+            SessionMessage::User { base, .. } => base.parent_uuid.as_deref(),
+            SessionMessage::Assistant { base, .. } => base.parent_uuid.as_deref(),
+        }
+    }
+
+    pub fn is_sidechain(&self) -> bool {
+        match self {
+            SessionMessage::Summary { .. } => false,
+            SessionMessage::System { base, .. } => base.is_sidechain,
+            SessionMessage::User { base, .. } => base.is_sidechain,
+            SessionMessage::Assistant { base, .. } => base.is_sidechain,
+        }
+    }
+
+    /// Whether the message was injected by the client rather than written in the
+    /// conversation: `isMeta` messages and compaction summaries
+    pub fn is_meta(&self) -> bool {
+        match self {
+            SessionMessage::System { is_meta, .. } => *is_meta,
+            SessionMessage::User {
+                is_meta,
+                is_compact_summary,
+                ..
+            } => is_meta.unwrap_or(false) || is_compact_summary.unwrap_or(false),
+            SessionMessage::Summary { .. } | SessionMessage::Assistant { .. } => false,
+        }
+    }
+
+    /// Whether the message contains the `tool_use` with id `id` or a `tool_result` for it
+    pub fn references_tool_use(&self, id: &str) -> bool {
+        let contents = match self {
+            SessionMessage::User {
+                message:
+                    UserMessageContent {
+                        content: UserContent::Array(contents),
+                        ..
+                    },
+                ..
+            } => contents,
+            SessionMessage::Assistant { message, .. } => &message.content,
+            _ => return false,
+        };
+        contents.iter().any(|content| match content {
+            Content::ToolUse { id: use_id, .. } => use_id == id,
+            Content::ToolResult { tool_use_id, .. } => tool_use_id == id,
+            _ => false,
+        })
+    }
+
+    /// Whether the message carries a `tool_result` marked `is_error: true`
+    pub fn has_tool_error(&self) -> bool {
+        let SessionMessage::User {
+            message:
+                UserMessageContent {
+                    content: UserContent::Array(contents),
+                    ..
+                },
+            ..
+        } = self
+        else {
+            return false;
+        };
+        contents.iter().any(|content| {
+            matches!(
+                content,
+                Content::ToolResult {
+                    is_error: Some(true),
+                    ..
+                }
+            )
+        })
+    }
+
+    /// Token usage reported for an assistant response
+    pub fn get_usage(&self) -> Option<&Usage> {
+        match self {
+            SessionMessage::Assistant { message, .. } => Some(&message.usage),
+            _ => None,
+        }
+    }
+
+    /// `message.content` of a shape other than a string or an array, as compact JSON
+    pub fn get_unknown_content(&self) -> Option<String> {
+        match self {
+            SessionMessage::User {
+                message:
+                    UserMessageContent {
+                        content: UserContent::Other(value),
+                        ..
+                    },
+                ..
+            } => Some(value.to_string()),
+            _ => None,
+        }
+    }
+
+    /// Text from the `toolUseResult` payload of a user message, one string per
+    /// line. Its shape varies by tool (a plain string, `stdout`/`stderr`, a file
+    /// or a patch), so every string in it is collected.
+    pub fn get_tool_use_result_text(&self) -> Option<String> {
+        let SessionMessage::User {
+            tool_use_result: Some(value),
+            ..
+        } = self
+        else {
+            return None;
+        };
+        let mut lines = Vec::new();
+        collect_strings(value, &mut lines);
+        (!lines.is_empty()).then(|| lines.join("\n"))
+    }
+
     pub fn get_searchable_text(&self) -> String {
-        let mut parts = vec![self.get_content_text()];
+        self.get_searchable_text_with(true)
+    }
+
+    /// Searchable text, optionally leaving out assistant `thinking` blocks
+    pub fn get_searchable_text_with(&self, include_thinking: bool) -> String {
+        let mut parts = vec![self.get_content_text_with(include_thinking)];
 
         // Add Session ID
         if let Some(session_id) = self.get_session_id() {
@@ -650,6 +663,143 @@ mod tests {
         );
     }
 
+    // Parse `json`, serialize it again and check nothing was lost or renamed.
+    // The `message` field decodes to a different struct per variant, chosen by
+    // the outer `type` tag, so both directions must agree on the shape.
+    fn assert_round_trip(json: serde_json::Value) {
+        let msg: SessionMessage = serde_json::from_value(json.clone()).unwrap();
+        assert_eq!(serde_json::to_value(&msg).unwrap(), json);
+
+        let line = serde_json::to_string(&msg).unwrap();
+        let reparsed: SessionMessage = sonic_rs::from_str(&line).unwrap();
+        assert_eq!(serde_json::to_value(&reparsed).unwrap(), json);
+    }
+
+    #[test]
+    fn test_user_message_round_trip() {
+        let base = serde_json::json!({
+            "uuid": "u1",
+            "timestamp": "2024-01-01T00:00:00Z",
+            "sessionId": "s1",
+            "parentUuid": null,
+            "isSidechain": false,
+            "userType": "external",
+            "cwd": "/test",
+            "version": "1.0"
+        });
+        let with = |extra: serde_json::Value| {
+            let mut json = base.clone();
+            json.as_object_mut()
+                .unwrap()
+                .extend(extra.as_object().unwrap().clone());
+            json
+        };
+
+        assert_round_trip(with(serde_json::json!({
+            "type": "user",
+            "message": {"role": "user", "content": "Hello"},
+            "gitBranch": "main"
+        })));
+        assert_round_trip(with(serde_json::json!({
+            "type": "user",
+            "message": {
+                "role": "user",
+                "content": [
+                    {"type": "tool_result", "tool_use_id": "toolu_1", "content": "boom", "is_error": true},
+                    {"type": "tool_result", "tool_use_id": "toolu_2", "content": [{"type": "text", "text": "ok"}]},
+                    {"type": "text", "text": "and then?"}
+                ]
+            },
+            "isMeta": false,
+            "toolUseResult": {"stdout": "ok", "interrupted": false}
+        })));
+    }
+
+    #[test]
+    fn test_tool_use_result_text() {
+        let text = |tool_use_result: serde_json::Value| {
+            let message: SessionMessage = serde_json::from_value(serde_json::json!({
+                "type": "user",
+                "message": {"role": "user", "content": "result"},
+                "uuid": "u1",
+                "timestamp": "2024-01-01T00:00:00Z",
+                "sessionId": "s1",
+                "parentUuid": null,
+                "isSidechain": false,
+                "userType": "external",
+                "cwd": "/t",
+                "version": "1",
+                "toolUseResult": tool_use_result
+            }))
+            .unwrap();
+            message.get_tool_use_result_text()
+        };
+
+        assert_eq!(
+            text(serde_json::json!("Error: exit code 1")).as_deref(),
+            Some("Error: exit code 1")
+        );
+        assert_eq!(
+            text(serde_json::json!({"stdout": "built", "stderr": "warning: unused", "interrupted": false}))
+                .as_deref(),
+            Some("warning: unused\nbuilt")
+        );
+        assert_eq!(
+            text(serde_json::json!({
+                "filePath": "/t/a.rs",
+                "originalFile": "fn old() {}",
+                "structuredPatch": [{"oldStart": 1, "lines": ["-fn old() {}", "+fn new() {}"]}]
+            }))
+            .as_deref(),
+            Some("/t/a.rs\n-fn old() {}\n+fn new() {}")
+        );
+        assert_eq!(
+            text(serde_json::json!([{"type": "text", "text": "done"}])).as_deref(),
+            Some("done")
+        );
+        assert_eq!(
+            text(serde_json::json!({"interrupted": false, "stdout": ""})),
+            None
+        );
+        assert_eq!(text(serde_json::Value::Null), None);
+    }
+
+    #[test]
+    fn test_assistant_message_round_trip() {
+        assert_round_trip(serde_json::json!({
+            "type": "assistant",
+            "message": {
+                "id": "msg_01",
+                "type": "message",
+                "role": "assistant",
+                "model": "claude-3-5-sonnet",
+                "content": [
+                    {"type": "thinking", "thinking": "hmm", "signature": "sig"},
+                    {"type": "text", "text": "Reading it"},
+                    {"type": "tool_use", "id": "toolu_1", "name": "Read", "input": {"path": "a.txt"}}
+                ],
+                "stop_reason": "tool_use",
+                "stop_sequence": null,
+                "usage": {
+                    "input_tokens": 10,
+                    "cache_creation_input_tokens": 1,
+                    "cache_read_input_tokens": 2,
+                    "output_tokens": 3,
+                    "service_tier": "standard"
+                }
+            },
+            "requestId": "req_1",
+            "uuid": "a1",
+            "timestamp": "2024-01-01T00:00:01Z",
+            "sessionId": "s1",
+            "parentUuid": "u1",
+            "isSidechain": true,
+            "userType": "external",
+            "cwd": "/test",
+            "version": "1.0"
+        }));
+    }
+
     #[test]
     fn test_parse_assistant_message_with_thinking() {
         let json = r#"{
@@ -955,4 +1105,176 @@ mod tests {
         assert!(searchable_text.contains("leaf-uuid-789"));
         assert!(!searchable_text.contains("session")); // No session ID for summary
     }
+
+    #[test]
+    fn test_is_meta() {
+        let user = |extra: &str| -> SessionMessage {
+            let json = format!(
+                r#"{{"type":"user","message":{{"role":"user","content":"hi"}},"uuid":"u","timestamp":"2024-01-01T00:00:00Z","sessionId":"s","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"{extra}}}"#
+            );
+            serde_json::from_str(&json).unwrap()
+        };
+
+        assert!(!user("").is_meta());
+        assert!(!user(r#","isMeta":false"#).is_meta());
+        assert!(user(r#","isMeta":true"#).is_meta());
+        assert!(user(r#","isCompactSummary":true"#).is_meta());
+        assert_eq!(user("").get_user_type(), Some("external"));
+    }
+
+    #[test]
+    fn test_content_text_without_thinking() {
+        let json = r#"{"type":"assistant","message":{"id":"m","type":"message","role":"assistant","model":"claude","content":[{"type":"thinking","thinking":"maybe a race condition","signature":"sig"},{"type":"text","text":"Fixed the flaky test"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":1,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":1}},"uuid":"a1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}"#;
+        let msg: SessionMessage = serde_json::from_str(json).unwrap();
+
+        assert_eq!(
+            msg.get_content_text(),
+            "maybe a race condition\nFixed the flaky test"
+        );
+        assert_eq!(msg.get_content_text_with(false), "Fixed the flaky test");
+        assert!(!msg.get_searchable_text_with(false).contains("race"));
+        assert!(msg.get_searchable_text_with(false).contains("a1"));
+    }
+
+    #[test]
+    fn test_content_segments() {
+        let user = |content: &str| -> SessionMessage {
+            serde_json::from_str(&format!(
+                r#"{{"type":"user","message":{{"role":"user","content":{content}}},"uuid":"u1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            ))
+            .unwrap()
+        };
+        let segments = |msg: &SessionMessage| -> Vec<(&'static str, String)> {
+            msg.get_content_segments()
+                .into_iter()
+                .map(|segment| (segment.kind.name(), segment.text))
+                .collect()
+        };
+
+        assert_eq!(
+            segments(&user(r#""plain""#)),
+            [("text", "plain".to_string())]
+        );
+        assert!(segments(&user(r#"{"type":"document"}"#)).is_empty());
+
+        // Tool results: text, nested text blocks, images only, missing or failed
+        let msg = user(
+            r#"[
+                {"type":"tool_result","tool_use_id":"t1","content":"done"},
+                {"type":"tool_result","tool_use_id":"t2","content":[{"type":"text","text":"line one"},{"type":"text","text":"line two"}]},
+                {"type":"tool_result","tool_use_id":"t3","content":[{"type":"image","source":{"type":"base64","data":"x","media_type":"image/png"}}]},
+                {"type":"tool_result","tool_use_id":"t4"},
+                {"type":"tool_result","tool_use_id":"t5","content":"","is_error":true},
+                {"type":"tool_result","tool_use_id":"t6","content":{"exit_code":1},"is_error":true},
+                {"type":"image","source":{"type":"base64","data":"x","media_type":"image/png"}}
+            ]"#,
+        );
+        assert_eq!(
+            segments(&msg),
+            [
+                ("tool_result", "done".to_string()),
+                ("tool_result", "line one\nline two".to_string()),
+                ("tool_result", "[Tool Result: t3]".to_string()),
+                ("tool_result", "[Tool Result: t4]".to_string()),
+                ("tool_result", "[Tool Result: t5 (error)]".to_string()),
+                (
+                    "tool_result",
+                    "[Tool Result: t6 - JSON value (error)]".to_string()
+                ),
+                ("image", "[Image]".to_string()),
+            ]
+        );
+        assert_eq!(
+            msg.get_content_text(),
+            msg.get_content_segments()
+                .into_iter()
+                .map(|segment| segment.text)
+                .collect::<Vec<_>>()
+                .join("\n")
+        );
+
+        let json = r#"{"type":"assistant","message":{"id":"m","type":"message","role":"assistant","model":"claude","content":[{"type":"thinking","thinking":"check the config","signature":"sig"},{"type":"text","text":"Reading it"},{"type":"tool_use","id":"t1","name":"Read","input":{"file_path":"/etc/app/config.toml"}},{"type":"tool_use","id":"t2","name":"Bash","input":{"command":"cargo test --workspace --all-features -- --nocapture --test-threads 1"}}],"stop_reason":"tool_use","stop_sequence":null,"usage":{"input_tokens":1,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":1}},"uuid":"a1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}"#;
+        let msg: SessionMessage = serde_json::from_str(json).unwrap();
+        assert_eq!(
+            segments(&msg),
+            [
+                ("thinking", "check the config".to_string()),
+                ("text", "Reading it".to_string()),
+                ("tool_use", "Read: config.toml".to_string()),
+                (
+                    "tool_use",
+                    "Bash: cargo test --workspace --all-features -- --nocaptu...".to_string()
+                ),
+            ]
+        );
+        assert_eq!(
+            msg.get_content_text_with(false),
+            "Reading it\nRead: config.toml\nBash: cargo test --workspace --all-features -- --nocaptu..."
+        );
+
+        let summary: SessionMessage =
+            serde_json::from_str(r#"{"type":"summary","summary":"Config fix","leafUuid":"a1"}"#)
+                .unwrap();
+        assert_eq!(segments(&summary), [("text", "Config fix".to_string())]);
+    }
+
+    #[test]
+    fn test_object_content() {
+        let json = r#"{"type":"user","message":{"role":"user","content":{"type":"document","title":"Design notes","pages":3}},"uuid":"u1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}"#;
+        let msg: SessionMessage = serde_json::from_str(json).unwrap();
+
+        assert_eq!(msg.get_content_text(), "");
+        assert_eq!(
+            msg.get_unknown_content().as_deref(),
+            Some(r#"{"pages":3,"title":"Design notes","type":"document"}"#)
+        );
+        let msg: SessionMessage = sonic_rs::from_str(json).unwrap();
+        assert!(msg.get_unknown_content().unwrap().contains("Design notes"));
+
+        let plain = json.replace(
+            r#"{"type":"document","title":"Design notes","pages":3}"#,
+            r#""hi""#,
+        );
+        let msg: SessionMessage = serde_json::from_str(&plain).unwrap();
+        assert_eq!(msg.get_unknown_content(), None);
+    }
+
+    #[test]
+    fn test_references_tool_use() {
+        let call = r#"{"type":"assistant","message":{"id":"m","type":"message","role":"assistant","model":"claude","content":[{"type":"tool_use","id":"toolu_1","name":"Bash","input":{"command":"ls"}}],"stop_reason":"tool_use","stop_sequence":null,"usage":{"input_tokens":1,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":1}},"uuid":"a1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}"#;
+        let result = r#"{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":"file.txt"}]},"uuid":"u1","timestamp":"2024-01-01T00:00:01Z","sessionId":"s","parentUuid":"a1","isSidechain":false,"userType":"external","cwd":"/","version":"1"}"#;
+        let plain = r#"{"type":"user","message":{"role":"user","content":"toolu_1"},"uuid":"u2","timestamp":"2024-01-01T00:00:02Z","sessionId":"s","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}"#;
+
+        for json in [call, result] {
+            let msg: SessionMessage = serde_json::from_str(json).unwrap();
+            assert!(msg.references_tool_use("toolu_1"));
+            assert!(!msg.references_tool_use("toolu_2"));
+        }
+        let msg: SessionMessage = serde_json::from_str(plain).unwrap();
+        assert!(!msg.references_tool_use("toolu_1"));
+    }
+
+    #[test]
+    fn test_has_tool_error() {
+        let result = |is_error: &str| {
+            let json = format!(
+                r#"{{"type":"user","message":{{"role":"user","content":[{{"type":"tool_result","tool_use_id":"toolu_1","content":"No such file"{is_error}}}]}},"uuid":"u1","timestamp":"2024-01-01T00:00:01Z","sessionId":"s","parentUuid":"a1","isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            );
+            serde_json::from_str::<SessionMessage>(&json).unwrap()
+        };
+        assert!(result(r#","is_error":true"#).has_tool_error());
+        assert!(!result(r#","is_error":false"#).has_tool_error());
+        assert!(!result("").has_tool_error());
+    }
+
+    #[test]
+    fn test_is_other_entry() {
+        assert!(SessionMessage::is_other_entry(
+            br#"{"type":"file-history-snapshot","snapshot":{}}"#
+        ));
+        // A message type with missing fields is a real parse error
+        assert!(!SessionMessage::is_other_entry(br#"{"type":"user"}"#));
+        assert!(!SessionMessage::is_other_entry(br#"{"no":"type"}"#));
+        assert!(!SessionMessage::is_other_entry(b"{not json"));
+    }
 }
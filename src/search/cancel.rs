@@ -0,0 +1,58 @@
+use std::io;
+use std::sync::Arc;
+use std::sync::atomic::{AtomicBool, Ordering};
+
+/// Stops a running search from outside it, such as on Ctrl-C.
+///
+/// Once cancelled, workers stop reading the file they are in and skip the
+/// files they have not started, and the search returns whatever it collected
+/// so far. Clones share the same flag.
+#[derive(Debug, Clone, Default)]
+pub struct CancelToken(Arc<AtomicBool>);
+
+impl CancelToken {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn cancel(&self) {
+        self.0.store(true, Ordering::Relaxed);
+    }
+
+    pub fn is_cancelled(&self) -> bool {
+        self.0.load(Ordering::Relaxed)
+    }
+
+    /// Cancel on SIGINT. A second SIGINT exits at once, as if there were no
+    /// handler, for when the search doesn't stop quickly enough.
+    #[cfg(unix)]
+    pub fn cancel_on_interrupt(&self) -> io::Result<()> {
+        use signal_hook::consts::signal::SIGINT;
+        use signal_hook::flag;
+
+        // Registered first so it only sees a flag set by an earlier SIGINT
+        flag::register_conditional_shutdown(SIGINT, 130, Arc::clone(&self.0))?;
+        flag::register(SIGINT, Arc::clone(&self.0))?;
+        Ok(())
+    }
+
+    /// Without signal-hook, Ctrl-C still ends the process right away
+    #[cfg(not(unix))]
+    pub fn cancel_on_interrupt(&self) -> io::Result<()> {
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_clones_share_cancellation() {
+        let token = CancelToken::new();
+        let clone = token.clone();
+        assert!(!clone.is_cancelled());
+        token.cancel();
+        assert!(clone.is_cancelled());
+    }
+}
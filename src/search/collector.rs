@@ -0,0 +1,468 @@
+use super::cancel::CancelToken;
+use crate::interactive_ratatui::domain::models::SearchOrder;
+use crate::query::SearchResult;
+use std::cmp::Ordering;
+use std::collections::HashMap;
+use std::collections::hash_map::{DefaultHasher, Entry};
+use std::hash::{Hash, Hasher};
+use std::path::PathBuf;
+use std::sync::atomic::{AtomicUsize, Ordering as AtomicOrdering};
+
+/// Collects search results as files finish, keeping only the best `limit`
+/// results in the requested timestamp order.
+///
+/// Every offered result is counted, but the buffer is compacted back down to
+/// `limit` whenever it grows past twice that size, so memory stays bounded by
+/// the result cap rather than by the number of matches.
+///
+/// A limit of `Some(0)` is treated like `None`: every result is kept.
+///
+/// With deduplication enabled, repeated copies of a message (as written when a
+/// session is resumed) are collected and counted once, keeping the copy with
+/// the earliest timestamp.
+///
+/// A per-file limit keeps only the best results of each batch, so one chatty
+/// session cannot fill the whole result cap. Results dropped by it are not
+/// counted in the total.
+///
+/// Counted results are also tallied per message type, for `--type-breakdown`.
+///
+/// With a file order (`--order-stable`) results are sorted by the position of
+/// their file in the searched list and then by line number rather than by
+/// timestamp, so the output does not depend on which worker finished first.
+pub struct ResultCollector {
+    results: Vec<SearchResult>,
+    limit: Option<usize>,
+    per_file_limit: Option<usize>,
+    order: SearchOrder,
+    // File -> its index in the searched list, when sorting by position on disk
+    file_order: Option<HashMap<String, usize>>,
+    total: usize,
+    type_counts: HashMap<String, usize>,
+    // Dedupe key -> earliest timestamp seen, when deduplicating
+    seen: Option<HashMap<String, String>>,
+}
+
+impl ResultCollector {
+    pub fn new(limit: Option<usize>, order: SearchOrder) -> Self {
+        Self {
+            results: Vec::new(),
+            limit: limit.filter(|&limit| limit > 0),
+            per_file_limit: None,
+            order,
+            file_order: None,
+            total: 0,
+            type_counts: HashMap::new(),
+            seen: None,
+        }
+    }
+
+    pub fn with_dedupe(mut self, dedupe: bool) -> Self {
+        self.seen = dedupe.then(HashMap::new);
+        self
+    }
+
+    /// Keep at most `limit` results from each file; `None` or `Some(0)` keeps all
+    pub fn with_per_file_limit(mut self, limit: Option<usize>) -> Self {
+        self.per_file_limit = limit.filter(|&limit| limit > 0);
+        self
+    }
+
+    /// Sort by file, in the order of `files`, and then by line instead of by
+    /// timestamp; `None` keeps the timestamp order
+    pub fn with_file_order(mut self, files: Option<&[PathBuf]>) -> Self {
+        self.file_order = files.map(|files| {
+            files
+                .iter()
+                .enumerate()
+                .map(|(index, path)| (path.to_string_lossy().into_owned(), index))
+                .collect()
+        });
+        self
+    }
+
+    /// Add the (already filtered) results of one file
+    pub fn extend<I: IntoIterator<Item = SearchResult>>(&mut self, batch: I) {
+        let mut batch: Vec<SearchResult> = batch.into_iter().collect();
+        if let Some(limit) = self.per_file_limit
+            && limit < batch.len()
+        {
+            let (order, file_order) = (self.order, self.file_order.as_ref());
+            batch.select_nth_unstable_by(limit - 1, |a, b| compare(order, file_order, a, b));
+            batch.truncate(limit);
+        }
+
+        for result in batch {
+            if self.is_duplicate(&result) {
+                continue;
+            }
+            self.total += 1;
+            *self
+                .type_counts
+                .entry(result.message_type.clone())
+                .or_insert(0) += 1;
+            self.results.push(result);
+        }
+
+        if let Some(limit) = self.limit
+            && self.results.len() > limit.saturating_mul(2).max(64)
+        {
+            self.compact(limit);
+        }
+    }
+
+    /// Number of results offered so far, including ones already dropped
+    pub fn total(&self) -> usize {
+        self.total
+    }
+
+    /// Counted results per message type
+    pub fn type_counts(&self) -> &HashMap<String, usize> {
+        &self.type_counts
+    }
+
+    /// Number of results currently held in memory
+    pub fn buffered(&self) -> usize {
+        self.results.len()
+    }
+
+    /// Sorted, capped results together with the total match count
+    pub fn finish(mut self) -> (Vec<SearchResult>, usize) {
+        let (order, file_order) = (self.order, self.file_order.as_ref());
+        self.results
+            .sort_by(|a, b| compare(order, file_order, a, b));
+        if let Some(limit) = self.limit {
+            self.results.truncate(limit);
+        }
+        (self.results, self.total)
+    }
+
+    // Record `result` for deduplication and tell whether an equally old or older
+    // copy was already collected. A newer copy still buffered is dropped in favour
+    // of `result`.
+    fn is_duplicate(&mut self, result: &SearchResult) -> bool {
+        let Some(seen) = &mut self.seen else {
+            return false;
+        };
+
+        match seen.entry(dedupe_key(result)) {
+            Entry::Vacant(entry) => {
+                entry.insert(result.timestamp.clone());
+                false
+            }
+            Entry::Occupied(entry) if *entry.get() <= result.timestamp => true,
+            Entry::Occupied(mut entry) => {
+                entry.insert(result.timestamp.clone());
+                let key = entry.key();
+                self.results.retain(|r| dedupe_key(r) != *key);
+                // The replacement is counted again by the caller
+                self.total -= 1;
+                if let Some(count) = self.type_counts.get_mut(&result.message_type) {
+                    *count -= 1;
+                }
+                false
+            }
+        }
+    }
+
+    // Keep the best `limit` results (in no particular order) and drop the rest
+    fn compact(&mut self, limit: usize) {
+        if limit < self.results.len() {
+            let (order, file_order) = (self.order, self.file_order.as_ref());
+            self.results
+                .select_nth_unstable_by(limit - 1, |a, b| compare(order, file_order, a, b));
+            self.results.truncate(limit);
+        }
+    }
+}
+
+/// Result count shared between the collector and the workers of one search.
+///
+/// With `--first` the collector records how many results it holds after each
+/// file, and workers stop reading (and skip files they have not started) once
+/// the limit is reached. Without a limit the cap is never reached, unless the
+/// search is cancelled.
+#[derive(Debug, Default)]
+pub struct ResultCap {
+    limit: Option<usize>,
+    collected: AtomicUsize,
+    cancel: CancelToken,
+}
+
+impl ResultCap {
+    /// A cap of `limit` results; `None` or `Some(0)` never stops a search
+    pub fn new(limit: Option<usize>) -> Self {
+        Self {
+            limit: limit.filter(|&limit| limit > 0),
+            collected: AtomicUsize::new(0),
+            cancel: CancelToken::new(),
+        }
+    }
+
+    /// Also count as reached once `cancel` is cancelled
+    pub fn with_cancel(mut self, cancel: CancelToken) -> Self {
+        self.cancel = cancel;
+        self
+    }
+
+    /// Record the number of results collected so far
+    pub fn record(&self, collected: usize) {
+        self.collected.store(collected, AtomicOrdering::Relaxed);
+    }
+
+    /// Whether enough results have been collected to stop searching
+    pub fn reached(&self) -> bool {
+        self.cancel.is_cancelled()
+            || self
+                .limit
+                .is_some_and(|limit| self.collected.load(AtomicOrdering::Relaxed) >= limit)
+    }
+}
+
+// Messages are identified by uuid; summaries (and anything without a uuid) by
+// a hash of their text
+fn dedupe_key(result: &SearchResult) -> String {
+    if result.message_type == "summary" || result.uuid.is_empty() {
+        let mut hasher = DefaultHasher::new();
+        result.text.hash(&mut hasher);
+        format!("summary:{:016x}", hasher.finish())
+    } else {
+        result.uuid.clone()
+    }
+}
+
+fn compare(
+    order: SearchOrder,
+    file_order: Option<&HashMap<String, usize>>,
+    a: &SearchResult,
+    b: &SearchResult,
+) -> Ordering {
+    if let Some(file_order) = file_order {
+        // Files outside the list (there should be none) go last
+        let position = |result: &SearchResult| {
+            (
+                file_order.get(&result.file).copied().unwrap_or(usize::MAX),
+                result.line_number.unwrap_or(0),
+            )
+        };
+        return position(a).cmp(&position(b));
+    }
+    match order {
+        SearchOrder::Descending => b.timestamp.cmp(&a.timestamp),
+        SearchOrder::Ascending => a.timestamp.cmp(&b.timestamp),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::query::QueryCondition;
+
+    fn result(timestamp: String) -> SearchResult {
+        SearchResult {
+            file: "/test.jsonl".to_string(),
+            uuid: timestamp.clone(),
+            timestamp,
+            session_id: "session1".to_string(),
+            role: "user".to_string(),
+            text: "text".to_string(),
+            message_type: "user".to_string(),
+            query: QueryCondition::Literal {
+                pattern: "text".to_string(),
+                case_sensitive: false,
+            },
+            cwd: "/test".to_string(),
+            raw_json: None,
+            line_number: None,
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
+        }
+    }
+
+    fn batch(range: std::ops::Range<usize>) -> Vec<SearchResult> {
+        range
+            .map(|i| result(format!("2024-01-01T00:00:00.{i:06}Z")))
+            .collect()
+    }
+
+    #[test]
+    fn test_buffer_stays_bounded_by_limit() {
+        let mut collector = ResultCollector::new(Some(10), SearchOrder::Descending);
+        for start in (0..10_000).step_by(100) {
+            collector.extend(batch(start..start + 100));
+            assert!(collector.buffered() <= 64);
+        }
+
+        let (results, total) = collector.finish();
+        assert_eq!(total, 10_000);
+        assert_eq!(results.len(), 10);
+        assert_eq!(results[0].timestamp, "2024-01-01T00:00:00.009999Z");
+        assert_eq!(results[9].timestamp, "2024-01-01T00:00:00.009990Z");
+    }
+
+    #[test]
+    fn test_per_file_limit_keeps_best_of_each_file() {
+        let mut collector =
+            ResultCollector::new(Some(5), SearchOrder::Descending).with_per_file_limit(Some(2));
+        collector.extend(batch(0..100));
+        collector.extend(batch(100..101));
+        collector.extend(batch(200..203));
+
+        let (results, total) = collector.finish();
+        // Only what survived the per-file limit counts
+        assert_eq!(total, 5);
+        let timestamps: Vec<&str> = results.iter().map(|r| r.timestamp.as_str()).collect();
+        assert_eq!(
+            timestamps,
+            vec![
+                "2024-01-01T00:00:00.000202Z",
+                "2024-01-01T00:00:00.000201Z",
+                "2024-01-01T00:00:00.000100Z",
+                "2024-01-01T00:00:00.000099Z",
+                "2024-01-01T00:00:00.000098Z",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_ascending_order_keeps_oldest() {
+        let mut collector = ResultCollector::new(Some(3), SearchOrder::Ascending);
+        collector.extend(batch(50..200));
+        collector.extend(batch(0..50));
+
+        let (results, total) = collector.finish();
+        assert_eq!(total, 200);
+        let timestamps: Vec<&str> = results.iter().map(|r| r.timestamp.as_str()).collect();
+        assert_eq!(
+            timestamps,
+            vec![
+                "2024-01-01T00:00:00.000000Z",
+                "2024-01-01T00:00:00.000001Z",
+                "2024-01-01T00:00:00.000002Z",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_dedupe_keeps_earliest_copy() {
+        let mut first = result("2024-01-02T00:00:00Z".to_string());
+        first.uuid = "same".to_string();
+        first.file = "/resumed.jsonl".to_string();
+        let mut second = first.clone();
+        second.timestamp = "2024-01-01T00:00:00Z".to_string();
+        second.file = "/original.jsonl".to_string();
+        let mut third = first.clone();
+        third.file = "/resumed-again.jsonl".to_string();
+
+        let mut summary = result("2024-01-03T00:00:00Z".to_string());
+        summary.message_type = "summary".to_string();
+        summary.uuid = "leaf-1".to_string();
+        let mut summary_copy = summary.clone();
+        summary_copy.uuid = "leaf-2".to_string();
+
+        let mut collector =
+            ResultCollector::new(Some(10), SearchOrder::Descending).with_dedupe(true);
+        collector.extend(vec![first, summary]);
+        collector.extend(vec![second, summary_copy]);
+        collector.extend(vec![third]);
+
+        let (results, total) = collector.finish();
+        assert_eq!(total, 2);
+        assert_eq!(results.len(), 2);
+        assert_eq!(results[0].message_type, "summary");
+        assert_eq!(results[1].file, "/original.jsonl");
+    }
+
+    #[test]
+    fn test_without_dedupe_keeps_copies() {
+        let mut message = result("2024-01-01T00:00:00Z".to_string());
+        message.uuid = "same".to_string();
+
+        let mut collector = ResultCollector::new(None, SearchOrder::Descending);
+        collector.extend(vec![message.clone(), message]);
+        assert_eq!(collector.finish().1, 2);
+    }
+
+    #[test]
+    fn test_zero_limit_means_unlimited() {
+        let mut collector = ResultCollector::new(Some(0), SearchOrder::Descending);
+        collector.extend(batch(0..500));
+        assert_eq!(collector.buffered(), 500);
+
+        let (results, total) = collector.finish();
+        assert_eq!(results.len(), 500);
+        assert_eq!(total, 500);
+    }
+
+    #[test]
+    fn test_unlimited_keeps_everything() {
+        let mut collector = ResultCollector::new(None, SearchOrder::Descending);
+        collector.extend(batch(0..500));
+        assert_eq!(collector.total(), 500);
+
+        let (results, total) = collector.finish();
+        assert_eq!(results.len(), 500);
+        assert_eq!(total, 500);
+    }
+
+    #[test]
+    fn test_result_cap() {
+        let cap = ResultCap::new(Some(3));
+        assert!(!cap.reached());
+        cap.record(2);
+        assert!(!cap.reached());
+        cap.record(3);
+        assert!(cap.reached());
+
+        for unlimited in [ResultCap::new(None), ResultCap::new(Some(0))] {
+            unlimited.record(1000);
+            assert!(!unlimited.reached());
+        }
+
+        let cancel = CancelToken::new();
+        let cap = ResultCap::new(None).with_cancel(cancel.clone());
+        assert!(!cap.reached());
+        cancel.cancel();
+        assert!(cap.reached());
+    }
+
+    #[test]
+    fn test_type_counts_cover_dropped_results() {
+        let mut collector = ResultCollector::new(Some(1), SearchOrder::Descending);
+        let mut results = batch(0..3);
+        results[0].message_type = "assistant".to_string();
+        collector.extend(results);
+
+        let counts = collector.type_counts();
+        assert_eq!(counts.get("user"), Some(&2));
+        assert_eq!(counts.get("assistant"), Some(&1));
+        assert_eq!(collector.finish().0.len(), 1);
+    }
+
+    #[test]
+    fn test_file_order_sorts_by_file_then_line() {
+        let files = [PathBuf::from("/b.jsonl"), PathBuf::from("/a.jsonl")];
+        let at = |file: &str, line: usize, second: u32| SearchResult {
+            file: file.to_string(),
+            line_number: Some(line),
+            ..result(format!("2024-01-01T00:00:{second:02}Z"))
+        };
+        let mut collector =
+            ResultCollector::new(Some(3), SearchOrder::Descending).with_file_order(Some(&files));
+        // Files finish in any order; timestamps play no part
+        collector.extend([at("/a.jsonl", 1, 50), at("/a.jsonl", 2, 10)]);
+        collector.extend([at("/b.jsonl", 7, 5), at("/b.jsonl", 3, 40)]);
+
+        let (results, total) = collector.finish();
+        assert_eq!(total, 4);
+        let positions: Vec<(&str, usize)> = results
+            .iter()
+            .map(|r| (r.file.as_str(), r.line_number.unwrap()))
+            .collect();
+        assert_eq!(
+            positions,
+            [("/b.jsonl", 3), ("/b.jsonl", 7), ("/a.jsonl", 1)]
+        );
+    }
+}
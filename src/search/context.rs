@@ -0,0 +1,406 @@
+use crate::query::{SearchOptions, SearchResult};
+use crate::schemas::SessionMessage;
+use crate::utils::{compression, line_reader};
+use anyhow::{Context, Result};
+use std::collections::hash_map::Entry;
+use std::collections::{HashMap, HashSet};
+use std::path::Path;
+
+/// Messages surrounding a search hit (grep-style -A/-B/-C)
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct ContextWindow {
+    pub before: Vec<SearchResult>,
+    pub after: Vec<SearchResult>,
+}
+
+/// Collect up to `before` preceding and `after` following messages for each result.
+///
+/// Context is taken from the same file and session as the hit. Each message is
+/// returned at most once across all windows and hits are never repeated as
+/// context, so overlapping windows are merged. Context text is built like the
+/// hits' own (`--no-thinking`, `--include-unknown`, ...) and lines longer than
+/// `options.max_line_bytes` are skipped. The returned vector is parallel to `results`.
+pub fn collect_context(
+    results: &[SearchResult],
+    before: usize,
+    after: usize,
+    options: &SearchOptions,
+) -> Result<Vec<ContextWindow>> {
+    let mut files: HashMap<String, Vec<SearchResult>> = HashMap::new();
+    let mut seen: HashSet<(String, usize)> = results
+        .iter()
+        .filter_map(|r| r.line_number.map(|line| (r.file.clone(), line)))
+        .collect();
+
+    let mut windows = Vec::with_capacity(results.len());
+    for result in results {
+        let Some(line_number) = result.line_number else {
+            windows.push(ContextWindow::default());
+            continue;
+        };
+
+        let messages = match files.entry(result.file.clone()) {
+            Entry::Occupied(entry) => entry.into_mut(),
+            Entry::Vacant(entry) => entry.insert(
+                load_file_messages(Path::new(&result.file), result, options)
+                    .with_context(|| format!("Failed to read context from {}", result.file))?,
+            ),
+        };
+
+        let Some(index) = messages
+            .iter()
+            .position(|m| m.line_number == Some(line_number))
+        else {
+            windows.push(ContextWindow::default());
+            continue;
+        };
+
+        let mut take = |range: &[SearchResult]| -> Vec<SearchResult> {
+            range
+                .iter()
+                .filter(|m| m.session_id == result.session_id)
+                .filter(|m| seen.insert((m.file.clone(), m.line_number.unwrap_or(0))))
+                .cloned()
+                .collect()
+        };
+
+        let before_range = &messages[index.saturating_sub(before)..index];
+        let after_range = &messages[index + 1..(index + 1 + after).min(messages.len())];
+        windows.push(ContextWindow {
+            before: take(before_range),
+            after: take(after_range),
+        });
+    }
+
+    Ok(windows)
+}
+
+/// The raw JSONL around each result for `--raw` with -A/-B/-C: up to `before`
+/// preceding and `after` following lines of the file, with the hit's own line
+/// between them, exactly as they are on disk.
+///
+/// Unlike [`collect_context`] neighbours are taken by line rather than by
+/// parsed message, so a block is a verbatim slice of the file that can be
+/// replayed or re-imported; only empty lines and lines longer than
+/// `max_line_bytes` are left out. Each line is returned at most once across all
+/// blocks, so overlapping windows are merged. A result without a line number is
+/// just its own raw JSON. The returned vector is parallel to `results`.
+pub fn collect_raw_context(
+    results: &[SearchResult],
+    before: usize,
+    after: usize,
+    max_line_bytes: usize,
+) -> Result<Vec<Vec<String>>> {
+    let mut files: HashMap<String, Vec<(usize, String)>> = HashMap::new();
+    let mut seen: HashSet<(String, usize)> = HashSet::new();
+
+    let mut blocks = Vec::with_capacity(results.len());
+    for result in results {
+        let own_line = || result.raw_json.iter().cloned().collect::<Vec<_>>();
+        let Some(line_number) = result.line_number else {
+            blocks.push(own_line());
+            continue;
+        };
+
+        let lines = match files.entry(result.file.clone()) {
+            Entry::Occupied(entry) => entry.into_mut(),
+            Entry::Vacant(entry) => entry.insert(
+                read_raw_lines(Path::new(&result.file), max_line_bytes)
+                    .with_context(|| format!("Failed to read context from {}", result.file))?,
+            ),
+        };
+
+        let Some(index) = lines.iter().position(|(line, _)| *line == line_number) else {
+            blocks.push(own_line());
+            continue;
+        };
+
+        let range = index.saturating_sub(before)..(index + 1 + after).min(lines.len());
+        blocks.push(
+            lines[range]
+                .iter()
+                .filter(|(line, _)| seen.insert((result.file.clone(), *line)))
+                .map(|(_, raw)| raw.clone())
+                .collect(),
+        );
+    }
+
+    Ok(blocks)
+}
+
+// Every non-empty line of a file no longer than `max_line_bytes` with its
+// 1-based line number, without the line ending
+fn read_raw_lines(file_path: &Path, max_line_bytes: usize) -> Result<Vec<(usize, String)>> {
+    let mut reader = compression::open_session_reader(file_path)?;
+    let mut lines = Vec::new();
+    let mut line_buffer = Vec::with_capacity(16 * 1024);
+    let mut line_number = 0usize;
+
+    while line_reader::read_line_within(
+        &mut reader,
+        &mut line_buffer,
+        max_line_bytes,
+        file_path,
+        &mut line_number,
+    )? {
+        if line_buffer.trim_ascii().is_empty() {
+            continue;
+        }
+
+        if line_buffer.ends_with(b"\n") {
+            line_buffer.pop();
+            if line_buffer.ends_with(b"\r") {
+                line_buffer.pop();
+            }
+        }
+
+        lines.push((
+            line_number,
+            String::from_utf8_lossy(&line_buffer).into_owned(),
+        ));
+    }
+
+    Ok(lines)
+}
+
+// Load every parseable message in a file as a result, keeping its line number
+fn load_file_messages(
+    file_path: &Path,
+    hit: &SearchResult,
+    options: &SearchOptions,
+) -> Result<Vec<SearchResult>> {
+    Ok(read_session_messages(file_path, options.max_line_bytes)?
+        .into_iter()
+        .map(|(line_number, message)| SearchResult {
+            file: hit.file.clone(),
+            uuid: message.get_uuid().unwrap_or("").to_string(),
+            timestamp: message.get_timestamp().unwrap_or("").to_string(),
+            session_id: message.get_session_id().unwrap_or("").to_string(),
+            role: message.get_type().to_string(),
+            text: options.display_text(&message),
+            message_type: message.get_type().to_string(),
+            query: hit.query.clone(),
+            cwd: message.get_cwd().unwrap_or("").to_string(),
+            raw_json: None,
+            line_number: Some(line_number),
+            request_id: message.get_request_id().map(str::to_string),
+            parent_uuid: message.get_parent_uuid().map(str::to_string),
+            is_sidechain: message.is_sidechain(),
+        })
+        .collect())
+}
+
+/// Read every parseable message in a file along with its 1-based line number.
+/// Lines that are empty, longer than `max_line_bytes` or fail to parse are skipped.
+pub(crate) fn read_session_messages(
+    file_path: &Path,
+    max_line_bytes: usize,
+) -> Result<Vec<(usize, SessionMessage)>> {
+    let mut reader = compression::open_session_reader(file_path)?;
+    let mut messages = Vec::new();
+    let mut line_buffer = Vec::with_capacity(16 * 1024);
+    let mut line_number = 0usize;
+
+    while line_reader::read_line_within(
+        &mut reader,
+        &mut line_buffer,
+        max_line_bytes,
+        file_path,
+        &mut line_number,
+    )? {
+        if line_buffer.trim_ascii().is_empty() {
+            continue;
+        }
+
+        if line_buffer.ends_with(b"\n") {
+            line_buffer.pop();
+            if line_buffer.ends_with(b"\r") {
+                line_buffer.pop();
+            }
+        }
+
+        if let Ok(message) = sonic_rs::from_slice::<SessionMessage>(&line_buffer) {
+            messages.push((line_number, message));
+        }
+    }
+
+    Ok(messages)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::query::QueryCondition;
+    use crate::utils::line_reader::MAX_LINE_BYTES;
+    use std::fs::File;
+    use std::io::Write;
+    use tempfile::tempdir;
+
+    fn write_session(path: &Path, count: usize) -> Result<()> {
+        let mut file = File::create(path)?;
+        for i in 1..=count {
+            writeln!(
+                file,
+                r#"{{"type":"user","message":{{"role":"user","content":"message {i}"}},"uuid":"uuid-{i}","timestamp":"2024-01-01T00:00:0{i}Z","sessionId":"session1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/test","version":"1.0"}}"#
+            )?;
+        }
+        Ok(())
+    }
+
+    fn hit(path: &Path, line: usize) -> SearchResult {
+        SearchResult {
+            file: path.to_string_lossy().to_string(),
+            uuid: format!("uuid-{line}"),
+            timestamp: format!("2024-01-01T00:00:0{line}Z"),
+            session_id: "session1".to_string(),
+            role: "user".to_string(),
+            text: format!("message {line}"),
+            message_type: "user".to_string(),
+            query: QueryCondition::Literal {
+                pattern: "message".to_string(),
+                case_sensitive: false,
+            },
+            cwd: "/test".to_string(),
+            raw_json: None,
+            line_number: Some(line),
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
+        }
+    }
+
+    fn uuids(messages: &[SearchResult]) -> Vec<&str> {
+        messages.iter().map(|m| m.uuid.as_str()).collect()
+    }
+
+    #[test]
+    fn test_collect_context_before_and_after() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let path = temp_dir.path().join("session.jsonl");
+        write_session(&path, 5)?;
+
+        let windows = collect_context(&[hit(&path, 3)], 2, 1, &SearchOptions::default())?;
+
+        assert_eq!(windows.len(), 1);
+        assert_eq!(uuids(&windows[0].before), vec!["uuid-1", "uuid-2"]);
+        assert_eq!(uuids(&windows[0].after), vec!["uuid-4"]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_collect_context_deduplicates_overlapping_windows() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let path = temp_dir.path().join("session.jsonl");
+        write_session(&path, 6)?;
+
+        let windows = collect_context(
+            &[hit(&path, 2), hit(&path, 4)],
+            2,
+            2,
+            &SearchOptions::default(),
+        )?;
+
+        assert_eq!(uuids(&windows[0].before), vec!["uuid-1"]);
+        // uuid-4 is a hit itself, so it is not repeated as context
+        assert_eq!(uuids(&windows[0].after), vec!["uuid-3"]);
+        assert!(windows[1].before.is_empty());
+        assert_eq!(uuids(&windows[1].after), vec!["uuid-5", "uuid-6"]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_collect_raw_context_is_a_verbatim_slice() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let path = temp_dir.path().join("session.jsonl");
+        write_session(&path, 6)?;
+        // A line that is not a message still belongs to the slice
+        let mut content = std::fs::read_to_string(&path)?;
+        content.insert_str(0, "{\"type\":\"unknown-entry\"}\n\n");
+        std::fs::write(&path, &content)?;
+        let lines: Vec<&str> = content.lines().collect();
+
+        // uuid-2 is on line 4 now; the empty line 2 is left out
+        let blocks = collect_raw_context(&[hit(&path, 4), hit(&path, 6)], 2, 1, MAX_LINE_BYTES)?;
+        assert_eq!(blocks[0], [lines[0], lines[2], lines[3], lines[4]]);
+        // Lines 4 and 5 were printed with the first hit
+        assert_eq!(blocks[1], [lines[5], lines[6]]);
+
+        let mut result = hit(&path, 4);
+        result.line_number = None;
+        result.raw_json = Some("{}".to_string());
+        assert_eq!(
+            collect_raw_context(&[result], 1, 1, MAX_LINE_BYTES)?,
+            [["{}"]]
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_context_skips_lines_over_the_limit() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let path = temp_dir.path().join("session.jsonl");
+        write_session(&path, 3)?;
+        let mut content = std::fs::read_to_string(&path)?;
+        content.insert_str(0, &format!("{}\n", "x".repeat(1000)));
+        std::fs::write(&path, &content)?;
+        let lines: Vec<&str> = content.lines().collect();
+
+        // uuid-2 is on line 3 now, after the skipped line 1 and uuid-1
+        let mut result = hit(&path, 2);
+        result.line_number = Some(3);
+        let options = SearchOptions {
+            max_line_bytes: 512,
+            ..Default::default()
+        };
+        let windows = collect_context(std::slice::from_ref(&result), 2, 0, &options)?;
+        assert_eq!(uuids(&windows[0].before), vec!["uuid-1"]);
+        assert_eq!(windows[0].before[0].line_number, Some(2));
+
+        let blocks = collect_raw_context(&[result], 2, 0, 512)?;
+        assert_eq!(blocks[0], [lines[1], lines[2]]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_context_text_follows_the_options() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let path = temp_dir.path().join("session.jsonl");
+        let assistant = r#"{"type":"assistant","message":{"id":"m","type":"message","role":"assistant","model":"claude","content":[{"type":"thinking","thinking":"maybe a race condition","signature":"sig"},{"type":"text","text":"Fixed the flaky test"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":1,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":1}},"uuid":"uuid-0","timestamp":"2024-01-01T00:00:00Z","sessionId":"session1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/test","version":"1.0"}"#;
+        write_session(&path, 1)?;
+        let content = std::fs::read_to_string(&path)?;
+        std::fs::write(&path, format!("{assistant}\n{content}"))?;
+        let mut result = hit(&path, 1);
+        result.line_number = Some(2);
+
+        let windows = collect_context(
+            std::slice::from_ref(&result),
+            1,
+            0,
+            &SearchOptions::default(),
+        )?;
+        assert!(windows[0].before[0].text.contains("maybe a race condition"));
+
+        // --no-thinking hides thinking in context as it does in hits
+        let options = SearchOptions {
+            include_thinking: false,
+            ..Default::default()
+        };
+        let windows = collect_context(&[result], 1, 0, &options)?;
+        assert_eq!(windows[0].before[0].text, "Fixed the flaky test");
+        Ok(())
+    }
+
+    #[test]
+    fn test_collect_context_without_line_number() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let path = temp_dir.path().join("session.jsonl");
+        write_session(&path, 3)?;
+
+        let mut result = hit(&path, 2);
+        result.line_number = None;
+        let windows = collect_context(&[result], 1, 1, &SearchOptions::default())?;
+
+        assert_eq!(windows, vec![ContextWindow::default()]);
+        Ok(())
+    }
+}
@@ -0,0 +1,242 @@
+//! Reading every message of the session files, for passes that don't run a
+//! query (corpus `--stats`, `--agg tokens`, `--window`)
+//!
+//! Files are read the way the search engines read them: `--project`,
+//! `--max-filesize`, `--max-line-bytes` and `--file-timeout` apply, and a file
+//! that can't be read is skipped and counted rather than ending the whole pass.
+
+use super::skipped::SkipCounter;
+use crate::query::SearchOptions;
+use crate::schemas::SessionMessage;
+use crate::stats::corpus_filter;
+use crate::utils::line_reader::{self, BoundedLine};
+use crate::utils::{compression, path_encoding};
+use anyhow::Result;
+use rayon::prelude::*;
+use std::path::{Path, PathBuf};
+
+/// Call `visit` with the messages of each of `files` that pass the filters of
+/// `options` (see [`corpus_filter`]) and their 1-based line numbers, files in
+/// parallel, and collect what it returns.
+///
+/// Files outside `--project` are left out. Files over `--max-filesize` and files
+/// that can't be opened are left out and recorded in `skipped`, as are lines
+/// over `--max-line-bytes` and lines that don't parse. A read error partway
+/// through a file or running past `--file-timeout` ends that file, keeping the
+/// messages read before it.
+pub fn map_corpus_files<T, F>(
+    files: &[PathBuf],
+    options: &SearchOptions,
+    skipped: &SkipCounter,
+    visit: F,
+) -> Vec<T>
+where
+    T: Send,
+    F: Fn(&Path, Vec<(usize, SessionMessage)>) -> T + Sync,
+{
+    let keep = corpus_filter(options);
+
+    files
+        .par_iter()
+        .filter_map(|path| {
+            if let Some(project_path) = &options.project_path
+                && !path_encoding::file_belongs_to_project(&path.to_string_lossy(), project_path)
+            {
+                return None;
+            }
+            match read_corpus_file(path, options, &keep, skipped) {
+                Ok(messages) => messages.map(|messages| visit(path, messages)),
+                Err(e) => {
+                    skipped.skip_file();
+                    tracing::info!("Skipping {path:?}: {e}");
+                    None
+                }
+            }
+        })
+        .collect()
+}
+
+// The kept messages of one file, or `None` if it is over --max-filesize
+fn read_corpus_file(
+    path: &Path,
+    options: &SearchOptions,
+    keep: &(impl Fn(&SessionMessage) -> bool + Sync),
+    skipped: &SkipCounter,
+) -> Result<Option<Vec<(usize, SessionMessage)>>> {
+    let metadata = std::fs::metadata(path)?;
+    if options
+        .max_file_size
+        .is_some_and(|limit| metadata.len() > limit)
+    {
+        skipped.skip_large_file(path);
+        return Ok(None);
+    }
+    let mut reader = compression::open_session_reader(path)?;
+
+    let mut messages = Vec::new();
+    let mut line_buffer = Vec::with_capacity(16 * 1024);
+    let mut line_number = 0usize;
+    let started = std::time::Instant::now();
+    loop {
+        if options
+            .file_timeout
+            .is_some_and(|timeout| started.elapsed() >= timeout)
+        {
+            skipped.skip_rest_of_file();
+            tracing::warn!(
+                "Stopped reading {path:?} after line {line_number}: took longer than --file-timeout"
+            );
+            break;
+        }
+        let line = match line_reader::read_bounded_line(
+            &mut reader,
+            &mut line_buffer,
+            options.max_line_bytes,
+        ) {
+            Ok(line) => line,
+            Err(e) => {
+                skipped.skip_rest_of_file();
+                tracing::warn!("Stopped reading {path:?} after line {line_number}: {e}");
+                break;
+            }
+        };
+        match line {
+            BoundedLine::Eof => break,
+            BoundedLine::Line => line_number += 1,
+            BoundedLine::TooLong => {
+                line_number += 1;
+                skipped.skip_line();
+                tracing::warn!(
+                    "Skipping line {line_number} of {path:?}: longer than {} bytes (see --max-line-bytes)",
+                    options.max_line_bytes
+                );
+                continue;
+            }
+        }
+
+        let content = line_buffer.trim_ascii();
+        if content.is_empty() {
+            continue;
+        }
+        match sonic_rs::from_slice::<SessionMessage>(content) {
+            Ok(message) => {
+                if keep(&message) {
+                    messages.push((line_number, message));
+                }
+            }
+            Err(_) if SessionMessage::is_other_entry(content) => {
+                tracing::debug!("Skipping line {line_number} of {path:?}: not a message");
+            }
+            Err(e) => {
+                skipped.skip_unparseable_line();
+                tracing::info!("Failed to parse line {line_number} of {path:?}: {e}");
+            }
+        }
+    }
+    Ok(Some(messages))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_fixtures;
+    use std::io::Write;
+    use tempfile::tempdir;
+
+    fn user_line(uuid: &str, content: &str) -> String {
+        test_fixtures::user(uuid).text(content).to_string()
+    }
+
+    // The uuids of the messages read from each file, by file name
+    fn read_uuids(
+        files: &[PathBuf],
+        options: &SearchOptions,
+        skipped: &SkipCounter,
+    ) -> Vec<(String, Vec<(usize, String)>)> {
+        let mut read = map_corpus_files(files, options, skipped, |path, messages| {
+            let name = path.file_name().unwrap().to_string_lossy().to_string();
+            let uuids = messages
+                .iter()
+                .map(|(line, message)| (*line, message.get_uuid().unwrap().to_string()))
+                .collect();
+            (name, uuids)
+        });
+        read.sort();
+        read
+    }
+
+    #[test]
+    fn test_bad_files_are_skipped_and_counted() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let good = temp_dir.path().join("good.jsonl");
+        let corrupt = temp_dir.path().join("corrupt.jsonl.gz");
+        let missing = temp_dir.path().join("missing.jsonl");
+        std::fs::write(
+            &good,
+            format!(
+                "{}\n{{not json\n\n{}\n",
+                user_line("1", "a"),
+                user_line("2", "b")
+            ),
+        )?;
+        std::fs::write(&corrupt, b"not gzip at all")?;
+
+        let skipped = SkipCounter::new();
+        let read = read_uuids(
+            &[good, corrupt, missing],
+            &SearchOptions::default(),
+            &skipped,
+        );
+
+        assert_eq!(
+            read,
+            vec![(
+                "good.jsonl".to_string(),
+                vec![(1, "1".to_string()), (4, "2".to_string())]
+            )]
+        );
+        assert_eq!(skipped.files(), 2);
+        assert_eq!(skipped.parse_errors(), 1);
+        Ok(())
+    }
+
+    #[test]
+    fn test_size_limits_apply() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let small = temp_dir.path().join("small.jsonl");
+        let large = temp_dir.path().join("large.jsonl");
+        std::fs::write(&small, user_line("small", "x"))?;
+        let mut file = std::fs::File::create(&large)?;
+        writeln!(file, "{}", user_line("short", "x"))?;
+        writeln!(file, "{}", user_line("long", &"x".repeat(2048)))?;
+
+        let options = SearchOptions {
+            max_file_size: Some(4096),
+            max_line_bytes: 1024,
+            ..Default::default()
+        };
+        let skipped = SkipCounter::new();
+        let read = read_uuids(&[small, large.clone()], &options, &skipped);
+        assert_eq!(
+            read,
+            vec![
+                ("large.jsonl".to_string(), vec![(1, "short".to_string())]),
+                ("small.jsonl".to_string(), vec![(1, "small".to_string())]),
+            ]
+        );
+        assert_eq!(skipped.lines(), 1);
+
+        let options = SearchOptions {
+            max_file_size: Some(1024),
+            ..Default::default()
+        };
+        let skipped = SkipCounter::new();
+        let read = read_uuids(std::slice::from_ref(&large), &options, &skipped);
+        assert!(read.is_empty());
+        assert_eq!(
+            skipped.large_files(),
+            vec![large.to_string_lossy().to_string()]
+        );
+        Ok(())
+    }
+}
@@ -0,0 +1,145 @@
+//! Spreadsheet-friendly output for `--format csv`
+//!
+//! Columns are chosen from the `--template` fields. Values containing commas,
+//! quotes or line breaks are quoted as described in RFC 4180, so message text
+//! survives a round trip through spreadsheet import unchanged.
+
+use super::template::{Field, TEMPLATE_FIELDS};
+use crate::query::SearchResult;
+use anyhow::{Result, bail};
+use std::borrow::Cow;
+
+/// Columns written when `--csv-fields` is not given
+pub const DEFAULT_CSV_FIELDS: &[&str] = &[
+    "timestamp",
+    "type",
+    "session_id",
+    "file",
+    "line",
+    "uuid",
+    "content",
+];
+
+/// Validated column list for CSV output
+#[derive(Debug, Clone, PartialEq)]
+pub struct CsvFormat {
+    names: Vec<String>,
+    fields: Vec<Field>,
+}
+
+impl CsvFormat {
+    /// Columns from `names`, or [`DEFAULT_CSV_FIELDS`] when it is empty
+    pub fn new(names: &[String]) -> Result<Self> {
+        let names: Vec<String> = if names.is_empty() {
+            DEFAULT_CSV_FIELDS
+                .iter()
+                .map(|name| name.to_string())
+                .collect()
+        } else {
+            names.iter().map(|name| name.trim().to_string()).collect()
+        };
+
+        let mut fields = Vec::with_capacity(names.len());
+        for name in &names {
+            let Some(field) = Field::parse(name) else {
+                bail!(
+                    "Unknown CSV field '{name}' (available: {})",
+                    TEMPLATE_FIELDS.join(", ")
+                );
+            };
+            fields.push(field);
+        }
+
+        Ok(Self { names, fields })
+    }
+
+    /// Header row, including the trailing newline
+    pub fn header(&self) -> String {
+        let cells: Vec<Cow<str>> = self.names.iter().map(|name| escape(name)).collect();
+        format!("{}\n", cells.join(","))
+    }
+
+    /// One row for `result`, including the trailing newline
+    pub fn row(&self, result: &SearchResult) -> String {
+        let mut row = String::new();
+        let mut value = String::new();
+        for (i, field) in self.fields.iter().enumerate() {
+            if i > 0 {
+                row.push(',');
+            }
+            value.clear();
+            field.write(result, &mut value);
+            row.push_str(&escape(&value));
+        }
+        row.push('\n');
+        row
+    }
+}
+
+// Quote a value if it contains a delimiter, quote or line break, doubling quotes
+fn escape(value: &str) -> Cow<'_, str> {
+    if value.contains([',', '"', '\n', '\r']) {
+        Cow::Owned(format!("\"{}\"", value.replace('"', "\"\"")))
+    } else {
+        Cow::Borrowed(value)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::query::QueryCondition;
+
+    fn result(text: &str) -> SearchResult {
+        SearchResult {
+            file: "/projects/session.jsonl".to_string(),
+            uuid: "uuid-1".to_string(),
+            timestamp: "2024-01-01T00:00:00Z".to_string(),
+            session_id: "session1".to_string(),
+            role: "user".to_string(),
+            text: text.to_string(),
+            message_type: "user".to_string(),
+            query: QueryCondition::Literal {
+                pattern: "hello".to_string(),
+                case_sensitive: false,
+            },
+            cwd: "/test".to_string(),
+            raw_json: None,
+            line_number: Some(7),
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
+        }
+    }
+
+    #[test]
+    fn test_default_columns() -> Result<()> {
+        let csv = CsvFormat::new(&[])?;
+        assert_eq!(
+            csv.header(),
+            "timestamp,type,session_id,file,line,uuid,content\n"
+        );
+        assert_eq!(
+            csv.row(&result("hello")),
+            "2024-01-01T00:00:00Z,user,session1,/projects/session.jsonl,7,uuid-1,hello\n"
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_escapes_commas_quotes_and_newlines() -> Result<()> {
+        let csv = CsvFormat::new(&["uuid".to_string(), "content".to_string()])?;
+        assert_eq!(
+            csv.row(&result("say \"hi\", then\nleave")),
+            "uuid-1,\"say \"\"hi\"\", then\nleave\"\n"
+        );
+        assert_eq!(csv.row(&result("plain text")), "uuid-1,plain text\n");
+        Ok(())
+    }
+
+    #[test]
+    fn test_unknown_field_is_rejected() {
+        let error = CsvFormat::new(&["uuid".to_string(), "body".to_string()]).unwrap_err();
+        assert!(error.to_string().contains("Unknown CSV field 'body'"));
+    }
+}
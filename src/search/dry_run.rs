@@ -0,0 +1,218 @@
+use crate::query::{MatchTarget, SearchOptions};
+use crate::utils::path_encoding;
+use std::path::PathBuf;
+
+/// What a search would read, worked out without opening any session file
+#[derive(Debug, Default, Clone, PartialEq)]
+pub struct SearchPlan {
+    /// Files that would be searched
+    pub files: Vec<PathBuf>,
+    /// On-disk size of `files` (compressed size for `.gz` and `.zst` sessions)
+    pub total_bytes: u64,
+    /// Files dropped because they belong to another project
+    pub outside_project: usize,
+    /// Files dropped by `--max-filesize`
+    pub too_large: usize,
+}
+
+/// Apply the per-file filters of both engines (`--project` and
+/// `--max-filesize`) to the discovered `files`
+pub fn plan_search(files: &[PathBuf], options: &SearchOptions) -> SearchPlan {
+    let mut plan = SearchPlan::default();
+    for file in files {
+        if let Some(project_path) = &options.project_path
+            && !path_encoding::file_belongs_to_project(&file.to_string_lossy(), project_path)
+        {
+            plan.outside_project += 1;
+            continue;
+        }
+        let Ok(metadata) = std::fs::metadata(file) else {
+            continue;
+        };
+        if !metadata.is_file() {
+            continue;
+        }
+        if options
+            .max_file_size
+            .is_some_and(|limit| metadata.len() > limit)
+        {
+            plan.too_large += 1;
+            continue;
+        }
+        plan.total_bytes += metadata.len();
+        plan.files.push(file.clone());
+    }
+    plan
+}
+
+/// Report for `--dry-run`: the pattern, what would be read and the options in effect
+pub fn format_search_plan(
+    pattern: &str,
+    plan: &SearchPlan,
+    options: &SearchOptions,
+    engine: &str,
+    workers: usize,
+) -> String {
+    let mut output = format!("Pattern:  {pattern}\n");
+    output.push_str(&format!(
+        "Files:    {} ({})\n",
+        plan.files.len(),
+        format_bytes(plan.total_bytes)
+    ));
+    if plan.outside_project > 0 {
+        output.push_str(&format!(
+            "Skipped:  {} outside --project\n",
+            plan.outside_project
+        ));
+    }
+    if plan.too_large > 0 {
+        output.push_str(&format!(
+            "Skipped:  {} larger than --max-filesize\n",
+            plan.too_large
+        ));
+    }
+    let plural = if workers == 1 { "" } else { "s" };
+    output.push_str(&format!("Engine:   {engine} ({workers} worker{plural})\n"));
+
+    let filters = active_filters(options);
+    if filters.is_empty() {
+        output.push_str("Filters:  none\n");
+    } else {
+        for (index, (name, value)) in filters.iter().enumerate() {
+            let label = if index == 0 { "Filters:" } else { "" };
+            let filter = format!("{name} {value}");
+            output.push_str(&format!("{label:<9} {}\n", filter.trim_end()));
+        }
+    }
+    output
+}
+
+// Options that narrow down the results, as `(flag, value)`
+fn active_filters(options: &SearchOptions) -> Vec<(&'static str, String)> {
+    let mut filters = Vec::new();
+    let mut push = |name, value: Option<String>| {
+        if let Some(value) = value {
+            filters.push((name, value));
+        }
+    };
+    push("--project", options.project_path.clone());
+    for glob in &options.exclude {
+        push("--exclude", Some(glob.clone()));
+    }
+    push("--session-id", options.session_id.clone());
+    push("--role", options.role.clone());
+    push("--user-type", options.user_type.clone());
+    push("--request-id", options.request_id.clone());
+    push("--tool-use-id", options.tool_use_id.clone());
+    push("--after", options.after.clone());
+    push("--before", options.before.clone());
+    push("--min-length", options.min_length.map(|n| n.to_string()));
+    push("--max-length", options.max_length.map(|n| n.to_string()));
+    push("--max-filesize", options.max_file_size.map(format_bytes));
+    push(
+        "--max-results",
+        options.max_results.map(|n| match n {
+            0 => "unlimited".to_string(),
+            n => n.to_string(),
+        }),
+    );
+    push(
+        "--max-per-file",
+        options.max_per_file.map(|n| n.to_string()),
+    );
+    push("--first", options.stop_early.then(String::new));
+    push("--no-meta", options.exclude_meta.then(String::new));
+    push("--tool-errors", options.tool_errors.then(String::new));
+    push(
+        "--no-thinking",
+        (!options.include_thinking).then(String::new),
+    );
+    push(
+        "--include-unknown",
+        options.include_unknown.then(String::new),
+    );
+    push(
+        "--highlight-thinking",
+        options.label_thinking.then(String::new),
+    );
+    push(
+        "--match-field",
+        (options.match_target != MatchTarget::Content)
+            .then(|| format!("{:?}", options.match_target).to_lowercase()),
+    );
+    push("--dedupe", options.dedupe.then(String::new));
+    push("--no-pre-filter", (!options.pre_filter).then(String::new));
+    filters
+}
+
+// Binary units, as accepted by --max-filesize
+pub(crate) fn format_bytes(bytes: u64) -> String {
+    const UNITS: [&str; 4] = ["KiB", "MiB", "GiB", "TiB"];
+    if bytes < 1024 {
+        return format!("{bytes} B");
+    }
+    let mut value = bytes as f64 / 1024.0;
+    let mut unit = 0;
+    while value >= 1024.0 && unit < UNITS.len() - 1 {
+        value /= 1024.0;
+        unit += 1;
+    }
+    format!("{value:.1} {}", UNITS[unit])
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use anyhow::Result;
+    use std::fs;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_format_bytes() {
+        assert_eq!(format_bytes(0), "0 B");
+        assert_eq!(format_bytes(1023), "1023 B");
+        assert_eq!(format_bytes(1536), "1.5 KiB");
+        assert_eq!(format_bytes(100 << 20), "100.0 MiB");
+        assert_eq!(format_bytes(3 << 40), "3.0 TiB");
+    }
+
+    #[test]
+    fn test_plan_applies_file_filters() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let projects = temp_dir.path().join(".claude/projects");
+        let app = projects.join("-work-app");
+        let other = projects.join("-work-other");
+        fs::create_dir_all(&app)?;
+        fs::create_dir_all(&other)?;
+
+        let small = app.join("small.jsonl");
+        let large = app.join("large.jsonl");
+        let elsewhere = other.join("other.jsonl");
+        fs::write(&small, "x".repeat(100))?;
+        fs::write(&large, "x".repeat(2000))?;
+        fs::write(&elsewhere, "x".repeat(10))?;
+        let files = vec![small.clone(), large, elsewhere, app.join("missing.jsonl")];
+
+        let options = SearchOptions {
+            project_path: Some("/work/app".to_string()),
+            max_file_size: Some(1024),
+            min_length: Some(5),
+            exclude: vec!["*/archive/*".to_string()],
+            ..Default::default()
+        };
+        let plan = plan_search(&files, &options);
+        assert_eq!(plan.files, vec![small]);
+        assert_eq!(plan.total_bytes, 100);
+        assert_eq!(plan.outside_project, 1);
+        assert_eq!(plan.too_large, 1);
+
+        let report = format_search_plan("/p/**/*.jsonl", &plan, &options, "smol", 4);
+        assert!(report.contains("Files:    1 (100 B)\n"));
+        assert!(report.contains("Skipped:  1 larger than --max-filesize\n"));
+        assert!(report.contains("Engine:   smol (4 workers)\n"));
+        assert!(report.contains("Filters:  --project /work/app\n"));
+        assert!(report.contains("          --exclude */archive/*\n"));
+        assert!(report.contains("          --min-length 5\n"));
+        Ok(())
+    }
+}
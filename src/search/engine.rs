@@ -2,9 +2,12 @@ use crate::interactive_ratatui::domain::models::SearchOrder;
 use crate::query::{QueryCondition, SearchResult};
 use anyhow::Result;
 use chrono::DateTime;
+use std::collections::HashMap;
 
 /// Trait defining the interface for search engines
 pub trait SearchEngineTrait {
+    /// Returns the capped results, the elapsed time and the total number of
+    /// matches after filtering, which is exact regardless of `max_results`
     fn search(
         &self,
         pattern: &str,
@@ -29,43 +32,191 @@ pub trait SearchEngineTrait {
 
 /// Format a search result for display
 pub fn format_search_result(result: &SearchResult, use_color: bool, full_text: bool) -> String {
-    use chrono::{Local, TimeZone};
     use colored::Colorize;
 
-    let timestamp = if let Ok(dt) = DateTime::parse_from_rfc3339(&result.timestamp) {
-        // Convert to local timezone
-        let local_dt = Local.from_utc_datetime(&dt.naive_utc());
-        local_dt.format("%Y-%m-%d %H:%M:%S").to_string()
-    } else {
-        result.timestamp.clone()
-    };
+    let timestamp = format_local_timestamp(&result.timestamp);
 
     // Format text preview similar to TypeScript implementation
     let text_preview = if full_text {
         result.text.clone()
     } else {
-        format_preview(&result.text, &result.query, 150)
+        format_preview(&result.text, &result.query, 150, use_color)
     };
 
+    let location = format_location(result);
+
     if use_color {
         format!(
             "{} {} [{}] {}\n  {}",
             timestamp.bright_blue(),
             result.role.bright_yellow(),
-            result.file.bright_green(),
+            location.bright_green(),
             result.uuid.dimmed(),
             text_preview
         )
     } else {
         format!(
             "{} {} [{}] {}\n  {}",
-            timestamp, result.role, result.file, result.uuid, text_preview
+            timestamp, result.role, location, result.uuid, text_preview
+        )
+    }
+}
+
+/// Format a search result as one grep-like line, `timestamp role file:line  snippet`,
+/// with the snippet cut to fit in `width` columns when a width is given
+pub fn format_compact_result(
+    result: &SearchResult,
+    use_color: bool,
+    full_text: bool,
+    width: Option<usize>,
+) -> String {
+    use colored::Colorize;
+
+    let timestamp = format_local_timestamp(&result.timestamp);
+    let location = format_location(result);
+    let prefix_width =
+        timestamp.chars().count() + result.role.chars().count() + location.chars().count() + 4;
+
+    let mut snippet = if full_text {
+        result.text.split_whitespace().collect::<Vec<_>>().join(" ")
+    } else {
+        format_preview(&result.text, &result.query, 150, false)
+    };
+    if let Some(width) = width {
+        let room = width.saturating_sub(prefix_width);
+        if let Some((end, _)) = snippet.char_indices().nth(room) {
+            // Keep room for the ellipsis; a trailing one from the preview is cut with the rest
+            let end = snippet[..end]
+                .char_indices()
+                .last()
+                .map_or(0, |(last, _)| last);
+            snippet.truncate(end);
+            snippet.push('…');
+        }
+    }
+
+    if use_color {
+        format!(
+            "{} {} {}  {}",
+            timestamp.bright_blue(),
+            result.role.bright_yellow(),
+            location.bright_green(),
+            highlight_matches(&snippet, &result.query)
+        )
+    } else {
+        format!("{timestamp} {} {location}  {snippet}", result.role)
+    }
+}
+
+/// Format a search result listed under its file's header (`--group-by-file`):
+/// like `format_search_result`, with only the line number for a location
+pub fn format_grouped_result(result: &SearchResult, use_color: bool, full_text: bool) -> String {
+    use colored::Colorize;
+
+    let timestamp = format_local_timestamp(&result.timestamp);
+    let text_preview = if full_text {
+        result.text.clone()
+    } else {
+        format_preview(&result.text, &result.query, 150, use_color)
+    };
+    let location = result
+        .line_number
+        .map(|line| format!("line {line} "))
+        .unwrap_or_default();
+
+    if use_color {
+        format!(
+            "{} {} {}{}\n  {}",
+            timestamp.bright_blue(),
+            result.role.bright_yellow(),
+            location.bright_green(),
+            result.uuid.dimmed(),
+            text_preview
+        )
+    } else {
+        format!(
+            "{timestamp} {} {location}{}\n  {text_preview}",
+            result.role, result.uuid
         )
     }
 }
 
-/// Format text preview with context around match
-fn format_preview(text: &str, query: &QueryCondition, context_length: usize) -> String {
+/// Header printed once above a file's results with `--group-by-file`
+pub fn format_file_header(file: &str, use_color: bool) -> String {
+    use colored::Colorize;
+
+    if use_color {
+        file.bright_green().bold().to_string()
+    } else {
+        file.to_string()
+    }
+}
+
+/// Reorder results so each file's results are together, files in the order of
+/// their first result and results within a file in their original order
+pub fn group_by_file(results: Vec<SearchResult>) -> Vec<SearchResult> {
+    let mut first_seen: HashMap<String, usize> = HashMap::new();
+    for result in &results {
+        let next = first_seen.len();
+        first_seen.entry(result.file.clone()).or_insert(next);
+    }
+    let mut results = results;
+    results.sort_by_key(|result| first_seen[&result.file]);
+    results
+}
+
+// "file.jsonl:123" when the line is known, so editors can jump straight to it
+fn format_location(result: &SearchResult) -> String {
+    match result.line_number {
+        Some(line) => format!("{}:{line}", result.file),
+        None => result.file.clone(),
+    }
+}
+
+/// Format a context message shown around a hit (-A/-B/-C) as a single dimmed line
+pub fn format_context_result(result: &SearchResult, use_color: bool, full_text: bool) -> String {
+    use colored::Colorize;
+
+    let timestamp = format_local_timestamp(&result.timestamp);
+    let cleaned = result.text.split_whitespace().collect::<Vec<_>>().join(" ");
+    let text = if full_text {
+        cleaned
+    } else {
+        match cleaned.char_indices().nth(150) {
+            Some((end, _)) => format!("{}…", &cleaned[..preview_end(&cleaned, 0, end)]),
+            None => cleaned,
+        }
+    };
+
+    let line = format!("- {timestamp} {} {text}", result.role);
+    if use_color {
+        line.dimmed().to_string()
+    } else {
+        line
+    }
+}
+
+// Convert an RFC3339 timestamp to local time for display
+pub(crate) fn format_local_timestamp(timestamp: &str) -> String {
+    use chrono::{Local, TimeZone};
+
+    if let Ok(dt) = DateTime::parse_from_rfc3339(timestamp) {
+        // Convert to local timezone
+        let local_dt = Local.from_utc_datetime(&dt.naive_utc());
+        local_dt.format("%Y-%m-%d %H:%M:%S").to_string()
+    } else {
+        timestamp.to_string()
+    }
+}
+
+/// Format text preview with context around the first match, optionally
+/// highlighting every match that ends up in the preview
+pub(crate) fn format_preview(
+    text: &str,
+    query: &QueryCondition,
+    context_length: usize,
+    highlight: bool,
+) -> String {
     // Find the first match position
     let match_info = query.find_match(text);
 
@@ -75,19 +226,8 @@ fn format_preview(text: &str, query: &QueryCondition, context_length: usize) ->
             let context_before = 50;
             let context_after = context_length.saturating_sub(context_before);
 
-            let preview_start = start.saturating_sub(context_before);
-            let preview_end = (start + len + context_after).min(text.len());
-
-            // Handle UTF-8 boundaries
-            let mut actual_start = preview_start;
-            while actual_start > 0 && !text.is_char_boundary(actual_start) {
-                actual_start -= 1;
-            }
-
-            let mut actual_end = preview_end;
-            while actual_end < text.len() && !text.is_char_boundary(actual_end) {
-                actual_end += 1;
-            }
+            let actual_start = preview_start(text, start.saturating_sub(context_before), start);
+            let actual_end = preview_end(text, start + len, start + len + context_after);
 
             let preview = &text[actual_start..actual_end];
             let match_start_in_preview = start.saturating_sub(actual_start);
@@ -100,11 +240,7 @@ fn format_preview(text: &str, query: &QueryCondition, context_length: usize) ->
             )
         } else {
             // No match found, show beginning of text
-            let end = context_length.min(text.len());
-            let mut actual_end = end;
-            while actual_end < text.len() && !text.is_char_boundary(actual_end) {
-                actual_end += 1;
-            }
+            let actual_end = preview_end(text, 0, context_length);
 
             (
                 text[..actual_end].to_string(),
@@ -122,17 +258,214 @@ fn format_preview(text: &str, query: &QueryCondition, context_length: usize) ->
         .join(" ");
 
     // Apply highlighting and ellipsis
-    let mut result = cleaned;
-
-    // No longer need to highlight matches - removed color highlighting code
+    let mut result = if highlight {
+        highlight_matches(&cleaned, query)
+    } else {
+        cleaned
+    };
 
     // Add ellipsis
     if has_prefix {
-        result = format!("...{result}");
+        result = format!("…{result}");
     }
     if has_suffix {
-        result = format!("{result}...");
+        result = format!("{result}…");
     }
 
     result
 }
+
+// Where a preview allowed to end anywhere in `min..=max` should end: at `max`
+// moved back to a char boundary, then back to the last whitespace so no word
+// is cut in half. A word filling the whole range is cut anyway.
+pub(crate) fn preview_end(text: &str, min: usize, max: usize) -> usize {
+    if max >= text.len() {
+        return text.len();
+    }
+    let mut end = max;
+    while !text.is_char_boundary(end) {
+        end -= 1;
+    }
+    if text[end..].starts_with(char::is_whitespace) {
+        return end;
+    }
+    text[min..end]
+        .rfind(char::is_whitespace)
+        .map(|space| min + space)
+        .filter(|&end| end > 0)
+        .unwrap_or(end)
+}
+
+// Where a preview allowed to start anywhere in `min..=max` should start: at
+// `min` moved forward to a char boundary, then to the end of the word it
+// falls in. `max` (the match) is always a char boundary.
+fn preview_start(text: &str, min: usize, max: usize) -> usize {
+    if min == 0 {
+        return 0;
+    }
+    let mut start = min;
+    while !text.is_char_boundary(start) {
+        start += 1;
+    }
+    if text[..start].ends_with(char::is_whitespace) {
+        return start;
+    }
+    text[start..max]
+        .find(char::is_whitespace)
+        .map_or(start, |space| start + space)
+}
+
+// Wrap every match of `query` in `text` in the highlight color
+fn highlight_matches(text: &str, query: &QueryCondition) -> String {
+    use colored::Colorize;
+
+    let mut highlighted = String::with_capacity(text.len());
+    let mut last_end = 0;
+    for (start, len) in query.find_all_matches(text) {
+        if !text.is_char_boundary(start) || !text.is_char_boundary(start + len) {
+            continue;
+        }
+        highlighted.push_str(&text[last_end..start]);
+        highlighted.push_str(&text[start..start + len].red().bold().to_string());
+        last_end = start + len;
+    }
+    highlighted.push_str(&text[last_end..]);
+    highlighted
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn result(line_number: Option<usize>) -> SearchResult {
+        SearchResult {
+            file: "/projects/session.jsonl".to_string(),
+            uuid: "uuid-1".to_string(),
+            timestamp: "2024-01-01T00:00:00Z".to_string(),
+            session_id: "session1".to_string(),
+            role: "user".to_string(),
+            text: "hello world".to_string(),
+            message_type: "user".to_string(),
+            query: QueryCondition::Literal {
+                pattern: "hello".to_string(),
+                case_sensitive: false,
+            },
+            cwd: "/test".to_string(),
+            raw_json: None,
+            line_number,
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
+        }
+    }
+
+    #[test]
+    fn test_format_compact_result() {
+        let mut long = result(Some(7));
+        long.text = format!("hello {}", "word ".repeat(40));
+
+        let line = format_compact_result(&long, false, false, None);
+        assert!(!line.contains('\n'));
+        assert!(line.contains(" user /projects/session.jsonl:7  hello word word"));
+
+        let line = format_compact_result(&long, false, false, Some(80));
+        assert_eq!(line.chars().count(), 80);
+        assert!(line.ends_with('…'));
+
+        // Short snippets are left whole, and a narrow width still shows the location
+        let line = format_compact_result(&result(None), false, false, Some(200));
+        assert!(line.ends_with("/projects/session.jsonl  hello world"));
+        let line = format_compact_result(&long, false, false, Some(10));
+        assert!(line.ends_with("/projects/session.jsonl:7  …"));
+    }
+
+    #[test]
+    fn test_group_by_file() {
+        let in_file = |file: &str, uuid: &str| SearchResult {
+            file: file.to_string(),
+            uuid: uuid.to_string(),
+            ..result(None)
+        };
+        let results = vec![
+            in_file("b.jsonl", "1"),
+            in_file("a.jsonl", "2"),
+            in_file("b.jsonl", "3"),
+            in_file("c.jsonl", "4"),
+            in_file("a.jsonl", "5"),
+        ];
+        let uuids: Vec<_> = group_by_file(results)
+            .into_iter()
+            .map(|result| result.uuid)
+            .collect();
+        assert_eq!(uuids, ["1", "3", "2", "5", "4"]);
+    }
+
+    #[test]
+    fn test_format_grouped_result_omits_file() {
+        let output = format_grouped_result(&result(Some(12)), false, false);
+        assert!(output.contains(" user line 12 uuid-1\n  hello world"));
+        assert!(!output.contains("session.jsonl"));
+
+        let output = format_grouped_result(&result(None), false, false);
+        assert!(output.contains(" user uuid-1\n"));
+    }
+
+    #[test]
+    fn test_format_search_result_includes_line_number() {
+        let output = format_search_result(&result(Some(123)), false, false);
+        assert!(output.contains("[/projects/session.jsonl:123] uuid-1"));
+
+        let output = format_search_result(&result(None), false, false);
+        assert!(output.contains("[/projects/session.jsonl] uuid-1"));
+    }
+
+    #[test]
+    fn test_preview_highlights_every_occurrence() {
+        use colored::Colorize;
+
+        let query = QueryCondition::Literal {
+            pattern: "retry".to_string(),
+            case_sensitive: false,
+        };
+        let text = "Retry failed, so we retry\nonce more before giving up on retry";
+
+        let expected = format!(
+            "{} failed, so we {} once more before giving up on {}",
+            "Retry".red().bold(),
+            "retry".red().bold(),
+            "retry".red().bold()
+        );
+        assert_eq!(format_preview(text, &query, 150, true), expected);
+        assert_eq!(
+            format_preview(text, &query, 150, false),
+            "Retry failed, so we retry once more before giving up on retry"
+        );
+    }
+
+    #[test]
+    fn test_preview_cuts_between_words() {
+        let query = QueryCondition::Literal {
+            pattern: "needle".to_string(),
+            case_sensitive: false,
+        };
+        // Multi-byte words put byte limits in the middle of characters
+        let words = "日本語の長い出力 ".repeat(20);
+
+        let preview = format_preview(&words, &query, 150, false);
+        assert!(preview.ends_with("出力…"), "{preview}");
+        assert!(!preview.contains('�'));
+
+        let text = format!("{words}the needle is here {words}");
+        let preview = format_preview(&text, &query, 150, false);
+        assert!(preview.starts_with("…日本語"), "{preview}");
+        assert!(preview.contains("the needle is here"));
+        assert!(preview.ends_with("出力…"), "{preview}");
+
+        // A single word longer than the preview is still cut
+        let long_word = "x".repeat(400);
+        assert_eq!(
+            format_preview(&long_word, &query, 150, false),
+            format!("{}…", "x".repeat(150))
+        );
+    }
+}
@@ -0,0 +1,105 @@
+use super::fingerprint::fnv1a64;
+use anyhow::Result;
+use serde::Serialize;
+use serde::de::DeserializeOwned;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// A directory of JSON entries, one file per key, shared by the on-disk caches
+/// and state (`--since-last-run`, `--cache-results`, the file list cache).
+///
+/// Files are named by an FNV hash of the key rather than `DefaultHasher`, whose
+/// output may change between builds and would silently orphan every entry.
+/// Keys can collide, so entries should hold their key and readers compare it.
+#[derive(Debug, Clone)]
+pub struct JsonEntryStore {
+    dir: PathBuf,
+}
+
+impl JsonEntryStore {
+    pub fn new(dir: PathBuf) -> Self {
+        Self { dir }
+    }
+
+    pub fn dir(&self) -> &Path {
+        &self.dir
+    }
+
+    /// File holding the entry for `key`
+    pub fn entry_path(&self, key: &str) -> PathBuf {
+        self.dir
+            .join(format!("{:016x}.json", fnv1a64(key.as_bytes())))
+    }
+
+    /// The entry for `key`, or `None` if there is none or it can't be read
+    pub fn read<T: DeserializeOwned>(&self, key: &str) -> Option<T> {
+        serde_json::from_slice(&fs::read(self.entry_path(key)).ok()?).ok()
+    }
+
+    /// Store `entry` for `key`, replacing any earlier one
+    pub fn write<T: Serialize>(&self, key: &str, entry: &T) -> Result<()> {
+        fs::create_dir_all(&self.dir)?;
+        let entry_path = self.entry_path(key);
+        // Write then rename so concurrent runs never read a partial entry
+        let temp_path = entry_path.with_extension(format!("json.{}", std::process::id()));
+        let bytes = serde_json::to_vec(entry)?;
+        let written =
+            fs::write(&temp_path, bytes).and_then(|()| fs::rename(&temp_path, &entry_path));
+        if written.is_err()
+            && let Err(e) = fs::remove_file(&temp_path)
+            && e.kind() != std::io::ErrorKind::NotFound
+        {
+            tracing::debug!("Failed to remove {temp_path:?}: {e}");
+        }
+        Ok(written?)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_round_trip() -> Result<()> {
+        let temp = tempdir()?;
+        let store = JsonEntryStore::new(temp.path().join("entries"));
+        assert_eq!(store.read::<Vec<u32>>("key"), None);
+
+        store.write("key", &vec![1, 2])?;
+        store.write("other", &vec![3])?;
+        assert_eq!(store.read::<Vec<u32>>("key"), Some(vec![1, 2]));
+        store.write("key", &vec![4])?;
+        assert_eq!(store.read::<Vec<u32>>("key"), Some(vec![4]));
+
+        // An entry that doesn't parse as the type asked for is no entry
+        fs::write(store.entry_path("key"), "not json")?;
+        assert_eq!(store.read::<Vec<u32>>("key"), None);
+        Ok(())
+    }
+
+    #[test]
+    fn test_entry_names_are_stable() {
+        // A name that changed with the build would lose every stored entry
+        let store = JsonEntryStore::new(PathBuf::from("entries"));
+        assert_eq!(
+            store.entry_path("error"),
+            Path::new("entries/9f7452dd75d54d31.json")
+        );
+    }
+
+    #[test]
+    fn test_failed_write_leaves_no_temp_file() -> Result<()> {
+        let temp = tempdir()?;
+        let store = JsonEntryStore::new(temp.path().to_path_buf());
+        // A directory where the entry should go makes the rename fail
+        fs::create_dir(store.entry_path("key"))?;
+
+        assert!(store.write("key", &vec![1]).is_err());
+        let names: Vec<_> = fs::read_dir(temp.path())?
+            .map(|entry| entry.map(|entry| entry.file_name()))
+            .collect::<std::io::Result<_>>()?;
+        assert_eq!(names, [store.entry_path("key").file_name().unwrap()]);
+        Ok(())
+    }
+}
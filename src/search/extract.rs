@@ -0,0 +1,120 @@
+use anyhow::{Result, bail};
+use serde_json::Value;
+
+/// A parsed `--extract` path such as `.message.content[0].text`, validated up
+/// front so typos fail before searching.
+///
+/// Paths are dotted field names with optional `[N]` array indexes; the leading
+/// dot is optional and a lone `.` is the whole message.
+#[derive(Debug, Clone, PartialEq)]
+pub struct FieldPath {
+    steps: Vec<Step>,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum Step {
+    Field(String),
+    Index(usize),
+}
+
+impl FieldPath {
+    pub fn parse(path: &str) -> Result<Self> {
+        let trimmed = path.trim();
+        let rest = trimmed.strip_prefix('.').unwrap_or(trimmed);
+        let mut steps = Vec::new();
+        if rest.is_empty() {
+            return Ok(Self { steps });
+        }
+
+        for part in rest.split('.') {
+            // A field name, then any number of indexes: `content[0][1]`
+            let (name, mut indexes) = part.split_at(part.find('[').unwrap_or(part.len()));
+            if name.is_empty() && (indexes.is_empty() || !steps.is_empty()) {
+                bail!("Empty field name in extract path '{trimmed}'");
+            }
+            if !name.is_empty() {
+                steps.push(Step::Field(name.to_string()));
+            }
+            while !indexes.is_empty() {
+                let Some((index, after)) = indexes
+                    .strip_prefix('[')
+                    .and_then(|inner| inner.split_once(']'))
+                else {
+                    bail!("Expected '[N]' after '{name}' in extract path '{trimmed}'");
+                };
+                let Ok(index) = index.trim().parse() else {
+                    bail!("Array index '{index}' in extract path '{trimmed}' is not a number");
+                };
+                steps.push(Step::Index(index));
+                indexes = after;
+            }
+        }
+        Ok(Self { steps })
+    }
+
+    /// The value at this path in `value`, if every step exists
+    pub fn get<'a>(&self, value: &'a Value) -> Option<&'a Value> {
+        self.steps.iter().try_fold(value, |value, step| match step {
+            Step::Field(name) => value.get(name),
+            Step::Index(index) => value.get(index),
+        })
+    }
+
+    /// The value at this path in a raw JSON message, as one printed line: strings
+    /// as they are, other values as compact JSON, and `null` when it is missing
+    pub fn extract(&self, raw_json: &str) -> String {
+        let value = serde_json::from_str::<Value>(raw_json).ok();
+        match value.as_ref().and_then(|value| self.get(value)) {
+            Some(Value::String(text)) => text.clone(),
+            Some(other) => other.to_string(),
+            None => "null".to_string(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const MESSAGE: &str = r#"{"type":"assistant","uuid":"a1","message":{"content":[{"type":"text","text":"Hi\tthere"},{"type":"tool_use","input":{"paths":["a.rs","b.rs"]}}],"usage":{"output_tokens":42}}}"#;
+
+    fn extract(path: &str) -> String {
+        FieldPath::parse(path).unwrap().extract(MESSAGE)
+    }
+
+    #[test]
+    fn test_extract() {
+        assert_eq!(extract(".message.usage.output_tokens"), "42");
+        assert_eq!(extract("uuid"), "a1");
+        assert_eq!(extract(".message.content[0].text"), "Hi\tthere");
+        assert_eq!(extract(".message.content[1].input.paths[1]"), "b.rs");
+        assert_eq!(
+            extract(".message.content[1].input"),
+            r#"{"paths":["a.rs","b.rs"]}"#
+        );
+        assert_eq!(extract(".message.content[2].text"), "null");
+        assert_eq!(extract(".message.missing"), "null");
+        assert_eq!(extract(".uuid[0]"), "null");
+        assert!(extract(".").starts_with(r#"{"message":"#));
+        assert_eq!(FieldPath::parse("x").unwrap().extract("not json"), "null");
+    }
+
+    #[test]
+    fn test_parse() {
+        assert_eq!(
+            FieldPath::parse("[1][0]").unwrap().steps,
+            [Step::Index(1), Step::Index(0)]
+        );
+        assert_eq!(
+            FieldPath::parse(" .a.b[2] ").unwrap().steps,
+            [
+                Step::Field("a".to_string()),
+                Step::Field("b".to_string()),
+                Step::Index(2)
+            ]
+        );
+        for invalid in ["a..b", ".a.", "a[x]", "a[1", "a[1]b", "a.[0]", "a[-1]"] {
+            assert!(FieldPath::parse(invalid).is_err(), "{invalid}");
+        }
+    }
+}
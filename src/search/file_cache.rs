@@ -0,0 +1,160 @@
+use super::entry_store::JsonEntryStore;
+use super::file_discovery::{DiscoveryPlan, FileDiscovery, plan_discovery};
+use anyhow::Result;
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::path::PathBuf;
+use std::time::{Duration, SystemTime};
+
+/// On-disk cache of discovered file lists, one entry per pattern, so repeated
+/// runs can skip the recursive walk.
+///
+/// An entry is reused while it is younger than the TTL and the walk's base
+/// directory (`~/.claude/projects` by default) has the same modification time
+/// as when the list was written. New projects change that time; new sessions in
+/// an existing project do not, so those only show up once the TTL expires.
+pub struct FileListCache {
+    entries: JsonEntryStore,
+    ttl: Duration,
+}
+
+#[derive(Debug, Serialize, Deserialize)]
+struct CachedFileList {
+    glob: String,
+    base_modified: Option<SystemTime>,
+    created: SystemTime,
+    files: Vec<PathBuf>,
+}
+
+impl FileListCache {
+    pub fn new(dir: PathBuf, ttl: Duration) -> Self {
+        Self {
+            entries: JsonEntryStore::new(dir),
+            ttl,
+        }
+    }
+
+    /// Cache under the platform cache directory (e.g. `~/.cache/ccms/file-lists`)
+    pub fn in_default_dir(ttl: Duration) -> Option<Self> {
+        let dir = dirs::cache_dir()?.join("ccms").join("file-lists");
+        Some(Self::new(dir, ttl))
+    }
+
+    /// Files matching `pattern`, from the cache when it is fresh, otherwise by
+    /// walking the file system and refreshing the cache
+    pub fn discover(&self, pattern: Option<&str>) -> Result<Vec<PathBuf>> {
+        let (base, glob) = match plan_discovery(pattern) {
+            DiscoveryPlan::SingleFile(path) => return Ok(vec![path]),
+            DiscoveryPlan::Walk { base, glob } => (base, glob),
+        };
+
+        let base_modified = fs::metadata(&base).and_then(|m| m.modified()).ok();
+        if let Some(files) = self.load(&glob, base_modified) {
+            tracing::debug!("Using cached file list for {glob} ({} files)", files.len());
+            return Ok(files);
+        }
+
+        let files = FileDiscovery::from_pattern(&glob)?.discover_files(&base)?;
+        let entry = CachedFileList {
+            glob,
+            base_modified,
+            created: SystemTime::now(),
+            files,
+        };
+        if let Err(e) = self.entries.write(&entry.glob, &entry) {
+            tracing::debug!(
+                "Failed to write the file list cache for {}: {e}",
+                entry.glob
+            );
+        }
+        Ok(entry.files)
+    }
+
+    fn load(&self, glob: &str, base_modified: Option<SystemTime>) -> Option<Vec<PathBuf>> {
+        let entry: CachedFileList = self.entries.read(glob)?;
+        let age = entry.created.elapsed().ok()?;
+        if entry.glob != glob || entry.base_modified != base_modified || age >= self.ttl {
+            return None;
+        }
+
+        // Sessions deleted since the list was written would only fail to open
+        Some(
+            entry
+                .files
+                .into_iter()
+                .filter(|path| path.is_file())
+                .collect(),
+        )
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs::File;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_cache_hit_skips_walk() -> Result<()> {
+        let projects = tempdir()?;
+        let cache_dir = tempdir()?;
+        fs::create_dir(projects.path().join("project"))?;
+        File::create(projects.path().join("project/a.jsonl"))?;
+        let pattern = format!("{}/**/*.jsonl", projects.path().display());
+
+        let cache = FileListCache::new(cache_dir.path().to_path_buf(), Duration::from_secs(60));
+        assert_eq!(cache.discover(Some(&pattern))?.len(), 1);
+
+        // A new session in an existing project is not seen until the TTL expires
+        File::create(projects.path().join("project/b.jsonl"))?;
+        assert_eq!(cache.discover(Some(&pattern))?.len(), 1);
+
+        let expired = FileListCache::new(cache_dir.path().to_path_buf(), Duration::ZERO);
+        assert_eq!(expired.discover(Some(&pattern))?.len(), 2);
+        Ok(())
+    }
+
+    #[test]
+    fn test_base_directory_change_invalidates() -> Result<()> {
+        let projects = tempdir()?;
+        let cache_dir = tempdir()?;
+        fs::create_dir(projects.path().join("project"))?;
+        File::create(projects.path().join("project/a.jsonl"))?;
+        let pattern = format!("{}/**/*.jsonl", projects.path().display());
+
+        let cache = FileListCache::new(cache_dir.path().to_path_buf(), Duration::from_secs(60));
+        assert_eq!(cache.discover(Some(&pattern))?.len(), 1);
+
+        // Pin the base directory's mtime so the new project is guaranteed to change it
+        let base = File::open(projects.path())?;
+        base.set_modified(SystemTime::UNIX_EPOCH)?;
+        assert_eq!(cache.discover(Some(&pattern))?.len(), 1);
+        fs::create_dir(projects.path().join("new-project"))?;
+        File::create(projects.path().join("new-project/b.jsonl"))?;
+        assert_eq!(cache.discover(Some(&pattern))?.len(), 2);
+        Ok(())
+    }
+
+    #[test]
+    fn test_deleted_files_are_dropped_from_hits() -> Result<()> {
+        let projects = tempdir()?;
+        let cache_dir = tempdir()?;
+        File::create(projects.path().join("a.jsonl"))?;
+        File::create(projects.path().join("b.jsonl"))?;
+        let pattern = format!("{}/*.jsonl", projects.path().display());
+
+        let cache = FileListCache::new(cache_dir.path().to_path_buf(), Duration::from_secs(60));
+        assert_eq!(cache.discover(Some(&pattern))?.len(), 2);
+
+        // Removing a file changes the base mtime too, so pin it to force a hit
+        let base = File::open(projects.path())?;
+        let modified = base.metadata()?.modified()?;
+        fs::remove_file(projects.path().join("b.jsonl"))?;
+        base.set_modified(modified)?;
+        assert_eq!(
+            cache.discover(Some(&pattern))?,
+            vec![projects.path().join("a.jsonl")]
+        );
+        Ok(())
+    }
+}
@@ -2,7 +2,11 @@ use anyhow::{Context, Result};
 use dirs::home_dir;
 use globset::{Glob, GlobSet, GlobSetBuilder};
 use jwalk::WalkDir;
+use std::io::BufRead;
 use std::path::{Path, PathBuf};
+use std::time::Duration;
+
+use super::file_cache::FileListCache;
 
 pub struct FileDiscovery {
     glob_set: GlobSet,
@@ -50,44 +54,180 @@ impl FileDiscovery {
     }
 }
 
+/// Files left out of a search by `--exclude` globs.
+///
+/// Patterns are matched against full paths with the same syntax as the search
+/// pattern, so `*` and `**` both cross directories. A file is excluded when its
+/// own path or any parent directory matches, which lets a pattern name a whole
+/// project directory. A leading `~` is expanded.
+pub struct ExcludeFilter {
+    glob_set: GlobSet,
+}
+
+impl ExcludeFilter {
+    pub fn new(patterns: &[String]) -> Result<Self> {
+        let patterns = patterns
+            .iter()
+            .map(|pattern| expand_tilde(pattern).to_string_lossy().into_owned())
+            .collect();
+        Ok(Self {
+            glob_set: FileDiscovery::new(patterns)?.glob_set,
+        })
+    }
+
+    pub fn is_excluded(&self, path: &Path) -> bool {
+        path.ancestors().any(|path| self.glob_set.is_match(path))
+    }
+}
+
+/// Drop the `files` matching any of the `--exclude` `patterns`
+pub fn exclude_files(files: Vec<PathBuf>, patterns: &[String]) -> Result<Vec<PathBuf>> {
+    if patterns.is_empty() {
+        return Ok(files);
+    }
+    let filter = ExcludeFilter::new(patterns)?;
+    Ok(files
+        .into_iter()
+        .filter(|path| !filter.is_excluded(path))
+        .collect())
+}
+
+/// Replace a leading `~` (alone, or followed by a path separator) with the
+/// home directory. `~\` is accepted on Windows as well as `~/`.
 pub fn expand_tilde(path: &str) -> PathBuf {
-    if let Some(stripped) = path.strip_prefix("~/")
-        && let Some(home) = home_dir()
-    {
-        return home.join(stripped);
+    match home_dir() {
+        Some(home) => expand_tilde_in(path, &home),
+        None => PathBuf::from(path),
+    }
+}
+
+fn expand_tilde_in(path: &str, home: &Path) -> PathBuf {
+    if path == "~" {
+        return home.to_path_buf();
+    }
+    let rest = path
+        .strip_prefix("~/")
+        .or_else(|| path.strip_prefix("~\\").filter(|_| cfg!(windows)));
+    match rest {
+        Some(rest) => home.join(rest),
+        None => PathBuf::from(path),
     }
-    PathBuf::from(path)
 }
 
+/// Glob for every session under `~/.claude/projects`, with the home directory
+/// resolved (or left as `~` when it is unknown)
 pub fn default_claude_pattern() -> String {
-    "~/.claude/projects/**/*.jsonl".to_string()
+    match home_dir() {
+        Some(home) => default_pattern_in(&home),
+        None => format!("~/.claude/projects/{SESSION_GLOB}"),
+    }
 }
 
-pub fn discover_claude_files(pattern: Option<&str>) -> Result<Vec<PathBuf>> {
+fn default_pattern_in(home: &Path) -> String {
+    glob_path(&home.join(".claude").join("projects").join(SESSION_GLOB))
+}
+
+// Archived sessions may be gzip- or zstd-compressed
+const SESSION_GLOB: &str = "**/*.{jsonl,jsonl.gz,jsonl.zst}";
+
+/// A path as glob syntax. Glob patterns use `/` between components on every
+/// platform, and a Windows `\` would otherwise be read as an escape.
+fn glob_path(path: &Path) -> String {
+    let path = path.to_string_lossy();
+    if cfg!(windows) {
+        path.replace('\\', "/")
+    } else {
+        path.into_owned()
+    }
+}
+
+/// Directory to start walking from for a glob pattern: the leading path
+/// components that contain no glob syntax (`*`, `?`, `[`, `{`).
+/// Returns `None` if the path contains no glob syntax at all.
+fn glob_base_path(path: &Path) -> Option<PathBuf> {
+    let is_glob = |s: &str| s.contains(['*', '?', '[', '{']);
+    if !is_glob(&path.to_string_lossy()) {
+        return None;
+    }
+
+    let mut base = PathBuf::new();
+    for component in path.components() {
+        if is_glob(&component.as_os_str().to_string_lossy()) {
+            break;
+        }
+        base.push(component);
+    }
+    if base.as_os_str().is_empty() {
+        base.push(".");
+    }
+    Some(base)
+}
+
+/// Where a pattern's files come from: a single named file, or a walk of `base`
+/// keeping the paths that match `glob`
+pub(crate) enum DiscoveryPlan {
+    SingleFile(PathBuf),
+    Walk { base: PathBuf, glob: String },
+}
+
+pub(crate) fn plan_discovery(pattern: Option<&str>) -> DiscoveryPlan {
     let default_pattern = default_claude_pattern();
     let pattern = pattern.unwrap_or(&default_pattern);
     let expanded_path = expand_tilde(pattern);
 
     // Extract base path and glob pattern
-    let path_str = expanded_path.to_string_lossy();
-    let (base_path, glob_pattern) = if let Some(pos) = path_str.find("**") {
-        let base = &path_str[..pos];
-        (PathBuf::from(base), path_str.to_string())
-    } else if let Some(pos) = path_str.find('*') {
-        let base = &path_str[..pos];
-        let parent = Path::new(base).parent().unwrap_or(Path::new("/"));
-        (parent.to_path_buf(), path_str.to_string())
+    if let Some(base) = glob_base_path(&expanded_path) {
+        DiscoveryPlan::Walk {
+            base,
+            glob: glob_path(&expanded_path),
+        }
     } else if expanded_path.is_dir() {
         // If it's a directory, append the jsonl pattern
-        let glob_pattern = format!("{}/**/*.jsonl", expanded_path.display());
-        (expanded_path, glob_pattern)
+        let glob = glob_path(&expanded_path.join(SESSION_GLOB));
+        DiscoveryPlan::Walk {
+            base: expanded_path,
+            glob,
+        }
     } else {
         // No glob pattern, treat as single file
-        return Ok(vec![expanded_path]);
-    };
+        DiscoveryPlan::SingleFile(expanded_path)
+    }
+}
 
-    let discovery = FileDiscovery::from_pattern(&glob_pattern)?;
-    discovery.discover_files(&base_path)
+pub fn discover_claude_files(pattern: Option<&str>) -> Result<Vec<PathBuf>> {
+    match plan_discovery(pattern) {
+        DiscoveryPlan::SingleFile(path) => Ok(vec![path]),
+        DiscoveryPlan::Walk { base, glob } => {
+            let discovery = FileDiscovery::from_pattern(&glob)?;
+            discovery.discover_files(&base)
+        }
+    }
+}
+
+/// Paths listed one per line, as given to `--files-from`. Blank lines are
+/// skipped, surrounding whitespace is trimmed and a leading `~` is expanded.
+pub fn read_file_list<R: BufRead>(reader: R) -> Result<Vec<PathBuf>> {
+    let mut files = Vec::new();
+    for line in reader.lines() {
+        let line = line.context("failed to read file list")?;
+        let path = line.trim();
+        if !path.is_empty() {
+            files.push(expand_tilde(path));
+        }
+    }
+    Ok(files)
+}
+
+/// Like [`discover_claude_files`], but reuse a file list cached on disk by an
+/// earlier run when `cache_ttl` is set (see [`FileListCache`])
+pub fn discover_claude_files_cached(
+    pattern: Option<&str>,
+    cache_ttl: Option<Duration>,
+) -> Result<Vec<PathBuf>> {
+    match cache_ttl.and_then(FileListCache::in_default_dir) {
+        Some(cache) => cache.discover(pattern),
+        None => discover_claude_files(pattern),
+    }
 }
 
 #[cfg(test)]
@@ -107,6 +247,86 @@ mod tests {
         );
     }
 
+    #[test]
+    fn test_expand_tilde_in_fake_home() {
+        let home = Path::new("fake-home").join("me");
+        assert_eq!(expand_tilde_in("~", &home), home);
+        assert_eq!(
+            expand_tilde_in("~/.claude/projects", &home),
+            home.join(".claude/projects")
+        );
+        assert_eq!(expand_tilde_in("~user/x", &home), PathBuf::from("~user/x"));
+        assert_eq!(
+            expand_tilde_in("~\\.claude", &home) == home.join(".claude"),
+            cfg!(windows)
+        );
+    }
+
+    #[test]
+    fn test_default_pattern_under_fake_home() -> Result<()> {
+        let home = tempdir()?;
+        let project = home.path().join(".claude").join("projects").join("-work");
+        create_dir_all(&project)?;
+        File::create(project.join("session.jsonl"))?;
+        File::create(project.join("archived.jsonl.gz"))?;
+        File::create(project.join("notes.txt"))?;
+
+        let pattern = default_pattern_in(home.path());
+        assert!(pattern.ends_with("/.claude/projects/**/*.{jsonl,jsonl.gz,jsonl.zst}"));
+        assert!(!pattern.contains('\\'));
+
+        let mut files = discover_claude_files(Some(&pattern))?;
+        files.sort();
+        assert_eq!(
+            files,
+            vec![
+                project.join("archived.jsonl.gz"),
+                project.join("session.jsonl")
+            ]
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_read_file_list() -> Result<()> {
+        let list = "/p/a.jsonl\n\n  /p/b c.jsonl  \r\n~/s.jsonl";
+        let files = read_file_list(list.as_bytes())?;
+        assert_eq!(
+            files,
+            vec![
+                PathBuf::from("/p/a.jsonl"),
+                PathBuf::from("/p/b c.jsonl"),
+                expand_tilde("~/s.jsonl"),
+            ]
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_exclude_files() -> Result<()> {
+        let files: Vec<PathBuf> = [
+            "/p/-work-app/a.jsonl",
+            "/p/-work-app/archive/b.jsonl",
+            "/p/-work-old/c.jsonl",
+            "/p/-work-old/sub/d.jsonl.gz",
+        ]
+        .iter()
+        .map(PathBuf::from)
+        .collect();
+
+        assert_eq!(exclude_files(files.clone(), &[])?, files);
+        let kept = exclude_files(
+            files.clone(),
+            &["*/archive/*".to_string(), "/p/-work-old".to_string()],
+        )?;
+        assert_eq!(kept, vec![PathBuf::from("/p/-work-app/a.jsonl")]);
+        let kept = exclude_files(files, &["**/*.gz".to_string()])?;
+        assert_eq!(kept.len(), 3);
+
+        assert!(exclude_files(Vec::new(), &["[".to_string()]).is_err());
+        Ok(())
+    }
+
     #[test]
     fn test_file_discovery() -> Result<()> {
         let temp_dir = tempdir()?;
@@ -161,4 +381,75 @@ mod tests {
 
         Ok(())
     }
+
+    #[test]
+    fn test_glob_base_path() {
+        assert_eq!(
+            glob_base_path(Path::new("/home/me/.claude/projects/**/*.jsonl")),
+            Some(PathBuf::from("/home/me/.claude/projects"))
+        );
+        assert_eq!(
+            glob_base_path(Path::new("/data/{a,b}/*.jsonl")),
+            Some(PathBuf::from("/data"))
+        );
+        assert_eq!(
+            glob_base_path(Path::new("*.jsonl")),
+            Some(PathBuf::from("."))
+        );
+        assert_eq!(glob_base_path(Path::new("/data/session.jsonl")), None);
+    }
+
+    #[test]
+    fn test_discover_claude_files_recursive() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let base_path = temp_dir.path();
+
+        create_dir_all(base_path.join("projects/project1/nested"))?;
+        File::create(base_path.join("projects/top.jsonl"))?;
+        File::create(base_path.join("projects/project1/one.jsonl"))?;
+        File::create(base_path.join("projects/project1/nested/two.jsonl"))?;
+        File::create(base_path.join("projects/project1/nested/ignored.txt"))?;
+
+        let pattern = format!("{}/projects/**/*.jsonl", base_path.display());
+        let mut files = discover_claude_files(Some(&pattern))?;
+        files.sort();
+
+        assert_eq!(
+            files,
+            vec![
+                base_path.join("projects/project1/nested/two.jsonl"),
+                base_path.join("projects/project1/one.jsonl"),
+                base_path.join("projects/top.jsonl"),
+            ]
+        );
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_discover_claude_files_brace_expansion() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let base_path = temp_dir.path();
+
+        for dir in ["alpha/deep", "beta", "gamma"] {
+            create_dir_all(base_path.join(dir))?;
+        }
+        File::create(base_path.join("alpha/deep/a.jsonl"))?;
+        File::create(base_path.join("beta/b.jsonl"))?;
+        File::create(base_path.join("gamma/c.jsonl"))?;
+
+        let pattern = format!("{}/{{alpha,beta}}/**/*.jsonl", base_path.display());
+        let mut files = discover_claude_files(Some(&pattern))?;
+        files.sort();
+
+        assert_eq!(
+            files,
+            vec![
+                base_path.join("alpha/deep/a.jsonl"),
+                base_path.join("beta/b.jsonl"),
+            ]
+        );
+
+        Ok(())
+    }
 }
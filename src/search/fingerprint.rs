@@ -0,0 +1,161 @@
+//! Content fingerprints for `ccms fingerprint`
+//!
+//! A session's fingerprint depends only on what was said: every message is
+//! reduced to its type and text, hashed with 64-bit FNV-1a, and the sorted,
+//! de-duplicated message hashes are hashed again. UUIDs, timestamps, paths and
+//! message order are ignored, so a conversation replayed under another project
+//! gets the same fingerprint. FNV is used instead of `DefaultHasher` because
+//! the values must stay comparable between runs and builds.
+
+use crate::schemas::SessionMessage;
+use crate::utils::{compression, line_reader};
+use anyhow::{Context, Result};
+use std::collections::HashMap;
+use std::path::PathBuf;
+
+const FNV_OFFSET_BASIS: u64 = 0xcbf2_9ce4_8422_2325;
+const FNV_PRIME: u64 = 0x0000_0100_0000_01b3;
+
+/// Fingerprint of one session
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct SessionFingerprint {
+    pub session_id: String,
+    pub hash: u64,
+    /// Distinct messages that went into the hash
+    pub messages: usize,
+}
+
+/// 64-bit FNV-1a hash of `bytes`
+pub fn fnv1a64(bytes: &[u8]) -> u64 {
+    bytes.iter().fold(FNV_OFFSET_BASIS, |hash, &byte| {
+        (hash ^ u64::from(byte)).wrapping_mul(FNV_PRIME)
+    })
+}
+
+/// Hash of a message's canonical form: its type and text content
+pub fn message_hash(message: &SessionMessage) -> u64 {
+    let mut canonical = message.get_type().as_bytes().to_vec();
+    canonical.push(0);
+    canonical.extend_from_slice(message.get_content_text().as_bytes());
+    fnv1a64(&canonical)
+}
+
+/// Combine message hashes into an order-independent session hash
+pub fn combine_hashes(mut hashes: Vec<u64>) -> (u64, usize) {
+    hashes.sort_unstable();
+    hashes.dedup();
+    let bytes: Vec<u8> = hashes.iter().flat_map(|hash| hash.to_le_bytes()).collect();
+    (fnv1a64(&bytes), hashes.len())
+}
+
+/// Fingerprint every session found in `files`, sorted by session ID.
+/// Summaries, lines that do not parse and lines longer than `max_line_bytes`
+/// are ignored.
+pub fn fingerprint_sessions(
+    files: &[PathBuf],
+    max_line_bytes: usize,
+) -> Result<Vec<SessionFingerprint>> {
+    let mut hashes: HashMap<String, Vec<u64>> = HashMap::new();
+    let mut line_buffer = Vec::with_capacity(16 * 1024);
+
+    for path in files {
+        let mut reader = compression::open_session_reader(path)
+            .with_context(|| format!("failed to open file: {}", path.display()))?;
+        let mut line_number = 0;
+        while line_reader::read_line_within(
+            &mut reader,
+            &mut line_buffer,
+            max_line_bytes,
+            path,
+            &mut line_number,
+        )
+        .with_context(|| format!("failed to read line from {}", path.display()))?
+        {
+            let Ok(message) = sonic_rs::from_slice::<SessionMessage>(line_buffer.trim_ascii())
+            else {
+                continue;
+            };
+            if let Some(session_id) = message.get_session_id() {
+                hashes
+                    .entry(session_id.to_string())
+                    .or_default()
+                    .push(message_hash(&message));
+            }
+        }
+    }
+
+    let mut fingerprints: Vec<SessionFingerprint> = hashes
+        .into_iter()
+        .map(|(session_id, hashes)| {
+            let (hash, messages) = combine_hashes(hashes);
+            SessionFingerprint {
+                session_id,
+                hash,
+                messages,
+            }
+        })
+        .collect();
+    fingerprints.sort_by(|a, b| a.session_id.cmp(&b.session_id));
+    Ok(fingerprints)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_fixtures;
+    use crate::utils::line_reader::MAX_LINE_BYTES;
+    use std::fs::File;
+    use std::io::Write;
+    use tempfile::tempdir;
+
+    fn user_line(session: &str, uuid: &str, cwd: &str, text: &str) -> String {
+        test_fixtures::user(uuid)
+            .session(session)
+            .timestamp(&format!("2024-01-01T00:00:0{uuid}Z"))
+            .cwd(cwd)
+            .text(text)
+            .to_string()
+    }
+
+    #[test]
+    fn test_fnv1a64_known_values() {
+        assert_eq!(fnv1a64(b""), 0xcbf2_9ce4_8422_2325);
+        assert_eq!(fnv1a64(b"a"), 0xaf63_dc4c_8601_ec8c);
+        assert_eq!(fnv1a64(b"foobar"), 0x8594_4171_f739_67e8);
+    }
+
+    #[test]
+    fn test_replayed_session_has_same_fingerprint() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let original = temp_dir.path().join("original.jsonl");
+        let copy = temp_dir.path().join("copy.jsonl");
+        let other = temp_dir.path().join("other.jsonl");
+
+        writeln!(
+            File::create(&original)?,
+            "{}\n{}",
+            user_line("s1", "1", "/repo", "fix the build"),
+            user_line("s1", "2", "/repo", "now run tests")
+        )?;
+        // Same content, different project, ids, timestamps and order
+        writeln!(
+            File::create(&copy)?,
+            "{}\n{}",
+            user_line("s2", "4", "/repo-copy", "now run tests"),
+            user_line("s2", "3", "/repo-copy", "fix the build")
+        )?;
+        writeln!(
+            File::create(&other)?,
+            "{}",
+            user_line("s3", "5", "/repo", "fix the build")
+        )?;
+
+        let fingerprints = fingerprint_sessions(&[original, copy, other], MAX_LINE_BYTES)?;
+        let ids: Vec<&str> = fingerprints.iter().map(|f| f.session_id.as_str()).collect();
+        assert_eq!(ids, vec!["s1", "s2", "s3"]);
+        assert_eq!(fingerprints[0].hash, fingerprints[1].hash);
+        assert_ne!(fingerprints[0].hash, fingerprints[2].hash);
+        assert_eq!(fingerprints[0].messages, 2);
+        Ok(())
+    }
+}
@@ -0,0 +1,200 @@
+//! Match counts over the fixture corpus in `tests/fixtures/corpus`, which every
+//! engine has to agree on.
+//!
+//! The corpus covers each message type and content shape: summaries, thinking
+//! blocks, tool calls, tool results as text, as nested arrays, empty or missing,
+//! images, empty messages, and a file written with CRLF line endings that also
+//! holds a blank and a truncated line. When a change moves one of these counts,
+//! it changed what gets matched, not just how fast.
+
+use super::{CancelToken, RayonEngine, SearchEngineTrait, SmolEngine};
+use crate::query::{MatchTarget, QueryCondition, SearchOptions, parse_query};
+use anyhow::Result;
+use tempfile::TempDir;
+
+const CORPUS: [(&str, &str); 3] = [
+    (
+        "conversation.jsonl",
+        include_str!("../../tests/fixtures/corpus/conversation.jsonl"),
+    ),
+    (
+        "tools.jsonl",
+        include_str!("../../tests/fixtures/corpus/tools.jsonl"),
+    ),
+    (
+        "windows.jsonl",
+        include_str!("../../tests/fixtures/corpus/windows.jsonl"),
+    ),
+];
+
+// The corpus copied to a temporary directory, and a pattern matching it
+fn corpus() -> Result<(TempDir, String)> {
+    let dir = tempfile::tempdir()?;
+    for (name, content) in CORPUS {
+        std::fs::write(dir.path().join(name), content)?;
+    }
+    let pattern = format!("{}/*.jsonl", dir.path().display());
+    Ok((dir, pattern))
+}
+
+// Total matches of `query` (empty matches every message, as on the command
+// line) with `options`, checking that both engines agree
+fn count(query: &str, options: SearchOptions) -> Result<usize> {
+    let (_dir, pattern) = corpus()?;
+    let parse = || -> Result<QueryCondition> {
+        if query.is_empty() {
+            return Ok(QueryCondition::Literal {
+                pattern: String::new(),
+                case_sensitive: false,
+            });
+        }
+        parse_query(query)
+    };
+    let options = SearchOptions {
+        max_results: None,
+        ..options
+    };
+    let (_, _, smol) = SmolEngine::new(options.clone()).search(&pattern, parse()?)?;
+    let (_, _, rayon) = RayonEngine::new(options).search(&pattern, parse()?)?;
+    assert_eq!(smol, rayon, "engines disagree on {query:?}");
+    Ok(smol)
+}
+
+#[test]
+fn test_corpus_match_counts() -> Result<()> {
+    let cases = [
+        // Every parseable line; the truncated one is skipped
+        ("", 17),
+        ("login", 10),
+        ("/redirect/i", 4),
+        ("login AND NOT test", 3),
+        // Placeholders for tool results with empty or missing content
+        ("\"Tool Result\"", 2),
+        // Case-insensitive, so the thinking block's "failed" counts too
+        ("FAILED", 2),
+        ("[Image]", 1),
+        ("Bash", 1),
+        ("session-b", 8),
+    ];
+    for (query, expected) in cases {
+        assert_eq!(
+            count(query, SearchOptions::default())?,
+            expected,
+            "{query:?}"
+        );
+    }
+    Ok(())
+}
+
+#[test]
+fn test_corpus_counts_with_filters() -> Result<()> {
+    let without_thinking = || SearchOptions {
+        include_thinking: false,
+        ..Default::default()
+    };
+    assert_eq!(count("login", without_thinking())?, 9);
+    assert_eq!(count("likely", without_thinking())?, 0);
+
+    let role = |role: &str| SearchOptions {
+        role: Some(role.to_string()),
+        ..Default::default()
+    };
+    assert_eq!(count("login", role("user"))?, 4);
+    assert_eq!(count("login", role("assistant"))?, 4);
+    assert_eq!(count("login", role("system"))?, 1);
+    assert_eq!(count("login", role("summary"))?, 1);
+
+    let tool_errors = SearchOptions {
+        tool_errors: true,
+        ..Default::default()
+    };
+    assert_eq!(count("", tool_errors)?, 1);
+
+    let field = |match_target| SearchOptions {
+        match_target,
+        ..Default::default()
+    };
+    // The model is only matched when asked for
+    assert_eq!(count("sonnet", SearchOptions::default())?, 0);
+    assert_eq!(count("sonnet", field(MatchTarget::Model))?, 6);
+    assert_eq!(count("main", field(MatchTarget::Branch))?, 16);
+    assert_eq!(count("session-b", field(MatchTarget::Session))?, 8);
+    assert_eq!(count("login", field(MatchTarget::Session))?, 0);
+    // Every login match, plus the assistant responses that don't mention it
+    assert_eq!(count("sonnet OR login", field(MatchTarget::All))?, 12);
+    Ok(())
+}
+
+#[test]
+fn test_corpus_crlf_lines() -> Result<()> {
+    let (_dir, pattern) = corpus()?;
+    let windows = pattern.replace("*.jsonl", "windows.jsonl");
+    let engine = SmolEngine::new(SearchOptions::default());
+    let (results, _, _) = engine.search(&windows, parse_query("merged")?)?;
+    assert_eq!(results.len(), 1);
+    assert!(!results[0].text.contains('\r'));
+    assert_eq!(results[0].line_number, Some(1));
+    assert_eq!(engine.skipped().parse_errors(), 1);
+
+    let (results, _, _) = engine.search(&windows, parse_query("main")?)?;
+    assert_eq!(results[0].line_number, Some(3));
+    Ok(())
+}
+
+#[test]
+fn test_corpus_cancelled_search() -> Result<()> {
+    let (_dir, pattern) = corpus()?;
+    let cancel = CancelToken::new();
+    let smol = SmolEngine::new(SearchOptions::default()).with_cancel(cancel.clone());
+    let rayon = RayonEngine::new(SearchOptions::default()).with_cancel(cancel.clone());
+    assert_eq!(smol.search(&pattern, parse_query("login")?)?.2, 10);
+
+    // A cancelled search ends without reading further, but without an error
+    cancel.cancel();
+    assert_eq!(smol.search(&pattern, parse_query("login")?)?.2, 0);
+    assert_eq!(rayon.search(&pattern, parse_query("login")?)?.2, 0);
+    Ok(())
+}
+
+#[test]
+fn test_corpus_zstd_archives_match_like_plain_files() -> Result<()> {
+    let (_plain_dir, plain) = corpus()?;
+    let archive_dir = tempfile::tempdir()?;
+    for (name, content) in CORPUS {
+        let compressed = zstd::encode_all(content.as_bytes(), 0)?;
+        std::fs::write(archive_dir.path().join(format!("{name}.zst")), compressed)?;
+    }
+    let archived = format!("{}/*.jsonl.zst", archive_dir.path().display());
+
+    // What identifies a match, apart from which file it was in
+    let matches = |results: Vec<crate::query::SearchResult>| {
+        let mut matches: Vec<_> = results
+            .into_iter()
+            .map(|result| (result.uuid, result.line_number, result.text))
+            .collect();
+        matches.sort();
+        matches
+    };
+    let options = SearchOptions {
+        max_results: None,
+        ..Default::default()
+    };
+    for query in [
+        "login",
+        "/redirect/i",
+        "FAILED OR \"Tool Result\"",
+        "session-b",
+    ] {
+        let (expected, _, _) =
+            SmolEngine::new(options.clone()).search(&plain, parse_query(query)?)?;
+        assert!(!expected.is_empty(), "{query:?} matches nothing");
+        let expected = matches(expected);
+        let (smol, _, _) =
+            SmolEngine::new(options.clone()).search(&archived, parse_query(query)?)?;
+        let (rayon, _, _) =
+            RayonEngine::new(options.clone()).search(&archived, parse_query(query)?)?;
+        assert_eq!(matches(smol), expected, "smol on {query:?}");
+        assert_eq!(matches(rayon), expected, "rayon on {query:?}");
+    }
+    Ok(())
+}
@@ -0,0 +1,92 @@
+use super::entry_store::JsonEntryStore;
+use anyhow::Result;
+use chrono::{DateTime, SecondsFormat, Utc};
+use serde::{Deserialize, Serialize};
+use std::path::PathBuf;
+
+/// When each standing query last ran, for `--since-last-run`.
+///
+/// One small file per query, named by a hash of the query and where it
+/// searched, holding the time the last run started. Starting times are kept
+/// rather than finishing times so messages written while a search runs are
+/// shown by the next one.
+pub struct LastRunStore {
+    entries: JsonEntryStore,
+}
+
+#[derive(Debug, Serialize, Deserialize)]
+struct LastRun {
+    query: String,
+    started: String,
+}
+
+impl LastRunStore {
+    pub fn new(dir: PathBuf) -> Self {
+        Self {
+            entries: JsonEntryStore::new(dir),
+        }
+    }
+
+    /// State under the platform state directory (`~/.local/state/ccms/last-run`
+    /// on Linux, and under the home directory where there is none)
+    pub fn in_default_dir() -> Option<Self> {
+        let state_dir =
+            dirs::state_dir().or_else(|| Some(dirs::home_dir()?.join(".local/state")))?;
+        Some(Self::new(state_dir.join("ccms").join("last-run")))
+    }
+
+    /// Start of the last run of `query` (RFC3339), if it ran before
+    pub fn last_run(&self, query: &str) -> Option<String> {
+        let entry: LastRun = self.entries.read(query)?;
+        (entry.query == query && DateTime::parse_from_rfc3339(&entry.started).is_ok())
+            .then_some(entry.started)
+    }
+
+    /// Remember that a run of `query` started at `started`
+    pub fn record(&self, query: &str, started: DateTime<Utc>) -> Result<()> {
+        let entry = LastRun {
+            query: query.to_string(),
+            started: started.to_rfc3339_opts(SecondsFormat::Millis, true),
+        };
+        self.entries.write(query, &entry)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_last_run_round_trip() -> Result<()> {
+        let state_dir = tempdir()?;
+        let store = LastRunStore::new(state_dir.path().join("last-run"));
+        assert_eq!(store.last_run("error"), None);
+
+        let started = DateTime::parse_from_rfc3339("2024-05-01T10:00:00.250Z")?.with_timezone(&Utc);
+        store.record("error", started)?;
+        assert_eq!(
+            store.last_run("error").as_deref(),
+            Some("2024-05-01T10:00:00.250Z")
+        );
+        // Each query has its own time
+        assert_eq!(store.last_run("error AND deploy"), None);
+
+        let later = started + chrono::Duration::hours(1);
+        store.record("error", later)?;
+        assert_eq!(
+            store.last_run("error").as_deref(),
+            Some("2024-05-01T11:00:00.250Z")
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_unreadable_entry_is_ignored() -> Result<()> {
+        let state_dir = tempdir()?;
+        let store = LastRunStore::new(state_dir.path().to_path_buf());
+        std::fs::write(store.entries.entry_path("error"), "not json")?;
+        assert_eq!(store.last_run("error"), None);
+        Ok(())
+    }
+}
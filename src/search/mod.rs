@@ -1,9 +1,55 @@
+pub mod cancel;
+pub mod collector;
+pub mod context;
+pub mod corpus;
+pub mod csv;
+pub mod dry_run;
 pub mod engine;
+pub mod entry_store;
+pub mod extract;
+pub mod file_cache;
 pub mod file_discovery;
+pub mod fingerprint;
+#[cfg(test)]
+mod fixture_corpus;
+pub mod last_run;
+pub mod prescan;
+pub mod progress;
 pub mod rayon_engine;
+pub mod result_cache;
+pub mod session_index;
+pub mod skipped;
 pub mod smol_engine;
+pub mod summary_timestamps;
+pub mod template;
+pub mod thread;
+pub mod window;
+pub mod workers;
 
-pub use engine::{SearchEngineTrait, format_search_result};
-pub use file_discovery::{default_claude_pattern, discover_claude_files, expand_tilde};
+pub use cancel::CancelToken;
+pub use collector::{ResultCap, ResultCollector};
+pub use context::{ContextWindow, collect_context, collect_raw_context};
+pub use csv::{CsvFormat, DEFAULT_CSV_FIELDS};
+pub use dry_run::{SearchPlan, format_search_plan, plan_search};
+pub use engine::{
+    SearchEngineTrait, format_compact_result, format_context_result, format_file_header,
+    format_grouped_result, format_search_result, group_by_file,
+};
+pub use extract::FieldPath;
+pub use file_cache::FileListCache;
+pub use file_discovery::{
+    default_claude_pattern, discover_claude_files, discover_claude_files_cached, exclude_files,
+    expand_tilde, read_file_list,
+};
+pub use last_run::LastRunStore;
+pub use prescan::FilePrescan;
 pub use rayon_engine::RayonEngine;
+pub use result_cache::{CachedSearch, CorpusStamp, ResultCache};
+pub use session_index::SessionIndex;
+pub use skipped::SkipCounter;
 pub use smol_engine::SmolEngine;
+pub use summary_timestamps::SummaryTimestamps;
+pub use template::{OutputTemplate, TEMPLATE_FIELDS};
+pub use thread::{ThreadIndex, ThreadNode, collect_threads, format_thread_node};
+pub use window::{WindowMatch, format_window_match, search_windows};
+pub use workers::{WorkerCount, auto_workers};
@@ -0,0 +1,252 @@
+//! Whole-file pre-scan for literal queries
+//!
+//! Most files of a large history don't match a given query, and parsing every
+//! line of them is most of the cost of a search. Before a plain session file is
+//! parsed, its raw bytes are checked for the text the query needs, and a file
+//! that can't hold a match is skipped without being parsed.
+//!
+//! Raw JSON holds message text verbatim except for escapes, so a literal made
+//! of printable ASCII that is absent from the bytes can't be in the decoded
+//! text either. Only the parts of a literal that can't come from escapes or
+//! from text the engines add themselves (`[Tool Result: …]`, `Bash: …`, the
+//! `...` of a shortened tool call) are looked for: a literal is cut at
+//! whitespace and the punctuation those use, and pieces of the placeholder
+//! words are dropped. Queries that can match text not spelled out in the file
+//! (regexes, `--fuzzy`, `--phrase`, `--normalize` and `NOT`) are not
+//! pre-scanned at all, and neither is anything with `--include-unknown`, whose
+//! content is searched as re-serialized JSON.
+//!
+//! Non-ASCII characters are often written as `\uXXXX` escapes, so a literal
+//! holding any is not pre-scanned either. What this can still miss is JSON that
+//! escapes ASCII characters too, which Claude Code never writes, and the two
+//! non-ASCII letters that lowercase to ASCII (the Kelvin sign and `İ`) in a
+//! case-insensitive search.
+//!
+//! `--no-pre-filter` turns the pre-scan off, so every line of every file is
+//! parsed and matched: slower, but the exact count to compare against.
+
+use crate::query::{QueryCondition, SearchOptions};
+use crate::utils::compression;
+use crate::utils::mapped_file::{MMAP_THRESHOLD, MappedFile};
+use regex::bytes::{Regex, RegexBuilder};
+use std::io;
+use std::path::Path;
+
+// Words of the placeholders engines put in message text, which the raw JSON
+// spells differently (`tool_result`) or not at all (`JSON value`)
+const PLACEHOLDER_WORDS: [&str; 6] = ["tool", "result", "json", "value", "image", "error"];
+
+/// What the raw bytes of a file must contain for the query to match in it
+#[derive(Debug)]
+pub struct FilePrescan {
+    gate: Gate,
+}
+
+#[derive(Debug)]
+enum Gate {
+    Contains(Regex),
+    All(Vec<Gate>),
+    Any(Vec<Gate>),
+}
+
+impl FilePrescan {
+    /// The pre-scan for `query` searched with `options`, or `None` when it
+    /// can't rule out any file
+    pub fn new(query: &QueryCondition, options: &SearchOptions) -> Option<Self> {
+        if options.include_unknown || !options.pre_filter {
+            return None;
+        }
+        gate(query).map(|gate| FilePrescan { gate })
+    }
+
+    /// Whether a file with these raw bytes could hold a match
+    pub fn may_match(&self, bytes: &[u8]) -> bool {
+        self.gate.may_match(bytes)
+    }
+
+    /// Whether the file at `path` could hold a match. Compressed files are not
+    /// decompressed twice, so they always could.
+    pub fn file_may_match(&self, path: &Path) -> io::Result<bool> {
+        if compression::is_compressed_path(path) {
+            return Ok(true);
+        }
+        if std::fs::metadata(path)?.len() >= MMAP_THRESHOLD {
+            let mapped = MappedFile::open(path)?;
+            return Ok(self.may_match(mapped.remaining()));
+        }
+        Ok(self.may_match(&std::fs::read(path)?))
+    }
+}
+
+impl Gate {
+    fn may_match(&self, bytes: &[u8]) -> bool {
+        match self {
+            Gate::Contains(regex) => regex.is_match(bytes),
+            Gate::All(gates) => gates.iter().all(|gate| gate.may_match(bytes)),
+            Gate::Any(gates) => gates.iter().any(|gate| gate.may_match(bytes)),
+        }
+    }
+}
+
+// `None` when a file without any of the query's text could still match
+fn gate(query: &QueryCondition) -> Option<Gate> {
+    match query {
+        QueryCondition::Literal {
+            pattern,
+            case_sensitive,
+        } => {
+            if !pattern.is_ascii() {
+                return None;
+            }
+            let gates: Vec<Gate> = literal_pieces(pattern)
+                .filter_map(|piece| {
+                    RegexBuilder::new(&regex::escape(piece))
+                        .case_insensitive(!case_sensitive)
+                        .unicode(false)
+                        .build()
+                        .ok()
+                })
+                .map(Gate::Contains)
+                .collect();
+            (!gates.is_empty()).then_some(Gate::All(gates))
+        }
+        // Any condition that can be checked narrows the files down
+        QueryCondition::And { conditions } => {
+            let gates: Vec<Gate> = conditions.iter().filter_map(gate).collect();
+            (!gates.is_empty()).then_some(Gate::All(gates))
+        }
+        // Every alternative has to be checkable
+        QueryCondition::Or { conditions } => conditions
+            .iter()
+            .map(gate)
+            .collect::<Option<Vec<_>>>()
+            .map(Gate::Any),
+        QueryCondition::Regex { .. }
+        | QueryCondition::Fuzzy { .. }
+        | QueryCondition::Phrase { .. }
+        | QueryCondition::Normalized { .. }
+        | QueryCondition::Not { .. } => None,
+    }
+}
+
+// Parts of a literal that appear as they are in the raw JSON of any match
+fn literal_pieces(pattern: &str) -> impl Iterator<Item = &str> {
+    pattern
+        .split(|c: char| {
+            !c.is_ascii_graphic()
+                || matches!(
+                    c,
+                    '"' | '\\' | '/' | ':' | '[' | ']' | '(' | ')' | '{' | '}' | ',' | '.' | '-'
+                )
+        })
+        .filter(|piece| {
+            let piece = piece.to_ascii_lowercase();
+            !piece.is_empty() && !PLACEHOLDER_WORDS.iter().any(|word| word.contains(&piece))
+        })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::query::parse_query;
+
+    fn pieces(pattern: &str) -> Vec<&str> {
+        literal_pieces(pattern).collect()
+    }
+
+    fn may_match(query: &str, bytes: &str) -> Option<bool> {
+        FilePrescan::new(&parse_query(query).unwrap(), &SearchOptions::default())
+            .map(|prescan| prescan.may_match(bytes.as_bytes()))
+    }
+
+    #[test]
+    fn test_literal_pieces() {
+        assert_eq!(pieces("deadlock"), ["deadlock"]);
+        assert_eq!(pieces("src/main.rs"), ["src", "main", "rs"]);
+        assert_eq!(
+            pieces("Bash: cargo test --all"),
+            ["Bash", "cargo", "test", "all"]
+        );
+        assert_eq!(pieces("say \"hi\"\tthere"), ["say", "hi", "there"]);
+        // Placeholder text may not be in the file as written
+        assert!(pieces("[Tool Result: toolu_1]").contains(&"toolu_1"));
+        assert_eq!(pieces("JSON Value"), Vec::<&str>::new());
+        assert_eq!(pieces("Image"), Vec::<&str>::new());
+        assert_eq!(pieces("errors"), ["errors"]);
+    }
+
+    #[test]
+    fn test_gate() {
+        let line =
+            r#"{"type":"user","message":{"role":"user","content":"The Build failed\non CI"}}"#;
+        assert_eq!(may_match("build", line), Some(true));
+        assert_eq!(may_match("/build/", line), None);
+        assert_eq!(may_match("deploy", line), Some(false));
+        assert_eq!(may_match("\"failed on ci\"", line), Some(true));
+        assert_eq!(may_match("build AND deploy", line), Some(false));
+        assert_eq!(may_match("build AND /deploy/", line), Some(true));
+        assert_eq!(may_match("/deploy/ AND deploy", line), Some(false));
+        assert_eq!(may_match("deploy OR failed", line), Some(true));
+        assert_eq!(may_match("deploy OR /failed/", line), None);
+        assert_eq!(may_match("NOT deploy", line), None);
+        assert_eq!(may_match("\"\"", line), None);
+        // Non-ASCII text may be escaped in the file
+        assert_eq!(may_match("café", line), None);
+        assert_eq!(may_match("build AND café", line), Some(true));
+    }
+
+    #[test]
+    fn test_file_may_match() -> io::Result<()> {
+        let dir = tempfile::tempdir()?;
+        let with = dir.path().join("with.jsonl");
+        let without = dir.path().join("without.jsonl");
+        let compressed = dir.path().join("without.jsonl.gz");
+        std::fs::write(&with, "{\"content\":\"a deadlock\"}\n")?;
+        std::fs::write(&without, "{\"content\":\"a race\"}\n")?;
+        std::fs::write(&compressed, "{\"content\":\"a race\"}\n")?;
+
+        let prescan =
+            FilePrescan::new(&parse_query("deadlock").unwrap(), &SearchOptions::default()).unwrap();
+        assert!(prescan.file_may_match(&with)?);
+        assert!(!prescan.file_may_match(&without)?);
+        assert!(prescan.file_may_match(&compressed)?);
+        assert!(
+            prescan
+                .file_may_match(&dir.path().join("missing.jsonl"))
+                .is_err()
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_unknown_content_is_not_prescanned() {
+        let options = SearchOptions {
+            include_unknown: true,
+            ..Default::default()
+        };
+        assert!(FilePrescan::new(&parse_query("build").unwrap(), &options).is_none());
+    }
+
+    #[test]
+    fn test_pre_filter_can_be_turned_off() {
+        let options = SearchOptions {
+            pre_filter: false,
+            ..Default::default()
+        };
+        assert!(FilePrescan::new(&parse_query("build").unwrap(), &options).is_none());
+    }
+
+    #[test]
+    fn test_case_sensitive_gate() {
+        let prescan = FilePrescan::new(
+            &QueryCondition::Literal {
+                pattern: "Build".to_string(),
+                case_sensitive: true,
+            },
+            &SearchOptions::default(),
+        )
+        .unwrap();
+        assert!(prescan.may_match(b"The Build failed"));
+        assert!(!prescan.may_match(b"the build failed"));
+    }
+}
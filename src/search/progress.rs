@@ -0,0 +1,111 @@
+use std::io::{IsTerminal, Write};
+use std::sync::atomic::{AtomicU64, AtomicUsize, Ordering};
+use std::time::{Duration, Instant};
+
+// Minimum delay between two progress lines
+const REPORT_INTERVAL: Duration = Duration::from_millis(100);
+
+/// Throttled "processed N/M files, K matches" indicator written to stderr.
+///
+/// Workers call [`ProgressReporter::file_done`] concurrently; counters are atomic
+/// and only one caller per interval actually writes. Reporting is disabled when
+/// stderr is not a terminal.
+pub struct ProgressReporter {
+    enabled: bool,
+    total_files: usize,
+    processed_files: AtomicUsize,
+    matches: AtomicUsize,
+    start: Instant,
+    last_report_ms: AtomicU64,
+}
+
+impl ProgressReporter {
+    pub fn new(total_files: usize, requested: bool) -> Self {
+        Self::with_enabled(total_files, requested && std::io::stderr().is_terminal())
+    }
+
+    fn with_enabled(total_files: usize, enabled: bool) -> Self {
+        Self {
+            enabled,
+            total_files,
+            processed_files: AtomicUsize::new(0),
+            matches: AtomicUsize::new(0),
+            start: Instant::now(),
+            last_report_ms: AtomicU64::new(0),
+        }
+    }
+
+    /// Record a finished file and its match count, reporting if the interval elapsed
+    pub fn file_done(&self, matches: usize) {
+        let processed = self.processed_files.fetch_add(1, Ordering::Relaxed) + 1;
+        let total_matches = self.matches.fetch_add(matches, Ordering::Relaxed) + matches;
+        if !self.enabled {
+            return;
+        }
+
+        let now_ms = self.start.elapsed().as_millis() as u64;
+        let last_ms = self.last_report_ms.load(Ordering::Relaxed);
+        let is_last = processed == self.total_files;
+        if (is_last || now_ms.saturating_sub(last_ms) >= REPORT_INTERVAL.as_millis() as u64)
+            && self
+                .last_report_ms
+                .compare_exchange(last_ms, now_ms, Ordering::Relaxed, Ordering::Relaxed)
+                .is_ok()
+        {
+            let mut stderr = std::io::stderr().lock();
+            let _ = write!(stderr, "\r{}", self.status_line(processed, total_matches));
+            let _ = stderr.flush();
+        }
+    }
+
+    /// Clear the progress line so later output starts on a clean line
+    pub fn finish(&self) {
+        if self.enabled {
+            let mut stderr = std::io::stderr().lock();
+            let _ = write!(stderr, "\r\x1b[2K");
+            let _ = stderr.flush();
+        }
+    }
+
+    pub fn processed_files(&self) -> usize {
+        self.processed_files.load(Ordering::Relaxed)
+    }
+
+    pub fn matches(&self) -> usize {
+        self.matches.load(Ordering::Relaxed)
+    }
+
+    fn status_line(&self, processed: usize, matches: usize) -> String {
+        format!(
+            "processed {processed}/{} files, {matches} matches",
+            self.total_files
+        )
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_counters_accumulate_across_threads() {
+        let reporter = ProgressReporter::with_enabled(8, false);
+        std::thread::scope(|scope| {
+            for _ in 0..8 {
+                scope.spawn(|| reporter.file_done(3));
+            }
+        });
+
+        assert_eq!(reporter.processed_files(), 8);
+        assert_eq!(reporter.matches(), 24);
+    }
+
+    #[test]
+    fn test_status_line() {
+        let reporter = ProgressReporter::with_enabled(10, false);
+        assert_eq!(
+            reporter.status_line(4, 12),
+            "processed 4/10 files, 12 matches"
+        );
+    }
+}
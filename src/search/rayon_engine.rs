@@ -1,25 +1,64 @@
 use anyhow::Result;
 use chrono::DateTime;
 use crossbeam::channel;
-use std::fs::File;
-use std::io::{BufRead, BufReader};
+use rayon::prelude::*;
+use std::collections::HashMap;
+use std::io::BufRead;
 use std::path::Path;
-use std::sync::Arc;
+use std::sync::{Arc, Mutex};
 
+use super::cancel::CancelToken;
+use super::collector::{ResultCap, ResultCollector};
 use super::engine::SearchEngineTrait;
-use super::file_discovery::{discover_claude_files, expand_tilde};
+use super::file_discovery::{discover_claude_files_cached, exclude_files, expand_tilde};
+use super::prescan::FilePrescan;
+use super::progress::ProgressReporter;
+use super::skipped::SkipCounter;
+use super::summary_timestamps::SummaryTimestamps;
 use crate::interactive_ratatui::domain::models::SearchOrder;
 use crate::query::{QueryCondition, SearchOptions, SearchResult};
 use crate::schemas::SessionMessage;
+use crate::utils::compression::{self, UTF8_BOM};
+use crate::utils::line_reader::{self, BoundedLine};
+use crate::utils::mapped_file::MappedFile;
 use crate::utils::path_encoding;
 
+/// Plain files at least twice this size are split into runs of lines of about this
+/// size or more, one per worker, so a single huge session is not searched by one thread
+const CHUNK_BYTES: u64 = 64 * 1024 * 1024;
+
 pub struct RayonEngine {
     options: SearchOptions,
+    skipped: SkipCounter,
+    type_counts: Mutex<HashMap<String, usize>>,
+    cancel: CancelToken,
 }
 
 impl RayonEngine {
     pub fn new(options: SearchOptions) -> Self {
-        Self { options }
+        Self {
+            options,
+            skipped: SkipCounter::new(),
+            type_counts: Mutex::default(),
+            cancel: CancelToken::new(),
+        }
+    }
+
+    /// Stop searching once `cancel` is cancelled, returning what was found so far
+    pub fn with_cancel(mut self, cancel: CancelToken) -> Self {
+        self.cancel = cancel;
+        self
+    }
+
+    /// Matches per message type in the most recent search, including results
+    /// beyond `max_results`
+    pub fn type_counts(&self) -> HashMap<String, usize> {
+        self.type_counts.lock().unwrap().clone()
+    }
+
+    /// Lines and files skipped by the most recent search
+    pub fn skipped(&self) -> &SkipCounter {
+        &self.skipped
     }
 }
 
@@ -49,94 +88,129 @@ impl SearchEngineTrait for RayonEngine {
         order: SearchOrder,
     ) -> Result<(Vec<SearchResult>, std::time::Duration, usize)> {
         let start_time = std::time::Instant::now();
+        self.skipped.reset();
+        self.type_counts.lock().unwrap().clear();
 
         // Discover files
         let file_discovery_start = std::time::Instant::now();
         let expanded_pattern = expand_tilde(pattern);
-        let files = if expanded_pattern.is_file() {
+        let files = if let Some(files) = &self.options.files {
+            files.clone()
+        } else if expanded_pattern.is_file() {
             vec![expanded_pattern]
         } else {
-            discover_claude_files(Some(pattern))?
+            discover_claude_files_cached(Some(pattern), self.options.file_cache_ttl)?
         };
+        let files = exclude_files(files, &self.options.exclude)?;
         let file_discovery_time = file_discovery_start.elapsed();
 
-        if self.options.verbose {
-            eprintln!(
-                "File discovery took: {}ms ({} files found)",
-                file_discovery_time.as_millis(),
-                files.len()
-            );
-        }
+        tracing::info!(
+            "File discovery took: {}ms ({} files found)",
+            file_discovery_time.as_millis(),
+            files.len()
+        );
 
         if files.is_empty() {
             return Ok((Vec::new(), start_time.elapsed(), 0));
         }
 
-        // Channel for collecting results
-        let (sender, receiver) = channel::unbounded();
+        // Channel for collecting each file's results as soon as it is searched
+        let (sender, receiver) = channel::unbounded::<Vec<SearchResult>>();
 
         // Process files in parallel using Rayon
         let search_start = std::time::Instant::now();
 
         let query = Arc::new(query);
         let options = Arc::new(self.options.clone());
+        let progress = Arc::new(ProgressReporter::new(files.len(), self.options.progress));
+        let cap = ResultCap::new(if self.options.stop_early {
+            self.options.max_results
+        } else {
+            None
+        })
+        .with_cancel(self.cancel.clone());
+        let cap = &cap;
 
-        // Process files in parallel
-        rayon::scope(|s| {
-            for file_path in files {
-                let sender = sender.clone();
-                let query = query.clone();
-                let options = options.clone();
-
-                s.spawn(move |_| {
-                    if let Ok(results) = search_file(&file_path, &query, &options) {
-                        for result in results {
-                            let _ = sender.send(result);
-                        }
-                    }
-                });
-            }
-        });
-
-        // Drop the original sender so the receiver knows when all tasks are done
-        drop(sender);
+        let pool = self
+            .options
+            .workers
+            .map(|workers| rayon::ThreadPoolBuilder::new().num_threads(workers).build())
+            .transpose()?;
+        let prescan = FilePrescan::new(&query, &self.options);
+        let prescan = prescan.as_ref();
 
-        // Collect all results
-        let mut all_results = Vec::new();
-        while let Ok(result) = receiver.recv() {
-            all_results.push(result);
-        }
+        // Positions on disk for --order-stable, taken before the files are handed out
+        let file_order = self.options.order_stable.then(|| files.clone());
 
-        let search_time = search_start.elapsed();
+        let collector = std::thread::scope(|scope| {
+            // Filter and collect results while files are being searched, keeping
+            // only the capped set in memory
+            let collecting = scope.spawn(move || {
+                let mut collector = ResultCollector::new(self.options.max_results, order)
+                    .with_dedupe(self.options.dedupe)
+                    .with_per_file_limit(self.options.max_per_file)
+                    .with_file_order(file_order.as_deref());
+                while let Ok(mut results) = receiver.recv() {
+                    self.apply_filters(&mut results, role_filter.clone())?;
+                    collector.extend(results);
+                    cap.record(collector.total());
+                }
+                anyhow::Ok(collector)
+            });
 
-        // Apply filters
-        self.apply_filters(&mut all_results, role_filter)?;
+            // Process files in parallel, on a pool of their own when the worker
+            // count is set
+            let search_files = || {
+                rayon::scope(|s| {
+                    for file_path in files {
+                        let sender = sender.clone();
+                        let query = query.clone();
+                        let options = options.clone();
+                        let progress = progress.clone();
+                        let skipped = &self.skipped;
 
-        // Sort by timestamp
-        match order {
-            SearchOrder::Descending => {
-                all_results.sort_by(|a, b| b.timestamp.cmp(&a.timestamp));
-            }
-            SearchOrder::Ascending => {
-                all_results.sort_by(|a, b| a.timestamp.cmp(&b.timestamp));
+                        s.spawn(move |_| {
+                            let mut match_count = 0;
+                            match search_file(&file_path, &query, &options, skipped, cap, prescan) {
+                                Ok(results) => {
+                                    match_count = results.len();
+                                    let _ = sender.send(results);
+                                }
+                                Err(e) => {
+                                    skipped.skip_file();
+                                    tracing::info!("Skipping {file_path:?}: {e}");
+                                }
+                            }
+                            progress.file_done(match_count);
+                        });
+                    }
+                });
+            };
+            match pool {
+                Some(pool) => pool.install(search_files),
+                None => search_files(),
             }
-        }
 
-        let total_count = all_results.len();
+            // Drop the original sender so the collector knows when all tasks are done
+            drop(sender);
 
-        // Only truncate if max_results is specified
-        if let Some(limit) = self.options.max_results {
-            all_results.truncate(limit);
-        }
+            collecting.join().expect("result collector thread panicked")
+        });
+        progress.finish();
+
+        let search_time = search_start.elapsed();
+        let collector = collector?;
+        *self.type_counts.lock().unwrap() = collector.type_counts().clone();
+        let (all_results, total_count) = collector.finish();
 
         let elapsed = start_time.elapsed();
 
-        if self.options.verbose {
-            eprintln!("\nPerformance breakdown:");
-            eprintln!("  File discovery: {}ms", file_discovery_time.as_millis());
-            eprintln!("  Search: {}ms", search_time.as_millis());
-            eprintln!("  Total: {}ms", elapsed.as_millis());
-        }
+        tracing::info!(
+            "Performance breakdown: file discovery {}ms, search {}ms, total {}ms",
+            file_discovery_time.as_millis(),
+            search_time.as_millis(),
+            elapsed.as_millis()
+        );
 
         Ok((all_results, elapsed, total_count))
     }
@@ -193,12 +267,26 @@ pub(super) fn search_file(
     file_path: &Path,
     query: &QueryCondition,
     options: &SearchOptions,
+    skipped: &SkipCounter,
+    cap: &ResultCap,
+    prescan: Option<&FilePrescan>,
 ) -> Result<Vec<SearchResult>> {
-    let file = File::open(file_path)?;
-    let metadata = file.metadata()?;
-    // Use same buffer size as Smol for fair comparison
-    let mut reader = BufReader::with_capacity(64 * 1024, file);
-
+    if cap.reached() {
+        return Ok(Vec::new());
+    }
+    let metadata = std::fs::metadata(file_path)?;
+    if options
+        .max_file_size
+        .is_some_and(|limit| metadata.len() > limit)
+    {
+        skipped.skip_large_file(file_path);
+        return Ok(Vec::new());
+    }
+    if let Some(prescan) = prescan
+        && !prescan.file_may_match(file_path)?
+    {
+        return Ok(Vec::new());
+    }
     // Get file creation time for fallback
     // Use platform-specific approach like main branch
     let file_ctime = Some(&metadata)
@@ -220,31 +308,158 @@ pub(super) fn search_file(
                 chrono::DateTime::<chrono::Utc>::from_timestamp(duration.as_secs() as i64, 0)
                     .unwrap_or_else(chrono::Utc::now)
                     .to_rfc3339();
-            if options.verbose {
-                eprintln!("DEBUG: file_ctime for {file_path:?} = {ctime}");
-            }
+            tracing::trace!("file_ctime for {file_path:?} = {ctime}");
             ctime
         })
         .unwrap_or_else(|| {
             let now = chrono::Utc::now().to_rfc3339();
-            if options.verbose {
-                eprintln!("DEBUG: Using current time as fallback: {now}");
-            }
+            tracing::trace!("Using current time as fallback: {now}");
             now
         });
 
+    // One deadline for the whole file, however many runs it is searched in
+    let deadline = options
+        .file_timeout
+        .and_then(|timeout| std::time::Instant::now().checked_add(timeout));
+
+    // A file too large for one worker is cut into runs of whole lines searched side by side
+    let threads = rayon::current_num_threads();
+    if threads > 1
+        && !compression::is_compressed_path(file_path)
+        && metadata.len() >= 2 * CHUNK_BYTES
+    {
+        let mut mapped = MappedFile::open(file_path)?;
+        if mapped.fill_buf()?.starts_with(UTF8_BOM) {
+            mapped.consume(UTF8_BOM.len());
+        }
+        let bytes = mapped.remaining();
+        let parts = threads.min((bytes.len() as u64 / CHUNK_BYTES) as usize);
+        let ranges = line_reader::split_lines(bytes, parts);
+        let runs = ranges
+            .par_iter()
+            .map(|range| {
+                let mut reader = &bytes[range.clone()];
+                search_lines(
+                    &mut reader,
+                    file_path,
+                    query,
+                    options,
+                    skipped,
+                    cap,
+                    &file_ctime,
+                    deadline,
+                )
+            })
+            .collect::<Result<Vec<_>>>()?;
+        return Ok(join_runs(
+            runs,
+            file_path,
+            &file_ctime,
+            skipped,
+            |leaf, run| find_message_timestamp(&bytes[ranges[run].end..], leaf),
+        ));
+    }
+
+    // Same reader as Smol (memory-mapped for large files) for a fair comparison
+    let mut reader = compression::open_session_reader(file_path)?;
+    let run = search_lines(
+        &mut reader,
+        file_path,
+        query,
+        options,
+        skipped,
+        cap,
+        &file_ctime,
+        deadline,
+    )?;
+    Ok(join_runs(
+        vec![run],
+        file_path,
+        &file_ctime,
+        skipped,
+        |_, _| None,
+    ))
+}
+
+// What searching a run of consecutive lines found. Line numbers and timestamps
+// depend on the lines before, so runs are put back together by `join_runs`.
+struct LineRun {
+    results: Vec<SearchResult>,
+    lines: usize,
+    // Whether the first message parsed is a summary, if any message parsed
+    opens_with_summary: Option<bool>,
+    first_timestamp: Option<String>,
+    last_timestamp: Option<String>,
+    summary_timestamps: SummaryTimestamps,
+    // Lines to report, numbered within the run
+    notes: Vec<(usize, LineNote)>,
+    // Why the run ended before its last line, if it did
+    stopped: Option<String>,
+}
+
+// What is reported about a line that produced no result
+enum LineNote {
+    // Over the --max-line-bytes limit given
+    TooLong(usize),
+    NotMessage,
+    Unparseable(String),
+}
+
+// Search the lines of `reader`, numbering them from 1 and leaving summaries
+// without an earlier timestamp in the run with an empty one. Reading stops at
+// `deadline` (--file-timeout). Nothing is logged here, as only `join_runs`
+// knows the line numbers within the file.
+#[allow(clippy::too_many_arguments)]
+fn search_lines(
+    mut reader: impl BufRead,
+    file_path: &Path,
+    query: &QueryCondition,
+    options: &SearchOptions,
+    skipped: &SkipCounter,
+    cap: &ResultCap,
+    file_ctime: &str,
+    deadline: Option<std::time::Instant>,
+) -> Result<LineRun> {
     let mut results = Vec::with_capacity(256); // Same capacity as Smol
     let mut latest_timestamp: Option<String> = None;
     let mut first_timestamp: Option<String> = None;
+    let mut opens_with_summary = None;
     let mut line_buffer = Vec::with_capacity(16 * 1024); // Same buffer size as Smol
-    let mut is_first_line = true;
-    let mut found_summary_first = false;
+    let mut line_number = 0usize;
+    let mut summary_timestamps = SummaryTimestamps::new();
+    let mut notes = Vec::new();
+    let mut stopped = None;
 
     loop {
-        line_buffer.clear();
-        let bytes_read = reader.read_until(b'\n', &mut line_buffer)?;
-        if bytes_read == 0 {
-            break; // EOF
+        // Enough results collected elsewhere (--first)
+        if cap.reached() {
+            break;
+        }
+        if deadline.is_some_and(|deadline| std::time::Instant::now() >= deadline) {
+            stopped = Some("took longer than --file-timeout".to_string());
+            break;
+        }
+        // A read error (e.g. a truncated .gz) ends the file but keeps what it matched
+        let line = match line_reader::read_bounded_line(
+            &mut reader,
+            &mut line_buffer,
+            options.max_line_bytes,
+        ) {
+            Ok(line) => line,
+            Err(e) => {
+                stopped = Some(e.to_string());
+                break;
+            }
+        };
+        match line {
+            BoundedLine::Eof => break,
+            BoundedLine::Line => line_number += 1,
+            BoundedLine::TooLong => {
+                line_number += 1;
+                skipped.skip_line();
+                notes.push((line_number, LineNote::TooLong(options.max_line_bytes)));
+                continue;
+            }
         }
 
         // Skip empty lines
@@ -267,32 +482,23 @@ pub(super) fn search_file(
         match message {
             Ok(message) => {
                 // Check if first message is summary
-                if is_first_line {
-                    is_first_line = false;
-                    if message.get_type() == "summary" {
-                        found_summary_first = true;
-                        if options.verbose {
-                            eprintln!("DEBUG: Found summary at first line in {file_path:?}");
-                        }
-                    }
+                if opens_with_summary.is_none() {
+                    opens_with_summary = Some(message.get_type() == "summary");
                 }
 
                 // Update timestamps
                 if let Some(ts) = message.get_timestamp() {
                     latest_timestamp = Some(ts.to_string());
-                    // Track first timestamp after summary for summary messages
-                    if first_timestamp.is_none() && found_summary_first {
+                    if let Some(uuid) = message.get_uuid() {
+                        summary_timestamps.observe(uuid, ts);
+                    }
+                    if first_timestamp.is_none() {
                         first_timestamp = Some(ts.to_string());
-                        if options.verbose {
-                            eprintln!(
-                                "DEBUG: Found first timestamp '{ts}' after summary in {file_path:?}"
-                            );
-                        }
                     }
                 }
 
                 // Get searchable text
-                let text = message.get_searchable_text();
+                let text = options.searchable_text(&message);
 
                 // Apply query condition
                 if let Ok(matches) = query.evaluate(&text)
@@ -316,6 +522,38 @@ pub(super) fn search_file(
                         continue;
                     }
 
+                    if let Some(user_type) = &options.user_type
+                        && message.get_user_type() != Some(user_type)
+                    {
+                        continue;
+                    }
+
+                    if let Some(request_id) = &options.request_id
+                        && message.get_request_id() != Some(request_id)
+                    {
+                        continue;
+                    }
+
+                    if let Some(tool_use_id) = &options.tool_use_id
+                        && !message.references_tool_use(tool_use_id)
+                    {
+                        continue;
+                    }
+
+                    if options.exclude_meta && message.is_meta() {
+                        continue;
+                    }
+
+                    if options.tool_errors && !message.has_tool_error() {
+                        continue;
+                    }
+
+                    if options.filters_length()
+                        && !options.accepts_length(&options.content_text(&message))
+                    {
+                        continue;
+                    }
+
                     // Check project_path filter (matches against file path)
                     if let Some(project_path) = &options.project_path {
                         let file_path_str = file_path.to_string_lossy();
@@ -326,30 +564,27 @@ pub(super) fn search_file(
 
                     // Create result
                     let timestamp = if message.get_type() == "summary" {
-                        // Use first non-summary timestamp or file ctime
-                        first_timestamp
-                            .as_ref()
-                            .or(latest_timestamp.as_ref())
-                            .cloned()
-                            .unwrap_or_else(|| file_ctime.clone())
+                        // Resolved once the whole file is read
+                        summary_timestamps.want(message.get_uuid().unwrap_or(""));
+                        latest_timestamp.clone().unwrap_or_default()
                     } else {
                         message
                             .get_timestamp()
                             .map(|s| s.to_string())
-                            .unwrap_or_else(|| file_ctime.clone())
+                            .unwrap_or_else(|| file_ctime.to_string())
                     };
 
                     // For SessionViewer and message details, we need raw_json
-                    let raw_json = if options.session_id.is_some() || options.message_id.is_some() {
+                    let raw_json = if options.captures_raw_json() {
                         // Convert line_buffer to String for raw_json
                         Some(String::from_utf8_lossy(&line_buffer).to_string())
                     } else {
                         None
                     };
-                    results.push(SearchResult {
+                    let result = SearchResult {
                         timestamp,
                         role: message.get_type().to_string(),
-                        text,
+                        text: options.display_text(&message),
                         file: file_path.display().to_string(),
                         uuid: message.get_uuid().unwrap_or("").to_string(),
                         session_id: message.get_session_id().unwrap_or("").to_string(),
@@ -357,28 +592,348 @@ pub(super) fn search_file(
                         cwd: message.get_cwd().unwrap_or("").to_string(),
                         message_type: message.get_type().to_string(),
                         raw_json,
-                    });
+                        line_number: Some(line_number),
+                        request_id: message.get_request_id().map(str::to_string),
+                        parent_uuid: message.get_parent_uuid().map(str::to_string),
+                        is_sidechain: message.is_sidechain(),
+                    };
+                    results.extend(options.segment_results(&message, query, result));
+                }
+            }
+            Err(_) if SessionMessage::is_other_entry(&line_buffer) => {
+                if tracing::enabled!(tracing::Level::DEBUG) {
+                    notes.push((line_number, LineNote::NotMessage));
                 }
             }
             Err(e) => {
-                if options.verbose {
-                    eprintln!("Failed to parse JSON in {file_path:?}: {e}");
+                skipped.skip_unparseable_line();
+                if tracing::enabled!(tracing::Level::INFO) {
+                    notes.push((line_number, LineNote::Unparseable(e.to_string())));
                 }
-                // Continue processing other lines
             }
         }
     }
 
-    Ok(results)
+    Ok(LineRun {
+        results,
+        lines: line_number,
+        opens_with_summary,
+        first_timestamp,
+        last_timestamp: latest_timestamp,
+        summary_timestamps,
+        notes,
+        stopped,
+    })
+}
+
+// Results of consecutive runs of a file as if it had been searched in one go:
+// line numbers count from the start of the file and summaries get their
+// timestamps. `find_leaf(uuid, run)` gives the timestamp of a message after
+// the given run, for summaries whose leaf was not in their own run. The notes
+// of the runs are logged here, and a file cut short counts once in `skipped`
+// however many of its runs stopped early.
+fn join_runs(
+    runs: Vec<LineRun>,
+    file_path: &Path,
+    file_ctime: &str,
+    skipped: &SkipCounter,
+    find_leaf: impl Fn(&str, usize) -> Option<String>,
+) -> Vec<SearchResult> {
+    let mut results = Vec::new();
+    let mut summary_timestamps = SummaryTimestamps::new();
+    let mut lines_before = 0;
+    let mut opens_with_summary = None;
+    let mut first_timestamp = None;
+    let mut last_timestamp: Option<String> = None;
+    let mut cut_short = false;
+    for (index, run) in runs.into_iter().enumerate() {
+        for (line, note) in &run.notes {
+            let line = lines_before + line;
+            match note {
+                LineNote::TooLong(limit) => tracing::warn!(
+                    "Skipping line {line} of {file_path:?}: longer than {limit} bytes (see --max-line-bytes)"
+                ),
+                LineNote::NotMessage => {
+                    tracing::debug!("Skipping line {line} of {file_path:?}: not a message")
+                }
+                LineNote::Unparseable(e) => {
+                    tracing::info!("Failed to parse line {line} of {file_path:?}: {e}")
+                }
+            }
+        }
+        if let Some(reason) = &run.stopped
+            && !cut_short
+        {
+            cut_short = true;
+            skipped.skip_rest_of_file();
+            tracing::warn!(
+                "Stopped reading {file_path:?} after line {}: {reason}",
+                lines_before + run.lines
+            );
+        }
+        for mut result in run.results {
+            result.line_number = result.line_number.map(|line| line + lines_before);
+            if result.message_type == "summary" && result.timestamp.is_empty() {
+                result.timestamp = last_timestamp.clone().unwrap_or_default();
+            }
+            results.push(result);
+        }
+        for leaf in summary_timestamps.append(run.summary_timestamps) {
+            if let Some(timestamp) = find_leaf(&leaf, index) {
+                summary_timestamps.observe(&leaf, &timestamp);
+            }
+        }
+        lines_before += run.lines;
+        opens_with_summary = opens_with_summary.or(run.opens_with_summary);
+        first_timestamp = first_timestamp.or(run.first_timestamp);
+        last_timestamp = run.last_timestamp.or(last_timestamp);
+    }
+    // Only a summary opening the file falls back to the first timestamp after it
+    let first_after_summary = first_timestamp.filter(|_| opens_with_summary == Some(true));
+    summary_timestamps.resolve(&mut results, first_after_summary.as_deref(), file_ctime);
+    results
+}
+
+// Timestamp of the message with `uuid` in `bytes`, checking only lines that mention it
+fn find_message_timestamp(bytes: &[u8], uuid: &str) -> Option<String> {
+    let finder = memchr::memmem::Finder::new(uuid.as_bytes());
+    let mut start = 0;
+    while let Some(found) = finder.find(&bytes[start..]) {
+        let at = start + found;
+        let line_start = memchr::memrchr(b'\n', &bytes[..at]).map_or(0, |i| i + 1);
+        let line_end = memchr::memchr(b'\n', &bytes[at..]).map_or(bytes.len(), |i| at + i);
+        if let Ok(message) =
+            sonic_rs::from_slice::<SessionMessage>(bytes[line_start..line_end].trim_ascii())
+            && message.get_uuid() == Some(uuid)
+            && let Some(timestamp) = message.get_timestamp()
+        {
+            return Some(timestamp.to_string());
+        }
+        start = line_end;
+    }
+    None
 }
 
 #[cfg(test)]
 mod tests {
     use super::*;
     use crate::query::parse_query;
+    use std::fs::File;
     use std::io::Write;
     use tempfile::tempdir;
 
+    #[test]
+    fn test_total_count_is_exact_when_capped() -> Result<()> {
+        let temp_dir = tempdir()?;
+        // 3 files x 7 matching messages, plus non-matching and filtered-out ones
+        for file_index in 0..3 {
+            let mut file = File::create(temp_dir.path().join(format!("s{file_index}.jsonl")))?;
+            for i in 0..10 {
+                let (role, content) = match i {
+                    0..7 => ("user", "needle"),
+                    7 => ("assistant", "needle"),
+                    _ => ("user", "haystack"),
+                };
+                if role == "assistant" {
+                    writeln!(
+                        file,
+                        r#"{{"type":"assistant","message":{{"id":"m{i}","type":"message","role":"assistant","model":"claude","content":[{{"type":"text","text":"{content}"}}],"stop_reason":null,"stop_sequence":null,"usage":{{"input_tokens":1,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":1}}}},"uuid":"{file_index}-{i}","timestamp":"2024-01-01T00:00:0{i}Z","sessionId":"s{file_index}","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+                    )?;
+                } else {
+                    writeln!(
+                        file,
+                        r#"{{"type":"user","message":{{"role":"user","content":"{content}"}},"uuid":"{file_index}-{i}","timestamp":"2024-01-01T00:00:0{i}Z","sessionId":"s{file_index}","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+                    )?;
+                }
+            }
+        }
+
+        let options = SearchOptions {
+            max_results: Some(1),
+            role: Some("user".to_string()),
+            ..Default::default()
+        };
+        let engine = RayonEngine::new(options);
+        let (results, _, total_count) =
+            engine.search(temp_dir.path().to_str().unwrap(), parse_query("needle")?)?;
+
+        assert_eq!(results.len(), 1);
+        assert_eq!(total_count, 21);
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_stop_early_once_cap_is_reached() -> Result<()> {
+        let temp_dir = tempdir()?;
+        for file_index in 0..20 {
+            let mut file = File::create(temp_dir.path().join(format!("s{file_index}.jsonl")))?;
+            for i in 0..5 {
+                writeln!(
+                    file,
+                    r#"{{"type":"user","message":{{"role":"user","content":"needle {i}"}},"uuid":"{file_index}-{i}","timestamp":"2024-01-01T00:00:0{i}Z","sessionId":"s{file_index}","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+                )?;
+            }
+        }
+
+        let options = SearchOptions {
+            max_results: Some(3),
+            stop_early: true,
+            workers: Some(1),
+            ..Default::default()
+        };
+        let engine = RayonEngine::new(options.clone());
+        let (results, _, total_count) =
+            engine.search(temp_dir.path().to_str().unwrap(), parse_query("needle")?)?;
+        assert_eq!(results.len(), 3);
+        assert!((3..=100).contains(&total_count));
+
+        // A worker does not read a file once the cap is reached
+        let cap = ResultCap::new(Some(3));
+        cap.record(3);
+        let results = search_file(
+            &temp_dir.path().join("s0.jsonl"),
+            &parse_query("needle")?,
+            &options,
+            &SkipCounter::new(),
+            &cap,
+            None,
+        )?;
+        assert!(results.is_empty());
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_results_keep_their_own_file_when_uuids_collide() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let original = temp_dir.path().join("original.jsonl");
+        let resumed = temp_dir.path().join("resumed.jsonl");
+
+        // A resumed session repeats the earlier turn with the same uuid
+        for (path, session, text) in [
+            (&original, "s1", "shared turn in original"),
+            (&resumed, "s2", "shared turn in resumed"),
+        ] {
+            writeln!(
+                File::create(path)?,
+                r#"{{"type":"user","message":{{"role":"user","content":"{text}"}},"uuid":"same-uuid","timestamp":"2024-01-01T00:00:00Z","sessionId":"{session}","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            )?;
+        }
+
+        let engine = RayonEngine::new(SearchOptions::default());
+        let (results, _, _) =
+            engine.search(temp_dir.path().to_str().unwrap(), parse_query("shared")?)?;
+
+        assert_eq!(results.len(), 2);
+        for result in &results {
+            let (expected_file, expected_text) = if result.session_id == "s1" {
+                (&original, "original")
+            } else {
+                (&resumed, "resumed")
+            };
+            assert_eq!(result.file, expected_file.to_string_lossy());
+            assert!(result.text.contains(expected_text));
+        }
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_max_file_size_skips_large_files() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let small = temp_dir.path().join("small.jsonl");
+        let large = temp_dir.path().join("large.jsonl");
+
+        let line = r#"{"type":"user","message":{"role":"user","content":"needle"},"uuid":"UUID","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}"#;
+        std::fs::write(&small, line.replace("UUID", "small"))?;
+        std::fs::write(&large, vec![line.replace("UUID", "large"); 20].join("\n"))?;
+
+        let options = SearchOptions {
+            max_file_size: Some(1024),
+            ..Default::default()
+        };
+        let engine = RayonEngine::new(options);
+        let (results, _, _) =
+            engine.search(temp_dir.path().to_str().unwrap(), parse_query("needle")?)?;
+
+        assert_eq!(results.len(), 1);
+        assert_eq!(results[0].uuid, "small");
+        assert_eq!(
+            engine.skipped().large_files(),
+            vec![large.to_string_lossy().to_string()]
+        );
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_file_timeout_cuts_file_short() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("slow.jsonl");
+        let line = r#"{"type":"user","message":{"role":"user","content":"needle"},"uuid":"1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}"#;
+        std::fs::write(&test_file, vec![line; 3].join("\n"))?;
+
+        // Out of time before the first line
+        let options = SearchOptions {
+            file_timeout: Some(std::time::Duration::ZERO),
+            ..Default::default()
+        };
+        let engine = RayonEngine::new(options);
+        let (results, _, _) = engine.search(test_file.to_str().unwrap(), parse_query("needle")?)?;
+
+        assert!(results.is_empty());
+        assert_eq!(engine.skipped().partial_files(), 1);
+        assert_eq!(engine.skipped().files(), 0);
+        assert!(engine.skipped().summary().unwrap().contains("cut short"));
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_file_timeout_cuts_split_file_short_once() -> Result<()> {
+        let line = r#"{"type":"user","message":{"role":"user","content":"needle"},"uuid":"1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}"#;
+        let content = vec![line; 8].join("\n");
+        let bytes = content.as_bytes();
+        let query = parse_query("needle")?;
+        let options = SearchOptions::default();
+        let skipped = SkipCounter::default();
+        let cap = ResultCap::new(None);
+
+        // Every run shares the file's deadline, which has passed
+        let deadline = Some(std::time::Instant::now());
+        let ranges = line_reader::split_lines(bytes, 4);
+        assert_eq!(ranges.len(), 4);
+        let runs = ranges
+            .iter()
+            .map(|range| {
+                search_lines(
+                    &bytes[range.clone()],
+                    Path::new("/test.jsonl"),
+                    &query,
+                    &options,
+                    &skipped,
+                    &cap,
+                    "2024-02-01T00:00:00Z",
+                    deadline,
+                )
+            })
+            .collect::<Result<Vec<_>>>()?;
+        assert!(runs.iter().all(|run| run.stopped.is_some()));
+        let results = join_runs(
+            runs,
+            Path::new("/test.jsonl"),
+            "2024-02-01T00:00:00Z",
+            &skipped,
+            |_, _| None,
+        );
+
+        assert!(results.is_empty());
+        assert_eq!(skipped.partial_files(), 1);
+        assert!(skipped.summary().unwrap().contains("1 file(s) cut short"));
+        Ok(())
+    }
+
     #[test]
     fn test_search_engine() -> Result<()> {
         let temp_dir = tempdir()?;
@@ -439,4 +994,192 @@ mod tests {
 
         Ok(())
     }
+
+    #[test]
+    fn test_split_runs_match_whole_file() -> Result<()> {
+        let user = |uuid: &str, second: u32, text: &str| {
+            format!(
+                r#"{{"type":"user","message":{{"role":"user","content":"{text}"}},"uuid":"{uuid}","timestamp":"2024-01-01T00:00:{second:02}Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            )
+        };
+        let mut lines = vec![
+            r#"{"type":"summary","summary":"note opening","leafUuid":"u9"}"#.to_string(),
+            user("u1", 1, "note one"),
+            "not json".to_string(),
+            String::new(),
+        ];
+        for i in 2..=8 {
+            lines.push(user(&format!("u{i}"), i, "note"));
+        }
+        lines.push(r#"{"type":"summary","summary":"note later","leafUuid":"u1"}"#.to_string());
+        lines.push(user("u9", 9, "note last"));
+        lines.push(r#"{"type":"summary","summary":"note orphan","leafUuid":"gone"}"#.to_string());
+        let query = parse_query("note")?;
+        let options = SearchOptions::default();
+        let skipped = SkipCounter::default();
+        let cap = ResultCap::new(None);
+        let search = |bytes: &[u8]| {
+            search_lines(
+                bytes,
+                Path::new("/test.jsonl"),
+                &query,
+                &options,
+                &skipped,
+                &cap,
+                "2024-02-01T00:00:00Z",
+                None,
+            )
+        };
+        let summarize = |results: &[SearchResult]| {
+            results
+                .iter()
+                .map(|r| (r.uuid.clone(), r.timestamp.clone(), r.line_number))
+                .collect::<Vec<_>>()
+        };
+
+        let content = lines.join("\n");
+        let join = |runs, find_leaf: &dyn Fn(&str, usize) -> Option<String>| {
+            join_runs(
+                runs,
+                Path::new("/test.jsonl"),
+                "2024-02-01T00:00:00Z",
+                &skipped,
+                find_leaf,
+            )
+        };
+        let whole = join(vec![search(content.as_bytes())?], &|_, _| None);
+        assert_eq!(whole.len(), 12);
+        // The opening summary's leaf comes further on. The later summary's leaf came
+        // before it, so like the orphan it takes the first timestamp in the file
+        assert_eq!(whole[0].timestamp, "2024-01-01T00:00:09Z");
+        assert_eq!(whole[9].timestamp, "2024-01-01T00:00:01Z");
+        assert_eq!(whole[11].timestamp, "2024-01-01T00:00:01Z");
+
+        // Without an opening summary, summaries take the last timestamp before them
+        let unopened = lines[1..].join("\n");
+        let expected = join(vec![search(unopened.as_bytes())?], &|_, _| None);
+        assert_eq!(expected[8].timestamp, "2024-01-01T00:00:08Z");
+        assert_eq!(expected[10].timestamp, "2024-01-01T00:00:09Z");
+
+        for (content, whole) in [(&content, &whole), (&unopened, &expected)] {
+            let bytes = content.as_bytes();
+            for parts in 2..=6 {
+                let ranges = line_reader::split_lines(bytes, parts);
+                let runs = ranges
+                    .iter()
+                    .map(|range| search(&bytes[range.clone()]))
+                    .collect::<Result<Vec<_>>>()?;
+                let joined = join(runs, &|leaf, run| {
+                    find_message_timestamp(&bytes[ranges[run].end..], leaf)
+                });
+                assert_eq!(summarize(&joined), summarize(whole), "{parts} parts");
+            }
+        }
+        Ok(())
+    }
+
+    #[test]
+    fn test_user_type_filter() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        let mut file = File::create(&test_file)?;
+        writeln!(
+            file,
+            r#"{{"type":"summary","summary":"typed summary","leafUuid":"0"}}"#
+        )?;
+        writeln!(
+            file,
+            r#"{{"type":"user","message":{{"role":"user","content":"typed by hand"}},"uuid":"1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+        writeln!(
+            file,
+            r#"{{"type":"user","message":{{"role":"user","content":"typed by a hook"}},"uuid":"2","timestamp":"2024-01-01T00:00:01Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"internal","cwd":"/","version":"1"}}"#
+        )?;
+
+        let options = SearchOptions {
+            user_type: Some("external".to_string()),
+            ..Default::default()
+        };
+
+        let engine = RayonEngine::new(options);
+        let (results, _, total) =
+            engine.search(test_file.to_str().unwrap(), parse_query("typed")?)?;
+
+        assert_eq!(total, 1);
+        assert_eq!(results[0].uuid, "1");
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_tool_errors_filter() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        let mut file = File::create(&test_file)?;
+        for (uuid, timestamp, is_error) in [
+            ("u1", "2024-01-01T00:00:00Z", "true"),
+            ("u2", "2024-01-01T00:00:01Z", "false"),
+            ("u3", "2024-01-02T00:00:00Z", "true"),
+        ] {
+            writeln!(
+                file,
+                r#"{{"type":"user","message":{{"role":"user","content":[{{"type":"tool_result","tool_use_id":"toolu_{uuid}","content":"exit code 1","is_error":{is_error}}}]}},"uuid":"{uuid}","timestamp":"{timestamp}","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            )?;
+        }
+
+        // Combined with a time window, as when debugging one stretch of a session
+        let options = SearchOptions {
+            tool_errors: true,
+            before: Some("2024-01-01T12:00:00Z".to_string()),
+            ..Default::default()
+        };
+
+        let engine = RayonEngine::new(options);
+        let (results, _, total) =
+            engine.search(test_file.to_str().unwrap(), parse_query("exit")?)?;
+
+        assert_eq!(total, 1);
+        assert_eq!(results[0].uuid, "u1");
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_crlf_and_bom_file() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("windows.jsonl");
+
+        // As saved by a Windows editor: BOM first, CRLF after every line
+        let line = |uuid: &str, text: &str| {
+            format!(
+                r#"{{"type":"user","message":{{"role":"user","content":"{text}"}},"uuid":"{uuid}","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            )
+        };
+        let content = format!(
+            "\u{feff}{}\r\n{}\r\n",
+            line("u1", "first line fix"),
+            line("u2", "second fix")
+        );
+        std::fs::write(&test_file, content)?;
+
+        let engine = RayonEngine::new(SearchOptions {
+            session_id: Some("s1".to_string()),
+            ..Default::default()
+        });
+        let (results, _, total) =
+            engine.search(test_file.to_str().unwrap(), parse_query("fix")?)?;
+
+        assert_eq!(total, 2);
+        let mut uuids: Vec<&str> = results.iter().map(|r| r.uuid.as_str()).collect();
+        uuids.sort();
+        assert_eq!(uuids, vec!["u1", "u2"]);
+        for result in &results {
+            let raw_json = result.raw_json.as_deref().unwrap();
+            assert!(raw_json.starts_with('{') && raw_json.ends_with('}'));
+        }
+
+        Ok(())
+    }
 }
@@ -0,0 +1,250 @@
+use super::entry_store::JsonEntryStore;
+use crate::query::SearchResult;
+use anyhow::Result;
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+use std::fs;
+use std::path::PathBuf;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+/// On-disk cache of complete search results for `--cache-results`, so running
+/// the same search again (say, to try other output flags) skips the search.
+///
+/// Entries are keyed by the search (query, filters and where it looked) and
+/// hold the size and modification time of every file it covered. An entry is
+/// only used while those still match: a new, removed, appended or touched
+/// session file makes the next run search again and replace it. Only the
+/// entries used most recently are kept.
+pub struct ResultCache {
+    entries: JsonEntryStore,
+    max_entries: usize,
+}
+
+/// Entries kept by default; older ones are removed as new ones are stored
+pub const MAX_ENTRIES: usize = 100;
+
+/// Size and modification time of each searched file, cheap to collect and
+/// compare without reading any of them
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct CorpusStamp(Vec<(PathBuf, u64, Option<u128>)>);
+
+impl CorpusStamp {
+    /// Stamp of `files`; files that can't be read are stamped as missing
+    pub fn of(files: &[PathBuf]) -> Self {
+        let mut stamps: Vec<_> = files
+            .iter()
+            .map(|path| {
+                let metadata = fs::metadata(path).ok();
+                let len = metadata.as_ref().map_or(0, |m| m.len());
+                let modified = metadata
+                    .and_then(|m| m.modified().ok())
+                    .and_then(|time| time.duration_since(UNIX_EPOCH).ok())
+                    .map(|since| since.as_nanos());
+                (path.clone(), len, modified)
+            })
+            .collect();
+        stamps.sort();
+        Self(stamps)
+    }
+}
+
+/// What a search produced, as stored in and returned from the cache
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct CachedSearch {
+    pub results: Vec<SearchResult>,
+    pub total_count: usize,
+    pub type_counts: HashMap<String, usize>,
+    pub skip_warnings: Vec<String>,
+}
+
+#[derive(Debug, Serialize, Deserialize)]
+struct CacheEntry {
+    key: String,
+    corpus: CorpusStamp,
+    search: CachedSearch,
+}
+
+impl ResultCache {
+    pub fn new(dir: PathBuf) -> Self {
+        Self {
+            entries: JsonEntryStore::new(dir),
+            max_entries: MAX_ENTRIES,
+        }
+    }
+
+    /// Keep at most `max_entries` entries, removing those used least recently
+    pub fn with_max_entries(mut self, max_entries: usize) -> Self {
+        self.max_entries = max_entries;
+        self
+    }
+
+    /// Cache under the platform cache directory (e.g. `~/.cache/ccms/results`)
+    pub fn in_default_dir() -> Option<Self> {
+        let dir = dirs::cache_dir()?.join("ccms").join("results");
+        Some(Self::new(dir))
+    }
+
+    /// The stored results of the search `key`, if they were stored for exactly
+    /// this corpus
+    pub fn load(&self, key: &str, corpus: &CorpusStamp) -> Option<CachedSearch> {
+        let entry: CacheEntry = self.entries.read(key)?;
+        if entry.key != key || entry.corpus != *corpus {
+            return None;
+        }
+        // Entries are evicted by modification time, so a hit counts as a use
+        let entry_path = self.entries.entry_path(key);
+        if let Err(e) = fs::File::options()
+            .write(true)
+            .open(&entry_path)
+            .and_then(|file| file.set_modified(SystemTime::now()))
+        {
+            tracing::debug!("Failed to touch result cache entry {entry_path:?}: {e}");
+        }
+        Some(entry.search)
+    }
+
+    /// Store the results of the search `key` over `corpus`, replacing any
+    /// earlier entry for it
+    pub fn store(&self, key: &str, corpus: CorpusStamp, search: CachedSearch) -> Result<()> {
+        let entry = CacheEntry {
+            key: key.to_string(),
+            corpus,
+            search,
+        };
+        self.entries.write(key, &entry)?;
+        self.evict()
+    }
+
+    // Remove the entries used least recently beyond `max_entries`
+    fn evict(&self) -> Result<()> {
+        let mut entries: Vec<(SystemTime, PathBuf)> = fs::read_dir(self.entries.dir())?
+            .filter_map(|entry| {
+                let path = entry.ok()?.path();
+                let modified = fs::metadata(&path).and_then(|m| m.modified()).ok()?;
+                (path.extension()? == "json").then_some((modified, path))
+            })
+            .collect();
+        if entries.len() <= self.max_entries {
+            return Ok(());
+        }
+        entries.sort();
+        for (_, path) in &entries[..entries.len() - self.max_entries] {
+            // Another run may have removed it already
+            if let Err(e) = fs::remove_file(path) {
+                tracing::debug!("Failed to remove result cache entry {path:?}: {e}");
+            }
+        }
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::query::QueryCondition;
+    use std::io::Write;
+    use tempfile::tempdir;
+
+    fn search(text: &str) -> CachedSearch {
+        CachedSearch {
+            results: vec![SearchResult {
+                file: "/projects/session.jsonl".to_string(),
+                uuid: "uuid-1".to_string(),
+                timestamp: "2024-01-01T00:00:00Z".to_string(),
+                session_id: "session1".to_string(),
+                role: "user".to_string(),
+                text: text.to_string(),
+                message_type: "user".to_string(),
+                query: QueryCondition::Literal {
+                    pattern: "hello".to_string(),
+                    case_sensitive: false,
+                },
+                cwd: "/test".to_string(),
+                raw_json: None,
+                line_number: Some(1),
+                request_id: None,
+                parent_uuid: None,
+                is_sidechain: false,
+            }],
+            total_count: 1,
+            type_counts: HashMap::from([("user".to_string(), 1)]),
+            skip_warnings: Vec::new(),
+        }
+    }
+
+    #[test]
+    fn test_result_cache_round_trip() -> Result<()> {
+        let temp = tempdir()?;
+        let session = temp.path().join("session.jsonl");
+        fs::write(&session, "{}\n")?;
+        let files = vec![session.clone()];
+        let cache = ResultCache::new(temp.path().join("results"));
+
+        assert_eq!(cache.load("hello", &CorpusStamp::of(&files)), None);
+        cache.store("hello", CorpusStamp::of(&files), search("hello world"))?;
+        assert_eq!(
+            cache.load("hello", &CorpusStamp::of(&files)),
+            Some(search("hello world"))
+        );
+        // Other searches have their own entries
+        assert_eq!(
+            cache.load("hello AND world", &CorpusStamp::of(&files)),
+            None
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_changed_corpus_misses() -> Result<()> {
+        let temp = tempdir()?;
+        let session = temp.path().join("session.jsonl");
+        fs::write(&session, "{}\n")?;
+        let mut files = vec![session.clone()];
+        let cache = ResultCache::new(temp.path().join("results"));
+        cache.store("hello", CorpusStamp::of(&files), search("hello world"))?;
+
+        // Appending a message changes the file's size
+        fs::OpenOptions::new()
+            .append(true)
+            .open(&session)?
+            .write_all(b"{}\n")?;
+        assert_eq!(cache.load("hello", &CorpusStamp::of(&files)), None);
+
+        cache.store("hello", CorpusStamp::of(&files), search("hello again"))?;
+        assert!(cache.load("hello", &CorpusStamp::of(&files)).is_some());
+
+        // So does a new session file
+        let other = temp.path().join("other.jsonl");
+        fs::write(&other, "{}\n")?;
+        files.push(other);
+        assert_eq!(cache.load("hello", &CorpusStamp::of(&files)), None);
+        Ok(())
+    }
+
+    #[test]
+    fn test_least_recently_used_entries_are_evicted() -> Result<()> {
+        let temp = tempdir()?;
+        let files: Vec<PathBuf> = Vec::new();
+        let cache = ResultCache::new(temp.path().join("results")).with_max_entries(2);
+        let age = |key: &str, seconds: u64| -> Result<()> {
+            let file = fs::File::options()
+                .write(true)
+                .open(cache.entries.entry_path(key))?;
+            file.set_modified(UNIX_EPOCH + std::time::Duration::from_secs(seconds))?;
+            Ok(())
+        };
+        cache.store("first", CorpusStamp::of(&files), search("first"))?;
+        cache.store("second", CorpusStamp::of(&files), search("second"))?;
+        age("first", 1)?;
+        age("second", 2)?;
+
+        // Reading "first" makes "second" the least recently used
+        assert!(cache.load("first", &CorpusStamp::of(&files)).is_some());
+        cache.store("third", CorpusStamp::of(&files), search("third"))?;
+        assert!(cache.load("first", &CorpusStamp::of(&files)).is_some());
+        assert_eq!(cache.load("second", &CorpusStamp::of(&files)), None);
+        assert!(cache.load("third", &CorpusStamp::of(&files)).is_some());
+        assert_eq!(fs::read_dir(temp.path().join("results"))?.count(), 2);
+        Ok(())
+    }
+}
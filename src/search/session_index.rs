@@ -0,0 +1,172 @@
+use crate::schemas::SessionMessage;
+use crate::session::{MessageOrder, load_session_from_files, order_messages};
+use crate::utils::{compression, line_reader};
+use anyhow::{Context, Result};
+use serde::Deserialize;
+use std::collections::{HashMap, HashSet};
+use std::path::{Path, PathBuf};
+
+/// Which files hold messages of which session.
+///
+/// A resumed conversation continues in a new file, usually after a copy of the
+/// earlier messages, and one file can hold several sessions, so files are
+/// indexed by the `sessionId` of every message they contain.
+#[derive(Debug, Default)]
+pub struct SessionIndex {
+    files: HashMap<String, Vec<PathBuf>>,
+    // Session IDs in order of first appearance
+    session_ids: Vec<String>,
+    // Longer lines are skipped, when indexing and when loading sessions
+    max_line_bytes: usize,
+}
+
+// Just the field the index needs from each line
+#[derive(Deserialize)]
+struct SessionLine {
+    #[serde(rename = "sessionId")]
+    session_id: Option<String>,
+}
+
+impl SessionIndex {
+    pub fn build(files: &[PathBuf], max_line_bytes: usize) -> Result<Self> {
+        let mut index = Self {
+            max_line_bytes,
+            ..Self::default()
+        };
+        for file in files {
+            for session_id in session_ids_in(file, max_line_bytes)? {
+                let files = index.files.entry(session_id).or_insert_with_key(|id| {
+                    index.session_ids.push(id.clone());
+                    Vec::new()
+                });
+                files.push(file.clone());
+            }
+        }
+        Ok(index)
+    }
+
+    /// Every indexed session ID, in order of first appearance
+    pub fn session_ids(&self) -> &[String] {
+        &self.session_ids
+    }
+
+    /// Files holding messages of `session_id`, in the order they were given
+    pub fn files(&self, session_id: &str) -> &[PathBuf] {
+        self.files.get(session_id).map(Vec::as_slice).unwrap_or(&[])
+    }
+
+    /// The messages of `session_id` from all of its files as one conversation:
+    /// messages copied into a resumed file appear once, everything is ordered
+    /// by timestamp, and summaries of the session come first
+    pub fn load(&self, session_id: &str) -> Result<Vec<SessionMessage>> {
+        let mut messages =
+            load_session_from_files(self.files(session_id), session_id, self.max_line_bytes)?;
+        // A summary's uuid is the leaf it summarizes, so summaries are told apart by text too
+        let mut seen = HashSet::new();
+        messages.retain(|message| {
+            let text = match message {
+                SessionMessage::Summary { .. } => message.get_content_text(),
+                _ => String::new(),
+            };
+            seen.insert((message.get_uuid().unwrap_or("").to_string(), text))
+        });
+        Ok(order_messages(messages, MessageOrder::Time))
+    }
+}
+
+// Distinct session IDs of the messages in `path`, in file order
+fn session_ids_in(path: &Path, max_line_bytes: usize) -> Result<Vec<String>> {
+    let mut reader = compression::open_session_reader(path)
+        .with_context(|| format!("failed to open file: {}", path.display()))?;
+    let mut line_buffer = Vec::with_capacity(16 * 1024);
+    let mut line_number = 0;
+    let mut session_ids = Vec::new();
+    while line_reader::read_line_within(
+        &mut reader,
+        &mut line_buffer,
+        max_line_bytes,
+        path,
+        &mut line_number,
+    )
+    .with_context(|| format!("failed to read line from {}", path.display()))?
+    {
+        if !line_buffer
+            .windows(b"sessionId".len())
+            .any(|w| w == b"sessionId")
+        {
+            continue;
+        }
+        let Ok(SessionLine {
+            session_id: Some(session_id),
+        }) = sonic_rs::from_slice(line_buffer.trim_ascii())
+        else {
+            continue;
+        };
+        if !session_ids.contains(&session_id) {
+            session_ids.push(session_id);
+        }
+    }
+    Ok(session_ids)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_fixtures;
+    use crate::utils::line_reader::MAX_LINE_BYTES;
+    use tempfile::tempdir;
+
+    fn user(uuid: &str, session_id: &str, timestamp: &str) -> String {
+        test_fixtures::user(uuid)
+            .session(session_id)
+            .timestamp(timestamp)
+            .text(uuid)
+            .to_string()
+    }
+
+    #[test]
+    fn test_resumed_session_is_stitched_together() -> Result<()> {
+        let dir = tempdir()?;
+        let first = dir.path().join("first.jsonl");
+        let resumed = dir.path().join("resumed.jsonl");
+        let other = dir.path().join("other.jsonl");
+        std::fs::write(
+            &first,
+            [
+                user("u1", "s1", "2024-01-01T00:00:01Z"),
+                user("x1", "s2", "2024-01-01T00:00:02Z"),
+                user("u2", "s1", "2024-01-01T00:00:03Z"),
+            ]
+            .join("\n"),
+        )?;
+        // The resumed file repeats the start of the conversation
+        std::fs::write(
+            &resumed,
+            [
+                r#"{"type":"summary","summary":"Topic","leafUuid":"u2"}"#.to_string(),
+                user("u1", "s1", "2024-01-01T00:00:01Z"),
+                user("u2", "s1", "2024-01-01T00:00:03Z"),
+                user("u3", "s1", "2024-01-02T00:00:00Z"),
+            ]
+            .join("\n"),
+        )?;
+        std::fs::write(&other, user("y1", "s3", "2024-01-03T00:00:00Z"))?;
+
+        let index = SessionIndex::build(
+            &[first.clone(), resumed.clone(), other.clone()],
+            MAX_LINE_BYTES,
+        )?;
+        assert_eq!(index.session_ids(), ["s1", "s2", "s3"]);
+        assert_eq!(index.files("s1"), [first.clone(), resumed]);
+        assert_eq!(index.files("s2"), [first]);
+        assert!(index.files("missing").is_empty());
+
+        let messages = index.load("s1")?;
+        let uuids: Vec<_> = messages.iter().filter_map(|m| m.get_uuid()).collect();
+        assert_eq!(uuids, ["u2", "u1", "u2", "u3"]);
+        assert_eq!(messages[0].get_type(), "summary");
+        assert_eq!(index.load("s2")?.len(), 1);
+        assert!(index.load("missing").is_err());
+        Ok(())
+    }
+}
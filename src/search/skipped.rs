@@ -0,0 +1,173 @@
+use std::path::Path;
+use std::sync::Mutex;
+use std::sync::atomic::{AtomicUsize, Ordering};
+
+/// Counts input that a search had to skip (oversized lines, unreadable files,
+/// files cut short by a read error or `--file-timeout`, files over
+/// `--max-filesize`, lines that are not valid messages), so callers can warn
+/// that the results may be partial.
+#[derive(Debug, Default)]
+pub struct SkipCounter {
+    lines: AtomicUsize,
+    parse_errors: AtomicUsize,
+    files: AtomicUsize,
+    partial_files: AtomicUsize,
+    large_files: Mutex<Vec<String>>,
+}
+
+impl SkipCounter {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn skip_line(&self) {
+        self.lines.fetch_add(1, Ordering::Relaxed);
+    }
+
+    pub fn skip_unparseable_line(&self) {
+        self.parse_errors.fetch_add(1, Ordering::Relaxed);
+    }
+
+    pub fn skip_file(&self) {
+        self.files.fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// A file whose reading stopped partway; what it matched so far is kept
+    pub fn skip_rest_of_file(&self) {
+        self.partial_files.fetch_add(1, Ordering::Relaxed);
+    }
+
+    pub fn skip_large_file(&self, path: &Path) {
+        self.large_files
+            .lock()
+            .unwrap()
+            .push(path.to_string_lossy().to_string());
+    }
+
+    pub fn reset(&self) {
+        self.lines.store(0, Ordering::Relaxed);
+        self.parse_errors.store(0, Ordering::Relaxed);
+        self.files.store(0, Ordering::Relaxed);
+        self.partial_files.store(0, Ordering::Relaxed);
+        self.large_files.lock().unwrap().clear();
+    }
+
+    pub fn lines(&self) -> usize {
+        self.lines.load(Ordering::Relaxed)
+    }
+
+    pub fn parse_errors(&self) -> usize {
+        self.parse_errors.load(Ordering::Relaxed)
+    }
+
+    pub fn files(&self) -> usize {
+        self.files.load(Ordering::Relaxed)
+    }
+
+    pub fn partial_files(&self) -> usize {
+        self.partial_files.load(Ordering::Relaxed)
+    }
+
+    /// Files skipped for exceeding the size limit, sorted by path
+    pub fn large_files(&self) -> Vec<String> {
+        let mut files = self.large_files.lock().unwrap().clone();
+        files.sort();
+        files
+    }
+
+    /// One-line warning for the output footer, or `None` if nothing was skipped
+    pub fn summary(&self) -> Option<String> {
+        let counts = [
+            (self.lines(), "line(s) over --max-line-bytes"),
+            (self.files(), "unreadable file(s)"),
+            (
+                self.partial_files(),
+                "file(s) cut short by a read error or --file-timeout",
+            ),
+            (
+                self.large_files.lock().unwrap().len(),
+                "file(s) over --max-filesize",
+            ),
+        ];
+        let parts: Vec<String> = counts
+            .iter()
+            .filter(|(count, _)| *count > 0)
+            .map(|(count, what)| format!("{count} {what}"))
+            .collect();
+
+        let skipped = match parts.split_last() {
+            None => return None,
+            Some((last, [])) => last.clone(),
+            Some((last, rest)) => format!("{} and {last}", rest.join(", ")),
+        };
+        Some(format!("Skipped {skipped}; results may be partial"))
+    }
+
+    /// Warning about lines that failed to parse, or `None` if every line parsed
+    pub fn parse_error_summary(&self) -> Option<String> {
+        match self.parse_errors() {
+            0 => None,
+            count => Some(format!(
+                "{count} line(s) skipped due to parse errors; run with -v to see them"
+            )),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_summary() {
+        let counter = SkipCounter::new();
+        assert_eq!(counter.summary(), None);
+
+        counter.skip_line();
+        counter.skip_line();
+        counter.skip_file();
+        assert_eq!(
+            counter.summary().as_deref(),
+            Some(
+                "Skipped 2 line(s) over --max-line-bytes and 1 unreadable file(s); results may be partial"
+            )
+        );
+
+        counter.reset();
+        assert_eq!(counter.summary(), None);
+    }
+
+    #[test]
+    fn test_parse_errors_are_reported_separately() {
+        let counter = SkipCounter::new();
+        assert_eq!(counter.parse_error_summary(), None);
+
+        counter.skip_unparseable_line();
+        counter.skip_unparseable_line();
+        assert_eq!(counter.parse_errors(), 2);
+        assert_eq!(counter.summary(), None);
+        assert_eq!(
+            counter.parse_error_summary().as_deref(),
+            Some("2 line(s) skipped due to parse errors; run with -v to see them")
+        );
+
+        counter.reset();
+        assert_eq!(counter.parse_error_summary(), None);
+    }
+
+    #[test]
+    fn test_large_files_are_listed() {
+        let counter = SkipCounter::new();
+        counter.skip_large_file(Path::new("/b.jsonl"));
+        counter.skip_large_file(Path::new("/a.jsonl"));
+        counter.skip_line();
+
+        assert_eq!(counter.large_files(), vec!["/a.jsonl", "/b.jsonl"]);
+        assert_eq!(
+            counter.summary().as_deref(),
+            Some(
+                "Skipped 1 line(s) over --max-line-bytes and 2 file(s) over --max-filesize; results may be partial"
+            )
+        );
+    }
+}
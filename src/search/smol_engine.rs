@@ -1,50 +1,82 @@
 use anyhow::Result;
 use chrono::DateTime;
 use smol::channel;
-use std::fs::File;
-use std::io::{BufRead, BufReader};
+use std::collections::HashMap;
 use std::path::Path;
-use std::sync::Arc;
+use std::sync::{Arc, Mutex};
 
+use super::cancel::CancelToken;
+use super::collector::{ResultCap, ResultCollector};
 use super::engine::SearchEngineTrait;
-use super::file_discovery::{discover_claude_files, expand_tilde};
+use super::file_discovery::{discover_claude_files_cached, exclude_files, expand_tilde};
+use super::prescan::FilePrescan;
+use super::progress::ProgressReporter;
+use super::skipped::SkipCounter;
+use super::summary_timestamps::SummaryTimestamps;
 use crate::interactive_ratatui::domain::models::SearchOrder;
 use crate::query::{QueryCondition, SearchOptions, SearchResult};
 use crate::schemas::SessionMessage;
-use crate::utils::path_encoding;
+use crate::utils::line_reader::{self, BoundedLine};
+use crate::utils::{compression, path_encoding};
 
 // Initialize blocking thread pool optimization
 static INIT: std::sync::Once = std::sync::Once::new();
 
-fn initialize_blocking_threads(verbose: bool) {
+// The blocking pool reads BLOCKING_MAX_THREADS once, so the first engine
+// created sizes it for the whole process; later engines with fewer workers are
+// held to their count by a semaphore instead
+fn initialize_blocking_threads(workers: Option<usize>) {
     INIT.call_once(|| {
         // Only set if not already set by user
         if std::env::var("BLOCKING_MAX_THREADS").is_err() {
-            let cpu_count = num_cpus::get();
+            let thread_count = workers.unwrap_or_else(num_cpus::get);
             unsafe {
-                std::env::set_var("BLOCKING_MAX_THREADS", cpu_count.to_string());
-            }
-            if verbose {
-                eprintln!("Optimized BLOCKING_MAX_THREADS to {cpu_count} (CPU count)");
+                std::env::set_var("BLOCKING_MAX_THREADS", thread_count.to_string());
             }
+            tracing::debug!("Optimized BLOCKING_MAX_THREADS to {thread_count}");
         }
     });
 }
 
 pub struct SmolEngine {
     options: SearchOptions,
+    skipped: Arc<SkipCounter>,
+    type_counts: Mutex<HashMap<String, usize>>,
+    cancel: CancelToken,
 }
 
 impl SmolEngine {
     pub fn new(options: SearchOptions) -> Self {
         // Initialize blocking threads optimization on first use
-        initialize_blocking_threads(options.verbose);
-        Self { options }
+        initialize_blocking_threads(options.workers);
+        Self {
+            options,
+            skipped: Arc::new(SkipCounter::new()),
+            type_counts: Mutex::default(),
+            cancel: CancelToken::new(),
+        }
+    }
+
+    /// Stop searching once `cancel` is cancelled, returning what was found so far
+    pub fn with_cancel(mut self, cancel: CancelToken) -> Self {
+        self.cancel = cancel;
+        self
     }
 
     pub fn get_options(&self) -> &SearchOptions {
         &self.options
     }
+
+    /// Matches per message type in the most recent search, including results
+    /// beyond `max_results`
+    pub fn type_counts(&self) -> HashMap<String, usize> {
+        self.type_counts.lock().unwrap().clone()
+    }
+
+    /// Lines and files skipped by the most recent search
+    pub fn skipped(&self) -> &SkipCounter {
+        &self.skipped
+    }
 }
 
 impl SearchEngineTrait for SmolEngine {
@@ -86,37 +118,57 @@ impl SmolEngine {
         order: SearchOrder,
     ) -> Result<(Vec<SearchResult>, std::time::Duration, usize)> {
         let start_time = std::time::Instant::now();
+        self.skipped.reset();
+        self.type_counts.lock().unwrap().clear();
 
         // Discover files
         let file_discovery_start = std::time::Instant::now();
         let expanded_pattern = expand_tilde(pattern);
-        let files = if expanded_pattern.is_file() {
+        let files = if let Some(files) = &self.options.files {
+            files.clone()
+        } else if expanded_pattern.is_file() {
             vec![expanded_pattern]
         } else {
-            discover_claude_files(Some(pattern))?
+            discover_claude_files_cached(Some(pattern), self.options.file_cache_ttl)?
         };
+        let files = exclude_files(files, &self.options.exclude)?;
         let file_discovery_time = file_discovery_start.elapsed();
 
-        if self.options.verbose {
-            eprintln!(
-                "File discovery took: {}ms ({} files found)",
-                file_discovery_time.as_millis(),
-                files.len()
-            );
-        }
+        tracing::info!(
+            "File discovery took: {}ms ({} files found)",
+            file_discovery_time.as_millis(),
+            files.len()
+        );
 
         if files.is_empty() {
             return Ok((Vec::new(), start_time.elapsed(), 0));
         }
 
-        // Channel for collecting results
-        let (sender, receiver) = channel::unbounded();
+        // Channel for collecting each file's results as soon as it is searched
+        let (sender, receiver) = channel::unbounded::<Vec<SearchResult>>();
 
         // Process files concurrently using multi-threaded executor
         let search_start = std::time::Instant::now();
 
+        let prescan = FilePrescan::new(&query, &self.options).map(Arc::new);
         let query = Arc::new(query);
         let options = Arc::new(self.options.clone());
+        let progress = Arc::new(ProgressReporter::new(files.len(), self.options.progress));
+        let cap = Arc::new(
+            ResultCap::new(if self.options.stop_early {
+                self.options.max_results
+            } else {
+                None
+            })
+            .with_cancel(self.cancel.clone()),
+        );
+        let permits = self
+            .options
+            .workers
+            .map(|workers| Arc::new(smol::lock::Semaphore::new(workers)));
+
+        // Positions on disk for --order-stable, taken before the files are handed out
+        let file_order = self.options.order_stable.then(|| files.clone());
 
         // Spawn tasks for each file on the global executor
         let mut tasks = Vec::new();
@@ -124,13 +176,31 @@ impl SmolEngine {
             let sender = sender.clone();
             let query = query.clone();
             let options = options.clone();
+            let progress = progress.clone();
+            let skipped = self.skipped.clone();
+            let permits = permits.clone();
+            let cap = cap.clone();
+            let prescan = prescan.clone();
 
             let task = smol::spawn(async move {
-                if let Ok(results) = search_file(&file_path, &query, &options).await {
-                    for result in results {
-                        let _ = sender.send(result).await;
+                // Held while the file is searched, limiting files in flight
+                let _permit = match &permits {
+                    Some(permits) => Some(permits.acquire_arc().await),
+                    None => None,
+                };
+                let mut match_count = 0;
+                match search_file(&file_path, &query, &options, skipped.clone(), cap, prescan).await
+                {
+                    Ok(results) => {
+                        match_count = results.len();
+                        let _ = sender.send(results).await;
+                    }
+                    Err(e) => {
+                        skipped.skip_file();
+                        tracing::info!("Skipping {file_path:?}: {e}");
                     }
                 }
+                progress.file_done(match_count);
             });
             tasks.push(task);
         }
@@ -145,48 +215,37 @@ impl SmolEngine {
             }
         };
 
-        // Collect results while processing
+        // Filter and collect results while processing, keeping only the capped set
         let collect_future = async {
-            let mut all_results = Vec::new();
-            while let Ok(result) = receiver.recv().await {
-                all_results.push(result);
+            let mut collector = ResultCollector::new(self.options.max_results, order)
+                .with_dedupe(self.options.dedupe)
+                .with_per_file_limit(self.options.max_per_file)
+                .with_file_order(file_order.as_deref());
+            while let Ok(mut results) = receiver.recv().await {
+                self.apply_filters(&mut results, role_filter.clone())?;
+                collector.extend(results);
+                cap.record(collector.total());
             }
-            all_results
+            anyhow::Ok(collector)
         };
 
         // Run search and collection concurrently
-        let (_, mut all_results) = futures_lite::future::zip(search_future, collect_future).await;
+        let (_, collector) = futures_lite::future::zip(search_future, collect_future).await;
+        progress.finish();
 
         let search_time = search_start.elapsed();
-
-        // Apply filters
-        self.apply_filters(&mut all_results, role_filter)?;
-
-        // Sort by timestamp
-        match order {
-            SearchOrder::Descending => {
-                all_results.sort_by(|a, b| b.timestamp.cmp(&a.timestamp));
-            }
-            SearchOrder::Ascending => {
-                all_results.sort_by(|a, b| a.timestamp.cmp(&b.timestamp));
-            }
-        }
-
-        let total_count = all_results.len();
-
-        // Only truncate if max_results is specified
-        if let Some(limit) = self.options.max_results {
-            all_results.truncate(limit);
-        }
+        let collector = collector?;
+        *self.type_counts.lock().unwrap() = collector.type_counts().clone();
+        let (all_results, total_count) = collector.finish();
 
         let elapsed = start_time.elapsed();
 
-        if self.options.verbose {
-            eprintln!("\nPerformance breakdown:");
-            eprintln!("  File discovery: {}ms", file_discovery_time.as_millis());
-            eprintln!("  Search: {}ms", search_time.as_millis());
-            eprintln!("  Total: {}ms", elapsed.as_millis());
-        }
+        tracing::info!(
+            "Performance breakdown: file discovery {}ms, search {}ms, total {}ms",
+            file_discovery_time.as_millis(),
+            search_time.as_millis(),
+            elapsed.as_millis()
+        );
 
         Ok((all_results, elapsed, total_count))
     }
@@ -241,13 +300,18 @@ async fn search_file(
     file_path: &Path,
     query: &QueryCondition,
     options: &SearchOptions,
+    skipped: Arc<SkipCounter>,
+    cap: Arc<ResultCap>,
+    prescan: Option<Arc<FilePrescan>>,
 ) -> Result<Vec<SearchResult>> {
+    if cap.reached() {
+        return Ok(Vec::new());
+    }
     let file_path_owned = file_path.to_owned();
     let file_path_str = file_path_owned.to_string_lossy().to_string();
     let query_owned = query.clone();
     let options_owned = options.clone();
-    let should_capture_raw_json =
-        options_owned.session_id.is_some() || options_owned.message_id.is_some();
+    let should_capture_raw_json = options_owned.captures_raw_json();
 
     if let Some(project_path) = &options_owned.project_path
         && !path_encoding::file_belongs_to_project(&file_path_str, project_path)
@@ -257,10 +321,20 @@ async fn search_file(
 
     // Use smol's blocking executor with larger buffer for better throughput
     blocking::unblock(move || {
-        let file = File::open(&file_path_owned)?;
-        let metadata = file.metadata()?;
-        // Increase buffer size for better I/O performance
-        let mut reader = BufReader::with_capacity(64 * 1024, file); // Changed to 64KB like basic Smol
+        let metadata = std::fs::metadata(&file_path_owned)?;
+        if options_owned
+            .max_file_size
+            .is_some_and(|limit| metadata.len() > limit)
+        {
+            skipped.skip_large_file(&file_path_owned);
+            return Ok(Vec::new());
+        }
+        if let Some(prescan) = &prescan
+            && !prescan.file_may_match(&file_path_owned)?
+        {
+            return Ok(Vec::new());
+        }
+        let mut reader = compression::open_session_reader(&file_path_owned)?;
 
         // Get file creation time for fallback
         // Use platform-specific approach like main branch
@@ -283,16 +357,12 @@ async fn search_file(
                     chrono::DateTime::<chrono::Utc>::from_timestamp(duration.as_secs() as i64, 0)
                         .unwrap_or_else(chrono::Utc::now)
                         .to_rfc3339();
-                if options_owned.verbose {
-                    eprintln!("DEBUG: file_ctime for {file_path_owned:?} = {ctime}");
-                }
+                tracing::trace!("file_ctime for {file_path_owned:?} = {ctime}");
                 ctime
             })
             .unwrap_or_else(|| {
                 let now = chrono::Utc::now().to_rfc3339();
-                if options_owned.verbose {
-                    eprintln!("DEBUG: Using current time as fallback: {now}");
-                }
+                tracing::trace!("Using current time as fallback: {now}");
                 now
             });
 
@@ -301,13 +371,51 @@ async fn search_file(
         let mut first_timestamp: Option<String> = None;
         let mut line_buffer = Vec::with_capacity(16 * 1024); // 2x larger reusable line buffer
         let mut is_first_line = true;
+        let mut line_number = 0usize;
         let mut found_summary_first = false;
+        let mut summary_timestamps = SummaryTimestamps::new();
 
+        let started = std::time::Instant::now();
         loop {
-            line_buffer.clear();
-            let bytes_read = reader.read_until(b'\n', &mut line_buffer)?;
-            if bytes_read == 0 {
-                break; // EOF
+            // Enough results collected elsewhere (--first)
+            if cap.reached() {
+                break;
+            }
+            if options_owned
+                .file_timeout
+                .is_some_and(|timeout| started.elapsed() >= timeout)
+            {
+                skipped.skip_rest_of_file();
+                tracing::warn!(
+                    "Stopped reading {file_path_owned:?} after line {line_number}: took longer than --file-timeout"
+                );
+                break;
+            }
+            // A read error (e.g. a truncated .gz) ends the file but keeps what it matched
+            let line = match line_reader::read_bounded_line(
+                &mut reader,
+                &mut line_buffer,
+                options_owned.max_line_bytes,
+            ) {
+                Ok(line) => line,
+                Err(e) => {
+                    skipped.skip_rest_of_file();
+                    tracing::warn!("Stopped reading {file_path_owned:?} after line {line_number}: {e}");
+                    break;
+                }
+            };
+            match line {
+                BoundedLine::Eof => break,
+                BoundedLine::Line => line_number += 1,
+                BoundedLine::TooLong => {
+                    line_number += 1;
+                    skipped.skip_line();
+                    tracing::warn!(
+                        "Skipping line {line_number} of {file_path_owned:?}: longer than {} bytes (see --max-line-bytes)",
+                        options_owned.max_line_bytes
+                    );
+                    continue;
+                }
             }
 
             // Skip empty lines
@@ -336,30 +444,25 @@ async fn search_file(
                         is_first_line = false;
                         if message_type == "summary" {
                             found_summary_first = true;
-                            if options_owned.verbose {
-                                eprintln!(
-                                    "DEBUG: Found summary at first line in {file_path_owned:?}"
-                                );
-                            }
+                            tracing::trace!("Found summary at first line in {file_path_owned:?}");
                         }
                     }
 
                     // Update timestamps
                     if let Some(ts) = message.get_timestamp() {
                         latest_timestamp = Some(ts.to_string());
+                        if let Some(uuid) = message.get_uuid() {
+                            summary_timestamps.observe(uuid, ts);
+                        }
                         // Track first timestamp after summary for summary messages
                         if first_timestamp.is_none() && found_summary_first {
                             first_timestamp = Some(ts.to_string());
-                            if options_owned.verbose {
-                                eprintln!(
-                                    "DEBUG: Found first timestamp '{ts}' after summary in {file_path_owned:?}"
-                                );
-                            }
+                            tracing::trace!("Found first timestamp '{ts}' after summary in {file_path_owned:?}");
                         }
                     }
 
                     // Get searchable text
-                    let text = message.get_searchable_text();
+                    let text = options_owned.searchable_text(&message);
 
                     // Apply query condition
                     if let Ok(matches) = query_owned.evaluate(&text)
@@ -381,19 +484,45 @@ async fn search_file(
                                     continue;
                                 }
 
-                            // Determine timestamp based on message type (matching main branch logic)
-                            let final_timestamp = message
-                                .get_timestamp()
-                                .map(|ts| ts.to_string())
-                                .or_else(|| {
-                                    // For summary messages, prefer first_timestamp over latest_timestamp
-                                    if message_type == "summary" {
-                                        first_timestamp.clone()
-                                    } else {
-                                        latest_timestamp.clone()
-                                    }
-                                })
-                                .unwrap_or_else(|| file_ctime.clone());
+                            if let Some(user_type) = &options_owned.user_type
+                                && message.get_user_type() != Some(user_type) {
+                                    continue;
+                                }
+
+                            if let Some(request_id) = &options_owned.request_id
+                                && message.get_request_id() != Some(request_id) {
+                                    continue;
+                                }
+
+                            if let Some(tool_use_id) = &options_owned.tool_use_id
+                                && !message.references_tool_use(tool_use_id) {
+                                    continue;
+                                }
+
+                            if options_owned.exclude_meta && message.is_meta() {
+                                continue;
+                            }
+
+                            if options_owned.tool_errors && !message.has_tool_error() {
+                                continue;
+                            }
+
+                            let content_text = options_owned.content_text(&message);
+                            if !options_owned.accepts_length(&content_text) {
+                                continue;
+                            }
+
+                            // Summaries get theirs once the whole file is read
+                            let final_timestamp = if message_type == "summary" {
+                                summary_timestamps.want(message.get_uuid().unwrap_or(""));
+                                latest_timestamp.clone().unwrap_or_default()
+                            } else {
+                                message
+                                    .get_timestamp()
+                                    .map(|ts| ts.to_string())
+                                    .or_else(|| latest_timestamp.clone())
+                                    .unwrap_or_else(|| file_ctime.clone())
+                            };
 
                             // For SessionViewer and message details, we need raw_json
                             let raw_json = if should_capture_raw_json {
@@ -411,28 +540,43 @@ async fn search_file(
                                 timestamp: final_timestamp,
                                 session_id: message.get_session_id().unwrap_or("").to_string(),
                                 role: message_type_owned.clone(),
-                                text: message.get_content_text(),
+                                text: if options_owned.label_thinking {
+                                    options_owned.display_text(&message)
+                                } else {
+                                    content_text
+                                },
                                 message_type: message_type_owned,
                                 query: query_owned.clone(),
                                 cwd: message.get_cwd().unwrap_or("").to_string(),
                                 raw_json,
+                                line_number: Some(line_number),
+                                request_id: message.get_request_id().map(str::to_string),
+                                parent_uuid: message.get_parent_uuid().map(str::to_string),
+                                is_sidechain: message.is_sidechain(),
                             };
-                            results.push(result);
+                            results.extend(
+                                options_owned.segment_results(&message, &query_owned, result),
+                            );
                         }
                 }
+                Err(_) if SessionMessage::is_other_entry(&line_buffer) => {
+                    tracing::debug!(
+                        "Skipping line {line_number} of {file_path_owned:?}: not a message"
+                    );
+                }
                 Err(e) => {
-                    if options_owned.verbose {
-                        eprintln!("Failed to parse JSON in {file_path_owned:?}: {e:?}");
-                    }
+                    skipped.skip_unparseable_line();
+                    tracing::info!(
+                        "Failed to parse line {line_number} of {file_path_owned:?}: {e}"
+                    );
                 }
             }
         }
 
-        if found_summary_first && first_timestamp.is_none() && options_owned.verbose {
-            eprintln!(
-                "DEBUG: No timestamp found after summary in {file_path_owned:?}"
-            );
+        if found_summary_first && first_timestamp.is_none() {
+            tracing::trace!("No timestamp found after summary in {file_path_owned:?}");
         }
+        summary_timestamps.resolve(&mut results, first_timestamp.as_deref(), &file_ctime);
 
         Ok(results)
     })
@@ -443,9 +587,86 @@ async fn search_file(
 mod tests {
     use super::*;
     use crate::query::parse_query;
+    use std::fs::File;
     use std::io::Write;
     use tempfile::tempdir;
 
+    #[test]
+    fn test_total_count_is_exact_when_capped() -> Result<()> {
+        let temp_dir = tempdir()?;
+        // 3 files x 7 matching messages, plus non-matching and filtered-out ones
+        for file_index in 0..3 {
+            let mut file = File::create(temp_dir.path().join(format!("s{file_index}.jsonl")))?;
+            for i in 0..10 {
+                let (role, content) = match i {
+                    0..7 => ("user", "needle"),
+                    7 => ("assistant", "needle"),
+                    _ => ("user", "haystack"),
+                };
+                if role == "assistant" {
+                    writeln!(
+                        file,
+                        r#"{{"type":"assistant","message":{{"id":"m{i}","type":"message","role":"assistant","model":"claude","content":[{{"type":"text","text":"{content}"}}],"stop_reason":null,"stop_sequence":null,"usage":{{"input_tokens":1,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":1}}}},"uuid":"{file_index}-{i}","timestamp":"2024-01-01T00:00:0{i}Z","sessionId":"s{file_index}","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+                    )?;
+                } else {
+                    writeln!(
+                        file,
+                        r#"{{"type":"user","message":{{"role":"user","content":"{content}"}},"uuid":"{file_index}-{i}","timestamp":"2024-01-01T00:00:0{i}Z","sessionId":"s{file_index}","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+                    )?;
+                }
+            }
+        }
+
+        let options = SearchOptions {
+            max_results: Some(1),
+            role: Some("user".to_string()),
+            ..Default::default()
+        };
+        let engine = SmolEngine::new(options);
+        let (results, _, total_count) =
+            engine.search(temp_dir.path().to_str().unwrap(), parse_query("needle")?)?;
+
+        assert_eq!(results.len(), 1);
+        assert_eq!(total_count, 21);
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_results_keep_their_own_file_when_uuids_collide() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let original = temp_dir.path().join("original.jsonl");
+        let resumed = temp_dir.path().join("resumed.jsonl");
+
+        // A resumed session repeats the earlier turn with the same uuid
+        for (path, session, text) in [
+            (&original, "s1", "shared turn in original"),
+            (&resumed, "s2", "shared turn in resumed"),
+        ] {
+            writeln!(
+                File::create(path)?,
+                r#"{{"type":"user","message":{{"role":"user","content":"{text}"}},"uuid":"same-uuid","timestamp":"2024-01-01T00:00:00Z","sessionId":"{session}","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            )?;
+        }
+
+        let engine = SmolEngine::new(SearchOptions::default());
+        let (results, _, _) =
+            engine.search(temp_dir.path().to_str().unwrap(), parse_query("shared")?)?;
+
+        assert_eq!(results.len(), 2);
+        for result in &results {
+            let (expected_file, expected_text) = if result.session_id == "s1" {
+                (&original, "original")
+            } else {
+                (&resumed, "resumed")
+            };
+            assert_eq!(result.file, expected_file.to_string_lossy());
+            assert!(result.text.contains(expected_text));
+        }
+
+        Ok(())
+    }
+
     #[test]
     fn test_search_engine() -> Result<()> {
         let temp_dir = tempdir()?;
@@ -574,6 +795,39 @@ mod tests {
         Ok(())
     }
 
+    #[test]
+    fn test_summary_timestamp_comes_from_leaf() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        // The summary opens the file; its leaf is the last message of the
+        // resumed conversation further down
+        let mut file = File::create(&test_file)?;
+        writeln!(
+            file,
+            r#"{{"type":"summary","summary":"Fixing the build","leafUuid":"2"}}"#
+        )?;
+        for (uuid, timestamp) in [("1", "2024-01-01T00:00:00Z"), ("2", "2024-01-03T00:00:00Z")] {
+            writeln!(
+                file,
+                r#"{{"type":"user","message":{{"role":"user","content":"Hello"}},"uuid":"{uuid}","timestamp":"{timestamp}","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            )?;
+        }
+
+        let options = SearchOptions {
+            role: Some("summary".to_string()),
+            after: Some("2024-01-02T00:00:00Z".to_string()),
+            ..Default::default()
+        };
+        let engine = SmolEngine::new(options);
+        let (results, _, _) = engine.search(test_file.to_str().unwrap(), parse_query("build")?)?;
+
+        assert_eq!(results.len(), 1);
+        assert_eq!(results[0].timestamp, "2024-01-03T00:00:00Z");
+
+        Ok(())
+    }
+
     #[test]
     fn test_timestamp_filtering() -> Result<()> {
         let temp_dir = tempdir()?;
@@ -694,6 +948,355 @@ mod tests {
         Ok(())
     }
 
+    #[test]
+    fn test_user_type_filter() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        let mut file = File::create(&test_file)?;
+        writeln!(
+            file,
+            r#"{{"type":"summary","summary":"typed summary","leafUuid":"0"}}"#
+        )?;
+        writeln!(
+            file,
+            r#"{{"type":"user","message":{{"role":"user","content":"typed by hand"}},"uuid":"1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+        writeln!(
+            file,
+            r#"{{"type":"user","message":{{"role":"user","content":"typed by a hook"}},"uuid":"2","timestamp":"2024-01-01T00:00:01Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"internal","cwd":"/","version":"1"}}"#
+        )?;
+
+        let options = SearchOptions {
+            user_type: Some("external".to_string()),
+            ..Default::default()
+        };
+
+        let engine = SmolEngine::new(options);
+        let (results, _, total) =
+            engine.search(test_file.to_str().unwrap(), parse_query("typed")?)?;
+
+        assert_eq!(total, 1);
+        assert_eq!(results[0].uuid, "1");
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_request_id_filter() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        let mut file = File::create(&test_file)?;
+        for (uuid, request_id) in [("a1", "req_1"), ("a2", "req_2")] {
+            writeln!(
+                file,
+                r#"{{"type":"assistant","message":{{"id":"m","type":"message","role":"assistant","model":"claude","content":[{{"type":"text","text":"answer"}}],"stop_reason":"end_turn","stop_sequence":null,"usage":{{"input_tokens":1,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":1}}}},"requestId":"{request_id}","uuid":"{uuid}","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            )?;
+        }
+
+        let options = SearchOptions {
+            request_id: Some("req_2".to_string()),
+            ..Default::default()
+        };
+
+        let engine = SmolEngine::new(options);
+        let (results, _, total) =
+            engine.search(test_file.to_str().unwrap(), parse_query("answer")?)?;
+
+        assert_eq!(total, 1);
+        assert_eq!(results[0].uuid, "a2");
+        assert_eq!(results[0].request_id.as_deref(), Some("req_2"));
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_tool_use_id_filter() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        let mut file = File::create(&test_file)?;
+        for (uuid, id) in [("a1", "toolu_1"), ("a2", "toolu_2")] {
+            writeln!(
+                file,
+                r#"{{"type":"assistant","message":{{"id":"m","type":"message","role":"assistant","model":"claude","content":[{{"type":"tool_use","id":"{id}","name":"Bash","input":{{"command":"ls"}}}}],"stop_reason":"tool_use","stop_sequence":null,"usage":{{"input_tokens":1,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":1}}}},"uuid":"{uuid}","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            )?;
+        }
+        writeln!(
+            file,
+            r#"{{"type":"user","message":{{"role":"user","content":[{{"type":"tool_result","tool_use_id":"toolu_1","content":"Permission denied"}}]}},"uuid":"u1","timestamp":"2024-01-01T00:00:01Z","sessionId":"s1","parentUuid":"a1","isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+
+        let options = SearchOptions {
+            tool_use_id: Some("toolu_1".to_string()),
+            ..Default::default()
+        };
+
+        // An empty literal matches everything, as for a search without a query
+        let match_all = QueryCondition::Literal {
+            pattern: String::new(),
+            case_sensitive: false,
+        };
+        let engine = SmolEngine::new(options);
+        let (results, _, total) = engine.search(test_file.to_str().unwrap(), match_all)?;
+
+        assert_eq!(total, 2);
+        let mut uuids: Vec<&str> = results.iter().map(|r| r.uuid.as_str()).collect();
+        uuids.sort();
+        assert_eq!(uuids, vec!["a1", "u1"]);
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_no_thinking_ignores_reasoning() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        let mut file = File::create(&test_file)?;
+        writeln!(
+            file,
+            r#"{{"type":"assistant","message":{{"id":"m","type":"message","role":"assistant","model":"claude","content":[{{"type":"thinking","thinking":"could this be a deadlock?","signature":"sig"}},{{"type":"text","text":"Added a timeout"}}],"stop_reason":"end_turn","stop_sequence":null,"usage":{{"input_tokens":1,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":1}}}},"uuid":"1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+        let pattern = test_file.to_str().unwrap();
+
+        let engine = SmolEngine::new(SearchOptions::default());
+        let (results, _, _) = engine.search(pattern, parse_query("deadlock")?)?;
+        assert_eq!(results.len(), 1);
+
+        let engine = SmolEngine::new(SearchOptions {
+            include_thinking: false,
+            ..Default::default()
+        });
+        let (results, _, _) = engine.search(pattern, parse_query("deadlock")?)?;
+        assert!(results.is_empty());
+        let (results, _, _) = engine.search(pattern, parse_query("timeout")?)?;
+        assert_eq!(results[0].text, "Added a timeout");
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_highlight_thinking_labels_reasoning() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        let mut file = File::create(&test_file)?;
+        writeln!(
+            file,
+            r#"{{"type":"assistant","message":{{"id":"m","type":"message","role":"assistant","model":"claude","content":[{{"type":"thinking","thinking":"could this be a deadlock?\nor a leak","signature":"sig"}},{{"type":"text","text":"Added a timeout"}}],"stop_reason":"end_turn","stop_sequence":null,"usage":{{"input_tokens":1,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":1}}}},"uuid":"1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+        let pattern = test_file.to_str().unwrap();
+
+        let engine = SmolEngine::new(SearchOptions {
+            label_thinking: true,
+            ..Default::default()
+        });
+        let (results, _, _) = engine.search(pattern, parse_query("timeout")?)?;
+        assert_eq!(
+            results[0].text,
+            "[thinking] could this be a deadlock?\n[thinking] or a leak\nAdded a timeout"
+        );
+        // Labels are only added for display, not matched
+        let (results, _, _) = engine.search(pattern, parse_query("\"[thinking]\"")?)?;
+        assert!(results.is_empty());
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_include_unknown_searches_object_content() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        let mut file = File::create(&test_file)?;
+        writeln!(
+            file,
+            r#"{{"type":"user","message":{{"role":"user","content":{{"type":"document","title":"Quarterly roadmap"}}}},"uuid":"1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+        let pattern = test_file.to_str().unwrap();
+
+        let engine = SmolEngine::new(SearchOptions::default());
+        let (results, _, _) = engine.search(pattern, parse_query("roadmap")?)?;
+        assert!(results.is_empty());
+        assert_eq!(engine.skipped().parse_errors(), 0);
+
+        let engine = SmolEngine::new(SearchOptions {
+            include_unknown: true,
+            ..Default::default()
+        });
+        let (results, _, _) = engine.search(pattern, parse_query("roadmap")?)?;
+        assert_eq!(results.len(), 1);
+        assert_eq!(
+            results[0].text,
+            r#"{"title":"Quarterly roadmap","type":"document"}"#
+        );
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_include_tool_use_result_searches_tool_output() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        let mut file = File::create(&test_file)?;
+        writeln!(
+            file,
+            r#"{{"type":"user","message":{{"role":"user","content":[{{"type":"tool_result","tool_use_id":"toolu_1","content":"(truncated)"}}]}},"toolUseResult":{{"stdout":"test result: FAILED. 3 passed","stderr":"","interrupted":false}},"uuid":"1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+        let pattern = test_file.to_str().unwrap();
+
+        let engine = SmolEngine::new(SearchOptions::default());
+        let (results, _, _) = engine.search(pattern, parse_query("FAILED")?)?;
+        assert!(results.is_empty());
+
+        let engine = SmolEngine::new(SearchOptions {
+            include_tool_use_result: true,
+            ..Default::default()
+        });
+        let (results, _, _) = engine.search(pattern, parse_query("FAILED")?)?;
+        assert_eq!(results.len(), 1);
+        assert!(results[0].text.ends_with("\ntest result: FAILED. 3 passed"));
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_per_segment_reports_each_matching_block() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        let mut file = File::create(&test_file)?;
+        writeln!(
+            file,
+            r#"{{"type":"assistant","message":{{"id":"m1","type":"message","role":"assistant","model":"claude","content":[{{"type":"text","text":"The timeout is 30s"}},{{"type":"tool_use","id":"t1","name":"Bash","input":{{"command":"grep timeout config.toml"}}}},{{"type":"text","text":"unrelated"}}],"stop_reason":null,"stop_sequence":null,"usage":{{"input_tokens":1,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":1}}}},"uuid":"a1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+        let pattern = test_file.to_str().unwrap();
+        let options = SearchOptions {
+            per_segment: true,
+            ..Default::default()
+        };
+
+        let engine = SmolEngine::new(options.clone());
+        let (results, _, total_count) = engine.search(pattern, parse_query("timeout")?)?;
+        let texts: Vec<&str> = results.iter().map(|r| r.text.as_str()).collect();
+        assert_eq!(
+            texts,
+            [
+                "[text] The timeout is 30s",
+                "[tool_use] Bash: grep timeout config.toml"
+            ]
+        );
+        assert_eq!(total_count, 2);
+        assert!(results.iter().all(|r| r.uuid == "a1"));
+
+        // Only the blocks together match, so the message stays whole
+        let engine = SmolEngine::new(options);
+        let (results, _, _) = engine.search(pattern, parse_query("30s AND config")?)?;
+        assert_eq!(results.len(), 1);
+        assert!(results[0].text.starts_with("The timeout is 30s\n"));
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_no_pre_filter_finds_escaped_text() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        // "build" with its first letter written as an escape
+        let mut file = File::create(&test_file)?;
+        writeln!(
+            file,
+            r#"{{"type":"user","message":{{"role":"user","content":"the \u0062uild failed"}},"uuid":"1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+        let pattern = test_file.to_str().unwrap();
+
+        let engine = SmolEngine::new(SearchOptions::default());
+        let (results, _, _) = engine.search(pattern, parse_query("build")?)?;
+        assert!(results.is_empty());
+
+        let engine = SmolEngine::new(SearchOptions {
+            pre_filter: false,
+            ..Default::default()
+        });
+        let (results, _, _) = engine.search(pattern, parse_query("build")?)?;
+        assert_eq!(results.len(), 1);
+        assert_eq!(results[0].text, "the build failed");
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_results_carry_message_linkage() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        let mut file = File::create(&test_file)?;
+        writeln!(
+            file,
+            r#"{{"type":"user","message":{{"role":"user","content":"first note"}},"uuid":"u1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+        writeln!(
+            file,
+            r#"{{"type":"user","message":{{"role":"user","content":"second note"}},"uuid":"u2","timestamp":"2024-01-01T00:00:01Z","sessionId":"s1","parentUuid":"u1","isSidechain":true,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+
+        let engine = SmolEngine::new(SearchOptions::default());
+        let (results, _, _) = engine.search(test_file.to_str().unwrap(), parse_query("note")?)?;
+        let linkage: Vec<_> = results
+            .iter()
+            .map(|r| (r.uuid.as_str(), r.parent_uuid.as_deref(), r.is_sidechain))
+            .collect();
+        assert_eq!(linkage, [("u2", Some("u1"), true), ("u1", None, false)]);
+
+        let json = serde_json::to_value(&results[0])?;
+        assert_eq!(json["parent_uuid"], "u1");
+        assert_eq!(json["is_sidechain"], true);
+        let json = serde_json::to_value(&results[1])?;
+        assert!(json.get("parent_uuid").is_none());
+        assert!(json.get("is_sidechain").is_none());
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_length_filters_count_characters() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        let mut file = File::create(&test_file)?;
+        // 5 characters but 15 bytes, so a byte count would drop it below
+        for (uuid, content) in [
+            ("short", "ok"),
+            ("wide", "日本語です"),
+            ("long", "a much longer message"),
+        ] {
+            writeln!(
+                file,
+                r#"{{"type":"user","message":{{"role":"user","content":"{content}"}},"uuid":"{uuid}","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            )?;
+        }
+        let pattern = test_file.to_str().unwrap();
+
+        let engine = SmolEngine::new(SearchOptions {
+            min_length: Some(3),
+            max_length: Some(5),
+            ..Default::default()
+        });
+        let match_all = QueryCondition::Literal {
+            pattern: String::new(),
+            case_sensitive: false,
+        };
+        let (results, _, _) = engine.search(pattern, match_all)?;
+        let uuids: Vec<&str> = results.iter().map(|r| r.uuid.as_str()).collect();
+        assert_eq!(uuids, vec!["wide"]);
+
+        Ok(())
+    }
+
     #[test]
     fn test_max_results_limit() -> Result<()> {
         let temp_dir = tempdir()?;
@@ -724,6 +1327,70 @@ mod tests {
         Ok(())
     }
 
+    #[test]
+    fn test_max_results_keeps_newest_across_files() -> Result<()> {
+        let temp_dir = tempdir()?;
+
+        // Interleave timestamps across files so the newest matches are spread out,
+        // with enough matches to force the collector to compact its buffer
+        let files = 8;
+        for file_index in 0..files {
+            let mut file = File::create(temp_dir.path().join(format!("s{file_index}.jsonl")))?;
+            for i in 0..30 {
+                let second = i * files + file_index;
+                writeln!(
+                    file,
+                    r#"{{"type":"user","message":{{"role":"user","content":"error {second}"}},"uuid":"{second}","timestamp":"2024-01-01T00:{:02}:{:02}Z","sessionId":"s{file_index}","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#,
+                    second / 60,
+                    second % 60
+                )?;
+            }
+        }
+
+        let options = SearchOptions {
+            max_results: Some(5),
+            ..Default::default()
+        };
+        let engine = SmolEngine::new(options);
+        let (results, _, total_count) =
+            engine.search(temp_dir.path().to_str().unwrap(), parse_query("error")?)?;
+
+        assert_eq!(total_count, 240);
+        let uuids: Vec<&str> = results.iter().map(|r| r.uuid.as_str()).collect();
+        assert_eq!(uuids, vec!["239", "238", "237", "236", "235"]);
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_zero_max_results_returns_all() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+
+        let mut file = File::create(&test_file)?;
+        for i in 0..100 {
+            writeln!(
+                file,
+                r#"{{"type":"user","message":{{"role":"user","content":"Message {i}"}},"uuid":"{i}","timestamp":"2024-01-01T00:00:{:02}Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#,
+                i % 60
+            )?;
+        }
+
+        let options = SearchOptions {
+            max_results: Some(0),
+            ..Default::default()
+        };
+
+        let engine = SmolEngine::new(options);
+        let query = parse_query("Message")?;
+        let (results, _, total_count) = engine.search(test_file.to_str().unwrap(), query)?;
+
+        assert_eq!(results.len(), 100);
+        assert_eq!(total_count, 100);
+
+        Ok(())
+    }
+
     #[test]
     fn test_cwd_filter() -> Result<()> {
         let temp_dir = tempdir()?;
@@ -1064,6 +1731,183 @@ mod tests {
         Ok(())
     }
 
+    #[test]
+    fn test_gzip_session_file() -> Result<()> {
+        use flate2::Compression;
+        use flate2::write::GzEncoder;
+
+        let temp_dir = tempdir()?;
+        let plain_file = temp_dir.path().join("plain.jsonl");
+        let gz_file = temp_dir.path().join("archived.jsonl.gz");
+
+        writeln!(
+            File::create(&plain_file)?,
+            r#"{{"type":"user","message":{{"role":"user","content":"Archived hello"}},"uuid":"1","timestamp":"2024-01-01T00:00:01Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+        let mut encoder = GzEncoder::new(File::create(&gz_file)?, Compression::default());
+        writeln!(
+            encoder,
+            r#"{{"type":"user","message":{{"role":"user","content":"Archived hello again"}},"uuid":"2","timestamp":"2024-01-01T00:00:02Z","sessionId":"s2","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+        encoder.finish()?;
+
+        let engine = SmolEngine::new(SearchOptions::default());
+        let (results, _, _) = engine.search(gz_file.to_str().unwrap(), parse_query("Archived")?)?;
+        assert_eq!(results.len(), 1);
+        assert_eq!(results[0].uuid, "2");
+        assert_eq!(results[0].line_number, Some(1));
+
+        // A directory search picks up both plain and compressed sessions
+        let (results, _, _) =
+            engine.search(temp_dir.path().to_str().unwrap(), parse_query("Archived")?)?;
+        assert_eq!(results.len(), 2);
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_unreadable_file_is_skipped_and_counted() -> Result<()> {
+        let temp_dir = tempdir()?;
+        writeln!(
+            File::create(temp_dir.path().join("good.jsonl"))?,
+            r#"{{"type":"user","message":{{"role":"user","content":"still searchable"}},"uuid":"1","timestamp":"2024-01-01T00:00:01Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+        // Not actually gzip data, so decompression fails
+        std::fs::write(temp_dir.path().join("corrupt.jsonl.gz"), b"not gzip at all")?;
+
+        let engine = SmolEngine::new(SearchOptions::default());
+        let (results, _, _) = engine.search(
+            temp_dir.path().to_str().unwrap(),
+            parse_query("searchable")?,
+        )?;
+
+        assert_eq!(results.len(), 1);
+        assert_eq!(engine.skipped().files(), 1);
+        assert_eq!(engine.skipped().lines(), 0);
+        assert!(engine.skipped().summary().is_some());
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_truncated_gzip_keeps_earlier_matches() -> Result<()> {
+        use flate2::Compression;
+        use flate2::write::GzEncoder;
+
+        let temp_dir = tempdir()?;
+        let gz_file = temp_dir.path().join("cut.jsonl.gz");
+        let mut encoder = GzEncoder::new(Vec::new(), Compression::default());
+        for i in 0..500 {
+            writeln!(
+                encoder,
+                r#"{{"type":"user","message":{{"role":"user","content":"partial {i} {}"}},"uuid":"{i}","timestamp":"2024-01-01T00:00:01Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#,
+                "x".repeat(i % 17)
+            )?;
+        }
+        let compressed = encoder.finish()?;
+        // Drop the end of the stream, as an interrupted copy would
+        std::fs::write(&gz_file, &compressed[..compressed.len() / 2])?;
+
+        let engine = SmolEngine::new(SearchOptions::default());
+        let (results, _, _) = engine.search(gz_file.to_str().unwrap(), parse_query("partial")?)?;
+
+        assert!(!results.is_empty());
+        assert!(results.len() < 500);
+        assert_eq!(engine.skipped().partial_files(), 1);
+        assert_eq!(engine.skipped().files(), 0);
+        assert!(engine.skipped().summary().unwrap().contains("cut short"));
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_file_timeout_cuts_file_short() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("slow.jsonl");
+        let mut file = File::create(&test_file)?;
+        for i in 0..3 {
+            writeln!(
+                file,
+                r#"{{"type":"user","message":{{"role":"user","content":"needle {i}"}},"uuid":"{i}","timestamp":"2024-01-01T00:00:01Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            )?;
+        }
+
+        // Out of time before the first line
+        let options = SearchOptions {
+            file_timeout: Some(std::time::Duration::ZERO),
+            ..Default::default()
+        };
+        let engine = SmolEngine::new(options);
+        let (results, _, _) = engine.search(test_file.to_str().unwrap(), parse_query("needle")?)?;
+
+        assert!(results.is_empty());
+        assert_eq!(engine.skipped().partial_files(), 1);
+        assert_eq!(engine.skipped().files(), 0);
+        assert!(engine.skipped().summary().unwrap().contains("cut short"));
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_max_line_bytes_skips_longer_lines() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("test.jsonl");
+        let mut file = File::create(&test_file)?;
+        for (uuid, content) in [("short", "needle"), ("long", &"needle ".repeat(100))] {
+            writeln!(
+                file,
+                r#"{{"type":"user","message":{{"role":"user","content":"{content}"}},"uuid":"{uuid}","timestamp":"2024-01-01T00:00:01Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            )?;
+        }
+        let pattern = test_file.to_str().unwrap();
+
+        let engine = SmolEngine::new(SearchOptions {
+            max_line_bytes: 500,
+            ..Default::default()
+        });
+        let (results, _, _) = engine.search(pattern, parse_query("needle")?)?;
+        assert_eq!(results.len(), 1);
+        assert_eq!(results[0].uuid, "short");
+        assert_eq!(engine.skipped().lines(), 1);
+
+        let engine = SmolEngine::new(SearchOptions::default());
+        let (results, _, _) = engine.search(pattern, parse_query("needle")?)?;
+        assert_eq!(results.len(), 2);
+        assert_eq!(engine.skipped().lines(), 0);
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_unparseable_lines_are_counted() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let mut file = File::create(temp_dir.path().join("session.jsonl"))?;
+        writeln!(file, "{{not json")?;
+        writeln!(
+            file,
+            r#"{{"type":"user","message":{{"role":"user","content":"still searchable"}},"uuid":"1","timestamp":"2024-01-01T00:00:01Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+        )?;
+        writeln!(file, r#"{{"type":"user","message":"not a message"}}"#)?;
+        // Valid JSON that isn't a message is skipped, not reported as an error
+        writeln!(
+            file,
+            r#"{{"type":"file-history-snapshot","messageId":"m1","snapshot":{{}}}}"#
+        )?;
+
+        let engine = SmolEngine::new(SearchOptions::default());
+        let (results, _, _) = engine.search(
+            temp_dir.path().to_str().unwrap(),
+            parse_query("searchable")?,
+        )?;
+
+        assert_eq!(results.len(), 1);
+        assert_eq!(engine.skipped().parse_errors(), 2);
+        assert_eq!(engine.skipped().summary(), None);
+        assert!(engine.skipped().parse_error_summary().is_some());
+
+        Ok(())
+    }
+
     #[test]
     fn test_session_with_thinking_and_tools() -> Result<()> {
         let temp_dir = tempdir()?;
@@ -1091,4 +1935,41 @@ mod tests {
 
         Ok(())
     }
+
+    #[test]
+    fn test_crlf_and_bom_file() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let test_file = temp_dir.path().join("windows.jsonl");
+
+        // As saved by a Windows editor: BOM first, CRLF after every line
+        let line = |uuid: &str, text: &str| {
+            format!(
+                r#"{{"type":"user","message":{{"role":"user","content":"{text}"}},"uuid":"{uuid}","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/","version":"1"}}"#
+            )
+        };
+        let content = format!(
+            "\u{feff}{}\r\n{}\r\n",
+            line("u1", "first line fix"),
+            line("u2", "second fix")
+        );
+        std::fs::write(&test_file, content)?;
+
+        let engine = SmolEngine::new(SearchOptions {
+            session_id: Some("s1".to_string()),
+            ..Default::default()
+        });
+        let (results, _, total) =
+            engine.search(test_file.to_str().unwrap(), parse_query("fix")?)?;
+
+        assert_eq!(total, 2);
+        let mut uuids: Vec<&str> = results.iter().map(|r| r.uuid.as_str()).collect();
+        uuids.sort();
+        assert_eq!(uuids, vec!["u1", "u2"]);
+        for result in &results {
+            let raw_json = result.raw_json.as_deref().unwrap();
+            assert!(raw_json.starts_with('{') && raw_json.ends_with('}'));
+        }
+
+        Ok(())
+    }
 }
@@ -0,0 +1,156 @@
+use crate::query::SearchResult;
+use std::collections::HashMap;
+
+/// Timestamps for summary results, which carry none of their own.
+///
+/// A summary names the last message of the conversation it summarizes in
+/// `leafUuid`. When that message is in the same file (as it is once a session
+/// is resumed and the earlier conversation copied in), the summary takes its
+/// timestamp. Otherwise it falls back to the first timestamp after a summary
+/// that opens the file, then the last timestamp before the summary, then the
+/// file's modification time.
+#[derive(Debug, Default)]
+pub struct SummaryTimestamps {
+    // Leaf uuid -> its timestamp, once seen
+    leaves: HashMap<String, Option<String>>,
+}
+
+impl SummaryTimestamps {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Look out for the message with `leaf_uuid` in the rest of the file
+    pub fn want(&mut self, leaf_uuid: &str) {
+        if !leaf_uuid.is_empty() {
+            self.leaves.entry(leaf_uuid.to_string()).or_insert(None);
+        }
+    }
+
+    /// Note the timestamp of a message, if a summary is waiting for it
+    pub fn observe(&mut self, uuid: &str, timestamp: &str) {
+        if let Some(slot @ None) = self.leaves.get_mut(uuid) {
+            *slot = Some(timestamp.to_string());
+        }
+    }
+
+    /// Take over the summaries waiting in a later part of the same file, read on
+    /// its own. Returns the leaves they still wait for at the end of that part,
+    /// which may yet appear after it; leaves this part waits for keep their slot.
+    pub fn append(&mut self, later: SummaryTimestamps) -> Vec<String> {
+        let mut waiting = Vec::new();
+        for (leaf, timestamp) in later.leaves {
+            self.leaves.entry(leaf).or_insert_with_key(|leaf| {
+                if timestamp.is_none() {
+                    waiting.push(leaf.clone());
+                }
+                timestamp
+            });
+        }
+        waiting
+    }
+
+    /// Give every summary in `results` its timestamp once the whole file is read.
+    ///
+    /// Summary results are expected to hold the last timestamp seen before them,
+    /// or an empty string when there was none.
+    pub fn resolve(
+        &self,
+        results: &mut [SearchResult],
+        first_after_summary: Option<&str>,
+        file_time: &str,
+    ) {
+        for result in results
+            .iter_mut()
+            .filter(|result| result.message_type == "summary")
+        {
+            // A summary's uuid is its leafUuid
+            let leaf = self.leaves.get(&result.uuid).and_then(Option::as_deref);
+            let timestamp = leaf
+                .or(first_after_summary)
+                .or_else(|| (!result.timestamp.is_empty()).then_some(result.timestamp.as_str()));
+            result.timestamp = timestamp.unwrap_or(file_time).to_string();
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::query::QueryCondition;
+
+    fn summary(leaf_uuid: &str, timestamp: &str) -> SearchResult {
+        SearchResult {
+            file: "/test.jsonl".to_string(),
+            uuid: leaf_uuid.to_string(),
+            timestamp: timestamp.to_string(),
+            session_id: String::new(),
+            role: "summary".to_string(),
+            text: "summary".to_string(),
+            message_type: "summary".to_string(),
+            query: QueryCondition::Literal {
+                pattern: "summary".to_string(),
+                case_sensitive: false,
+            },
+            cwd: String::new(),
+            raw_json: None,
+            line_number: None,
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
+        }
+    }
+
+    #[test]
+    fn test_summary_takes_leaf_timestamp() {
+        let mut timestamps = SummaryTimestamps::new();
+        timestamps.want("leaf");
+        timestamps.observe("other", "2024-01-01T00:00:00Z");
+        timestamps.observe("leaf", "2024-01-02T00:00:00Z");
+        // Only the first copy of a repeated message counts
+        timestamps.observe("leaf", "2024-01-03T00:00:00Z");
+
+        let mut results = vec![summary("leaf", ""), summary("missing", "")];
+        timestamps.resolve(
+            &mut results,
+            Some("2024-01-01T00:00:00Z"),
+            "2024-02-01T00:00:00Z",
+        );
+        assert_eq!(results[0].timestamp, "2024-01-02T00:00:00Z");
+        assert_eq!(results[1].timestamp, "2024-01-01T00:00:00Z");
+    }
+
+    #[test]
+    fn test_append_later_part() {
+        let mut timestamps = SummaryTimestamps::new();
+        timestamps.want("early");
+        let mut later = SummaryTimestamps::new();
+        later.want("early");
+        later.observe("early", "2024-01-03T00:00:00Z");
+        later.want("found");
+        later.observe("found", "2024-01-04T00:00:00Z");
+        later.want("open");
+
+        assert_eq!(timestamps.append(later), ["open"]);
+        timestamps.observe("early", "2024-01-02T00:00:00Z");
+        let mut results = vec![
+            summary("early", ""),
+            summary("found", ""),
+            summary("open", ""),
+        ];
+        timestamps.resolve(&mut results, None, "2024-02-01T00:00:00Z");
+        // The earlier part's wait is met first, by whatever follows it
+        assert_eq!(results[0].timestamp, "2024-01-02T00:00:00Z");
+        assert_eq!(results[1].timestamp, "2024-01-04T00:00:00Z");
+        assert_eq!(results[2].timestamp, "2024-02-01T00:00:00Z");
+    }
+
+    #[test]
+    fn test_summary_fallbacks() {
+        let timestamps = SummaryTimestamps::new();
+        let mut results = vec![summary("a", "2024-01-05T00:00:00Z"), summary("b", "")];
+        timestamps.resolve(&mut results, None, "2024-02-01T00:00:00Z");
+        assert_eq!(results[0].timestamp, "2024-01-05T00:00:00Z");
+        assert_eq!(results[1].timestamp, "2024-02-01T00:00:00Z");
+    }
+}
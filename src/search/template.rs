@@ -0,0 +1,267 @@
+//! User-defined output lines for `--template`
+//!
+//! A template is plain text with `{field}` placeholders, rendered once per
+//! result. `{{` and `}}` produce literal braces and `\t`, `\n` and `\\` are
+//! unescaped so tab- or newline-separated output can be written from a shell.
+
+use super::engine::format_preview;
+use crate::query::SearchResult;
+use anyhow::{Result, bail};
+
+/// Fields available to templates, as documented in `--help`
+pub const TEMPLATE_FIELDS: &[&str] = &[
+    "timestamp",
+    "type",
+    "role",
+    "uuid",
+    "session_id",
+    "file",
+    "line",
+    "cwd",
+    "request_id",
+    "parent_uuid",
+    "is_sidechain",
+    "content",
+    "snippet",
+];
+
+#[derive(Debug, Clone, PartialEq)]
+enum Segment {
+    Text(String),
+    Field(Field),
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub(crate) enum Field {
+    Timestamp,
+    Type,
+    Role,
+    Uuid,
+    SessionId,
+    File,
+    Line,
+    Cwd,
+    RequestId,
+    ParentUuid,
+    IsSidechain,
+    Content,
+    Snippet,
+}
+
+impl Field {
+    pub(crate) fn parse(name: &str) -> Option<Self> {
+        Some(match name {
+            "timestamp" => Field::Timestamp,
+            "type" => Field::Type,
+            "role" => Field::Role,
+            "uuid" => Field::Uuid,
+            "session_id" => Field::SessionId,
+            "file" => Field::File,
+            "line" => Field::Line,
+            "cwd" => Field::Cwd,
+            "request_id" => Field::RequestId,
+            "parent_uuid" => Field::ParentUuid,
+            "is_sidechain" => Field::IsSidechain,
+            "content" => Field::Content,
+            "snippet" => Field::Snippet,
+            _ => return None,
+        })
+    }
+}
+
+/// A parsed `--template`, validated up front so typos fail before searching
+#[derive(Debug, Clone, PartialEq)]
+pub struct OutputTemplate {
+    segments: Vec<Segment>,
+}
+
+impl OutputTemplate {
+    pub fn parse(template: &str) -> Result<Self> {
+        let mut segments = Vec::new();
+        let mut text = String::new();
+        let mut chars = template.chars().peekable();
+
+        while let Some(c) = chars.next() {
+            match c {
+                '{' if chars.peek() == Some(&'{') => {
+                    chars.next();
+                    text.push('{');
+                }
+                '}' if chars.peek() == Some(&'}') => {
+                    chars.next();
+                    text.push('}');
+                }
+                '{' => {
+                    let mut name = String::new();
+                    loop {
+                        match chars.next() {
+                            Some('}') => break,
+                            Some(c) => name.push(c),
+                            None => bail!("Unclosed placeholder '{{{name}' in template"),
+                        }
+                    }
+                    let Some(field) = Field::parse(name.trim()) else {
+                        bail!(
+                            "Unknown template field '{{{name}}}' (available: {})",
+                            TEMPLATE_FIELDS.join(", ")
+                        );
+                    };
+                    if !text.is_empty() {
+                        segments.push(Segment::Text(std::mem::take(&mut text)));
+                    }
+                    segments.push(Segment::Field(field));
+                }
+                '}' => bail!("Unmatched '}}' in template (use '}}}}' for a literal brace)"),
+                '\\' => match chars.next() {
+                    Some('t') => text.push('\t'),
+                    Some('n') => text.push('\n'),
+                    Some('\\') => text.push('\\'),
+                    Some(other) => {
+                        text.push('\\');
+                        text.push(other);
+                    }
+                    None => text.push('\\'),
+                },
+                c => text.push(c),
+            }
+        }
+        if !text.is_empty() {
+            segments.push(Segment::Text(text));
+        }
+
+        Ok(Self { segments })
+    }
+
+    pub fn render(&self, result: &SearchResult) -> String {
+        let mut output = String::new();
+        for segment in &self.segments {
+            match segment {
+                Segment::Text(text) => output.push_str(text),
+                Segment::Field(field) => field.write(result, &mut output),
+            }
+        }
+        output
+    }
+}
+
+impl Field {
+    /// Append this field's value for `result` to `output`
+    pub(crate) fn write(self, result: &SearchResult, output: &mut String) {
+        match self {
+            Field::Timestamp => output.push_str(&result.timestamp),
+            Field::Type => output.push_str(&result.message_type),
+            Field::Role => output.push_str(&result.role),
+            Field::Uuid => output.push_str(&result.uuid),
+            Field::SessionId => output.push_str(&result.session_id),
+            Field::File => output.push_str(&result.file),
+            Field::Line => {
+                if let Some(line) = result.line_number {
+                    output.push_str(&line.to_string());
+                }
+            }
+            Field::Cwd => output.push_str(&result.cwd),
+            Field::RequestId => {
+                if let Some(request_id) = &result.request_id {
+                    output.push_str(request_id);
+                }
+            }
+            Field::ParentUuid => {
+                if let Some(parent_uuid) = &result.parent_uuid {
+                    output.push_str(parent_uuid);
+                }
+            }
+            Field::IsSidechain => {
+                output.push_str(if result.is_sidechain { "true" } else { "false" })
+            }
+            Field::Content => output.push_str(&result.text),
+            Field::Snippet => {
+                output.push_str(&format_preview(&result.text, &result.query, 150, false))
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::query::QueryCondition;
+
+    fn result() -> SearchResult {
+        SearchResult {
+            file: "/projects/session.jsonl".to_string(),
+            uuid: "uuid-1".to_string(),
+            timestamp: "2024-01-01T00:00:00Z".to_string(),
+            session_id: "session1".to_string(),
+            role: "user".to_string(),
+            text: "hello\nworld".to_string(),
+            message_type: "user".to_string(),
+            query: QueryCondition::Literal {
+                pattern: "world".to_string(),
+                case_sensitive: false,
+            },
+            cwd: "/test".to_string(),
+            raw_json: None,
+            line_number: Some(42),
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
+        }
+    }
+
+    #[test]
+    fn test_render_fields_and_escapes() -> Result<()> {
+        let template = OutputTemplate::parse(r"{timestamp}\t{file}:{line}\t{ snippet }")?;
+        assert_eq!(
+            template.render(&result()),
+            "2024-01-01T00:00:00Z\t/projects/session.jsonl:42\thello world"
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_literal_braces() -> Result<()> {
+        let template = OutputTemplate::parse("{{\"uuid\": \"{uuid}\"}}")?;
+        assert_eq!(template.render(&result()), "{\"uuid\": \"uuid-1\"}");
+        Ok(())
+    }
+
+    #[test]
+    fn test_invalid_templates() {
+        let unknown = OutputTemplate::parse("{Timestamp}").unwrap_err();
+        assert!(unknown.to_string().contains("Unknown template field"));
+        assert!(OutputTemplate::parse("{uuid").is_err());
+        assert!(OutputTemplate::parse("uuid}").is_err());
+    }
+
+    #[test]
+    fn test_missing_line_renders_empty() -> Result<()> {
+        let mut result = result();
+        result.line_number = None;
+        let template = OutputTemplate::parse("{file}:{line}")?;
+        assert_eq!(template.render(&result), "/projects/session.jsonl:");
+        Ok(())
+    }
+
+    #[test]
+    fn test_linkage_fields() -> Result<()> {
+        let template = OutputTemplate::parse("{uuid} {parent_uuid} {is_sidechain}")?;
+        assert_eq!(template.render(&result()), "uuid-1  false");
+
+        let mut result = result();
+        result.parent_uuid = Some("uuid-0".to_string());
+        result.is_sidechain = true;
+        assert_eq!(template.render(&result), "uuid-1 uuid-0 true");
+        Ok(())
+    }
+
+    #[test]
+    fn test_request_id_field() -> Result<()> {
+        let template = OutputTemplate::parse("{uuid} {request_id}")?;
+        assert_eq!(template.render(&result()), "uuid-1 ");
+
+        let mut result = result();
+        result.request_id = Some("req_123".to_string());
+        assert_eq!(template.render(&result), "uuid-1 req_123");
+        Ok(())
+    }
+}
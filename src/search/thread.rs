@@ -0,0 +1,290 @@
+use super::context::read_session_messages;
+use crate::query::{SearchOptions, SearchResult};
+use crate::schemas::SessionMessage;
+use anyhow::{Context, Result};
+use std::collections::{HashMap, HashSet};
+use std::path::Path;
+
+/// A message in a reply tree built from `parentUuid` links
+#[derive(Debug, Clone, PartialEq)]
+pub struct ThreadNode {
+    pub uuid: String,
+    pub parent_uuid: Option<String>,
+    pub is_sidechain: bool,
+    pub role: String,
+    pub timestamp: String,
+    pub text: String,
+}
+
+/// Parent/child index over the messages of one session file
+#[derive(Debug, Default)]
+pub struct ThreadIndex {
+    nodes: HashMap<String, ThreadNode>,
+    children: HashMap<String, Vec<String>>,
+}
+
+impl ThreadIndex {
+    /// Index `messages`, with their text built as `options` builds a hit's
+    pub fn from_messages<I: IntoIterator<Item = SessionMessage>>(
+        messages: I,
+        options: &SearchOptions,
+    ) -> Self {
+        let mut index = Self::default();
+        for message in messages {
+            // Summaries have no place in the reply tree
+            let Some(timestamp) = message.get_timestamp() else {
+                continue;
+            };
+            let node = ThreadNode {
+                uuid: message.get_uuid().unwrap_or("").to_string(),
+                parent_uuid: message.get_parent_uuid().map(str::to_string),
+                is_sidechain: message.is_sidechain(),
+                role: message.get_type().to_string(),
+                timestamp: timestamp.to_string(),
+                text: options.display_text(&message),
+            };
+            if index.nodes.contains_key(&node.uuid) {
+                continue;
+            }
+            if let Some(parent) = &node.parent_uuid {
+                index
+                    .children
+                    .entry(parent.clone())
+                    .or_default()
+                    .push(node.uuid.clone());
+            }
+            index.nodes.insert(node.uuid.clone(), node);
+        }
+        index
+    }
+
+    /// Index the messages of a session file, skipping lines longer than
+    /// `options.max_line_bytes`
+    pub fn from_file(file_path: &Path, options: &SearchOptions) -> Result<Self> {
+        let messages = read_session_messages(file_path, options.max_line_bytes)?;
+        Ok(Self::from_messages(
+            messages.into_iter().map(|(_, message)| message),
+            options,
+        ))
+    }
+
+    pub fn get(&self, uuid: &str) -> Option<&ThreadNode> {
+        self.nodes.get(uuid)
+    }
+
+    /// UUIDs of the direct replies to `uuid`, in file order
+    pub fn children(&self, uuid: &str) -> &[String] {
+        self.children.get(uuid).map(Vec::as_slice).unwrap_or(&[])
+    }
+
+    /// Ancestors of `uuid` from the root down to its direct parent.
+    /// Stops at a missing parent and guards against cycles.
+    pub fn ancestors(&self, uuid: &str) -> Vec<&ThreadNode> {
+        let mut chain = Vec::new();
+        let mut visited = HashSet::from([uuid]);
+        let mut current = self.nodes.get(uuid).and_then(|n| n.parent_uuid.as_deref());
+
+        while let Some(parent) = current {
+            if !visited.insert(parent) {
+                break;
+            }
+            let Some(node) = self.nodes.get(parent) else {
+                break;
+            };
+            chain.push(node);
+            current = node.parent_uuid.as_deref();
+        }
+
+        chain.reverse();
+        chain
+    }
+}
+
+/// Thread chains for each result, parallel to `results`. Each chain runs from
+/// the root down to the matched message itself, which is the last element
+/// (empty if the message cannot be found in its file). Message text is built
+/// like the hits' own and lines longer than `options.max_line_bytes` are skipped.
+pub fn collect_threads(
+    results: &[SearchResult],
+    options: &SearchOptions,
+) -> Result<Vec<Vec<ThreadNode>>> {
+    let mut indexes: HashMap<&str, ThreadIndex> = HashMap::new();
+    let mut threads = Vec::with_capacity(results.len());
+
+    for result in results {
+        if !indexes.contains_key(result.file.as_str()) {
+            let index = ThreadIndex::from_file(Path::new(&result.file), options)
+                .with_context(|| format!("Failed to read thread from {}", result.file))?;
+            indexes.insert(&result.file, index);
+        }
+        let index = &indexes[result.file.as_str()];
+        let chain = match index.get(&result.uuid) {
+            Some(node) => index
+                .ancestors(&result.uuid)
+                .into_iter()
+                .chain(std::iter::once(node))
+                .cloned()
+                .collect(),
+            None => Vec::new(),
+        };
+        threads.push(chain);
+    }
+
+    Ok(threads)
+}
+
+/// Format a thread message as an indented, dimmed line
+pub fn format_thread_node(node: &ThreadNode, depth: usize, use_color: bool) -> String {
+    use colored::Colorize;
+
+    let text = node.text.split_whitespace().collect::<Vec<_>>().join(" ");
+    let text = match text.char_indices().nth(100) {
+        Some((end, _)) => format!("{}...", &text[..end]),
+        None => text,
+    };
+    let marker = if node.is_sidechain {
+        " [sidechain]"
+    } else {
+        ""
+    };
+    let line = format!(
+        "{}↳ {} {}{marker} {text}",
+        "  ".repeat(depth),
+        node.timestamp,
+        node.role
+    );
+
+    if use_color {
+        if node.is_sidechain {
+            line.bright_magenta().dimmed().to_string()
+        } else {
+            line.dimmed().to_string()
+        }
+    } else {
+        line
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn message(uuid: &str, parent: Option<&str>, sidechain: bool) -> SessionMessage {
+        serde_json::from_value(json!({
+            "type": "user",
+            "message": { "role": "user", "content": format!("text of {uuid}") },
+            "uuid": uuid,
+            "timestamp": "2024-01-01T00:00:00Z",
+            "sessionId": "session1",
+            "parentUuid": parent,
+            "isSidechain": sidechain,
+            "userType": "external",
+            "cwd": "/test",
+            "version": "1.0"
+        }))
+        .unwrap()
+    }
+
+    fn index() -> ThreadIndex {
+        ThreadIndex::from_messages(
+            vec![
+                message("root", None, false),
+                message("a", Some("root"), false),
+                message("b", Some("a"), false),
+                message("side", Some("a"), true),
+            ],
+            &SearchOptions::default(),
+        )
+    }
+
+    #[test]
+    fn test_children() {
+        let index = index();
+        assert_eq!(index.children("a"), ["b".to_string(), "side".to_string()]);
+        assert!(index.children("b").is_empty());
+    }
+
+    #[test]
+    fn test_ancestors_root_first() {
+        let index = index();
+        let chain: Vec<&str> = index
+            .ancestors("b")
+            .iter()
+            .map(|n| n.uuid.as_str())
+            .collect();
+        assert_eq!(chain, vec!["root", "a"]);
+        assert!(index.ancestors("root").is_empty());
+        assert!(index.get("side").unwrap().is_sidechain);
+    }
+
+    #[test]
+    fn test_ancestors_stop_on_cycle() {
+        let index = ThreadIndex::from_messages(
+            vec![
+                message("x", Some("y"), false),
+                message("y", Some("x"), false),
+            ],
+            &SearchOptions::default(),
+        );
+        let chain: Vec<&str> = index
+            .ancestors("x")
+            .iter()
+            .map(|n| n.uuid.as_str())
+            .collect();
+        assert_eq!(chain, vec!["y"]);
+    }
+
+    #[test]
+    fn test_collect_threads_from_file() -> Result<()> {
+        let temp_dir = tempfile::tempdir()?;
+        let path = temp_dir.path().join("session.jsonl");
+        let lines = [
+            message("root", None, false),
+            message("a", Some("root"), true),
+        ]
+        .iter()
+        .map(|m| serde_json::to_string(m).unwrap())
+        .collect::<Vec<_>>()
+        .join("\n");
+        std::fs::write(&path, lines)?;
+
+        let mut hit = SearchResult {
+            file: path.to_string_lossy().to_string(),
+            uuid: "a".to_string(),
+            timestamp: "2024-01-01T00:00:00Z".to_string(),
+            session_id: "session1".to_string(),
+            role: "user".to_string(),
+            text: "text of a".to_string(),
+            message_type: "user".to_string(),
+            query: crate::query::QueryCondition::Literal {
+                pattern: "text".to_string(),
+                case_sensitive: false,
+            },
+            cwd: "/test".to_string(),
+            raw_json: None,
+            line_number: Some(2),
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
+        };
+        let threads = collect_threads(std::slice::from_ref(&hit), &SearchOptions::default())?;
+        let chain: Vec<&str> = threads[0].iter().map(|n| n.uuid.as_str()).collect();
+        assert_eq!(chain, vec!["root", "a"]);
+        assert!(threads[0][1].is_sidechain);
+
+        hit.uuid = "missing".to_string();
+        assert!(collect_threads(&[hit], &SearchOptions::default())?[0].is_empty());
+        Ok(())
+    }
+
+    #[test]
+    fn test_format_thread_node_marks_sidechain() {
+        let index = index();
+        let line = format_thread_node(index.get("side").unwrap(), 1, false);
+        assert_eq!(
+            line,
+            "  ↳ 2024-01-01T00:00:00Z user [sidechain] text of side"
+        );
+    }
+}
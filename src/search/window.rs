@@ -0,0 +1,305 @@
+use super::corpus::map_corpus_files;
+use super::engine::{format_local_timestamp, format_preview};
+use super::skipped::SkipCounter;
+use crate::query::{QueryCondition, SearchOptions};
+use crate::schemas::SessionMessage;
+use anyhow::{Context, Result};
+use serde::Serialize;
+use std::collections::HashMap;
+use std::path::PathBuf;
+
+/// A run of consecutive messages in one session whose combined text matches
+/// a query (`--window N`), such as a question and the answer that finishes
+/// the phrase.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct WindowMatch {
+    pub file: String,
+    pub session_id: String,
+    /// Time of the first and last message in the window (RFC3339)
+    pub start_timestamp: String,
+    pub end_timestamp: String,
+    /// 1-based line numbers of the messages, in time order
+    pub line_numbers: Vec<usize>,
+    pub uuids: Vec<String>,
+    /// The messages' text joined by newlines, as it was matched
+    pub text: String,
+    #[serde(skip)]
+    pub query: QueryCondition,
+}
+
+// A message taking part in windows
+struct Turn {
+    line_number: usize,
+    uuid: String,
+    timestamp: String,
+    text: String,
+}
+
+/// Find windows of up to `size` consecutive messages whose combined text
+/// matches `query`, newest first.
+///
+/// Messages are taken per session in time order, after the role, time and
+/// other message filters of `options`. Each match is reported once, as the
+/// shortest window that still matches, and windows never overlap, so a phrase
+/// split over a question and its answer is one match spanning both messages
+/// while a message that matches by itself is a window of one. Files and lines
+/// that can't be read are skipped into `skipped`.
+pub fn search_windows(
+    files: &[PathBuf],
+    query: &QueryCondition,
+    options: &SearchOptions,
+    size: usize,
+    skipped: &SkipCounter,
+) -> Result<Vec<WindowMatch>> {
+    let per_file = map_corpus_files(files, options, skipped, |path, messages| -> Result<_> {
+        let file = path.to_string_lossy();
+        let mut sessions: HashMap<String, Vec<Turn>> = HashMap::new();
+        for (line_number, message) in messages {
+            let (Some(session_id), Some(timestamp)) =
+                (message.get_session_id(), message.get_timestamp())
+            else {
+                continue;
+            };
+            sessions
+                .entry(session_id.to_string())
+                .or_default()
+                .push(turn(line_number, timestamp, &message, options));
+        }
+
+        let mut matches = Vec::new();
+        for (session_id, mut turns) in sessions {
+            turns.sort_by(|a, b| a.timestamp.cmp(&b.timestamp));
+            for span in matching_spans(&turns, size, |text| query.evaluate(text))? {
+                let window = &turns[span];
+                matches.push(WindowMatch {
+                    file: file.to_string(),
+                    session_id: session_id.clone(),
+                    start_timestamp: window[0].timestamp.clone(),
+                    end_timestamp: window[window.len() - 1].timestamp.clone(),
+                    line_numbers: window.iter().map(|turn| turn.line_number).collect(),
+                    uuids: window.iter().map(|turn| turn.uuid.clone()).collect(),
+                    text: joined(window),
+                    query: query.clone(),
+                });
+            }
+        }
+        Ok(matches)
+    })
+    .into_iter()
+    .collect::<Result<Vec<_>>>()?;
+
+    let mut matches: Vec<WindowMatch> = per_file.into_iter().flatten().collect();
+    matches.sort_by(|a, b| b.start_timestamp.cmp(&a.start_timestamp));
+    if let Some(max) = options.max_results.filter(|&max| max > 0) {
+        matches.truncate(max);
+    }
+    Ok(matches)
+}
+
+fn turn(
+    line_number: usize,
+    timestamp: &str,
+    message: &SessionMessage,
+    options: &SearchOptions,
+) -> Turn {
+    Turn {
+        line_number,
+        uuid: message.get_uuid().unwrap_or_default().to_string(),
+        timestamp: timestamp.to_string(),
+        text: options.content_text(message),
+    }
+}
+
+fn joined(turns: &[Turn]) -> String {
+    turns
+        .iter()
+        .map(|turn| turn.text.as_str())
+        .collect::<Vec<_>>()
+        .join("\n")
+}
+
+// Non-overlapping spans of at most `size` turns whose joined text matches:
+// from each start the first end that matches, then the start moved up as far
+// as the span still matches
+fn matching_spans(
+    turns: &[Turn],
+    size: usize,
+    matches: impl Fn(&str) -> Result<bool, regex::Error>,
+) -> Result<Vec<std::ops::Range<usize>>> {
+    let span_matches =
+        |start: usize, end: usize| matches(&joined(&turns[start..end])).context("Invalid regex");
+    let mut spans = Vec::new();
+    let mut start = 0;
+    while start < turns.len() {
+        let limit = (start + size).min(turns.len());
+        let mut found = None;
+        for end in start + 1..=limit {
+            if span_matches(start, end)? {
+                found = Some(end);
+                break;
+            }
+        }
+        let Some(end) = found else {
+            start += 1;
+            continue;
+        };
+        let mut first = start;
+        while first + 1 < end && span_matches(first + 1, end)? {
+            first += 1;
+        }
+        spans.push(first..end);
+        start = end;
+    }
+    Ok(spans)
+}
+
+/// Format a window match for display: its time span, file and lines, then a
+/// preview of the combined text around the match
+pub fn format_window_match(window: &WindowMatch, use_color: bool) -> String {
+    use colored::Colorize;
+
+    let start = format_local_timestamp(&window.start_timestamp);
+    let end = format_local_timestamp(&window.end_timestamp);
+    let time = if start == end {
+        start
+    } else {
+        format!("{start} → {end}")
+    };
+    let lines = match (window.line_numbers.first(), window.line_numbers.last()) {
+        (Some(first), Some(last)) if first != last => format!("{first}-{last}"),
+        (Some(first), _) => first.to_string(),
+        _ => String::new(),
+    };
+    let location = format!("{}:{lines}", window.file);
+    let count = match window.line_numbers.len() {
+        1 => "1 message".to_string(),
+        n => format!("{n} messages"),
+    };
+    let preview = format_preview(&window.text, &window.query, 150, use_color);
+
+    if use_color {
+        format!(
+            "{} [{}] {}\n  {}",
+            time.bright_blue(),
+            location.bright_green(),
+            count.dimmed(),
+            preview
+        )
+    } else {
+        format!("{time} [{location}] {count}\n  {preview}")
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::query::parse_query;
+    use tempfile::tempdir;
+
+    fn message(kind: &str, uuid: &str, second: u32, session: &str, text: &str) -> String {
+        format!(
+            r#"{{"type":"{kind}","message":{{"role":"{kind}","content":"{text}"}},"uuid":"{uuid}","timestamp":"2024-01-01T00:00:{second:02}Z","sessionId":"{session}","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/p","version":"1"}}"#
+        )
+    }
+
+    fn search(lines: &[String], query: &str, size: usize) -> Result<Vec<WindowMatch>> {
+        let dir = tempdir()?;
+        let file = dir.path().join("session.jsonl");
+        std::fs::write(&file, lines.join("\n"))?;
+        let options = SearchOptions {
+            max_results: None,
+            ..Default::default()
+        };
+        search_windows(
+            &[file],
+            &parse_query(query)?,
+            &options,
+            size,
+            &SkipCounter::new(),
+        )
+    }
+
+    #[test]
+    fn test_window_spans_question_and_answer() -> Result<()> {
+        let lines = [
+            message("user", "u1", 1, "s1", "which port does the server use"),
+            message("user", "u2", 2, "s1", "unrelated"),
+            message("user", "u3", 3, "s1", "the staging port"),
+            message("user", "u4", 4, "s1", "is 8443"),
+        ];
+        let matches = search(&lines, "port AND 8443", 2)?;
+        assert_eq!(matches.len(), 1);
+        assert_eq!(matches[0].line_numbers, [3, 4]);
+        assert_eq!(matches[0].uuids, ["u3", "u4"]);
+        assert_eq!(matches[0].text, "the staging port\nis 8443");
+        assert_eq!(matches[0].start_timestamp, "2024-01-01T00:00:03Z");
+        assert_eq!(matches[0].end_timestamp, "2024-01-01T00:00:04Z");
+
+        // Per-message search misses it
+        assert!(search(&lines, "port AND 8443", 1)?.is_empty());
+        Ok(())
+    }
+
+    #[test]
+    fn test_corrupt_file_is_skipped() -> Result<()> {
+        let dir = tempdir()?;
+        let good = dir.path().join("session.jsonl");
+        std::fs::write(&good, message("user", "u1", 1, "s1", "the port is 8443"))?;
+        let corrupt = dir.path().join("corrupt.jsonl.gz");
+        std::fs::write(&corrupt, b"not gzip at all")?;
+
+        let skipped = SkipCounter::new();
+        let matches = search_windows(
+            &[corrupt, good],
+            &parse_query("8443")?,
+            &SearchOptions::default(),
+            2,
+            &skipped,
+        )?;
+        assert_eq!(matches.len(), 1);
+        assert_eq!(skipped.files(), 1);
+        Ok(())
+    }
+
+    #[test]
+    fn test_windows_are_shortest_and_do_not_overlap() -> Result<()> {
+        let lines = [
+            message("user", "u1", 1, "s1", "deploy failed"),
+            message("user", "u2", 2, "s1", "deploy again"),
+            message("user", "u3", 3, "s1", "other"),
+        ];
+        let matches = search(&lines, "deploy", 3)?;
+        let lines: Vec<_> = matches.iter().map(|m| m.line_numbers.clone()).collect();
+        assert_eq!(lines, [vec![2], vec![1]]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_windows_stay_within_a_session_in_time_order() -> Result<()> {
+        let lines = [
+            // Written out of order; the answer came last
+            message("user", "u2", 5, "s1", "is 8443"),
+            message("user", "x1", 2, "s2", "is 8443"),
+            message("user", "u1", 4, "s1", "the port"),
+            message("user", "x2", 3, "s2", "the port"),
+        ];
+        let matches = search(&lines, "/port\\sis/", 2)?;
+        assert_eq!(matches.len(), 1);
+        assert_eq!(matches[0].session_id, "s1");
+        assert_eq!(matches[0].uuids, ["u1", "u2"]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_format_window_match() -> Result<()> {
+        let lines = [
+            message("user", "u1", 1, "s1", "the port"),
+            message("user", "u2", 2, "s1", "is 8443"),
+        ];
+        let matches = search(&lines, "port AND 8443", 2)?;
+        let output = format_window_match(&matches[0], false);
+        assert!(output.contains(" → "));
+        assert!(output.contains("session.jsonl:1-2] 2 messages\n  the port is 8443"));
+        Ok(())
+    }
+}
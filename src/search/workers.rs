@@ -0,0 +1,101 @@
+use std::str::FromStr;
+
+/// Files below this average size are cheap to parse, so opening and reading
+/// them dominates and extra workers keep more reads in flight
+const SMALL_FILE_BYTES: u64 = 256 * 1024;
+
+/// Files above this average size keep a worker busy parsing for a long time;
+/// running fewer at once eases contention for memory bandwidth and page cache
+const LARGE_FILE_BYTES: u64 = 32 * 1024 * 1024;
+
+/// Upper bound for `auto`, however many small files there are
+const MAX_AUTO_WORKERS: usize = 64;
+
+/// `--workers` value: a fixed number of files searched at once, or `auto`
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum WorkerCount {
+    Auto,
+    Fixed(usize),
+}
+
+impl WorkerCount {
+    /// The fixed count, if this is not `auto`
+    pub fn fixed(self) -> Option<usize> {
+        match self {
+            WorkerCount::Auto => None,
+            WorkerCount::Fixed(workers) => Some(workers),
+        }
+    }
+}
+
+impl FromStr for WorkerCount {
+    type Err = String;
+
+    fn from_str(input: &str) -> Result<Self, Self::Err> {
+        if input.eq_ignore_ascii_case("auto") {
+            return Ok(WorkerCount::Auto);
+        }
+        match input.parse::<usize>() {
+            Ok(workers) if workers > 0 => Ok(WorkerCount::Fixed(workers)),
+            _ => Err(format!(
+                "invalid worker count '{input}' (expected a positive number or 'auto')"
+            )),
+        }
+    }
+}
+
+/// Pick a worker count for searching `files` session files of `total_bytes`
+/// on a machine with `cpus` CPUs.
+///
+/// One worker per CPU is the baseline. Many small files are I/O-bound, so
+/// twice as many workers are used to overlap reads; a few very large files are
+/// parse-bound, so half as many are used. Never more workers than files.
+pub fn auto_workers(files: usize, total_bytes: u64, cpus: usize) -> usize {
+    let cpus = cpus.max(1);
+    if files == 0 {
+        return 1;
+    }
+
+    let average = total_bytes / files as u64;
+    let workers = if average < SMALL_FILE_BYTES && files > cpus * 4 {
+        (cpus * 2).min(MAX_AUTO_WORKERS).max(cpus)
+    } else if average > LARGE_FILE_BYTES {
+        cpus.div_ceil(2)
+    } else {
+        cpus
+    };
+    workers.clamp(1, files)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_worker_count() {
+        assert_eq!("auto".parse(), Ok(WorkerCount::Auto));
+        assert_eq!("AUTO".parse(), Ok(WorkerCount::Auto));
+        assert_eq!("4".parse(), Ok(WorkerCount::Fixed(4)));
+        assert!("0".parse::<WorkerCount>().is_err());
+        assert!("many".parse::<WorkerCount>().is_err());
+        assert_eq!(WorkerCount::Fixed(3).fixed(), Some(3));
+        assert_eq!(WorkerCount::Auto.fixed(), None);
+    }
+
+    #[test]
+    fn test_auto_workers() {
+        const MIB: u64 = 1024 * 1024;
+
+        // Many small files: more workers than CPUs, capped
+        assert_eq!(auto_workers(1000, 1000 * 10 * 1024, 8), 16);
+        assert_eq!(auto_workers(10_000, 10_000 * 1024, 48), MAX_AUTO_WORKERS);
+        // Typical sessions: one per CPU
+        assert_eq!(auto_workers(100, 100 * 2 * MIB, 8), 8);
+        // A few huge files: half the CPUs
+        assert_eq!(auto_workers(6, 6 * 100 * MIB, 8), 4);
+        assert_eq!(auto_workers(6, 6 * 100 * MIB, 1), 1);
+        // Never more workers than files
+        assert_eq!(auto_workers(3, 3 * 1024, 8), 3);
+        assert_eq!(auto_workers(0, 0, 8), 1);
+    }
+}
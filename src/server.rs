@@ -0,0 +1,1316 @@
+//! Local HTTP search API for `ccms serve`
+//!
+//! A deliberately small HTTP/1.1 server on top of `std::net`: every request is
+//! a `GET`, answered with JSON and then the connection is closed. Parsed
+//! messages are kept in memory per file and re-read only when the file's size
+//! or modification time changes, so repeated queries do not touch the disk
+//! beyond a directory walk and a `stat` per file. A session that is being
+//! written to only has its new lines parsed, and a last line that is still
+//! incomplete is left for a later refresh.
+//!
+//! With a cache limit (`--cache-messages`) only that many parsed messages are
+//! held: the files searched least recently are dropped first and parsed again
+//! when a search needs them. A `project` filter then only loads the files of
+//! that project, so a few busy projects stay in memory while the rest wait on
+//! disk.
+//!
+//! Endpoints:
+//! - `GET /healthz` - liveness check
+//! - `GET /search?q=...` - search; optional `role`, `session`, `project`,
+//!   `after`, `before` (RFC3339) and `max` (0 for unlimited)
+//! - `GET /stats` - size of the in-memory message set and cache hit ratio
+//!
+//! The message set is loaded before the first connection is accepted (see
+//! [`Server::warm`]), so the first query does not pay for parsing every file.
+//!
+//! Transcripts are private, so only requests addressed to `localhost`,
+//! `127.0.0.1` or `[::1]` are answered, which keeps DNS rebinding from turning
+//! a web page into a client. A request from a browser page (one with an
+//! `Origin` header) is refused unless that origin was allowed with
+//! `--allow-origin`, and only that origin is told it may read the response.
+//! Connections are read with a timeout and only so many are served at once.
+
+use crate::interactive_ratatui::domain::models::SearchOrder;
+use crate::query::{QueryCondition, SearchResult, parse_query};
+use crate::schemas::SessionMessage;
+use crate::search::{ResultCollector, SummaryTimestamps, discover_claude_files};
+use crate::utils::line_reader::{self, BoundedLine};
+use crate::utils::{compression, path_encoding};
+use anyhow::{Context, Result};
+use chrono::DateTime;
+use lru::LruCache;
+use rayon::prelude::*;
+use serde_json::json;
+use std::collections::{HashMap, HashSet};
+use std::fs::File;
+use std::io::{BufRead, BufReader, Read, Seek, SeekFrom, Write};
+use std::net::{TcpListener, TcpStream, ToSocketAddrs};
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant, SystemTime};
+
+/// Results returned by `/search` when `max` is not given
+pub const DEFAULT_MAX_RESULTS: usize = 50;
+
+// Longest request line or header accepted, and how many headers
+const MAX_HEADER_BYTES: usize = 8 * 1024;
+const MAX_HEADERS: usize = 100;
+
+// Connections served at once; more are turned away until one finishes
+const MAX_CONNECTIONS: usize = 64;
+// How long a client may take to send its request or accept the response
+const IO_TIMEOUT: Duration = Duration::from_secs(10);
+
+/// Resolve a listen address; a bare `:PORT` listens on localhost only
+pub fn listen_address(addr: &str) -> String {
+    match addr.strip_prefix(':') {
+        Some(port) => format!("127.0.0.1:{port}"),
+        None => addr.to_string(),
+    }
+}
+
+/// What [`Server::warm`] loaded
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct WarmReport {
+    pub files: usize,
+    pub messages: usize,
+    pub elapsed: Duration,
+}
+
+impl std::fmt::Display for WarmReport {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(
+            f,
+            "Loaded {} messages from {} files in {}ms",
+            self.messages,
+            self.files,
+            self.elapsed.as_millis()
+        )
+    }
+}
+
+/// Parsed messages of one session file, with their line numbers
+struct StoredFile {
+    modified: SystemTime,
+    len: u64,
+    // Bytes and lines parsed so far; an incomplete last line is not included
+    parsed_len: u64,
+    lines: usize,
+    // The last parsed line had no newline yet
+    ends_open: bool,
+    // One chunk per read, so appending does not copy the earlier messages
+    chunks: Vec<Arc<Vec<(usize, SessionMessage)>>>,
+}
+
+impl StoredFile {
+    fn messages(&self) -> impl Iterator<Item = &(usize, SessionMessage)> {
+        self.chunks.iter().flat_map(|chunk| chunk.iter())
+    }
+
+    fn message_count(&self) -> usize {
+        self.chunks.iter().map(|chunk| chunk.len()).sum()
+    }
+}
+
+// Parsed files held in memory, least recently searched first out
+struct FileCache {
+    files: LruCache<PathBuf, Arc<StoredFile>>,
+    // Messages in `files`, kept up to date so eviction need not count them
+    messages: usize,
+    hits: usize,
+    misses: usize,
+    evictions: usize,
+}
+
+impl FileCache {
+    fn new() -> Self {
+        Self {
+            files: LruCache::unbounded(),
+            messages: 0,
+            hits: 0,
+            misses: 0,
+            evictions: 0,
+        }
+    }
+
+    fn insert(&mut self, path: PathBuf, file: Arc<StoredFile>) {
+        self.messages += file.message_count();
+        if let Some(old) = self.files.put(path, file) {
+            self.messages -= old.message_count();
+        }
+    }
+
+    fn remove(&mut self, path: &Path) {
+        if let Some(old) = self.files.pop(path) {
+            self.messages -= old.message_count();
+        }
+    }
+
+    // Drop files that are no longer on disk
+    fn retain_paths(&mut self, paths: &[PathBuf]) {
+        let on_disk: HashSet<&PathBuf> = paths.iter().collect();
+        let gone: Vec<PathBuf> = self
+            .files
+            .iter()
+            .map(|(path, _)| path)
+            .filter(|path| !on_disk.contains(path))
+            .cloned()
+            .collect();
+        for path in gone {
+            self.remove(&path);
+        }
+    }
+
+    // Evict least recently used files until at most `limit` messages are held
+    fn evict_to(&mut self, limit: usize) {
+        while self.messages > limit {
+            let Some((_, file)) = self.files.pop_lru() else {
+                break;
+            };
+            self.messages -= file.message_count();
+            self.evictions += 1;
+        }
+    }
+}
+
+/// Search API state: the file pattern and the parsed messages of the files
+/// searched so far
+pub struct Server {
+    pattern: Option<String>,
+    cache: Mutex<FileCache>,
+    // Most parsed messages to hold; `None` keeps every file
+    cache_limit: Option<usize>,
+    // The one browser origin allowed to read responses (`--allow-origin`)
+    allowed_origin: Option<String>,
+    // Longer lines are skipped (`--max-line-bytes`)
+    max_line_bytes: usize,
+    connections: AtomicUsize,
+    started: Instant,
+    searches: AtomicUsize,
+}
+
+impl Server {
+    pub fn new(pattern: Option<String>) -> Self {
+        Self {
+            pattern,
+            cache: Mutex::new(FileCache::new()),
+            cache_limit: None,
+            allowed_origin: None,
+            max_line_bytes: line_reader::MAX_LINE_BYTES,
+            connections: AtomicUsize::new(0),
+            started: Instant::now(),
+            searches: AtomicUsize::new(0),
+        }
+    }
+
+    /// Hold at most `limit` parsed messages, evicting the files searched least
+    /// recently once there are more
+    pub fn with_cache_limit(mut self, limit: Option<usize>) -> Self {
+        self.cache_limit = limit;
+        self
+    }
+
+    /// Let pages from `origin` (e.g. `chrome-extension://...`) call the API and
+    /// read its responses; other pages are refused
+    pub fn with_allowed_origin(mut self, origin: Option<String>) -> Self {
+        self.allowed_origin = origin;
+        self
+    }
+
+    /// Skip lines longer than `limit` bytes rather than holding them in memory
+    pub fn with_max_line_bytes(mut self, limit: usize) -> Self {
+        self.max_line_bytes = limit;
+        self
+    }
+
+    /// Load every session file into memory now rather than on the first
+    /// request. With a cache limit only the most recently loaded files are kept.
+    pub fn warm(&self) -> Result<WarmReport> {
+        let start = Instant::now();
+        let files = self.refresh(|_| true)?;
+        Ok(WarmReport {
+            files: files.len(),
+            messages: files.iter().map(|(_, file)| file.message_count()).sum(),
+            elapsed: start.elapsed(),
+        })
+    }
+
+    /// Accept connections on `listener` forever, one thread per connection
+    pub fn run(self: Arc<Self>, listener: TcpListener) -> Result<()> {
+        for stream in listener.incoming() {
+            let stream = match stream {
+                Ok(stream) => stream,
+                Err(e) => {
+                    tracing::info!("Failed to accept connection: {e}");
+                    continue;
+                }
+            };
+            if let Err(e) = stream
+                .set_read_timeout(Some(IO_TIMEOUT))
+                .and_then(|()| stream.set_write_timeout(Some(IO_TIMEOUT)))
+            {
+                tracing::info!("Failed to set connection timeouts: {e}");
+                continue;
+            }
+            if self.connections.fetch_add(1, Ordering::AcqRel) >= MAX_CONNECTIONS {
+                self.connections.fetch_sub(1, Ordering::AcqRel);
+                let busy = Response::error(503, "too many connections");
+                if let Err(e) = busy.write_to(stream, None) {
+                    tracing::info!("Connection failed: {e}");
+                }
+                continue;
+            }
+            let server = self.clone();
+            std::thread::spawn(move || {
+                // Given back even if handling the request panics
+                let _slot = ConnectionSlot(&server.connections);
+                if let Err(e) = server.handle_connection(stream) {
+                    tracing::info!("Connection failed: {e}");
+                }
+            });
+        }
+        Ok(())
+    }
+
+    fn handle_connection(&self, stream: TcpStream) -> Result<()> {
+        let mut reader = BufReader::new(stream.try_clone()?);
+        let request = match read_request(&mut reader) {
+            Ok(request) => request,
+            Err(e) => return Response::error(400, &e.to_string()).write_to(stream, None),
+        };
+        let origin = request
+            .origin
+            .as_deref()
+            .filter(|origin| self.allowed_origin.as_deref() == Some(*origin));
+        let response = if !request.host.as_deref().is_some_and(is_local_host) {
+            Response::error(403, "only requests to localhost are served")
+        } else if request.origin.is_some() && origin.is_none() {
+            Response::error(403, "origin not allowed (see --allow-origin)")
+        } else if request.method != "GET" {
+            Response::error(405, "only GET is supported")
+        } else {
+            self.handle(&request.target)
+        };
+        response.write_to(stream, origin)
+    }
+
+    /// Answer a `GET` for `target` (path and query string)
+    pub fn handle(&self, target: &str) -> Response {
+        let (path, query) = target.split_once('?').unwrap_or((target, ""));
+        let params = parse_query_string(query);
+        let result = match path {
+            "/healthz" => Ok(Response::ok(json!({ "status": "ok" }))),
+            "/search" => self.search(&params),
+            "/stats" => self.stats(),
+            _ => return Response::error(404, &format!("no such endpoint: {path}")),
+        };
+        result.unwrap_or_else(|e| Response::error(500, &format!("{e:#}")))
+    }
+
+    fn search(&self, params: &HashMap<String, String>) -> Result<Response> {
+        let start = Instant::now();
+        let request = match SearchRequest::from_params(params) {
+            Ok(request) => request,
+            Err(e) => return Ok(Response::error(400, &e.to_string())),
+        };
+
+        // Files of other projects would be searched for nothing; leaving them
+        // unloaded keeps the cache for the projects that are asked about
+        let files = self.refresh(|path| {
+            request.project_path.as_deref().is_none_or(|project_path| {
+                path_encoding::file_belongs_to_project(&path.to_string_lossy(), project_path)
+            })
+        })?;
+        let mut collector = ResultCollector::new(Some(request.max), SearchOrder::Descending);
+        let per_file: Vec<Vec<SearchResult>> = files
+            .par_iter()
+            .map(|(path, file)| search_file(path, file, &request))
+            .collect();
+        for results in per_file {
+            collector.extend(results);
+        }
+        let (results, total_count) = collector.finish();
+        self.searches.fetch_add(1, Ordering::Relaxed);
+
+        Ok(Response::ok(json!({
+            "query": request.text,
+            "total_count": total_count,
+            "returned": results.len(),
+            "duration_ms": start.elapsed().as_millis() as u64,
+            "results": results,
+        })))
+    }
+
+    fn stats(&self) -> Result<Response> {
+        // Without a limit everything is held anyway; with one, loading every
+        // file just to count it would push out the ones being searched
+        if self.cache_limit.is_none() {
+            self.refresh(|_| true)?;
+        }
+        let cache = self.cache.lock().unwrap();
+        let mut sessions = HashSet::new();
+        for (_, file) in cache.files.iter() {
+            sessions.extend(
+                file.messages()
+                    .filter_map(|(_, message)| message.get_session_id()),
+            );
+        }
+        let lookups = cache.hits + cache.misses;
+        let hit_ratio = if lookups == 0 {
+            0.0
+        } else {
+            cache.hits as f64 / lookups as f64
+        };
+        Ok(Response::ok(json!({
+            "files": cache.files.len(),
+            "messages": cache.messages,
+            "sessions": sessions.len(),
+            "searches": self.searches.load(Ordering::Relaxed),
+            "uptime_seconds": self.started.elapsed().as_secs(),
+            "cache": {
+                "limit_messages": self.cache_limit,
+                "hits": cache.hits,
+                "misses": cache.misses,
+                "hit_ratio": hit_ratio,
+                "evictions": cache.evictions,
+            },
+        })))
+    }
+
+    // Bring the cached files `wanted` up to date with the disk: parse new and
+    // changed files, forget deleted ones, then evict down to the cache limit.
+    // A file found in the cache is a hit even if its new lines are parsed; one
+    // that has to be read from the start is a miss. Returns a snapshot of the
+    // wanted files to search.
+    fn refresh(&self, wanted: impl Fn(&Path) -> bool) -> Result<Vec<(PathBuf, Arc<StoredFile>)>> {
+        let paths = discover_claude_files(self.pattern.as_deref())?;
+        let current: Vec<(PathBuf, Option<Arc<StoredFile>>)> = {
+            let mut cache = self.cache.lock().unwrap();
+            cache.retain_paths(&paths);
+            paths
+                .into_iter()
+                .filter(|path| wanted(path))
+                .map(|path| {
+                    let stored = cache.files.peek(&path).cloned();
+                    if stored.is_some() {
+                        cache.hits += 1;
+                    } else {
+                        cache.misses += 1;
+                    }
+                    (path, stored)
+                })
+                .collect()
+        };
+
+        // Parse outside the lock so other requests are not held up
+        let loaded: Vec<(PathBuf, Result<Arc<StoredFile>>)> = current
+            .into_par_iter()
+            .map(|(path, stored)| {
+                let file = load_if_changed(&path, stored, self.max_line_bytes);
+                (path, file)
+            })
+            .collect();
+
+        let mut cache = self.cache.lock().unwrap();
+        let mut files = Vec::with_capacity(loaded.len());
+        for (path, file) in loaded {
+            match file {
+                Ok(file) => {
+                    cache.insert(path.clone(), file.clone());
+                    files.push((path, file));
+                }
+                Err(e) => {
+                    tracing::info!("Skipping {path:?}: {e}");
+                    cache.remove(&path);
+                }
+            }
+        }
+        if let Some(limit) = self.cache_limit {
+            cache.evict_to(limit);
+        }
+        Ok(files)
+    }
+}
+
+// Reuse `stored` unless the file's size or modification time changed. A file
+// that only grew is taken to have been appended to and only its new lines are
+// parsed; one that shrank or was compressed is read again from the start.
+fn load_if_changed(
+    path: &Path,
+    stored: Option<Arc<StoredFile>>,
+    max_line_bytes: usize,
+) -> Result<Arc<StoredFile>> {
+    let metadata = std::fs::metadata(path)?;
+    let modified = metadata.modified()?;
+    let len = metadata.len();
+    match stored {
+        Some(stored) if stored.modified == modified && stored.len == len => Ok(stored),
+        Some(stored) if len > stored.len && !compression::is_compressed_path(path) => {
+            let mut file = File::open(path)?;
+            file.seek(SeekFrom::Start(stored.parsed_len))?;
+            let read = read_messages(
+                path,
+                BufReader::new(file),
+                stored.lines,
+                stored.ends_open,
+                max_line_bytes,
+            )?;
+            let mut chunks = stored.chunks.clone();
+            if !read.messages.is_empty() {
+                chunks.push(Arc::new(read.messages));
+            }
+            Ok(Arc::new(StoredFile {
+                modified,
+                len,
+                parsed_len: stored.parsed_len + read.bytes,
+                lines: stored.lines + read.lines,
+                ends_open: if read.bytes > 0 {
+                    read.ends_open
+                } else {
+                    stored.ends_open
+                },
+                chunks,
+            }))
+        }
+        _ => {
+            // Plain files are read as they are on disk, BOM included, so that
+            // `parsed_len` is where an append starts
+            let reader: Box<dyn BufRead> = if compression::is_compressed_path(path) {
+                compression::open_session_reader(path)?
+            } else {
+                Box::new(BufReader::new(File::open(path)?))
+            };
+            let read = read_messages(path, reader, 0, false, max_line_bytes)?;
+            Ok(Arc::new(StoredFile {
+                modified,
+                len,
+                parsed_len: read.bytes,
+                lines: read.lines,
+                ends_open: read.ends_open,
+                chunks: vec![Arc::new(read.messages)],
+            }))
+        }
+    }
+}
+
+/// Messages read by [`read_messages`]
+struct ReadMessages {
+    messages: Vec<(usize, SessionMessage)>,
+    // Bytes and lines consumed, up to the end of the last complete line
+    bytes: u64,
+    lines: usize,
+    ends_open: bool,
+}
+
+// Parse every line of `reader` (reading `path`), numbering them after `lines_before`.
+//
+// A last line without a newline may be a message that is still being written.
+// If it does not parse it is left unconsumed, so the next read starts at it
+// again once the rest has been appended; if it parses it is complete and kept.
+// `continues_line` says the previous read kept such a line, so a newline at the
+// start of this one ends that line rather than being an empty line of its own.
+// Lines longer than `max_line_bytes` are skipped but still counted; an
+// unterminated one is deferred like a line that does not parse.
+fn read_messages(
+    path: &Path,
+    reader: impl BufRead,
+    lines_before: usize,
+    continues_line: bool,
+    max_line_bytes: usize,
+) -> Result<ReadMessages> {
+    let mut read = ReadMessages {
+        messages: Vec::new(),
+        bytes: 0,
+        lines: 0,
+        ends_open: false,
+    };
+    let mut reader = CountingReader::new(reader);
+    let mut line = Vec::with_capacity(16 * 1024);
+    loop {
+        let consumed_before = reader.consumed;
+        let bounded = line_reader::read_bounded_line(&mut reader, &mut line, max_line_bytes)?;
+        let len = reader.consumed - consumed_before;
+        let terminated = reader.last_byte == Some(b'\n');
+        match bounded {
+            BoundedLine::Eof => break,
+            BoundedLine::Line => {}
+            BoundedLine::TooLong if !terminated => {
+                tracing::debug!("Deferring incomplete last line ({len} bytes)");
+                break;
+            }
+            BoundedLine::TooLong => {
+                read.bytes += len;
+                read.lines += 1;
+                read.ends_open = false;
+                tracing::warn!(
+                    "Skipping line {} of {path:?}: longer than {max_line_bytes} bytes (see --max-line-bytes)",
+                    lines_before + read.lines
+                );
+                continue;
+            }
+        }
+        if continues_line && read.bytes == 0 && line.trim_ascii().is_empty() {
+            read.bytes += len;
+            read.ends_open = !terminated;
+            continue;
+        }
+        let mut content = line.trim_ascii_end();
+        if lines_before + read.lines == 0 {
+            content = content
+                .strip_prefix(compression::UTF8_BOM)
+                .unwrap_or(content);
+        }
+
+        let message = (!content.trim_ascii().is_empty())
+            .then(|| sonic_rs::from_slice::<SessionMessage>(content))
+            .transpose();
+        if !terminated && message.is_err() {
+            tracing::debug!("Deferring incomplete last line ({len} bytes)");
+            break;
+        }
+
+        read.bytes += len;
+        read.lines += 1;
+        read.ends_open = !terminated;
+        if let Ok(Some(message)) = message {
+            read.messages.push((lines_before + read.lines, message));
+        }
+    }
+    Ok(read)
+}
+
+// Counts the bytes consumed from the reader it wraps and remembers the last
+// one, so the length and ending of a line skipped by `read_bounded_line` are
+// known even though it was never buffered
+struct CountingReader<R> {
+    inner: R,
+    consumed: u64,
+    last_byte: Option<u8>,
+}
+
+impl<R: BufRead> CountingReader<R> {
+    fn new(inner: R) -> Self {
+        Self {
+            inner,
+            consumed: 0,
+            last_byte: None,
+        }
+    }
+}
+
+impl<R: BufRead> Read for CountingReader<R> {
+    fn read(&mut self, buf: &mut [u8]) -> std::io::Result<usize> {
+        let available = self.fill_buf()?;
+        let len = available.len().min(buf.len());
+        buf[..len].copy_from_slice(&available[..len]);
+        self.consume(len);
+        Ok(len)
+    }
+}
+
+impl<R: BufRead> BufRead for CountingReader<R> {
+    fn fill_buf(&mut self) -> std::io::Result<&[u8]> {
+        self.inner.fill_buf()
+    }
+
+    fn consume(&mut self, amount: usize) {
+        if amount > 0 {
+            // The buffer handed out by the last `fill_buf` is returned again
+            // without reading
+            if let Ok(available) = self.inner.fill_buf() {
+                self.last_byte = available.get(amount - 1).copied();
+            }
+        }
+        self.consumed += amount as u64;
+        self.inner.consume(amount);
+    }
+}
+
+/// The parameters of one `/search` request
+struct SearchRequest {
+    text: String,
+    query: QueryCondition,
+    role: Option<String>,
+    session_id: Option<String>,
+    project_path: Option<String>,
+    after: Option<DateTime<chrono::FixedOffset>>,
+    before: Option<DateTime<chrono::FixedOffset>>,
+    max: usize,
+}
+
+impl SearchRequest {
+    fn from_params(params: &HashMap<String, String>) -> Result<Self> {
+        let text = params.get("q").cloned().unwrap_or_default();
+        let query = parse_query(&text).with_context(|| format!("invalid query '{text}'"))?;
+        let timestamp = |name: &str| -> Result<_> {
+            params
+                .get(name)
+                .map(|value| {
+                    DateTime::parse_from_rfc3339(value)
+                        .with_context(|| format!("invalid {name} '{value}' (expected RFC3339)"))
+                })
+                .transpose()
+        };
+        let max = match params.get("max") {
+            Some(value) => value
+                .parse()
+                .with_context(|| format!("invalid max '{value}'"))?,
+            None => DEFAULT_MAX_RESULTS,
+        };
+        Ok(Self {
+            query,
+            text,
+            role: params.get("role").map(|role| role.to_ascii_lowercase()),
+            session_id: params.get("session").cloned(),
+            project_path: params.get("project").cloned(),
+            after: timestamp("after")?,
+            before: timestamp("before")?,
+            max,
+        })
+    }
+
+    fn accepts_message(&self, message: &SessionMessage) -> bool {
+        if self
+            .role
+            .as_deref()
+            .is_some_and(|role| message.get_type() != role)
+        {
+            return false;
+        }
+        self.session_id
+            .as_deref()
+            .is_none_or(|session_id| message.get_session_id() == Some(session_id))
+    }
+
+    // Checked once summaries have their timestamps
+    fn accepts_timestamp(&self, timestamp: &str) -> bool {
+        if self.after.is_none() && self.before.is_none() {
+            return true;
+        }
+        let Ok(timestamp) = DateTime::parse_from_rfc3339(timestamp) else {
+            return false;
+        };
+        self.after.is_none_or(|after| timestamp >= after)
+            && self.before.is_none_or(|before| timestamp <= before)
+    }
+}
+
+// Search one stored file, giving summaries the timestamp of their leaf message
+fn search_file(path: &Path, file: &StoredFile, request: &SearchRequest) -> Vec<SearchResult> {
+    let file_name = path.to_string_lossy();
+    if let Some(project_path) = &request.project_path
+        && !path_encoding::file_belongs_to_project(&file_name, project_path)
+    {
+        return Vec::new();
+    }
+
+    let file_time = DateTime::<chrono::Utc>::from(file.modified).to_rfc3339();
+    let mut summary_timestamps = SummaryTimestamps::new();
+    let mut latest_timestamp = None;
+    let mut results = Vec::new();
+    for (line_number, message) in file.messages() {
+        if let Some(timestamp) = message.get_timestamp() {
+            latest_timestamp = Some(timestamp);
+        }
+        if !request.accepts_message(message) {
+            continue;
+        }
+        let text = message.get_searchable_text_with(true);
+        if !request.query.evaluate(&text).unwrap_or(false) {
+            continue;
+        }
+        let timestamp = if message.get_type() == "summary" {
+            summary_timestamps.want(message.get_uuid().unwrap_or(""));
+            latest_timestamp.unwrap_or_default()
+        } else {
+            message
+                .get_timestamp()
+                .or(latest_timestamp)
+                .unwrap_or(&file_time)
+        };
+        results.push(SearchResult {
+            file: file_name.to_string(),
+            uuid: message.get_uuid().unwrap_or("").to_string(),
+            timestamp: timestamp.to_string(),
+            session_id: message.get_session_id().unwrap_or("").to_string(),
+            role: message.get_type().to_string(),
+            text: message.get_content_text_with(true),
+            message_type: message.get_type().to_string(),
+            query: request.query.clone(),
+            cwd: message.get_cwd().unwrap_or("").to_string(),
+            raw_json: None,
+            line_number: Some(*line_number),
+            request_id: message.get_request_id().map(str::to_string),
+            parent_uuid: message.get_parent_uuid().map(str::to_string),
+            is_sidechain: message.is_sidechain(),
+        });
+    }
+
+    // The whole file is in memory, so leaves before their summary count too
+    if results
+        .iter()
+        .any(|result| result.message_type == "summary")
+    {
+        for (_, message) in file.messages() {
+            if let (Some(uuid), Some(timestamp)) = (message.get_uuid(), message.get_timestamp()) {
+                summary_timestamps.observe(uuid, timestamp);
+            }
+        }
+    }
+    let first_after_summary = match file.messages().next() {
+        Some((_, message)) if message.get_type() == "summary" => file
+            .messages()
+            .find_map(|(_, message)| message.get_timestamp()),
+        _ => None,
+    };
+    summary_timestamps.resolve(&mut results, first_after_summary, &file_time);
+
+    results.retain(|result| request.accepts_timestamp(&result.timestamp));
+    results
+}
+
+/// A JSON response
+#[derive(Debug)]
+pub struct Response {
+    pub status: u16,
+    pub body: serde_json::Value,
+}
+
+impl Response {
+    fn ok(body: serde_json::Value) -> Self {
+        Self { status: 200, body }
+    }
+
+    fn error(status: u16, message: &str) -> Self {
+        Self {
+            status,
+            body: json!({ "error": message }),
+        }
+    }
+
+    // `allow_origin` is the request's origin when it may read the response
+    fn write_to(&self, mut stream: TcpStream, allow_origin: Option<&str>) -> Result<()> {
+        let body = self.body.to_string();
+        let reason = match self.status {
+            200 => "OK",
+            400 => "Bad Request",
+            403 => "Forbidden",
+            404 => "Not Found",
+            405 => "Method Not Allowed",
+            503 => "Service Unavailable",
+            _ => "Internal Server Error",
+        };
+        let cors = allow_origin
+            .map(|origin| format!("Access-Control-Allow-Origin: {origin}\r\nVary: Origin\r\n"))
+            .unwrap_or_default();
+        write!(
+            stream,
+            "HTTP/1.1 {} {reason}\r\nContent-Type: application/json\r\nContent-Length: {}\r\n{cors}Connection: close\r\n\r\n{body}",
+            self.status,
+            body.len()
+        )?;
+        stream.flush()?;
+        Ok(())
+    }
+}
+
+// One of the `MAX_CONNECTIONS` taken by a connection while it is served
+struct ConnectionSlot<'a>(&'a AtomicUsize);
+
+impl Drop for ConnectionSlot<'_> {
+    fn drop(&mut self) {
+        self.0.fetch_sub(1, Ordering::AcqRel);
+    }
+}
+
+/// The parts of a request the server looks at
+struct Request {
+    method: String,
+    target: String,
+    host: Option<String>,
+    origin: Option<String>,
+}
+
+// Read the request line and the headers, keeping `Host` and `Origin`
+fn read_request(reader: &mut impl BufRead) -> Result<Request> {
+    let request_line = read_header_line(reader)?;
+    let mut parts = request_line.split_whitespace();
+    let (Some(method), Some(target)) = (parts.next(), parts.next()) else {
+        anyhow::bail!("malformed request line");
+    };
+    let mut request = Request {
+        method: method.to_string(),
+        target: target.to_string(),
+        host: None,
+        origin: None,
+    };
+
+    for _ in 0..MAX_HEADERS {
+        let line = read_header_line(reader)?;
+        if line.is_empty() {
+            return Ok(request);
+        }
+        let Some((name, value)) = line.split_once(':') else {
+            continue;
+        };
+        let value = Some(value.trim().to_string());
+        if name.eq_ignore_ascii_case("host") {
+            request.host = value;
+        } else if name.eq_ignore_ascii_case("origin") {
+            request.origin = value;
+        }
+    }
+    anyhow::bail!("too many headers")
+}
+
+// `localhost`, `127.0.0.1` or `[::1]`, with or without a port. Any other name
+// may be a DNS rebinding attack pointing a web page's domain at this server.
+fn is_local_host(host: &str) -> bool {
+    let name = match host.rsplit_once(':') {
+        Some((name, port)) if !name.is_empty() && port.bytes().all(|b| b.is_ascii_digit()) => name,
+        _ => host,
+    };
+    ["localhost", "127.0.0.1", "[::1]"]
+        .iter()
+        .any(|local| name.eq_ignore_ascii_case(local))
+}
+
+fn read_header_line(reader: &mut impl BufRead) -> Result<String> {
+    let mut line = Vec::new();
+    reader
+        .by_ref()
+        .take(MAX_HEADER_BYTES as u64 + 1)
+        .read_until(b'\n', &mut line)?;
+    anyhow::ensure!(line.len() <= MAX_HEADER_BYTES, "header too long");
+    let line = String::from_utf8(line).context("header is not UTF-8")?;
+    Ok(line.trim_end_matches(['\r', '\n']).to_string())
+}
+
+/// Decode `a=1&b=two+words` into a map; later repeats of a key win
+pub fn parse_query_string(query: &str) -> HashMap<String, String> {
+    query
+        .split('&')
+        .filter(|pair| !pair.is_empty())
+        .map(|pair| {
+            let (key, value) = pair.split_once('=').unwrap_or((pair, ""));
+            (percent_decode(key), percent_decode(value))
+        })
+        .collect()
+}
+
+// `+` is a space and `%XX` a byte; malformed escapes are kept as they are
+fn percent_decode(input: &str) -> String {
+    let bytes = input.as_bytes();
+    let mut decoded = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        let escaped = bytes
+            .get(i + 1..i + 3)
+            .filter(|_| bytes[i] == b'%')
+            .and_then(|hex| std::str::from_utf8(hex).ok())
+            .and_then(|hex| u8::from_str_radix(hex, 16).ok());
+        match (bytes[i], escaped) {
+            (_, Some(byte)) => {
+                decoded.push(byte);
+                i += 3;
+                continue;
+            }
+            (b'+', None) => decoded.push(b' '),
+            (byte, None) => decoded.push(byte),
+        }
+        i += 1;
+    }
+    String::from_utf8_lossy(&decoded).into_owned()
+}
+
+/// Bind `addr` and serve requests until the process is stopped, loading the
+/// message set first unless `warm` is false, holding at most `cache_limit`
+/// parsed messages, letting pages from `allowed_origin` read responses and
+/// skipping lines longer than `max_line_bytes`
+pub fn serve(
+    addr: &str,
+    pattern: Option<String>,
+    warm: bool,
+    cache_limit: Option<usize>,
+    allowed_origin: Option<String>,
+    max_line_bytes: usize,
+) -> Result<()> {
+    let addr = listen_address(addr);
+    let listener = TcpListener::bind(
+        addr.to_socket_addrs()
+            .with_context(|| format!("invalid address '{addr}'"))?
+            .collect::<Vec<_>>()
+            .as_slice(),
+    )
+    .with_context(|| format!("failed to listen on {addr}"))?;
+    let server = Arc::new(
+        Server::new(pattern)
+            .with_cache_limit(cache_limit)
+            .with_allowed_origin(allowed_origin)
+            .with_max_line_bytes(max_line_bytes),
+    );
+    // Connections made meanwhile wait in the listen backlog
+    if warm {
+        eprintln!("{}", server.warm()?);
+    }
+    eprintln!("Listening on http://{}", listener.local_addr()?);
+    server.run(listener)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_fixtures;
+    use crate::utils::line_reader::MAX_LINE_BYTES;
+    use std::fs;
+    use std::io::Read;
+    use tempfile::tempdir;
+
+    fn user_line(uuid: &str, timestamp: &str, text: &str) -> String {
+        test_fixtures::user(uuid)
+            .timestamp(timestamp)
+            .text(text)
+            .to_string()
+    }
+
+    #[test]
+    fn test_parse_query_string() {
+        let params = parse_query_string("q=connection+reset%21&role=user&empty=&flag");
+        assert_eq!(params["q"], "connection reset!");
+        assert_eq!(params["role"], "user");
+        assert_eq!(params["empty"], "");
+        assert_eq!(params["flag"], "");
+        assert_eq!(percent_decode("%E2%9C%93 100%"), "✓ 100%");
+        assert_eq!(percent_decode("%zz%4"), "%zz%4");
+    }
+
+    #[test]
+    fn test_listen_address() {
+        assert_eq!(listen_address(":8080"), "127.0.0.1:8080");
+        assert_eq!(listen_address("0.0.0.0:9000"), "0.0.0.0:9000");
+    }
+
+    #[test]
+    fn test_search_endpoint() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let session = temp_dir.path().join("session.jsonl");
+        fs::write(
+            &session,
+            [
+                r#"{"type":"summary","summary":"Fixing the error handling","leafUuid":"2"}"#
+                    .to_string(),
+                user_line("1", "2024-01-01T00:00:00Z", "first error"),
+                user_line("2", "2024-01-03T00:00:00Z", "second error"),
+                user_line("3", "2024-01-04T00:00:00Z", "all good"),
+            ]
+            .join("\n"),
+        )?;
+        let server = Server::new(Some(format!("{}/*.jsonl", temp_dir.path().display())));
+        let report = server.warm()?;
+        assert_eq!((report.files, report.messages), (1, 4));
+
+        let response = server.handle("/search?q=error&max=1");
+        assert_eq!(response.status, 200);
+        assert_eq!(response.body["total_count"], 3);
+        assert_eq!(response.body["returned"], 1);
+        assert_eq!(response.body["results"][0]["uuid"], "2");
+
+        // The summary is dated by its leaf message, so the time filter applies
+        let response = server.handle("/search?q=error&role=summary&after=2024-01-02T00:00:00Z");
+        assert_eq!(response.body["total_count"], 1);
+        assert_eq!(
+            response.body["results"][0]["timestamp"],
+            "2024-01-03T00:00:00Z"
+        );
+
+        let response = server.handle("/search?q=error&before=2024-01-02T00:00:00Z&role=user");
+        assert_eq!(response.body["total_count"], 1);
+        assert_eq!(response.body["results"][0]["uuid"], "1");
+
+        // Appended messages are picked up on the next request
+        let mut content = fs::read_to_string(&session)?;
+        content.push('\n');
+        content.push_str(&user_line("4", "2024-01-05T00:00:00Z", "third error"));
+        fs::write(&session, content)?;
+        let response = server.handle("/search?q=error&role=user");
+        assert_eq!(response.body["total_count"], 3);
+
+        let response = server.handle("/stats");
+        assert_eq!(response.body["files"], 1);
+        assert_eq!(response.body["messages"], 5);
+        assert_eq!(response.body["sessions"], 1);
+        assert_eq!(response.body["searches"], 4);
+        Ok(())
+    }
+
+    #[test]
+    fn test_partial_last_line_waits_for_the_rest() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let path = temp_dir.path().join("live.jsonl");
+        let append = |bytes: &str| -> Result<()> {
+            let mut file = fs::OpenOptions::new()
+                .create(true)
+                .append(true)
+                .open(&path)?;
+            file.write_all(bytes.as_bytes())?;
+            Ok(())
+        };
+        let uuids = |file: &StoredFile| -> Vec<(usize, String)> {
+            file.messages()
+                .map(|(line, message)| (*line, message.get_uuid().unwrap().to_string()))
+                .collect()
+        };
+
+        // The second message is cut off mid-object, as while it is being written
+        let second = user_line("2", "2024-01-01T00:00:01Z", "second");
+        let (head, tail) = second.split_at(second.len() / 2);
+        append(&format!(
+            "{}\n{head}",
+            user_line("1", "2024-01-01T00:00:00Z", "first")
+        ))?;
+        let file = load_if_changed(&path, None, MAX_LINE_BYTES)?;
+        assert_eq!(uuids(&file), vec![(1, "1".to_string())]);
+        assert!(file.parsed_len < file.len);
+
+        // Completed: only the new bytes are parsed, from the start of the line
+        append(&format!("{tail}\n"))?;
+        let file = load_if_changed(&path, Some(file), MAX_LINE_BYTES)?;
+        assert_eq!(
+            uuids(&file),
+            vec![(1, "1".to_string()), (2, "2".to_string())]
+        );
+        assert_eq!(file.chunks.len(), 2);
+        assert_eq!(file.parsed_len, file.len);
+
+        // A last line that is complete JSON is kept even without its newline
+        append(&user_line("3", "2024-01-01T00:00:02Z", "third"))?;
+        let file = load_if_changed(&path, Some(file), MAX_LINE_BYTES)?;
+        assert_eq!(file.message_count(), 3);
+        append(&format!(
+            "\n{}\n",
+            user_line("4", "2024-01-01T00:00:03Z", "fourth")
+        ))?;
+        let file = load_if_changed(&path, Some(file), MAX_LINE_BYTES)?;
+        assert_eq!(uuids(&file).last(), Some(&(4, "4".to_string())));
+        assert_eq!(file.lines, 4);
+
+        // Rewritten shorter: read again from the start
+        fs::write(
+            &path,
+            format!("{}\n", user_line("9", "2024-01-02T00:00:00Z", "new")),
+        )?;
+        let file = load_if_changed(&path, Some(file), MAX_LINE_BYTES)?;
+        assert_eq!(uuids(&file), vec![(1, "9".to_string())]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_append_to_file_with_bom() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let path = temp_dir.path().join("bom.jsonl");
+        let mut content = compression::UTF8_BOM.to_vec();
+        content.extend(format!("{}\n", user_line("1", "2024-01-01T00:00:00Z", "first")).bytes());
+        fs::write(&path, &content)?;
+        let file = load_if_changed(&path, None, MAX_LINE_BYTES)?;
+        assert_eq!(file.parsed_len, file.len);
+
+        let mut appended = fs::OpenOptions::new().append(true).open(&path)?;
+        writeln!(
+            appended,
+            "{}",
+            user_line("2", "2024-01-01T00:00:01Z", "second")
+        )?;
+        writeln!(
+            appended,
+            "{}",
+            user_line("3", "2024-01-01T00:00:02Z", "third")
+        )?;
+        let file = load_if_changed(&path, Some(file), MAX_LINE_BYTES)?;
+
+        let lines: Vec<(usize, &str)> = file
+            .messages()
+            .map(|(line, message)| (*line, message.get_uuid().unwrap()))
+            .collect();
+        assert_eq!(lines, vec![(1, "1"), (2, "2"), (3, "3")]);
+        assert_eq!(file.lines, 3);
+        assert_eq!(file.parsed_len, file.len);
+        Ok(())
+    }
+
+    #[test]
+    fn test_overlong_lines_are_skipped_and_counted() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let path = temp_dir.path().join("long.jsonl");
+        let long = user_line("2", "2024-01-01T00:00:01Z", &"x".repeat(2048));
+        let (head, tail) = long.split_at(long.len() / 2);
+        fs::write(
+            &path,
+            format!(
+                "{}\n{head}",
+                user_line("1", "2024-01-01T00:00:00Z", "first")
+            ),
+        )?;
+        let uuids = |file: &StoredFile| -> Vec<(usize, String)> {
+            file.messages()
+                .map(|(line, message)| (*line, message.get_uuid().unwrap().to_string()))
+                .collect()
+        };
+
+        // The long line is still being written: left for the next read
+        let file = load_if_changed(&path, None, 1024)?;
+        assert_eq!(uuids(&file), vec![(1, "1".to_string())]);
+        assert_eq!(file.lines, 1);
+        assert!(file.parsed_len < file.len);
+
+        // Once complete it is skipped, and the lines after it keep their numbers
+        let mut appended = fs::OpenOptions::new().append(true).open(&path)?;
+        writeln!(appended, "{tail}")?;
+        writeln!(
+            appended,
+            "{}",
+            user_line("3", "2024-01-01T00:00:02Z", "third")
+        )?;
+        let file = load_if_changed(&path, Some(file), 1024)?;
+        assert_eq!(
+            uuids(&file),
+            vec![(1, "1".to_string()), (3, "3".to_string())]
+        );
+        assert_eq!(file.lines, 3);
+        assert_eq!(file.parsed_len, file.len);
+        Ok(())
+    }
+
+    #[test]
+    fn test_cache_limit_evicts_least_recently_searched() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let projects = temp_dir.path().join(".claude/projects");
+        for project in ["a", "b"] {
+            let dir = projects.join(format!("-{project}"));
+            fs::create_dir_all(&dir)?;
+            fs::write(
+                dir.join("session.jsonl"),
+                [
+                    user_line("1", "2024-01-01T00:00:00Z", project),
+                    user_line("2", "2024-01-02T00:00:00Z", "error"),
+                ]
+                .join("\n"),
+            )?;
+        }
+        let server = Server::new(Some(format!("{}/**/*.jsonl", projects.display())))
+            .with_cache_limit(Some(3));
+        let search = |project: &str| {
+            let response = server.handle(&format!("/search?q=error&project=/{project}"));
+            response.body["total_count"].clone()
+        };
+
+        // Only the project asked about is loaded
+        assert_eq!(search("a"), 1);
+        let stats = server.handle("/stats").body;
+        assert_eq!(
+            (stats["files"].clone(), stats["messages"].clone()),
+            (1.into(), 2.into())
+        );
+
+        // Loading the second pushes the first out
+        assert_eq!(search("b"), 1);
+        assert_eq!(search("b"), 1);
+        assert_eq!(search("a"), 1);
+        let stats = server.handle("/stats").body;
+        assert_eq!(stats["files"], 1);
+        assert_eq!(stats["messages"], 2);
+        let cache = &stats["cache"];
+        assert_eq!(cache["limit_messages"], 3);
+        assert_eq!(
+            (cache["hits"].clone(), cache["misses"].clone()),
+            (1.into(), 3.into())
+        );
+        assert_eq!(cache["hit_ratio"], 0.25);
+        assert_eq!(cache["evictions"], 2);
+        Ok(())
+    }
+
+    #[test]
+    fn test_bad_requests() {
+        let server = Server::new(Some("/nonexistent/*.jsonl".to_string()));
+        assert_eq!(server.handle("/healthz").status, 200);
+        assert_eq!(server.handle("/nope").status, 404);
+        assert_eq!(server.handle("/search?q=x&max=many").status, 400);
+        let response = server.handle("/search?q=x&after=yesterday");
+        assert_eq!(response.status, 400);
+        assert!(
+            response.body["error"]
+                .as_str()
+                .unwrap()
+                .contains("invalid after")
+        );
+    }
+
+    #[test]
+    fn test_http_round_trip() -> Result<()> {
+        let listener = TcpListener::bind("127.0.0.1:0")?;
+        let addr = listener.local_addr()?;
+        let server = Arc::new(
+            Server::new(Some("/nonexistent/*.jsonl".to_string()))
+                .with_allowed_origin(Some("chrome-extension://abc".to_string())),
+        );
+        std::thread::spawn(move || server.run(listener));
+
+        let request = |request: &str| -> Result<String> {
+            let mut stream = TcpStream::connect(addr)?;
+            stream.write_all(request.as_bytes())?;
+            let mut response = String::new();
+            stream.read_to_string(&mut response)?;
+            Ok(response)
+        };
+
+        let response = request("GET /healthz HTTP/1.1\r\nHost: localhost\r\n\r\n")?;
+        assert!(response.starts_with("HTTP/1.1 200 OK\r\n"));
+        assert!(response.contains("Content-Type: application/json\r\n"));
+        assert!(response.ends_with(r#"{"status":"ok"}"#));
+        assert!(!response.contains("Access-Control-Allow-Origin"));
+
+        let response = request("POST /search HTTP/1.1\r\nHost: localhost\r\n\r\n")?;
+        assert!(response.starts_with("HTTP/1.1 405 Method Not Allowed\r\n"));
+
+        // A rebound domain name, or no Host at all
+        let response = request("GET /search?q=x HTTP/1.1\r\nHost: evil.example:8080\r\n\r\n")?;
+        assert!(response.starts_with("HTTP/1.1 403 Forbidden\r\n"));
+        let response = request("GET /healthz HTTP/1.0\r\n\r\n")?;
+        assert!(response.starts_with("HTTP/1.1 403 Forbidden\r\n"));
+
+        // Only the allowed origin may read responses
+        let response = request(
+            "GET /healthz HTTP/1.1\r\nHost: 127.0.0.1\r\nOrigin: https://evil.example\r\n\r\n",
+        )?;
+        assert!(response.starts_with("HTTP/1.1 403 Forbidden\r\n"));
+        assert!(!response.contains("Access-Control-Allow-Origin"));
+        let response = request(
+            "GET /healthz HTTP/1.1\r\nHost: 127.0.0.1\r\nOrigin: chrome-extension://abc\r\n\r\n",
+        )?;
+        assert!(response.starts_with("HTTP/1.1 200 OK\r\n"));
+        assert!(response.contains("Access-Control-Allow-Origin: chrome-extension://abc\r\n"));
+        Ok(())
+    }
+
+    #[test]
+    fn test_is_local_host() {
+        for host in [
+            "localhost",
+            "localhost:8080",
+            "127.0.0.1:80",
+            "[::1]:8080",
+            "[::1]",
+        ] {
+            assert!(is_local_host(host), "{host}");
+        }
+        for host in [
+            "evil.example",
+            "localhost.evil.example",
+            "10.0.0.1:8080",
+            "",
+        ] {
+            assert!(!is_local_host(host), "{host}");
+        }
+    }
+}
@@ -0,0 +1,485 @@
+use crate::schemas::SessionMessage;
+use crate::schemas::session_message::{Content, ToolResultContent, UserContent};
+use crate::search::discover_claude_files;
+use crate::utils::{compression, line_reader};
+use anyhow::{Context, Result, bail};
+use chrono::DateTime;
+use std::collections::{HashMap, HashSet};
+use std::io::BufReader;
+use std::path::{Path, PathBuf};
+
+/// Load every message belonging to `session_id` from the files matching `pattern`,
+/// in on-disk order.
+///
+/// Sessions may span several files when they are resumed, so all matching files
+/// are read. Summaries are kept when their leaf message belongs to the session.
+/// Lines longer than `max_line_bytes` are skipped.
+pub fn load_session(
+    session_id: &str,
+    pattern: Option<&str>,
+    max_line_bytes: usize,
+) -> Result<Vec<SessionMessage>> {
+    let files =
+        discover_claude_files(pattern).context("failed to discover Claude session files")?;
+    load_session_from_files(&files, session_id, max_line_bytes)
+}
+
+/// Like [`load_session`], reading only `files`
+pub fn load_session_from_files(
+    files: &[PathBuf],
+    session_id: &str,
+    max_line_bytes: usize,
+) -> Result<Vec<SessionMessage>> {
+    let mut messages = Vec::new();
+    for file in files {
+        load_session_from_file(file, session_id, max_line_bytes, &mut messages)?;
+    }
+
+    if !messages.iter().any(|m| m.get_session_id().is_some()) {
+        bail!("no messages found for session_id '{session_id}'");
+    }
+
+    let uuids: HashSet<String> = messages
+        .iter()
+        .filter(|m| m.get_session_id().is_some())
+        .filter_map(|m| m.get_uuid().map(str::to_string))
+        .collect();
+
+    // Summaries carry no session ID; they belong here if their leaf does
+    messages.retain(|m| {
+        m.get_session_id().is_some() || m.get_uuid().is_some_and(|leaf| uuids.contains(leaf))
+    });
+    Ok(messages)
+}
+
+// Append the session's messages, and every summary, from `path`
+fn load_session_from_file(
+    path: &Path,
+    session_id: &str,
+    max_line_bytes: usize,
+    messages: &mut Vec<SessionMessage>,
+) -> Result<()> {
+    let file = compression::open_session_file(path)
+        .with_context(|| format!("failed to open file: {}", path.display()))?;
+    let mut reader = BufReader::with_capacity(64 * 1024, file);
+    let mut line_buffer = Vec::with_capacity(16 * 1024);
+    let needle = session_id.as_bytes();
+    let mut line_number = 0;
+
+    while line_reader::read_line_within(
+        &mut reader,
+        &mut line_buffer,
+        max_line_bytes,
+        path,
+        &mut line_number,
+    )
+    .with_context(|| format!("failed to read line from {}", path.display()))?
+    {
+        // Only summaries and lines mentioning the session ID need to be parsed
+        let is_summary = line_buffer
+            .windows(b"\"summary\"".len())
+            .any(|w| w == b"\"summary\"");
+        if !is_summary && !line_buffer.windows(needle.len()).any(|w| w == needle) {
+            continue;
+        }
+
+        let Ok(message) = sonic_rs::from_slice::<SessionMessage>(line_buffer.trim_ascii()) else {
+            continue;
+        };
+
+        if message.get_session_id().is_none_or(|id| id == session_id) {
+            messages.push(message);
+        }
+    }
+
+    Ok(())
+}
+
+/// How a session's messages are put in order
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum MessageOrder {
+    /// As stored on disk, file by file
+    File,
+    /// By timestamp, stable for ties; messages without one (summaries) come first
+    Time,
+    /// Replies after their parent, following `parentUuid` (see [`order_conversation`])
+    #[default]
+    Thread,
+}
+
+/// Put messages loaded by [`load_session`] into `order`
+pub fn order_messages(messages: Vec<SessionMessage>, order: MessageOrder) -> Vec<SessionMessage> {
+    match order {
+        MessageOrder::File => messages,
+        MessageOrder::Time => {
+            let mut messages = messages;
+            // Stable, so equal and missing timestamps stay in file order
+            messages.sort_by_cached_key(|m| {
+                m.get_timestamp()
+                    .and_then(|t| DateTime::parse_from_rfc3339(t).ok())
+            });
+            messages
+        }
+        MessageOrder::Thread => order_conversation(messages),
+    }
+}
+
+/// Order messages into conversation order using the `parentUuid` chain.
+///
+/// Messages are first sorted by timestamp, then emitted depth-first from each
+/// root so every reply follows its parent. Duplicate UUIDs (from resumed
+/// sessions) are dropped, keeping the first occurrence. Summaries come first.
+pub fn order_conversation(messages: Vec<SessionMessage>) -> Vec<SessionMessage> {
+    let (summaries, mut rest): (Vec<_>, Vec<_>) = messages
+        .into_iter()
+        .partition(|m| matches!(m, SessionMessage::Summary { .. }));
+
+    let mut seen = HashSet::new();
+    rest.retain(|m| seen.insert(m.get_uuid().unwrap_or("").to_string()));
+    rest.sort_by(|a, b| a.get_timestamp().cmp(&b.get_timestamp()));
+
+    let index_by_uuid: HashMap<&str, usize> = rest
+        .iter()
+        .enumerate()
+        .filter_map(|(i, m)| m.get_uuid().map(|uuid| (uuid, i)))
+        .collect();
+
+    let mut children: HashMap<usize, Vec<usize>> = HashMap::new();
+    let mut roots = Vec::new();
+    for (i, message) in rest.iter().enumerate() {
+        match message
+            .get_parent_uuid()
+            .and_then(|parent| index_by_uuid.get(parent))
+        {
+            Some(&parent) if parent != i => children.entry(parent).or_default().push(i),
+            _ => roots.push(i),
+        }
+    }
+
+    let mut order = Vec::with_capacity(rest.len());
+    let mut visited = vec![false; rest.len()];
+    let mut stack: Vec<usize> = roots.into_iter().rev().collect();
+    while let Some(i) = stack.pop() {
+        if std::mem::replace(&mut visited[i], true) {
+            continue;
+        }
+        order.push(i);
+        if let Some(kids) = children.get(&i) {
+            stack.extend(kids.iter().rev());
+        }
+    }
+    // Messages caught in a parent cycle are never reached from a root
+    order.extend((0..rest.len()).filter(|&i| !visited[i]));
+
+    let mut slots: Vec<Option<SessionMessage>> = rest.into_iter().map(Some).collect();
+    summaries
+        .into_iter()
+        .chain(order.into_iter().filter_map(|i| slots[i].take()))
+        .collect()
+}
+
+/// Split ordered messages into turns. With `merge_adjacent`, consecutive
+/// messages that [`same_turn`] joins (an assistant reply streamed as several
+/// lines, say) form one turn; otherwise every message is a turn of its own.
+pub fn group_turns(messages: &[SessionMessage], merge_adjacent: bool) -> Vec<&[SessionMessage]> {
+    if merge_adjacent {
+        messages.chunk_by(same_turn).collect()
+    } else {
+        messages.chunks(1).collect()
+    }
+}
+
+/// Whether `next` continues the turn of `previous`: same role, same session
+/// and same side of a sidechain. Summaries always stand alone.
+pub fn same_turn(previous: &SessionMessage, next: &SessionMessage) -> bool {
+    !matches!(previous, SessionMessage::Summary { .. })
+        && previous.get_type() == next.get_type()
+        && previous.get_session_id() == next.get_session_id()
+        && previous.is_sidechain() == next.is_sidechain()
+}
+
+/// Format an ordered conversation as readable turn-by-turn text
+pub fn format_conversation(messages: &[SessionMessage], use_color: bool) -> String {
+    format_turns(&group_turns(messages, false), use_color)
+}
+
+/// Format turns from [`group_turns`], each under a single header
+pub fn format_turns(turns: &[&[SessionMessage]], use_color: bool) -> String {
+    use colored::Colorize;
+
+    let mut output = String::new();
+    for turn in turns {
+        let Some(message) = turn.first() else {
+            continue;
+        };
+        let sidechain = message.is_sidechain();
+        let prefix = if sidechain { "  ┆ " } else { "" };
+
+        let mut header = format!(
+            "{} {}",
+            message.get_timestamp().unwrap_or("-"),
+            message.get_type().to_uppercase()
+        );
+        if sidechain {
+            header.push_str(" [sidechain]");
+        }
+        if turn.len() > 1 {
+            header.push_str(&format!(" ({} messages)", turn.len()));
+        }
+        if use_color {
+            let label = match message.get_type() {
+                "user" => header.bright_green().bold(),
+                "assistant" => header.bright_blue().bold(),
+                "system" => header.bright_yellow().bold(),
+                _ => header.bright_magenta().bold(),
+            };
+            header = label.to_string();
+        }
+
+        output.push_str(prefix);
+        output.push_str(&header);
+        output.push('\n');
+        for line in turn.iter().flat_map(turn_lines) {
+            output.push_str(prefix);
+            output.push_str("  ");
+            output.push_str(&line);
+            output.push('\n');
+        }
+        output.push('\n');
+    }
+
+    output
+}
+
+// Body of a single turn, with tool calls and results reduced to one line each
+fn turn_lines(message: &SessionMessage) -> Vec<String> {
+    let contents: &[Content] = match message {
+        SessionMessage::User { message, .. } => match &message.content {
+            UserContent::String(text) => return text.lines().map(str::to_string).collect(),
+            UserContent::Array(contents) => contents,
+            UserContent::Other(value) => return vec![value.to_string()],
+        },
+        SessionMessage::Assistant { message, .. } => &message.content,
+        _ => {
+            return message
+                .get_content_text()
+                .lines()
+                .map(str::to_string)
+                .collect();
+        }
+    };
+
+    let mut lines = Vec::new();
+    for content in contents {
+        match content {
+            Content::Text { text } => lines.extend(text.lines().map(str::to_string)),
+            Content::Thinking { thinking, .. } => {
+                lines.push(format!("(thinking) {}", truncate(thinking, 80)));
+            }
+            Content::ToolUse { name, input, .. } => {
+                lines.push(format!("→ {name} {}", truncate(&input.to_string(), 80)));
+            }
+            Content::ToolResult {
+                content, is_error, ..
+            } => {
+                let text = match content {
+                    Some(ToolResultContent::String(s)) => s.clone(),
+                    Some(ToolResultContent::TextArray(items)) => items
+                        .iter()
+                        .map(|item| item.text.as_str())
+                        .collect::<Vec<_>>()
+                        .join("\n"),
+                    _ => String::new(),
+                };
+                lines.push(format!(
+                    "← {}result ({} lines) {}",
+                    if is_error.unwrap_or(false) {
+                        "error "
+                    } else {
+                        ""
+                    },
+                    text.lines().count(),
+                    truncate(text.lines().next().unwrap_or(""), 80)
+                ));
+            }
+            Content::Image { .. } => lines.push("[Image]".to_string()),
+        }
+    }
+    lines
+}
+
+fn truncate(text: &str, max_chars: usize) -> String {
+    let single_line = text.split_whitespace().collect::<Vec<_>>().join(" ");
+    match single_line.char_indices().nth(max_chars) {
+        Some((end, _)) => format!("{}...", &single_line[..end]),
+        None => single_line,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_fixtures;
+    use crate::utils::line_reader::MAX_LINE_BYTES;
+    use serde_json::{Value, json};
+    use tempfile::tempdir;
+
+    fn user(uuid: &str, parent: Option<&str>, timestamp: &str, text: &str) -> Value {
+        test_fixtures::user(uuid)
+            .session("session-1")
+            .parent(parent)
+            .timestamp(timestamp)
+            .text(text)
+            .into_value()
+    }
+
+    fn assistant(uuid: &str, parent: &str, timestamp: &str, sidechain: bool) -> Value {
+        test_fixtures::assistant(uuid)
+            .session("session-1")
+            .parent(Some(parent))
+            .timestamp(timestamp)
+            .sidechain(sidechain)
+            .content(json!([
+                {"type": "text", "text": "Running it"},
+                {"type": "tool_use", "id": "t1", "name": "Bash", "input": {"command": "ls"}}
+            ]))
+            .into_value()
+    }
+
+    fn parse(values: Vec<Value>) -> Vec<SessionMessage> {
+        values
+            .into_iter()
+            .map(|v| serde_json::from_value(v).unwrap())
+            .collect()
+    }
+
+    fn uuids(messages: &[SessionMessage]) -> Vec<&str> {
+        messages.iter().filter_map(|m| m.get_uuid()).collect()
+    }
+
+    #[test]
+    fn test_order_conversation_follows_parent_chain() {
+        // u2 has an earlier timestamp than its parent a1 but must follow it
+        let messages = parse(vec![
+            user("u2", Some("a1"), "2026-02-01T10:00:00Z", "second"),
+            assistant("a1", "u1", "2026-02-01T10:00:05Z", false),
+            user("u1", None, "2026-02-01T10:00:01Z", "first"),
+        ]);
+
+        let ordered = order_conversation(messages);
+        assert_eq!(uuids(&ordered), vec!["u1", "a1", "u2"]);
+    }
+
+    #[test]
+    fn test_order_conversation_drops_duplicates_and_keeps_summary_first() {
+        let mut values = vec![
+            user("u1", None, "2026-02-01T10:00:01Z", "first"),
+            user("u1", None, "2026-02-01T10:00:01Z", "first"),
+        ];
+        values.push(json!({"type": "summary", "summary": "Topic", "leafUuid": "u1"}));
+
+        let ordered = order_conversation(parse(values));
+        assert_eq!(ordered.len(), 2);
+        assert_eq!(ordered[0].get_type(), "summary");
+    }
+
+    #[test]
+    fn test_order_messages_file_time_and_thread() -> Result<()> {
+        // On disk: a reply logged before its parent, a sidechain logged after
+        // the reply it predates, and an earlier root appended last
+        let dir = tempdir()?;
+        let lines = parse(vec![
+            user("u1", None, "2026-02-01T10:00:01Z", "first"),
+            user("u2", Some("a1"), "2026-02-01T10:00:04Z", "second"),
+            assistant("a1", "u1", "2026-02-01T10:00:02Z", false),
+            json!({"type": "summary", "summary": "Topic", "leafUuid": "u2"}),
+            assistant("s1", "u1", "2026-02-01T10:00:03Z", true),
+            user("u0", None, "2026-02-01T10:00:00Z", "earlier root"),
+        ]);
+        let body = lines
+            .iter()
+            .map(|m| serde_json::to_string(m).unwrap())
+            .collect::<Vec<_>>()
+            .join("\n");
+        std::fs::write(dir.path().join("session.jsonl"), body)?;
+        let pattern = format!("{}/*.jsonl", dir.path().display());
+        let load = || load_session("session-1", Some(&pattern), MAX_LINE_BYTES);
+
+        let file = order_messages(load()?, MessageOrder::File);
+        assert_eq!(uuids(&file), vec!["u1", "u2", "a1", "u2", "s1", "u0"]);
+
+        let time = order_messages(load()?, MessageOrder::Time);
+        assert_eq!(uuids(&time), vec!["u2", "u0", "u1", "a1", "s1", "u2"]);
+        assert_eq!(time[0].get_type(), "summary");
+
+        let thread = order_messages(load()?, MessageOrder::Thread);
+        assert_eq!(uuids(&thread), vec!["u2", "u0", "u1", "a1", "u2", "s1"]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_format_conversation_marks_sidechains_and_tools() {
+        let ordered = order_conversation(parse(vec![
+            user("u1", None, "2026-02-01T10:00:01Z", "list files"),
+            assistant("a1", "u1", "2026-02-01T10:00:02Z", true),
+        ]));
+
+        let output = format_conversation(&ordered, false);
+        assert!(output.contains("USER\n  list files"));
+        assert!(output.contains("  ┆ 2026-02-01T10:00:02Z ASSISTANT [sidechain]"));
+        assert!(output.contains("  ┆   → Bash {\"command\":\"ls\"}"));
+    }
+
+    #[test]
+    fn test_merge_adjacent_turns() {
+        let messages = parse(vec![
+            json!({"type": "summary", "summary": "Topic", "leafUuid": "a2"}),
+            json!({"type": "summary", "summary": "Older topic", "leafUuid": "a2"}),
+            user("u1", None, "2026-02-01T10:00:01Z", "list files"),
+            assistant("a1", "u1", "2026-02-01T10:00:02Z", false),
+            assistant("a2", "a1", "2026-02-01T10:00:03Z", false),
+            assistant("s1", "a2", "2026-02-01T10:00:04Z", true),
+            user("u2", Some("s1"), "2026-02-01T10:00:05Z", "thanks"),
+        ]);
+
+        let lengths =
+            |turns: Vec<&[SessionMessage]>| turns.iter().map(|t| t.len()).collect::<Vec<_>>();
+        assert_eq!(lengths(group_turns(&messages, false)), vec![1; 7]);
+        assert_eq!(
+            lengths(group_turns(&messages, true)),
+            vec![1, 1, 1, 2, 1, 1]
+        );
+
+        let output = format_turns(&group_turns(&messages, true), false);
+        assert!(output.contains(
+            "2026-02-01T10:00:02Z ASSISTANT (2 messages)\n  Running it\n  → Bash {\"command\":\"ls\"}\n  Running it\n"
+        ));
+        assert!(!output.contains("10:00:03Z"));
+    }
+
+    #[test]
+    fn test_load_session_reads_all_files() -> Result<()> {
+        let dir = tempdir()?;
+        let first = parse(vec![user("u1", None, "2026-02-01T10:00:01Z", "first")]);
+        let second = parse(vec![
+            user("u2", Some("u1"), "2026-02-01T10:00:02Z", "resumed"),
+            json!({"type": "summary", "summary": "Topic", "leafUuid": "u2"}),
+            json!({"type": "summary", "summary": "Other", "leafUuid": "x"}),
+        ]);
+        for (name, messages) in [("a.jsonl", first), ("b.jsonl", second)] {
+            let body = messages
+                .iter()
+                .map(|m| serde_json::to_string(m).unwrap())
+                .collect::<Vec<_>>()
+                .join("\n");
+            std::fs::write(dir.path().join(name), body)?;
+        }
+
+        let pattern = format!("{}/*.jsonl", dir.path().display());
+        let ordered =
+            order_conversation(load_session("session-1", Some(&pattern), MAX_LINE_BYTES)?);
+        assert_eq!(uuids(&ordered), vec!["u2", "u1", "u2"]);
+        assert!(load_session("missing", Some(&pattern), MAX_LINE_BYTES).is_err());
+        Ok(())
+    }
+}
@@ -1,4 +1,77 @@
+use crate::query::SearchOptions;
+use crate::schemas::{SessionMessage, Usage};
+use crate::search::SkipCounter;
+use crate::search::corpus::map_corpus_files;
+use chrono::DateTime;
+use serde::Serialize;
 use std::collections::{HashMap, HashSet};
+use std::path::PathBuf;
+
+// Number of project directories listed under "Top Projects"
+const TOP_PROJECTS: usize = 10;
+
+/// Token counts summed over some API responses
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize)]
+pub struct TokenCounts {
+    /// Responses that reported usage
+    pub messages: u64,
+    pub input_tokens: u64,
+    pub output_tokens: u64,
+    pub cache_creation_tokens: u64,
+    pub cache_read_tokens: u64,
+}
+
+impl TokenCounts {
+    pub fn add(&mut self, usage: &Usage) {
+        self.messages += 1;
+        self.input_tokens += u64::from(usage.input_tokens);
+        self.output_tokens += u64::from(usage.output_tokens);
+        self.cache_creation_tokens += u64::from(usage.cache_creation_input_tokens);
+        self.cache_read_tokens += u64::from(usage.cache_read_input_tokens);
+    }
+
+    pub fn merge(&mut self, other: &TokenCounts) {
+        self.messages += other.messages;
+        self.input_tokens += other.input_tokens;
+        self.output_tokens += other.output_tokens;
+        self.cache_creation_tokens += other.cache_creation_tokens;
+        self.cache_read_tokens += other.cache_read_tokens;
+    }
+
+    pub fn total_tokens(&self) -> u64 {
+        self.input_tokens + self.output_tokens + self.cache_creation_tokens + self.cache_read_tokens
+    }
+}
+
+/// The API responses whose usage has been counted.
+///
+/// Claude Code writes a line for each content block of a response (text,
+/// thinking, every tool call), and each line repeats the usage of the whole
+/// response. Counting every line would multiply the tokens, so usage is taken
+/// once per (`message.id`, `requestId`) pair. Messages without an id always
+/// count.
+#[derive(Debug, Default)]
+pub struct CountedResponses(HashSet<(String, String)>);
+
+impl CountedResponses {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// The usage of `message`, unless its response was already counted
+    pub fn first_usage<'a>(&mut self, message: &'a SessionMessage) -> Option<&'a Usage> {
+        let usage = message.get_usage()?;
+        match message.get_response_id().filter(|id| !id.is_empty()) {
+            Some(id) => {
+                let request_id = message.get_request_id().unwrap_or_default();
+                self.0
+                    .insert((id.to_string(), request_id.to_string()))
+                    .then_some(usage)
+            }
+            None => Some(usage),
+        }
+    }
+}
 
 #[derive(Debug, Default)]
 pub struct Statistics {
@@ -12,6 +85,8 @@ pub struct Statistics {
     pub project_count: usize,
     pub unique_projects: HashSet<String>,
     pub message_type_counts: HashMap<String, usize>,
+    pub project_message_counts: HashMap<String, usize>,
+    pub tokens: TokenCounts,
 }
 
 impl Statistics {
@@ -40,7 +115,7 @@ impl Statistics {
             .or_insert(0) += 1;
 
         // Track unique sessions
-        if self.unique_sessions.insert(session_id.to_string()) {
+        if !session_id.is_empty() && self.unique_sessions.insert(session_id.to_string()) {
             self.session_count += 1;
         }
 
@@ -50,11 +125,20 @@ impl Statistics {
         }
 
         // Track unique projects
-        if self.unique_projects.insert(cwd.to_string()) {
-            self.project_count += 1;
+        if !cwd.is_empty() {
+            if self.unique_projects.insert(cwd.to_string()) {
+                self.project_count += 1;
+            }
+            *self
+                .project_message_counts
+                .entry(cwd.to_string())
+                .or_insert(0) += 1;
         }
 
-        // Update timestamp range
+        // Update timestamp range (summaries have no timestamp)
+        if timestamp.is_empty() {
+            return;
+        }
         match &mut self.timestamp_range {
             None => {
                 self.timestamp_range = Some((timestamp.to_string(), timestamp.to_string()));
@@ -69,6 +153,189 @@ impl Statistics {
             }
         }
     }
+
+    /// Add the usage of one API response (see [`CountedResponses`])
+    pub fn add_usage(&mut self, usage: &Usage) {
+        self.tokens.add(usage);
+    }
+
+    pub fn total_tokens(&self) -> u64 {
+        self.tokens.total_tokens()
+    }
+
+    /// Project directories with the most messages, most active first
+    pub fn top_projects(&self, limit: usize) -> Vec<(&str, usize)> {
+        let mut projects: Vec<_> = self
+            .project_message_counts
+            .iter()
+            .map(|(project, count)| (project.as_str(), *count))
+            .collect();
+        projects.sort_by(|a, b| b.1.cmp(&a.1).then(a.0.cmp(b.0)));
+        projects.truncate(limit);
+        projects
+    }
+
+    /// Fold the counters of `other` into `self`
+    pub fn merge(&mut self, other: Statistics) {
+        self.total_messages += other.total_messages;
+        for (role, count) in other.role_counts {
+            *self.role_counts.entry(role).or_insert(0) += count;
+        }
+        for (message_type, count) in other.message_type_counts {
+            *self.message_type_counts.entry(message_type).or_insert(0) += count;
+        }
+        for (project, count) in other.project_message_counts {
+            *self.project_message_counts.entry(project).or_insert(0) += count;
+        }
+        self.unique_sessions.extend(other.unique_sessions);
+        self.session_count = self.unique_sessions.len();
+        self.unique_files.extend(other.unique_files);
+        self.file_count = self.unique_files.len();
+        self.unique_projects.extend(other.unique_projects);
+        self.project_count = self.unique_projects.len();
+
+        if let Some((earliest, latest)) = other.timestamp_range {
+            self.timestamp_range = Some(match self.timestamp_range.take() {
+                None => (earliest, latest),
+                Some((current_earliest, current_latest)) => {
+                    (current_earliest.min(earliest), current_latest.max(latest))
+                }
+            });
+        }
+
+        self.tokens.merge(&other.tokens);
+    }
+}
+
+/// Aggregate statistics over every message in `files` without running a query.
+///
+/// Messages are only counted, never turned into searchable text, so this is
+/// much cheaper than a match-all search. The role, session, user type, meta,
+/// project and time filters of `options` are honoured, and files and lines that
+/// can't be read are skipped into `skipped` (see [`map_corpus_files`]).
+pub fn collect_corpus_statistics(
+    files: &[PathBuf],
+    options: &SearchOptions,
+    skipped: &SkipCounter,
+) -> Statistics {
+    let per_file = map_corpus_files(files, options, skipped, |path, messages| {
+        let mut stats = Statistics::new();
+        let file = path.to_string_lossy();
+        let mut counted = CountedResponses::new();
+        for (_, message) in messages {
+            stats.add_message(
+                message.get_type(),
+                message.get_session_id().unwrap_or(""),
+                &file,
+                message.get_timestamp().unwrap_or(""),
+                message.get_cwd().unwrap_or(""),
+                message.get_type(),
+            );
+            if let Some(usage) = counted.first_usage(&message) {
+                stats.add_usage(usage);
+            }
+        }
+        stats
+    });
+
+    let mut total = Statistics::new();
+    for stats in per_file {
+        total.merge(stats);
+    }
+    total
+}
+
+/// Whether a message passes the role, session, user type, request, tool,
+/// meta, length and time filters of `options`, for passes over every message
+/// that don't run a query
+pub(crate) fn corpus_filter(options: &SearchOptions) -> impl Fn(&SessionMessage) -> bool + Sync {
+    let after = options
+        .after
+        .as_deref()
+        .and_then(|t| DateTime::parse_from_rfc3339(t).ok());
+    let before = options
+        .before
+        .as_deref()
+        .and_then(|t| DateTime::parse_from_rfc3339(t).ok());
+
+    move |message: &SessionMessage| -> bool {
+        if options
+            .role
+            .as_deref()
+            .is_some_and(|role| message.get_type() != role)
+        {
+            return false;
+        }
+        if let Some(session_id) = &options.session_id
+            && message.get_session_id() != Some(session_id.as_str())
+        {
+            return false;
+        }
+        if let Some(user_type) = &options.user_type
+            && message.get_user_type() != Some(user_type.as_str())
+        {
+            return false;
+        }
+        if let Some(request_id) = &options.request_id
+            && message.get_request_id() != Some(request_id.as_str())
+        {
+            return false;
+        }
+        if let Some(tool_use_id) = &options.tool_use_id
+            && !message.references_tool_use(tool_use_id)
+        {
+            return false;
+        }
+        if options.exclude_meta && message.is_meta() {
+            return false;
+        }
+        if options.tool_errors && !message.has_tool_error() {
+            return false;
+        }
+        if options.filters_length() && !options.accepts_length(&options.content_text(message)) {
+            return false;
+        }
+        if after.is_none() && before.is_none() {
+            return true;
+        }
+        let Some(timestamp) = message
+            .get_timestamp()
+            .and_then(|t| DateTime::parse_from_rfc3339(t).ok())
+        else {
+            return false;
+        };
+        after.is_none_or(|after| timestamp >= after)
+            && before.is_none_or(|before| timestamp <= before)
+    }
+}
+
+// Message types --type-breakdown always lists, in this order; others follow by name
+const BREAKDOWN_TYPES: [&str; 4] = ["user", "assistant", "system", "summary"];
+
+/// One-line split of matches by message type, e.g.
+/// `user: 12, assistant: 30, system: 3, summary: 1`
+pub fn format_type_breakdown(counts: &HashMap<String, usize>) -> String {
+    let mut others: Vec<_> = counts
+        .iter()
+        .filter(|(message_type, count)| {
+            **count > 0 && !BREAKDOWN_TYPES.contains(&message_type.as_str())
+        })
+        .collect();
+    others.sort();
+
+    BREAKDOWN_TYPES
+        .iter()
+        .map(|message_type| {
+            let count = counts.get(*message_type).copied().unwrap_or(0);
+            format!("{message_type}: {count}")
+        })
+        .chain(
+            others
+                .into_iter()
+                .map(|(message_type, count)| format!("{message_type}: {count}")),
+        )
+        .collect::<Vec<_>>()
+        .join(", ")
 }
 
 pub fn format_statistics(stats: &Statistics, use_color: bool) -> String {
@@ -153,6 +420,37 @@ pub fn format_statistics(stats: &Statistics, use_color: bool) -> String {
             }
         }
 
+        // Token usage
+        if stats.total_tokens() > 0 {
+            output.push_str(&format!("\n{}\n", "Tokens".bright_yellow().bold()));
+            output.push_str(&"─".repeat(30).bright_blue().to_string());
+            output.push('\n');
+
+            for (label, count) in token_rows(stats) {
+                output.push_str(&format!(
+                    "  {}: {}\n",
+                    label.bright_cyan(),
+                    count.to_string().bright_white()
+                ));
+            }
+        }
+
+        // Most active projects
+        let top_projects = stats.top_projects(TOP_PROJECTS);
+        if !top_projects.is_empty() {
+            output.push_str(&format!("\n{}\n", "Top Projects".bright_yellow().bold()));
+            output.push_str(&"─".repeat(30).bright_blue().to_string());
+            output.push('\n');
+
+            for (project, count) in top_projects {
+                output.push_str(&format!(
+                    "  {}: {}\n",
+                    project.bright_cyan(),
+                    count.to_string().bright_white()
+                ));
+            }
+        }
+
         // Time range
         if let Some((earliest, latest)) = &stats.timestamp_range {
             output.push_str(&format!("\n{}\n", "Time Range".bright_yellow().bold()));
@@ -214,6 +512,27 @@ pub fn format_statistics(stats: &Statistics, use_color: bool) -> String {
             }
         }
 
+        if stats.total_tokens() > 0 {
+            output.push_str("\nTokens\n");
+            output.push_str(&"-".repeat(30));
+            output.push('\n');
+
+            for (label, count) in token_rows(stats) {
+                output.push_str(&format!("  {label}: {count}\n"));
+            }
+        }
+
+        let top_projects = stats.top_projects(TOP_PROJECTS);
+        if !top_projects.is_empty() {
+            output.push_str("\nTop Projects\n");
+            output.push_str(&"-".repeat(30));
+            output.push('\n');
+
+            for (project, count) in top_projects {
+                output.push_str(&format!("  {project}: {count}\n"));
+            }
+        }
+
         if let Some((earliest, latest)) = &stats.timestamp_range {
             output.push_str("\nTime Range\n");
             output.push_str(&"-".repeat(30));
@@ -230,6 +549,16 @@ pub fn format_statistics(stats: &Statistics, use_color: bool) -> String {
     output
 }
 
+fn token_rows(stats: &Statistics) -> [(&'static str, u64); 5] {
+    [
+        ("Input", stats.tokens.input_tokens),
+        ("Output", stats.tokens.output_tokens),
+        ("Cache creation", stats.tokens.cache_creation_tokens),
+        ("Cache read", stats.tokens.cache_read_tokens),
+        ("Total", stats.total_tokens()),
+    ]
+}
+
 fn format_timestamp(timestamp: &str) -> String {
     use chrono::{DateTime, Local, TimeZone};
 
@@ -244,6 +573,24 @@ fn format_timestamp(timestamp: &str) -> String {
 #[cfg(test)]
 mod tests {
     use super::*;
+    use anyhow::Result;
+
+    #[test]
+    fn test_format_type_breakdown() {
+        let counts: HashMap<String, usize> = [
+            ("assistant", 30),
+            ("user", 12),
+            ("summary", 1),
+            ("progress", 2),
+        ]
+        .into_iter()
+        .map(|(message_type, count)| (message_type.to_string(), count))
+        .collect();
+        assert_eq!(
+            format_type_breakdown(&counts),
+            "user: 12, assistant: 30, system: 0, summary: 1, progress: 2"
+        );
+    }
 
     #[test]
     fn test_statistics_new() {
@@ -416,4 +763,108 @@ mod tests {
         assert!(output.contains("system: 1"));
         assert!(output.contains("summary: 1"));
     }
+
+    fn write_corpus(dir: &std::path::Path) -> PathBuf {
+        let path = dir.join("session.jsonl");
+        let lines = [
+            r#"{"type":"summary","summary":"Fixing the build","leafUuid":"a1"}"#,
+            r#"{"type":"user","message":{"role":"user","content":"hello"},"uuid":"u1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/project1","version":"1.0"}"#,
+            r#"{"type":"assistant","message":{"id":"m1","type":"message","role":"assistant","model":"claude","content":[{"type":"text","text":"hi"}],"stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":100,"cache_creation_input_tokens":10,"cache_read_input_tokens":20,"output_tokens":50}},"uuid":"a1","timestamp":"2024-01-02T00:00:00Z","sessionId":"s1","parentUuid":"u1","isSidechain":false,"userType":"external","cwd":"/project1","version":"1.0"}"#,
+            r#"{"type":"user","message":{"role":"user","content":"other"},"uuid":"u2","timestamp":"2024-01-03T00:00:00Z","sessionId":"s2","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/project2","version":"1.0"}"#,
+        ];
+        std::fs::write(&path, lines.join("\n")).unwrap();
+        path
+    }
+
+    #[test]
+    fn test_collect_corpus_statistics() -> Result<()> {
+        let temp_dir = tempfile::tempdir()?;
+        let path = write_corpus(temp_dir.path());
+        // An unreadable file is counted, not fatal
+        let missing = temp_dir.path().join("missing.jsonl");
+
+        let options = SearchOptions::default();
+        let skipped = SkipCounter::new();
+        let stats = collect_corpus_statistics(&[path, missing], &options, &skipped);
+
+        assert_eq!(skipped.files(), 1);
+
+        assert_eq!(stats.total_messages, 4);
+        assert_eq!(stats.file_count, 1);
+        assert_eq!(stats.session_count, 2);
+        assert_eq!(stats.message_type_counts.get("summary"), Some(&1));
+        assert_eq!(stats.message_type_counts.get("user"), Some(&2));
+        assert_eq!(stats.tokens.input_tokens, 100);
+        assert_eq!(stats.tokens.output_tokens, 50);
+        assert_eq!(stats.total_tokens(), 180);
+        assert_eq!(stats.top_projects(1), vec![("/project1", 2)]);
+        assert_eq!(
+            stats.timestamp_range,
+            Some((
+                "2024-01-01T00:00:00Z".to_string(),
+                "2024-01-03T00:00:00Z".to_string()
+            ))
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_collect_corpus_statistics_with_filters() -> Result<()> {
+        let temp_dir = tempfile::tempdir()?;
+        let path = write_corpus(temp_dir.path());
+
+        let options = SearchOptions {
+            role: Some("user".to_string()),
+            after: Some("2024-01-02T00:00:00Z".to_string()),
+            ..Default::default()
+        };
+        let stats = collect_corpus_statistics(&[path], &options, &SkipCounter::new());
+
+        assert_eq!(stats.total_messages, 1);
+        assert_eq!(stats.total_tokens(), 0);
+        assert_eq!(stats.top_projects(TOP_PROJECTS), vec![("/project2", 1)]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_merge_statistics() {
+        let mut first = Statistics::new();
+        first.add_message("user", "s1", "f1", "2024-01-02T00:00:00Z", "/p", "user");
+        let mut second = Statistics::new();
+        second.add_message("user", "s1", "f2", "2024-01-01T00:00:00Z", "/p", "user");
+
+        first.merge(second);
+
+        assert_eq!(first.total_messages, 2);
+        assert_eq!(first.session_count, 1);
+        assert_eq!(first.file_count, 2);
+        assert_eq!(first.project_message_counts.get("/p"), Some(&2));
+        assert_eq!(
+            first.timestamp_range,
+            Some((
+                "2024-01-01T00:00:00Z".to_string(),
+                "2024-01-02T00:00:00Z".to_string()
+            ))
+        );
+    }
+
+    #[test]
+    fn test_format_statistics_tokens_and_projects() {
+        let mut stats = Statistics::new();
+        stats.add_message(
+            "assistant",
+            "s1",
+            "f1",
+            "2024-01-01T00:00:00Z",
+            "/p",
+            "assistant",
+        );
+        stats.tokens.input_tokens = 7;
+
+        let output = format_statistics(&stats, false);
+        assert!(output.contains("Tokens"));
+        assert!(output.contains("Input: 7"));
+        assert!(output.contains("Top Projects"));
+        assert!(output.contains("/p: 1"));
+    }
 }
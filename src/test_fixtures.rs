@@ -0,0 +1,123 @@
+//! Session file lines for unit tests.
+//!
+//! `user("u1")` and `assistant("a1")` start a message with the fields every
+//! session line carries; tests set the few they care about and take the line
+//! with `to_string()`, or the `Value` with `into_value()`.
+
+use serde_json::{Value, json};
+use std::fmt;
+
+/// A session message line under construction
+#[derive(Debug, Clone)]
+pub struct MessageLine {
+    value: Value,
+}
+
+/// A user message whose text is `"hello"`
+pub fn user(uuid: &str) -> MessageLine {
+    MessageLine::new("user", uuid, json!({ "role": "user", "content": "hello" }))
+}
+
+/// An assistant reply whose text is `"reply <uuid>"`, using one input and one
+/// output token
+pub fn assistant(uuid: &str) -> MessageLine {
+    MessageLine::new(
+        "assistant",
+        uuid,
+        json!({
+            "id": format!("m-{uuid}"),
+            "type": "message",
+            "role": "assistant",
+            "model": "claude",
+            "content": [{ "type": "text", "text": format!("reply {uuid}") }],
+            "stop_reason": null,
+            "stop_sequence": null,
+            "usage": {
+                "input_tokens": 1,
+                "cache_creation_input_tokens": 0,
+                "cache_read_input_tokens": 0,
+                "output_tokens": 1
+            }
+        }),
+    )
+}
+
+impl MessageLine {
+    fn new(kind: &str, uuid: &str, message: Value) -> Self {
+        Self {
+            value: json!({
+                "type": kind,
+                "message": message,
+                "uuid": uuid,
+                "timestamp": "2024-01-01T00:00:00Z",
+                "sessionId": "s1",
+                "parentUuid": null,
+                "isSidechain": false,
+                "userType": "external",
+                "cwd": "/",
+                "version": "1"
+            }),
+        }
+    }
+
+    pub fn session(mut self, session_id: &str) -> Self {
+        self.value["sessionId"] = session_id.into();
+        self
+    }
+
+    pub fn timestamp(mut self, timestamp: &str) -> Self {
+        self.value["timestamp"] = timestamp.into();
+        self
+    }
+
+    pub fn cwd(mut self, cwd: &str) -> Self {
+        self.value["cwd"] = cwd.into();
+        self
+    }
+
+    pub fn parent(mut self, parent: Option<&str>) -> Self {
+        self.value["parentUuid"] = parent.into();
+        self
+    }
+
+    pub fn sidechain(mut self, sidechain: bool) -> Self {
+        self.value["isSidechain"] = sidechain.into();
+        self
+    }
+
+    /// Replace the message text: the whole content of a user message, or the
+    /// content blocks of an assistant reply
+    pub fn text(self, text: &str) -> Self {
+        if self.value["type"] == "user" {
+            self.content(text.into())
+        } else {
+            self.content(json!([{ "type": "text", "text": text }]))
+        }
+    }
+
+    /// Replace the message content with any JSON, such as a list of blocks
+    pub fn content(mut self, content: Value) -> Self {
+        self.value["message"]["content"] = content;
+        self
+    }
+
+    pub fn usage(mut self, input: u64, cache_creation: u64, cache_read: u64, output: u64) -> Self {
+        self.value["message"]["usage"] = json!({
+            "input_tokens": input,
+            "cache_creation_input_tokens": cache_creation,
+            "cache_read_input_tokens": cache_read,
+            "output_tokens": output
+        });
+        self
+    }
+
+    pub fn into_value(self) -> Value {
+        self.value
+    }
+}
+
+impl fmt::Display for MessageLine {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}", self.value)
+    }
+}
@@ -0,0 +1,300 @@
+//! Token usage per day for `--agg tokens`
+//!
+//! Sums the `usage` of assistant messages, either over every message that
+//! passes the filters (a pass that never builds searchable text, like corpus
+//! `--stats`) or over the results of a query. Each API response is counted
+//! once even though its usage is repeated on every line written for it (see
+//! [`CountedResponses`]). Days are local calendar days of each message's
+//! timestamp, as the rest of the output shows times locally.
+
+use crate::query::{SearchOptions, SearchResult};
+use crate::schemas::{SessionMessage, Usage};
+use crate::search::SkipCounter;
+use crate::search::corpus::map_corpus_files;
+use crate::stats::{CountedResponses, TokenCounts};
+use chrono::{DateTime, Local, NaiveDate};
+use std::collections::BTreeMap;
+use std::path::PathBuf;
+
+/// Token counts overall and per day
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct TokenUsage {
+    pub total: TokenCounts,
+    /// Per local day, oldest first; messages without a timestamp only count
+    /// towards the total
+    pub by_day: BTreeMap<NaiveDate, TokenCounts>,
+}
+
+impl TokenUsage {
+    /// Add `usage`, the usage of `message`'s response
+    pub fn add(&mut self, message: &SessionMessage, usage: &Usage) {
+        self.total.add(usage);
+        let day = message
+            .get_timestamp()
+            .and_then(|timestamp| DateTime::parse_from_rfc3339(timestamp).ok())
+            .map(|timestamp| timestamp.with_timezone(&Local).date_naive());
+        if let Some(day) = day {
+            self.by_day.entry(day).or_default().add(usage);
+        }
+    }
+
+    pub fn merge(&mut self, other: TokenUsage) {
+        self.total.merge(&other.total);
+        for (day, counts) in other.by_day {
+            self.by_day.entry(day).or_default().merge(&counts);
+        }
+    }
+
+    /// Usage of the messages a search matched, read back from their raw JSON
+    /// (the search has to keep it)
+    pub fn from_results(results: &[SearchResult]) -> Self {
+        let mut usage = Self::default();
+        let mut counted = CountedResponses::new();
+        for raw_json in results
+            .iter()
+            .filter_map(|result| result.raw_json.as_deref())
+        {
+            if let Ok(message) = sonic_rs::from_str::<SessionMessage>(raw_json)
+                && let Some(response_usage) = counted.first_usage(&message)
+            {
+                usage.add(&message, response_usage);
+            }
+        }
+        usage
+    }
+}
+
+/// Usage of every message in `files` that passes the filters of `options`,
+/// without running a query; files and lines that can't be read are skipped
+/// into `skipped`
+pub fn collect_corpus_token_usage(
+    files: &[PathBuf],
+    options: &SearchOptions,
+    skipped: &SkipCounter,
+) -> TokenUsage {
+    let per_file = map_corpus_files(files, options, skipped, |_, messages| {
+        let mut usage = TokenUsage::default();
+        let mut counted = CountedResponses::new();
+        for (_, message) in messages {
+            if let Some(response_usage) = counted.first_usage(&message) {
+                usage.add(&message, response_usage);
+            }
+        }
+        usage
+    });
+
+    let mut total = TokenUsage::default();
+    for usage in per_file {
+        total.merge(usage);
+    }
+    total
+}
+
+/// Table of usage per day with a total row
+pub fn format_token_usage(usage: &TokenUsage, use_color: bool) -> String {
+    use colored::Colorize;
+
+    let header = format!(
+        "{:<10}  {:>8}  {:>12}  {:>12}  {:>14}  {:>14}  {:>14}",
+        "Day", "Messages", "Input", "Output", "Cache creation", "Cache read", "Total"
+    );
+    let row = |label: &str, counts: &TokenCounts| {
+        format!(
+            "{label:<10}  {:>8}  {:>12}  {:>12}  {:>14}  {:>14}  {:>14}",
+            counts.messages,
+            counts.input_tokens,
+            counts.output_tokens,
+            counts.cache_creation_tokens,
+            counts.cache_read_tokens,
+            counts.total_tokens()
+        )
+    };
+
+    let mut output = String::new();
+    if use_color {
+        output.push_str(&header.bright_blue().bold().to_string());
+    } else {
+        output.push_str(&header);
+    }
+    output.push('\n');
+    for (day, counts) in &usage.by_day {
+        output.push_str(&row(&day.to_string(), counts));
+        output.push('\n');
+    }
+    let total = row("Total", &usage.total);
+    if use_color {
+        output.push_str(&total.bold().to_string());
+    } else {
+        output.push_str(&total);
+    }
+    output.push('\n');
+    output
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_fixtures;
+    use anyhow::Result;
+    use tempfile::tempdir;
+
+    fn assistant(uuid: &str, timestamp: &str, input: u64, output: u64) -> String {
+        test_fixtures::assistant(uuid)
+            .timestamp(timestamp)
+            .usage(input, 1, 2, output)
+            .to_string()
+    }
+
+    fn user() -> String {
+        test_fixtures::user("u1")
+            .timestamp("2024-05-01T12:00:00Z")
+            .to_string()
+    }
+
+    fn day(date: &str) -> NaiveDate {
+        date.parse().unwrap()
+    }
+
+    #[test]
+    fn test_corpus_token_usage_by_day() -> Result<()> {
+        let dir = tempdir()?;
+        let file = dir.path().join("session.jsonl");
+        std::fs::write(
+            &file,
+            [
+                user(),
+                assistant("a1", "2024-05-01T12:00:00Z", 100, 10),
+                assistant("a2", "2024-05-01T13:00:00Z", 200, 20),
+                assistant("a3", "2024-05-03T12:00:00Z", 300, 30),
+            ]
+            .join("\n"),
+        )?;
+
+        // A corrupt file is skipped and counted, not fatal
+        let corrupt = dir.path().join("corrupt.jsonl.gz");
+        std::fs::write(&corrupt, b"not gzip at all")?;
+
+        let files = [file, corrupt];
+        let skipped = SkipCounter::new();
+        let usage = collect_corpus_token_usage(&files, &SearchOptions::default(), &skipped);
+        assert_eq!(skipped.files(), 1);
+        assert_eq!(
+            usage.total,
+            TokenCounts {
+                messages: 3,
+                input_tokens: 600,
+                output_tokens: 60,
+                cache_creation_tokens: 3,
+                cache_read_tokens: 6,
+            }
+        );
+        assert_eq!(usage.by_day.len(), 2);
+        assert_eq!(usage.by_day[&day("2024-05-01")].input_tokens, 300);
+        assert_eq!(usage.by_day[&day("2024-05-03")].total_tokens(), 333);
+
+        // Filters apply as they do to corpus statistics
+        let options = SearchOptions {
+            after: Some("2024-05-02T00:00:00Z".to_string()),
+            ..Default::default()
+        };
+        let usage = collect_corpus_token_usage(&files, &options, &SkipCounter::new());
+        assert_eq!(usage.total.messages, 1);
+        assert_eq!(usage.total.output_tokens, 30);
+        Ok(())
+    }
+
+    #[test]
+    fn test_response_split_over_lines_is_counted_once() -> Result<()> {
+        // One API response written as a line per content block, each with
+        // the response's usage, then a second request reusing the message id
+        let line = |uuid: &str, request_id: &str| {
+            assistant(uuid, "2024-05-01T12:00:00Z", 100, 10)
+                .replace(&format!("m-{uuid}"), "m-shared")
+                .replace(
+                    r#""version":"1.0"}"#,
+                    &format!(r#""version":"1.0","requestId":"{request_id}"}}"#),
+                )
+        };
+        let dir = tempdir()?;
+        let file = dir.path().join("session.jsonl");
+        std::fs::write(
+            &file,
+            [
+                line("a1", "r1"),
+                line("a2", "r1"),
+                line("a3", "r1"),
+                line("a4", "r2"),
+            ]
+            .join("\n"),
+        )?;
+
+        // A corrupt file is skipped and counted, not fatal
+        let corrupt = dir.path().join("corrupt.jsonl.gz");
+        std::fs::write(&corrupt, b"not gzip at all")?;
+
+        let files = [file, corrupt];
+        let skipped = SkipCounter::new();
+        let usage = collect_corpus_token_usage(&files, &SearchOptions::default(), &skipped);
+        assert_eq!(skipped.files(), 1);
+        assert_eq!(usage.total.messages, 2);
+        assert_eq!(usage.total.input_tokens, 200);
+
+        let stats = crate::stats::collect_corpus_statistics(
+            &files,
+            &SearchOptions::default(),
+            &SkipCounter::new(),
+        );
+        assert_eq!(stats.tokens, usage.total);
+        Ok(())
+    }
+
+    #[test]
+    fn test_token_usage_from_results() {
+        let result = |raw_json: Option<String>| SearchResult {
+            file: "/p/session.jsonl".to_string(),
+            uuid: "a1".to_string(),
+            timestamp: "2024-05-01T12:00:00Z".to_string(),
+            session_id: "s1".to_string(),
+            role: "assistant".to_string(),
+            text: "reply".to_string(),
+            message_type: "assistant".to_string(),
+            query: crate::query::parse_query("reply").unwrap(),
+            cwd: "/project".to_string(),
+            raw_json,
+            line_number: Some(1),
+            request_id: None,
+            parent_uuid: None,
+            is_sidechain: false,
+        };
+        let usage = TokenUsage::from_results(&[
+            result(Some(assistant("a1", "2024-05-01T12:00:00Z", 100, 10))),
+            result(Some(user())),
+            result(None),
+        ]);
+        assert_eq!(usage.total.messages, 1);
+        assert_eq!(usage.total.total_tokens(), 113);
+        assert_eq!(usage.by_day[&day("2024-05-01")].messages, 1);
+    }
+
+    #[test]
+    fn test_format_token_usage() {
+        let mut usage = TokenUsage::default();
+        let counts = TokenCounts {
+            messages: 2,
+            input_tokens: 100,
+            output_tokens: 50,
+            cache_creation_tokens: 0,
+            cache_read_tokens: 10,
+        };
+        usage.by_day.insert(day("2024-05-01"), counts);
+        usage.total = counts;
+
+        let output = format_token_usage(&usage, false);
+        let lines: Vec<_> = output.lines().collect();
+        assert_eq!(lines.len(), 3);
+        assert!(lines[0].starts_with("Day"));
+        assert!(lines[1].starts_with("2024-05-01"));
+        assert!(lines[2].starts_with("Total"));
+        assert!(lines[2].ends_with(" 160"));
+    }
+}
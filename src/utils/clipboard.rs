@@ -0,0 +1,112 @@
+use anyhow::{Context, Result, bail};
+use std::io::{ErrorKind, Write};
+use std::process::{Command, Stdio};
+
+// Clipboard programs to try, in order, as `(program, args)`
+#[cfg(target_os = "macos")]
+const CLIPBOARD_COMMANDS: &[(&str, &[&str])] = &[("pbcopy", &[])];
+
+#[cfg(target_os = "linux")]
+const CLIPBOARD_COMMANDS: &[(&str, &[&str])] = &[
+    ("xclip", &["-selection", "clipboard"]),
+    ("wl-copy", &[]),
+    ("xsel", &["--clipboard", "--input"]),
+];
+
+// PowerShell's Set-Clipboard is given an explicit UTF-8 input encoding so
+// non-ASCII text round-trips; clip.exe reads stdin in the active OEM codepage
+// and mangles multibyte characters, so it is only the fallback.
+#[cfg(target_os = "windows")]
+const CLIPBOARD_COMMANDS: &[(&str, &[&str])] = &[
+    (
+        "powershell",
+        &[
+            "-NoProfile",
+            "-NonInteractive",
+            "-Command",
+            "[Console]::InputEncoding = [System.Text.Encoding]::UTF8; \
+             [Console]::In.ReadToEnd() | Set-Clipboard",
+        ],
+    ),
+    ("clip.exe", &[]),
+];
+
+#[cfg(not(any(target_os = "macos", target_os = "linux", target_os = "windows")))]
+const CLIPBOARD_COMMANDS: &[(&str, &[&str])] = &[];
+
+/// Put `text` on the system clipboard using the first clipboard program that
+/// is installed (pbcopy on macOS; xclip, wl-copy or xsel on Linux; PowerShell
+/// or clip.exe on Windows)
+pub fn copy_to_clipboard(text: &str) -> Result<()> {
+    for (program, args) in CLIPBOARD_COMMANDS {
+        match pipe_to(program, args, text) {
+            Err(e) if is_not_found(&e) => continue,
+            result => return result,
+        }
+    }
+
+    let programs: Vec<&str> = CLIPBOARD_COMMANDS.iter().map(|(p, _)| *p).collect();
+    if programs.is_empty() {
+        bail!("Clipboard not supported on this platform");
+    }
+    bail!("No clipboard program found (tried {})", programs.join(", "))
+}
+
+// Run `program` with `text` on its stdin and wait for it to succeed
+fn pipe_to(program: &str, args: &[&str], text: &str) -> Result<()> {
+    let mut child = Command::new(program)
+        .args(args)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::null())
+        .spawn()
+        .with_context(|| format!("Failed to spawn {program}"))?;
+
+    if let Some(mut stdin) = child.stdin.take()
+        && let Err(e) = stdin.write_all(text.as_bytes())
+        // A program that exits without reading is reported by its status below
+        && e.kind() != ErrorKind::BrokenPipe
+    {
+        return Err(e).with_context(|| format!("Failed to write to {program}"));
+    }
+
+    let status = child
+        .wait()
+        .with_context(|| format!("Failed to wait for {program}"))?;
+    if !status.success() {
+        bail!("{program} exited with {status}");
+    }
+    Ok(())
+}
+
+fn is_not_found(error: &anyhow::Error) -> bool {
+    error
+        .downcast_ref::<std::io::Error>()
+        .is_some_and(|e| e.kind() == ErrorKind::NotFound)
+}
+
+#[cfg(all(test, unix))]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_pipe_to_writes_stdin() -> Result<()> {
+        let temp_dir = tempdir()?;
+        let output = temp_dir.path().join("clipboard");
+        let script = format!("cat > '{}'", output.display());
+
+        pipe_to("sh", &["-c", &script], "héllo\nworld")?;
+        assert_eq!(std::fs::read_to_string(&output)?, "héllo\nworld");
+        Ok(())
+    }
+
+    #[test]
+    fn test_missing_program_is_not_found() {
+        let error = pipe_to("ccms-no-such-clipboard", &[], "text").unwrap_err();
+        assert!(is_not_found(&error));
+
+        let error = pipe_to("sh", &["-c", "exit 3"], "text").unwrap_err();
+        assert!(!is_not_found(&error));
+        assert!(error.to_string().contains("exited with"));
+    }
+}
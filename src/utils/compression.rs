@@ -0,0 +1,168 @@
+use super::mapped_file::{MMAP_THRESHOLD, MappedFile};
+use flate2::read::MultiGzDecoder;
+use std::fs::File;
+use std::io::{self, BufRead, BufReader, Read};
+use std::path::Path;
+
+/// Whether a session file is gzip-compressed (`.gz` extension)
+pub fn is_gzip_path(path: &Path) -> bool {
+    path.extension()
+        .is_some_and(|ext| ext.eq_ignore_ascii_case("gz"))
+}
+
+/// Whether a session file is zstd-compressed (`.zst` extension)
+pub fn is_zstd_path(path: &Path) -> bool {
+    path.extension()
+        .is_some_and(|ext| ext.eq_ignore_ascii_case("zst"))
+}
+
+/// Whether a session file is compressed, so it can only be read from the start
+/// through a decoder: no memory mapping, seeking or reading it in chunks
+pub fn is_compressed_path(path: &Path) -> bool {
+    is_gzip_path(path) || is_zstd_path(path)
+}
+
+/// Byte order mark some Windows tools write at the start of UTF-8 files
+pub const UTF8_BOM: &[u8] = b"\xEF\xBB\xBF";
+
+/// Open a session file for reading, transparently decompressing `.gz` and
+/// `.zst` files as they are read and skipping a leading UTF-8 byte order mark
+pub fn open_session_file(path: &Path) -> io::Result<Box<dyn Read + Send>> {
+    let file = File::open(path)?;
+    if is_gzip_path(path) {
+        skip_bom(MultiGzDecoder::new(file))
+    } else if is_zstd_path(path) {
+        skip_bom(zstd::stream::read::Decoder::new(file)?)
+    } else {
+        skip_bom(file)
+    }
+}
+
+// Drop a leading BOM, which JSON parsers reject on the first line; anything
+// else read while checking is put back in front of the rest
+fn skip_bom<R: Read + Send + 'static>(mut reader: R) -> io::Result<Box<dyn Read + Send>> {
+    let mut head = Vec::with_capacity(UTF8_BOM.len());
+    (&mut reader)
+        .take(UTF8_BOM.len() as u64)
+        .read_to_end(&mut head)?;
+    if head == UTF8_BOM {
+        head.clear();
+    }
+    Ok(Box::new(io::Cursor::new(head).chain(reader)))
+}
+
+/// Open a session file for line-by-line reading. Large plain files are
+/// memory-mapped (see [`MMAP_THRESHOLD`]); smaller and compressed files are
+/// read through a 64 KiB buffer.
+pub fn open_session_reader(path: &Path) -> io::Result<Box<dyn BufRead + Send>> {
+    if !is_compressed_path(path) && std::fs::metadata(path)?.len() >= MMAP_THRESHOLD {
+        let mut mapped = MappedFile::open(path)?;
+        if mapped.fill_buf()?.starts_with(UTF8_BOM) {
+            mapped.consume(UTF8_BOM.len());
+        }
+        return Ok(Box::new(mapped));
+    }
+    Ok(Box::new(BufReader::with_capacity(
+        64 * 1024,
+        open_session_file(path)?,
+    )))
+}
+
+/// Read a whole session file into a string, decompressing `.gz` and `.zst` files
+pub fn read_session_file(path: &Path) -> io::Result<String> {
+    let mut content = String::new();
+    open_session_file(path)?.read_to_string(&mut content)?;
+    Ok(content)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use flate2::Compression;
+    use flate2::write::GzEncoder;
+    use std::io::Write;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_is_gzip_path() {
+        assert!(is_gzip_path(Path::new("/a/session.jsonl.gz")));
+        assert!(is_gzip_path(Path::new("/a/session.jsonl.GZ")));
+        assert!(!is_gzip_path(Path::new("/a/session.jsonl")));
+        assert!(is_zstd_path(Path::new("/a/session.jsonl.zst")));
+        assert!(!is_zstd_path(Path::new("/a/session.jsonl.gz")));
+        assert!(is_compressed_path(Path::new("/a/session.jsonl.zst")));
+        assert!(is_compressed_path(Path::new("/a/session.jsonl.gz")));
+        assert!(!is_compressed_path(Path::new("/a/session.jsonl")));
+    }
+
+    #[test]
+    fn test_open_session_file_plain_and_compressed() -> io::Result<()> {
+        let temp_dir = tempdir()?;
+        let plain = temp_dir.path().join("session.jsonl");
+        let gzipped = temp_dir.path().join("session.jsonl.gz");
+        let zstd = temp_dir.path().join("session.jsonl.zst");
+
+        std::fs::write(&plain, "line1\nline2\n")?;
+        let mut encoder = GzEncoder::new(File::create(&gzipped)?, Compression::default());
+        encoder.write_all(b"line1\nline2\n")?;
+        encoder.finish()?;
+        std::fs::write(&zstd, zstd::encode_all(&b"line1\nline2\n"[..], 0)?)?;
+
+        for path in [plain, gzipped, zstd] {
+            assert_eq!(read_session_file(&path)?, "line1\nline2\n");
+        }
+        Ok(())
+    }
+
+    #[test]
+    fn test_leading_bom_is_skipped() -> io::Result<()> {
+        let temp_dir = tempdir()?;
+        let plain = temp_dir.path().join("session.jsonl");
+        let gzipped = temp_dir.path().join("session.jsonl.gz");
+        let large = temp_dir.path().join("large.jsonl");
+
+        let content = [UTF8_BOM, b"line1\r\nline2\r\n"].concat();
+        std::fs::write(&plain, &content)?;
+        let mut encoder = GzEncoder::new(File::create(&gzipped)?, Compression::default());
+        encoder.write_all(&content)?;
+        encoder.finish()?;
+        for path in [&plain, &gzipped] {
+            assert_eq!(read_session_file(path)?, "line1\r\nline2\r\n");
+            let mut first = String::new();
+            open_session_reader(path)?.read_line(&mut first)?;
+            assert_eq!(first, "line1\r\n");
+        }
+
+        let mut content = UTF8_BOM.to_vec();
+        content.resize(MMAP_THRESHOLD as usize + 1, b'x');
+        std::fs::write(&large, &content)?;
+        let mut first = [0; 1];
+        open_session_reader(&large)?.read_exact(&mut first)?;
+        assert_eq!(&first, b"x");
+
+        // Short files and a BOM that is not at the start are left alone
+        std::fs::write(&plain, "x")?;
+        assert_eq!(read_session_file(&plain)?, "x");
+        std::fs::write(&plain, [b"x", UTF8_BOM].concat())?;
+        assert_eq!(read_session_file(&plain)?, "x\u{feff}");
+        Ok(())
+    }
+
+    #[test]
+    fn test_open_session_reader_maps_large_files() -> io::Result<()> {
+        let temp_dir = tempdir()?;
+        let small = temp_dir.path().join("small.jsonl");
+        let large = temp_dir.path().join("large.jsonl");
+
+        std::fs::write(&small, "line1\nline2\n")?;
+        let line = format!("{}\n", "x".repeat(1023));
+        std::fs::write(&large, line.repeat(MMAP_THRESHOLD as usize / 1024 + 1))?;
+
+        assert_eq!(open_session_reader(&small)?.lines().count(), 2);
+        assert_eq!(
+            open_session_reader(&large)?.lines().count(),
+            MMAP_THRESHOLD as usize / 1024 + 1
+        );
+        Ok(())
+    }
+}
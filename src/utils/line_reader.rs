@@ -0,0 +1,265 @@
+use std::io::{self, BufRead};
+use std::ops::Range;
+use std::path::Path;
+
+/// Longest JSONL line that is parsed; longer lines are skipped without being buffered
+pub const MAX_LINE_BYTES: usize = 32 * 1024 * 1024;
+
+/// Outcome of [`read_bounded_line`]
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum BoundedLine {
+    /// End of input, nothing was read
+    Eof,
+    /// A complete line (including its `\n`, if any) is in the buffer
+    Line,
+    /// The line exceeded the limit and was skipped; the buffer is empty
+    TooLong,
+}
+
+/// Read the next `\n`-terminated line into `buf`, like `read_until`, but stop
+/// buffering once the line grows past `limit` bytes. The rest of such a line is
+/// consumed and discarded so reading can continue with the following line.
+pub fn read_bounded_line<R: BufRead>(
+    reader: &mut R,
+    buf: &mut Vec<u8>,
+    limit: usize,
+) -> io::Result<BoundedLine> {
+    buf.clear();
+    let mut read_any = false;
+    let mut too_long = false;
+
+    loop {
+        let available = match reader.fill_buf() {
+            Ok(available) => available,
+            Err(e) if e.kind() == io::ErrorKind::Interrupted => continue,
+            Err(e) => return Err(e),
+        };
+        if available.is_empty() {
+            break;
+        }
+        read_any = true;
+
+        let (chunk_len, done) = match available.iter().position(|&b| b == b'\n') {
+            Some(index) => (index + 1, true),
+            None => (available.len(), false),
+        };
+        if !too_long {
+            if buf.len() + chunk_len > limit {
+                too_long = true;
+                buf.clear();
+            } else {
+                buf.extend_from_slice(&available[..chunk_len]);
+            }
+        }
+        reader.consume(chunk_len);
+
+        if done {
+            break;
+        }
+    }
+
+    Ok(match (read_any, too_long) {
+        (false, _) => BoundedLine::Eof,
+        (true, false) => BoundedLine::Line,
+        (true, true) => BoundedLine::TooLong,
+    })
+}
+
+/// Read the next line no longer than `limit` into `buf` with [`read_bounded_line`],
+/// skipping longer lines with a warning. `line_number` counts every line read,
+/// skipped ones included, so it is the 1-based number of the line in `buf`.
+/// Returns `false` at the end of input.
+pub fn read_line_within<R: BufRead>(
+    reader: &mut R,
+    buf: &mut Vec<u8>,
+    limit: usize,
+    path: &Path,
+    line_number: &mut usize,
+) -> io::Result<bool> {
+    loop {
+        match read_bounded_line(reader, buf, limit)? {
+            BoundedLine::Eof => return Ok(false),
+            BoundedLine::Line => {
+                *line_number += 1;
+                return Ok(true);
+            }
+            BoundedLine::TooLong => {
+                *line_number += 1;
+                tracing::warn!(
+                    "Skipping line {line_number} of {path:?}: longer than {limit} bytes (see --max-line-bytes)"
+                );
+            }
+        }
+    }
+}
+
+/// Split `buf` into at most `parts` ranges of about the same size, each ending
+/// just after a `\n` (the last one at the end of `buf`), so no line is cut
+pub fn split_lines(buf: &[u8], parts: usize) -> Vec<Range<usize>> {
+    let mut ranges = Vec::with_capacity(parts);
+    let mut start = 0;
+    for part in 1..parts {
+        // A line starting right at the target stays whole in the next range
+        let from = (buf.len() * part / parts).max(start + 1) - 1;
+        let Some(newline) = memchr::memchr(b'\n', &buf[from..]) else {
+            break;
+        };
+        let end = from + newline + 1;
+        ranges.push(start..end);
+        start = end;
+    }
+    if start < buf.len() || ranges.is_empty() {
+        ranges.push(start..buf.len());
+    }
+    ranges
+}
+
+/// Iterate over the lines of `buf` without copying or collecting them.
+///
+/// Lines end at `\n` or `\r\n`, which are not included, exactly like
+/// [`str::lines`]: empty lines are yielded as empty slices, and a trailing
+/// newline does not produce a final empty line.
+pub fn lines(buf: &[u8]) -> Lines<'_> {
+    Lines { rest: buf }
+}
+
+/// Iterator returned by [`lines`]
+#[derive(Debug, Clone)]
+pub struct Lines<'a> {
+    rest: &'a [u8],
+}
+
+impl<'a> Iterator for Lines<'a> {
+    type Item = &'a [u8];
+
+    fn next(&mut self) -> Option<&'a [u8]> {
+        if self.rest.is_empty() {
+            return None;
+        }
+        match self.rest.iter().position(|&b| b == b'\n') {
+            Some(index) => {
+                let line = &self.rest[..index];
+                self.rest = &self.rest[index + 1..];
+                Some(line.strip_suffix(b"\r").unwrap_or(line))
+            }
+            None => Some(std::mem::take(&mut self.rest)),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::io::BufReader;
+
+    #[test]
+    fn test_reads_lines_like_read_until() -> io::Result<()> {
+        let mut reader = BufReader::with_capacity(4, &b"first\nsecond\nlast"[..]);
+        let mut buf = Vec::new();
+
+        assert_eq!(
+            read_bounded_line(&mut reader, &mut buf, 100)?,
+            BoundedLine::Line
+        );
+        assert_eq!(buf, b"first\n");
+        assert_eq!(
+            read_bounded_line(&mut reader, &mut buf, 100)?,
+            BoundedLine::Line
+        );
+        assert_eq!(buf, b"second\n");
+        assert_eq!(
+            read_bounded_line(&mut reader, &mut buf, 100)?,
+            BoundedLine::Line
+        );
+        assert_eq!(buf, b"last");
+        assert_eq!(
+            read_bounded_line(&mut reader, &mut buf, 100)?,
+            BoundedLine::Eof
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_skips_overlong_line_and_continues() -> io::Result<()> {
+        let input = format!("short\n{}\nafter\n", "x".repeat(50));
+        let mut reader = BufReader::with_capacity(8, input.as_bytes());
+        let mut buf = Vec::new();
+
+        assert_eq!(
+            read_bounded_line(&mut reader, &mut buf, 16)?,
+            BoundedLine::Line
+        );
+        assert_eq!(
+            read_bounded_line(&mut reader, &mut buf, 16)?,
+            BoundedLine::TooLong
+        );
+        assert!(buf.is_empty());
+        assert_eq!(
+            read_bounded_line(&mut reader, &mut buf, 16)?,
+            BoundedLine::Line
+        );
+        assert_eq!(buf, b"after\n");
+        assert_eq!(
+            read_bounded_line(&mut reader, &mut buf, 16)?,
+            BoundedLine::Eof
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_read_line_within_counts_skipped_lines() -> io::Result<()> {
+        let input = format!("short\n{}\nafter\n{}", "x".repeat(50), "y".repeat(50));
+        let mut reader = BufReader::with_capacity(8, input.as_bytes());
+        let mut buf = Vec::new();
+        let mut line_number = 0;
+        let mut next = || -> io::Result<Option<(Vec<u8>, usize)>> {
+            let path = Path::new("session.jsonl");
+            Ok(
+                read_line_within(&mut reader, &mut buf, 16, path, &mut line_number)?
+                    .then(|| (buf.clone(), line_number)),
+            )
+        };
+
+        assert_eq!(next()?, Some((b"short\n".to_vec(), 1)));
+        assert_eq!(next()?, Some((b"after\n".to_vec(), 3)));
+        assert_eq!(next()?, None);
+        Ok(())
+    }
+
+    #[test]
+    fn test_split_lines_keeps_lines_whole() {
+        let buf = b"first line\nsecond\nthird line here\nlast";
+        for parts in 1..=8 {
+            let ranges = split_lines(buf, parts);
+            assert!(ranges.len() <= parts);
+            assert_eq!(ranges.first().unwrap().start, 0);
+            assert_eq!(ranges.last().unwrap().end, buf.len());
+            for pair in ranges.windows(2) {
+                assert_eq!(pair[0].end, pair[1].start);
+                assert_eq!(buf[pair[0].end - 1], b'\n');
+            }
+        }
+        assert_eq!(split_lines(b"one\ntwo\n", 2), [0..4, 4..8]);
+        assert_eq!(split_lines(b"no newline", 4), vec![0..10]);
+        assert_eq!(split_lines(b"", 3), vec![0..0]);
+    }
+
+    #[test]
+    fn test_lines_match_str_lines() {
+        for input in [
+            "",
+            "\n",
+            "one",
+            "one\n",
+            "one\n\ntwo",
+            "one\r\ntwo\r\n",
+            "\n\nlast\n\n",
+            "lone\rcarriage\n",
+            "unterminated\r",
+        ] {
+            let expected: Vec<&[u8]> = input.lines().map(str::as_bytes).collect();
+            let actual: Vec<&[u8]> = lines(input.as_bytes()).collect();
+            assert_eq!(actual, expected, "input {input:?}");
+        }
+    }
+}
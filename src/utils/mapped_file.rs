@@ -0,0 +1,97 @@
+use memmap2::Mmap;
+use std::fs::File;
+use std::io::{self, BufRead, Read};
+use std::path::Path;
+
+/// Plain session files at least this large are memory-mapped rather than read
+/// through a buffer; below it the mapping setup costs more than the copy it saves
+pub const MMAP_THRESHOLD: u64 = 1024 * 1024;
+
+/// A read-only memory map of a whole file, read as a [`BufRead`] so lines are
+/// scanned in place instead of being copied into an intermediate buffer first.
+///
+/// Only the pages that are actually touched get read from disk. The mapping is
+/// unmapped when the value is dropped.
+pub struct MappedFile {
+    map: Mmap,
+    pos: usize,
+}
+
+impl MappedFile {
+    pub fn open(path: &Path) -> io::Result<Self> {
+        let file = File::open(path)?;
+        // SAFETY: the mapping is read-only and owned by this reader. Session files
+        // are only ever appended to, which leaves the mapped range valid; anything
+        // appended after mapping is simply not seen, as with a plain read.
+        let map = unsafe { Mmap::map(&file)? };
+        #[cfg(unix)]
+        let _ = map.advise(memmap2::Advice::Sequential);
+        Ok(Self { map, pos: 0 })
+    }
+
+    /// The bytes not read yet
+    pub fn remaining(&self) -> &[u8] {
+        &self.map[self.pos..]
+    }
+
+    pub fn len(&self) -> usize {
+        self.map.len()
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.map.is_empty()
+    }
+}
+
+impl Read for MappedFile {
+    fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+        let n = (&self.map[self.pos..]).read(buf)?;
+        self.pos += n;
+        Ok(n)
+    }
+}
+
+impl BufRead for MappedFile {
+    fn fill_buf(&mut self) -> io::Result<&[u8]> {
+        Ok(&self.map[self.pos..])
+    }
+
+    fn consume(&mut self, amt: usize) {
+        self.pos = (self.pos + amt).min(self.map.len());
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_reads_lines_in_place() -> io::Result<()> {
+        let temp_dir = tempdir()?;
+        let path = temp_dir.path().join("session.jsonl");
+        std::fs::write(&path, "first\nsecond\nlast")?;
+
+        let mapped = MappedFile::open(&path)?;
+        assert_eq!(mapped.len(), 17);
+        let lines: Vec<String> = mapped.lines().collect::<io::Result<_>>()?;
+        assert_eq!(lines, vec!["first", "second", "last"]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_read_copies_remaining_bytes() -> io::Result<()> {
+        let temp_dir = tempdir()?;
+        let path = temp_dir.path().join("session.jsonl");
+        std::fs::write(&path, "abcdef")?;
+
+        let mut mapped = MappedFile::open(&path)?;
+        let mut head = [0u8; 4];
+        mapped.read_exact(&mut head)?;
+        assert_eq!(&head, b"abcd");
+        let mut rest = String::new();
+        mapped.read_to_string(&mut rest)?;
+        assert_eq!(rest, "ef");
+        Ok(())
+    }
+}
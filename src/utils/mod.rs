@@ -1 +1,5 @@
+pub mod clipboard;
+pub mod compression;
+pub mod line_reader;
+pub mod mapped_file;
 pub mod path_encoding;
@@ -1,3 +1,5 @@
+use std::path::{Path, PathBuf};
+
 /// Encode a path to Claude Code's project directory format
 /// Replaces path separators and special characters with hyphens
 pub fn encode_project_path(path: &str) -> String {
@@ -43,18 +45,14 @@ pub fn file_belongs_to_project(file_path: &str, project_path: &str) -> bool {
     if let Some(extracted_project) = extract_project_from_file_path(file_path) {
         let encoded_project = encode_project_path(project_path);
 
-        // Debug output
-        if std::env::var("DEBUG_PATH_ENCODING").is_ok() {
-            eprintln!("DEBUG PATH ENCODING:");
-            eprintln!("  file_path: {file_path}");
-            eprintln!("  project_path: {project_path}");
-            eprintln!("  extracted_project: {extracted_project}");
-            eprintln!("  encoded_project: {encoded_project}");
-            eprintln!(
-                "  starts_with result: {}",
-                extracted_project.starts_with(&encoded_project)
-            );
-        }
+        tracing::trace!(
+            file_path,
+            project_path,
+            extracted_project,
+            encoded_project,
+            "project path match: {}",
+            extracted_project.starts_with(&encoded_project)
+        );
 
         // Check if the extracted project starts with the encoded project path
         extracted_project.starts_with(&encoded_project)
@@ -63,9 +61,87 @@ pub fn file_belongs_to_project(file_path: &str, project_path: &str) -> bool {
     }
 }
 
+/// Best-effort inverse of [`encode_project_path`]: the filesystem path a
+/// project directory name such as `-Users-me-src-my-app` stands for.
+///
+/// Encoding is lossy, since `/`, `.`, `_` and `-` all become `-`. Where the
+/// path still exists, each component is looked up on disk, so real dashes,
+/// dots and underscores come back as they were (`/Users/me/src/my-app`). The
+/// rest is guessed: every `-` as a separator, except that `--` starts a hidden
+/// directory (`/.claude`). A Windows drive (`C--Users-me`) becomes `C:\`.
+pub fn decode_project_dir(name: &str) -> String {
+    let (root, rest) = match name.as_bytes() {
+        [drive, b'-', b'-', ..] if drive.is_ascii_alphabetic() => {
+            (PathBuf::from(format!("{}:\\", *drive as char)), &name[3..])
+        }
+        _ => (PathBuf::from("/"), name.strip_prefix('-').unwrap_or(name)),
+    };
+    if rest.is_empty() {
+        return root.to_string_lossy().into_owned();
+    }
+    let parts: Vec<&str> = rest.split('-').collect();
+    // As much of the path as exists, then a guess at the rest
+    let path = (0..=parts.len())
+        .rev()
+        .find_map(|known| {
+            let base = resolve_on_disk(&root, &parts[..known])?;
+            let rest = guess_components(&parts[known..]);
+            Some(if rest.as_os_str().is_empty() {
+                base
+            } else {
+                base.join(rest)
+            })
+        })
+        .unwrap_or(root);
+    path.to_string_lossy().into_owned()
+}
+
+// The existing path under `dir` whose components encode to `parts` joined by
+// `-`, preferring the longest component at each level
+fn resolve_on_disk(dir: &Path, parts: &[&str]) -> Option<PathBuf> {
+    if parts.is_empty() {
+        return Some(dir.to_path_buf());
+    }
+    let entries: Vec<(String, PathBuf)> = std::fs::read_dir(dir)
+        .ok()?
+        .filter_map(|entry| entry.ok())
+        .filter(|entry| entry.path().is_dir())
+        .map(|entry| {
+            let name = entry.file_name().to_string_lossy().into_owned();
+            (encode_project_path(&name), entry.path())
+        })
+        .collect();
+    (1..=parts.len()).rev().find_map(|taken| {
+        let component = parts[..taken].join("-");
+        entries
+            .iter()
+            .filter(|(encoded, _)| *encoded == component)
+            .find_map(|(_, path)| resolve_on_disk(path, &parts[taken..]))
+    })
+}
+
+// Components for `parts` when nothing is on disk: an empty part (from `--`)
+// makes the next one a hidden directory
+fn guess_components(parts: &[&str]) -> PathBuf {
+    let mut path = PathBuf::new();
+    let mut hidden = false;
+    for part in parts {
+        if part.is_empty() {
+            hidden = true;
+        } else if hidden {
+            path.push(format!(".{part}"));
+            hidden = false;
+        } else {
+            path.push(part);
+        }
+    }
+    path
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
+    use tempfile::tempdir;
 
     #[test]
     fn test_encode_project_path() {
@@ -149,4 +225,39 @@ mod tests {
             r"C:\Users\me\ghq\github.com\org\repo"
         ));
     }
+
+    #[test]
+    fn test_decode_project_dir_guesses_missing_paths() {
+        assert_eq!(
+            decode_project_dir("-nonexistent-ccms-src-app"),
+            "/nonexistent/ccms/src/app"
+        );
+        assert_eq!(
+            decode_project_dir("-nonexistent-me--claude-x"),
+            "/nonexistent/me/.claude/x"
+        );
+        assert_eq!(decode_project_dir("-"), "/");
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_decode_project_dir_follows_existing_paths() {
+        let temp = tempdir().unwrap();
+        let project = temp.path().join("src").join("my-app_v2.0").join("web");
+        std::fs::create_dir_all(&project).unwrap();
+        std::fs::create_dir_all(temp.path().join(".config")).unwrap();
+
+        let project = project.to_string_lossy().into_owned();
+        assert_eq!(decode_project_dir(&encode_project_path(&project)), project);
+
+        let hidden = temp.path().join(".config").to_string_lossy().into_owned();
+        assert_eq!(decode_project_dir(&encode_project_path(&hidden)), hidden);
+
+        // A component that is gone is guessed, with what exists kept as it is
+        let gone = format!(
+            "{}-src-my-app-v2-0-old",
+            encode_project_path(&temp.path().to_string_lossy())
+        );
+        assert!(decode_project_dir(&gone).starts_with(&*temp.path().to_string_lossy()));
+    }
 }
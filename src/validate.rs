@@ -0,0 +1,606 @@
+//! Schema check of session files for `--validate`
+//!
+//! Search decodes leniently: a line it cannot decode is counted and skipped,
+//! and content in a shape it doesn't know is kept but not searched. Validation
+//! instead checks every line field by field against the shape
+//! [`SessionMessage`] expects, then decodes it with the full schema, so a change
+//! in the format Claude Code writes shows up as a list of specific problems.
+
+use crate::schemas::SessionMessage;
+use crate::utils::compression::{self, UTF8_BOM};
+use crate::utils::line_reader::{self, BoundedLine};
+use anyhow::Result;
+use rayon::prelude::*;
+use serde_json::{Map, Value};
+use std::path::{Path, PathBuf};
+
+/// Something about a line (or a whole file) that doesn't fit the schema
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct SchemaProblem {
+    pub path: PathBuf,
+    /// 1-based line number, or `None` when the file itself couldn't be read
+    pub line: Option<usize>,
+    pub problem: String,
+}
+
+/// Outcome of validating a set of files
+#[derive(Debug, Default)]
+pub struct ValidationReport {
+    pub files: usize,
+    pub lines: usize,
+    /// Problems in file order, then line order
+    pub problems: Vec<SchemaProblem>,
+}
+
+impl ValidationReport {
+    /// Number of distinct lines with at least one problem
+    pub fn invalid_lines(&self) -> usize {
+        let mut lines: Vec<_> = self
+            .problems
+            .iter()
+            .map(|problem| (&problem.path, problem.line))
+            .collect();
+        lines.dedup();
+        lines.len()
+    }
+}
+
+/// Check every line of `files`; lines longer than `max_line_bytes` are
+/// reported rather than read, as searches skip them
+pub fn validate_files(files: &[PathBuf], max_line_bytes: usize) -> ValidationReport {
+    let checked: Vec<(usize, Vec<SchemaProblem>)> = files
+        .par_iter()
+        .map(|path| validate_file(path, max_line_bytes))
+        .collect();
+    let mut report = ValidationReport {
+        files: files.len(),
+        ..Default::default()
+    };
+    for (lines, problems) in checked {
+        report.lines += lines;
+        report.problems.extend(problems);
+    }
+    report
+}
+
+/// One problem per line: `path:line: problem`
+pub fn format_problems(problems: &[SchemaProblem]) -> String {
+    let mut output = String::new();
+    for SchemaProblem {
+        path,
+        line,
+        problem,
+    } in problems
+    {
+        match line {
+            Some(line) => output.push_str(&format!("{}:{line}: {problem}\n", path.display())),
+            None => output.push_str(&format!("{}: {problem}\n", path.display())),
+        }
+    }
+    output
+}
+
+// Lines read from the file, and the problems found in them
+fn validate_file(path: &Path, max_line_bytes: usize) -> (usize, Vec<SchemaProblem>) {
+    let mut problems = Vec::new();
+    let mut lines = 0;
+    if let Err(e) = check_lines(path, max_line_bytes, &mut lines, &mut problems) {
+        problems.push(SchemaProblem {
+            path: path.to_path_buf(),
+            line: None,
+            problem: format!("cannot be read: {e}"),
+        });
+    }
+    (lines, problems)
+}
+
+fn check_lines(
+    path: &Path,
+    max_line_bytes: usize,
+    lines: &mut usize,
+    problems: &mut Vec<SchemaProblem>,
+) -> Result<()> {
+    let mut reader = compression::open_session_reader(path)?;
+    let mut buffer = Vec::with_capacity(16 * 1024);
+    loop {
+        let read = line_reader::read_bounded_line(&mut reader, &mut buffer, max_line_bytes)?;
+        if read == BoundedLine::Eof {
+            return Ok(());
+        }
+        *lines += 1;
+        let line = *lines;
+        let mut report = |problem: String| {
+            problems.push(SchemaProblem {
+                path: path.to_path_buf(),
+                line: Some(line),
+                problem,
+            })
+        };
+        if read == BoundedLine::TooLong {
+            report(format!(
+                "line is longer than {max_line_bytes} bytes and is never searched (see --max-line-bytes)"
+            ));
+            continue;
+        }
+        let mut bytes = buffer.trim_ascii();
+        if line == 1 {
+            bytes = bytes.strip_prefix(UTF8_BOM).unwrap_or(bytes);
+        }
+        if bytes.is_empty() {
+            continue;
+        }
+        check_line(bytes).into_iter().for_each(&mut report);
+    }
+}
+
+// Problems with one JSONL line
+fn check_line(bytes: &[u8]) -> Vec<String> {
+    let value: Value = match serde_json::from_slice(bytes) {
+        Ok(value) => value,
+        Err(e) => return vec![format!("invalid JSON: {e}")],
+    };
+    let Some(object) = value.as_object() else {
+        return vec![format!("{} instead of a JSON object", describe(&value))];
+    };
+
+    let mut checker = Checker::default();
+    match object.get("type") {
+        None => checker.problems.push("missing `type`".to_string()),
+        Some(Value::String(message_type)) => match message_type.as_str() {
+            "summary" => checker.summary(object),
+            "system" => checker.system(object),
+            "user" => checker.user(object),
+            "assistant" => checker.assistant(object),
+            other => checker
+                .problems
+                .push(format!("unknown message type `{other}`")),
+        },
+        Some(other) => checker
+            .problems
+            .push(format!("`type` is {}, expected a string", describe(other))),
+    }
+
+    // Anything the field checks let through is caught by the schema search uses
+    if checker.problems.is_empty()
+        && let Err(e) = sonic_rs::from_slice::<SessionMessage>(bytes)
+    {
+        checker
+            .problems
+            .push(format!("does not decode as a session message: {e}"));
+    }
+    checker.problems
+}
+
+// The JSON kinds a field may have
+#[derive(Debug, Clone, Copy)]
+enum Kind {
+    String,
+    Bool,
+    Count,
+    Object,
+    Array,
+    StringOrNull,
+    Any,
+}
+
+impl Kind {
+    fn accepts(self, value: &Value) -> bool {
+        match self {
+            Kind::String => value.is_string(),
+            Kind::Bool => value.is_boolean(),
+            Kind::Count => value.as_u64().is_some_and(|n| u32::try_from(n).is_ok()),
+            Kind::Object => value.is_object(),
+            Kind::Array => value.is_array(),
+            Kind::StringOrNull => value.is_string() || value.is_null(),
+            Kind::Any => true,
+        }
+    }
+
+    fn expected(self) -> &'static str {
+        match self {
+            Kind::String => "a string",
+            Kind::Bool => "a boolean",
+            Kind::Count => "a non-negative 32-bit integer",
+            Kind::Object => "an object",
+            Kind::Array => "an array",
+            Kind::StringOrNull => "a string or null",
+            Kind::Any => "any value",
+        }
+    }
+}
+
+fn describe(value: &Value) -> &'static str {
+    match value {
+        Value::Null => "null",
+        Value::Bool(_) => "a boolean",
+        Value::Number(_) => "a number",
+        Value::String(_) => "a string",
+        Value::Array(_) => "an array",
+        Value::Object(_) => "an object",
+    }
+}
+
+#[derive(Default)]
+struct Checker {
+    problems: Vec<String>,
+}
+
+impl Checker {
+    // Field `name` of `object` (at `prefix` in the line) if it is there and of the right kind
+    fn field<'a>(
+        &mut self,
+        object: &'a Map<String, Value>,
+        prefix: &str,
+        name: &str,
+        kind: Kind,
+        required: bool,
+    ) -> Option<&'a Value> {
+        let path = format!("{prefix}{name}");
+        match object.get(name) {
+            None if required => {
+                self.problems.push(format!("missing `{path}`"));
+                None
+            }
+            None => None,
+            // serde reads an absent optional field and null alike
+            Some(Value::Null) if !required => None,
+            Some(value) if kind.accepts(value) => Some(value),
+            Some(value) => {
+                self.problems.push(format!(
+                    "`{path}` is {}, expected {}",
+                    describe(value),
+                    kind.expected()
+                ));
+                None
+            }
+        }
+    }
+
+    fn required(&mut self, object: &Map<String, Value>, fields: &[(&str, Kind)]) {
+        for &(name, kind) in fields {
+            self.field(object, "", name, kind, true);
+        }
+    }
+
+    fn optional(&mut self, object: &Map<String, Value>, fields: &[(&str, Kind)]) {
+        for &(name, kind) in fields {
+            self.field(object, "", name, kind, false);
+        }
+    }
+
+    fn summary(&mut self, object: &Map<String, Value>) {
+        self.required(
+            object,
+            &[("summary", Kind::String), ("leafUuid", Kind::String)],
+        );
+    }
+
+    // Fields every message but a summary has
+    fn base(&mut self, object: &Map<String, Value>) {
+        self.required(
+            object,
+            &[
+                ("parentUuid", Kind::StringOrNull),
+                ("isSidechain", Kind::Bool),
+                ("userType", Kind::String),
+                ("cwd", Kind::String),
+                ("sessionId", Kind::String),
+                ("version", Kind::String),
+                ("uuid", Kind::String),
+            ],
+        );
+        if let Some(Value::String(timestamp)) =
+            self.field(object, "", "timestamp", Kind::String, true)
+            && chrono::DateTime::parse_from_rfc3339(timestamp).is_err()
+        {
+            self.problems.push(format!(
+                "`timestamp` is not an RFC 3339 time: {timestamp:?}"
+            ));
+        }
+    }
+
+    fn system(&mut self, object: &Map<String, Value>) {
+        self.base(object);
+        self.required(object, &[("content", Kind::String), ("isMeta", Kind::Bool)]);
+        self.optional(
+            object,
+            &[
+                ("toolUseID", Kind::String),
+                ("level", Kind::String),
+                ("gitBranch", Kind::String),
+                ("requestId", Kind::String),
+            ],
+        );
+    }
+
+    fn user(&mut self, object: &Map<String, Value>) {
+        self.base(object);
+        self.optional(
+            object,
+            &[
+                ("gitBranch", Kind::String),
+                ("isMeta", Kind::Bool),
+                ("isCompactSummary", Kind::Bool),
+            ],
+        );
+        let Some(Value::Object(message)) = self.field(object, "", "message", Kind::Object, true)
+        else {
+            return;
+        };
+        self.field(message, "message.", "role", Kind::String, true);
+        match message.get("content") {
+            None => self.problems.push("missing `message.content`".to_string()),
+            Some(Value::String(_)) => {}
+            Some(Value::Array(blocks)) => self.content_blocks(blocks, "message.content"),
+            // Decoded, but never searched
+            Some(other) => self.problems.push(format!(
+                "`message.content` is {}, expected a string or an array",
+                describe(other)
+            )),
+        }
+    }
+
+    fn assistant(&mut self, object: &Map<String, Value>) {
+        self.base(object);
+        self.optional(
+            object,
+            &[
+                ("requestId", Kind::String),
+                ("gitBranch", Kind::String),
+                ("isApiErrorMessage", Kind::Bool),
+            ],
+        );
+        let Some(Value::Object(message)) = self.field(object, "", "message", Kind::Object, true)
+        else {
+            return;
+        };
+        for (name, kind) in [
+            ("id", Kind::String),
+            ("type", Kind::String),
+            ("role", Kind::String),
+            ("model", Kind::String),
+            ("stop_reason", Kind::StringOrNull),
+            ("stop_sequence", Kind::StringOrNull),
+        ] {
+            self.field(message, "message.", name, kind, true);
+        }
+        if let Some(Value::Array(blocks)) =
+            self.field(message, "message.", "content", Kind::Array, true)
+        {
+            self.content_blocks(blocks, "message.content");
+        }
+        if let Some(Value::Object(usage)) =
+            self.field(message, "message.", "usage", Kind::Object, true)
+        {
+            for name in [
+                "input_tokens",
+                "cache_creation_input_tokens",
+                "cache_read_input_tokens",
+                "output_tokens",
+            ] {
+                self.field(usage, "message.usage.", name, Kind::Count, true);
+            }
+            self.field(usage, "message.usage.", "service_tier", Kind::String, false);
+        }
+    }
+
+    fn content_blocks(&mut self, blocks: &[Value], path: &str) {
+        for (index, block) in blocks.iter().enumerate() {
+            let path = format!("{path}[{index}]");
+            let Value::Object(block) = block else {
+                self.problems.push(format!(
+                    "`{path}` is {}, expected an object",
+                    describe(block)
+                ));
+                continue;
+            };
+            let prefix = format!("{path}.");
+            let fields: &[(&str, Kind)] =
+                match self.field(block, &prefix, "type", Kind::String, true) {
+                    Some(Value::String(block_type)) => match block_type.as_str() {
+                        "text" => &[("text", Kind::String)],
+                        "thinking" => &[("thinking", Kind::String), ("signature", Kind::String)],
+                        "tool_use" => &[
+                            ("id", Kind::String),
+                            ("name", Kind::String),
+                            ("input", Kind::Any),
+                        ],
+                        "tool_result" => {
+                            self.tool_result(block, &prefix);
+                            &[("tool_use_id", Kind::String)]
+                        }
+                        "image" => &[("source", Kind::Object)],
+                        other => {
+                            self.problems
+                                .push(format!("`{path}` has unknown block type `{other}`"));
+                            &[]
+                        }
+                    },
+                    _ => &[],
+                };
+            for &(name, kind) in fields {
+                self.field(block, &prefix, name, kind, true);
+            }
+        }
+    }
+
+    // The optional parts of a tool result, whose content is searched only as
+    // text or an array of text blocks
+    fn tool_result(&mut self, block: &Map<String, Value>, prefix: &str) {
+        self.field(block, prefix, "is_error", Kind::Bool, false);
+        let blocks_of = |wanted: &str, field: &str, value: &Value| {
+            value.as_array().is_some_and(|items| {
+                items.iter().all(|item| {
+                    item.get("type").and_then(Value::as_str) == Some(wanted)
+                        && item
+                            .get(field)
+                            .is_some_and(|v| v.is_string() || v.is_object())
+                })
+            })
+        };
+        match block.get("content") {
+            None | Some(Value::Null) | Some(Value::String(_)) => {}
+            Some(content)
+                if blocks_of("text", "text", content) || blocks_of("image", "source", content) => {}
+            Some(other) => self.problems.push(format!(
+                "`{prefix}content` is {}, expected a string or an array of text or image blocks",
+                describe(other)
+            )),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::utils::line_reader::MAX_LINE_BYTES;
+    use tempfile::tempdir;
+
+    const USER: &str = r#"{"type":"user","message":{"role":"user","content":"Hello"},"uuid":"u1","timestamp":"2024-01-01T00:00:00Z","sessionId":"s1","parentUuid":null,"isSidechain":false,"userType":"external","cwd":"/work","version":"1"}"#;
+    const ASSISTANT: &str = r#"{"type":"assistant","message":{"id":"m1","type":"message","role":"assistant","model":"claude","content":[{"type":"text","text":"Hi"},{"type":"tool_use","id":"t1","name":"Bash","input":{"command":"ls"}}],"stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":1,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":2}},"uuid":"a1","timestamp":"2024-01-01T00:00:01Z","sessionId":"s1","parentUuid":"u1","isSidechain":false,"userType":"external","cwd":"/work","version":"1"}"#;
+
+    // `line` with the JSON value at `pointer` replaced, or removed for `None`
+    fn edit(line: &str, pointer: &str, value: Option<Value>) -> String {
+        let mut json: Value = serde_json::from_str(line).unwrap();
+        let (parent, name) = pointer.rsplit_once('/').unwrap();
+        let parent = json.pointer_mut(parent).unwrap();
+        match (parent, value) {
+            (Value::Object(object), Some(value)) => {
+                object.insert(name.to_string(), value);
+            }
+            (Value::Object(object), None) => {
+                object.remove(name);
+            }
+            (Value::Array(items), Some(value)) => items[name.parse::<usize>().unwrap()] = value,
+            _ => unreachable!(),
+        }
+        json.to_string()
+    }
+
+    fn problems(line: &str) -> Vec<String> {
+        check_line(line.as_bytes())
+    }
+
+    #[test]
+    fn test_valid_lines() {
+        assert!(problems(USER).is_empty());
+        assert!(problems(ASSISTANT).is_empty());
+        assert!(problems(r#"{"type":"summary","summary":"Topic","leafUuid":"u1"}"#).is_empty());
+        let tool_result = edit(
+            USER,
+            "/message/content",
+            Some(serde_json::json!([
+                {"type": "tool_result", "tool_use_id": "t1", "content": [{"type": "text", "text": "ok"}]},
+                {"type": "tool_result", "tool_use_id": "t2", "is_error": true},
+            ])),
+        );
+        assert!(problems(&tool_result).is_empty());
+    }
+
+    #[test]
+    fn test_line_problems() {
+        assert_eq!(
+            problems("{"),
+            ["invalid JSON: EOF while parsing an object at line 1 column 1"]
+        );
+        assert_eq!(problems("[1]"), ["an array instead of a JSON object"]);
+        assert_eq!(problems(r#"{"uuid":"x"}"#), ["missing `type`"]);
+        assert_eq!(
+            problems(r#"{"type":"progress"}"#),
+            ["unknown message type `progress`"]
+        );
+        assert_eq!(
+            problems(&edit(USER, "/sessionId", None)),
+            ["missing `sessionId`"]
+        );
+        assert_eq!(
+            problems(&edit(USER, "/isSidechain", Some("no".into()))),
+            ["`isSidechain` is a string, expected a boolean"]
+        );
+        assert_eq!(
+            problems(&edit(USER, "/timestamp", Some("yesterday".into()))),
+            ["`timestamp` is not an RFC 3339 time: \"yesterday\""]
+        );
+        assert_eq!(
+            problems(&edit(
+                USER,
+                "/message/content",
+                Some(serde_json::json!({"text": "Hi"}))
+            )),
+            ["`message.content` is an object, expected a string or an array"]
+        );
+        assert_eq!(
+            problems(&edit(
+                ASSISTANT,
+                "/message/content/1",
+                Some(serde_json::json!({"type": "server_tool_use", "id": "x"}))
+            )),
+            ["`message.content[1]` has unknown block type `server_tool_use`"]
+        );
+        assert_eq!(
+            problems(&edit(
+                ASSISTANT,
+                "/message/usage/output_tokens",
+                Some((-1).into())
+            )),
+            ["`message.usage.output_tokens` is a number, expected a non-negative 32-bit integer"]
+        );
+        // Every problem of a line is reported
+        let line = edit(
+            &edit(ASSISTANT, "/message/model", None),
+            "/uuid",
+            Some(1.into()),
+        );
+        assert_eq!(
+            problems(&line),
+            [
+                "`uuid` is a number, expected a string",
+                "missing `message.model`"
+            ]
+        );
+        let tool_result = edit(
+            USER,
+            "/message/content",
+            Some(
+                serde_json::json!([{"type": "tool_result", "tool_use_id": "t1", "content": {"rows": 3}}]),
+            ),
+        );
+        assert_eq!(
+            problems(&tool_result),
+            [
+                "`message.content[0].content` is an object, expected a string or an array of text or image blocks"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_validate_files() -> Result<()> {
+        let dir = tempdir()?;
+        let good = dir.path().join("good.jsonl");
+        let bad = dir.path().join("bad.jsonl");
+        std::fs::write(&good, format!("\u{feff}{USER}\r\n\n{ASSISTANT}\n"))?;
+        std::fs::write(
+            &bad,
+            [
+                USER.to_string(),
+                r#"{"type":"summary"}"#.to_string(),
+                edit(USER, "/message", None),
+            ]
+            .join("\n"),
+        )?;
+        let missing = dir.path().join("missing.jsonl");
+
+        let report = validate_files(&[good, bad.clone(), missing.clone()], MAX_LINE_BYTES);
+        assert_eq!(report.files, 3);
+        assert_eq!(report.lines, 6);
+        assert_eq!(report.invalid_lines(), 3);
+        let output = format_problems(&report.problems);
+        let lines: Vec<&str> = output.lines().collect();
+        assert_eq!(lines[0], format!("{}:2: missing `summary`", bad.display()));
+        assert_eq!(lines[1], format!("{}:2: missing `leafUuid`", bad.display()));
+        assert_eq!(lines[2], format!("{}:3: missing `message`", bad.display()));
+        assert!(lines[3].starts_with(&format!("{}: cannot be read: ", missing.display())));
+        assert_eq!(lines.len(), 4);
+        Ok(())
+    }
+}